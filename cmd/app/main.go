@@ -2,78 +2,292 @@ package main
 
 import (
 	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv" // Добавлен импорт для godotenv
 
+	"github.com/alex-pyslar/neuro-chat-bot/internal/adapters/langdetect"
 	"github.com/alex-pyslar/neuro-chat-bot/internal/adapters/llm"
 	"github.com/alex-pyslar/neuro-chat-bot/internal/adapters/persistence"
 	"github.com/alex-pyslar/neuro-chat-bot/internal/adapters/telegram" // Обновленный путь к Telegram контроллеру
+	"github.com/alex-pyslar/neuro-chat-bot/internal/config"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
 	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
 	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
 )
 
+// version задается при сборке: go build -ldflags "-X main.version=v1.2.3" ./cmd/app
+var version = "dev"
+
 func main() {
-	// Загрузка переменных окружения из .env файла
+	// Загрузка переменных окружения из .env файла (при заданном CONFIG_FILE файл .env необязателен)
 	err := godotenv.Load()
-	if err != nil {
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && os.Getenv("CONFIG_FILE") != "") {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
-	// Инициализация логгера
-	appLogger := logger.NewConsoleLogger(logger.AllLevels) // Логируем все уровни
-
-	// Загрузка переменных окружения
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		appLogger.Fatal("MONGO_URI environment variable not set.")
+	// Основные настройки: файл CONFIG_FILE, значения которого переопределяются переменными окружения,
+	// или только переменные окружения
+	var cfg *config.Config
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		cfg, err = config.LoadConfigFromFile(configFile)
+	} else {
+		cfg, err = config.LoadConfig()
 	}
-	mongoDBName := os.Getenv("MONGO_DB_NAME")
-	if mongoDBName == "" {
-		appLogger.Fatal("MONGO_DB_NAME environment variable not set.")
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
 	}
-	telegramBotToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if telegramBotToken == "" {
-		appLogger.Fatal("TELEGRAM_BOT_TOKEN environment variable not set.")
+
+	// Инициализация логгера
+	var appLogger logger.Logger = logger.NewConsoleLogger(logger.AllLevels) // Логируем все уровни
+	if cfg.Logging.File != "" {
+		file, err := logger.NewRotatingFile(cfg.Logging.File, int64(cfg.Logging.FileMaxMB)<<20, cfg.Logging.FileBackups)
+		if err != nil {
+			log.Fatalf("Error opening LOG_FILE: %v", err)
+		}
+		defer file.Close()
+		appLogger = logger.NewWriterLogger(io.MultiWriter(os.Stdout, file), logger.AllLevels)
 	}
-	llamaBaseURL := os.Getenv("LLAMA_BASE_URL")
-	if llamaBaseURL == "" {
-		appLogger.Fatal("LLAMA_BASE_URL environment variable not set. Using default.")
-		llamaBaseURL = "http://localhost:8080" // Default for llama-cpp-python server
+	if cfg.Logging.Async {
+		policy := logger.DropOnOverflow
+		if cfg.Logging.OverflowPolicy == "block" {
+			policy = logger.BlockOnOverflow
+		}
+		asyncLogger := logger.NewAsyncLogger(appLogger, cfg.Logging.BufferSize, policy)
+		defer asyncLogger.Close() // Сбрасываем буфер логов при штатном завершении
+		appLogger = asyncLogger
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Уровни логирования: общий и отдельные для компонентов (меняются командой /loglevel).
+	// Значения уже проверены при загрузке конфигурации
+	logLevels := logger.NewLevelRegistry(appLogger, logger.AllLevels)
+	appLogger = logLevels.For("app")
+	globalLevel, _ := logger.ParseLevel(cfg.Logging.Level)
+	logLevels.SetGlobal(globalLevel)
+	categoryLevels, _ := cfg.Logging.CategoryLevels()
+	for category, level := range categoryLevels {
+		logLevels.SetCategory(category, level)
+	}
+	gatewayLogger := logLevels.For("gateway")
+
+	llmProvider := cfg.LlamaCPP.Provider
+	llamaBaseURL := cfg.LlamaCPP.BaseURL
+
+	// SIGINT и SIGTERM отменяют контекст: polling и фоновые задачи останавливаются, а отложенные вызовы
+	// (сброс буфера логов, закрытие файла журнала) выполняются
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Инициализация MongoDB репозитория
-	userRepo, err := persistence.NewMongoDbRepository(mongoURI, mongoDBName, appLogger)
+	userRepo, err := persistence.NewMongoDbRepository(cfg.MongoDB.ConnectionString, cfg.MongoDB.DatabaseName, logLevels.For("persistence"))
 	if err != nil {
 		appLogger.Fatal("Failed to create MongoDB repository: %v", err)
 	}
 	appLogger.Info("MongoDB repository initialized.")
 
 	// Инициализация LlamaC++ Gateway
-	llamaGateway := llm.NewLlamaCppGateway(llamaBaseURL, appLogger, 60*time.Second)
-	appLogger.Info("LlamaC++ Gateway initialized with base URL: %s", llamaBaseURL)
+	gatewayOptions := llm.DefaultGatewayOptions()
+	gatewayOptions.Timeout = time.Duration(cfg.LlamaCPP.TimeoutSeconds) * time.Second
+	gatewayOptions.Model = cfg.LlamaCPP.Model
+	gatewayOptions.MergeConsecutiveRoles = cfg.LlamaCPP.MergeSameRole
+	gatewayOptions.AdaptiveTimeout = cfg.LlamaCPP.AdaptiveTimeout
+	gatewayOptions.MinTimeout = time.Duration(cfg.LlamaCPP.MinTimeoutSeconds) * time.Second
+	gatewayOptions.Retries = cfg.LlamaCPP.Retries
+	gatewayOptions.RetryDelay = time.Duration(cfg.LlamaCPP.RetryDelayMS) * time.Millisecond
+	if preset := cfg.LlamaCPP.FieldPreset; preset != "" {
+		mapping, ok := llm.FieldMappingPreset(preset)
+		if !ok {
+			appLogger.Fatal("Unknown LLAMA_FIELD_PRESET: %s", preset)
+		}
+		gatewayOptions.FieldMapping = mapping
+	}
+	if mappingFile := cfg.LlamaCPP.FieldMappingFile; mappingFile != "" {
+		mapping, err := config.LoadFieldMappingFile(mappingFile)
+		if err != nil {
+			appLogger.Fatal("Invalid LLAMA_FIELD_MAPPING_FILE: %v", err)
+		}
+		if gatewayOptions.FieldMapping == nil {
+			gatewayOptions.FieldMapping = llm.FieldMapping{}
+		}
+		for from, to := range mapping {
+			gatewayOptions.FieldMapping[from] = to // Файл дополняет и переопределяет готовое сопоставление
+		}
+	}
+
+	llamaAPIMode := cfg.LlamaCPP.APIMode
+	var llamaGateway usecases.ModelGateway
+	switch llmProvider {
+	case "llamacpp":
+		llamaGateway, err = llm.NewGateway(llamaAPIMode, llamaBaseURL, gatewayLogger, gatewayOptions)
+		if err != nil {
+			appLogger.Fatal("Invalid LLAMA_API_MODE: %v", err)
+		}
+		appLogger.Info("Model Gateway (%s) initialized with base URL: %s", llamaAPIMode, llamaBaseURL)
+	case "openai":
+		llamaGateway, err = llm.NewOpenAIGateway(cfg.OpenAI.BaseURL, cfg.OpenAI.APIKey, cfg.OpenAI.Model, gatewayLogger, gatewayOptions)
+		if err != nil {
+			appLogger.Fatal("Failed to create OpenAI gateway: %v", err)
+		}
+		appLogger.Info("OpenAI Model Gateway initialized with model: %s", cfg.OpenAI.Model)
+	case "ollama":
+		llamaGateway, err = llm.NewOllamaGateway(cfg.Ollama.BaseURL, cfg.Ollama.Model, gatewayLogger, gatewayOptions)
+		if err != nil {
+			appLogger.Fatal("Failed to create Ollama gateway: %v", err)
+		}
+		appLogger.Info("Ollama Model Gateway initialized with model: %s", cfg.Ollama.Model)
+	default:
+		appLogger.Fatal("Unknown LLM_PROVIDER: %s (expected llamacpp, openai or ollama)", llmProvider)
+	}
+	// Повторы при временных сбоях выполняются на каждом бэкенде до перехода на резервный
+	llamaGateway = llm.WithRetries(llamaGateway, gatewayLogger, gatewayOptions)
+
+	// Резервные бэкенды: "url" или "режим=url" через запятую, перебираются по порядку при ошибке основного
+	if len(cfg.LlamaCPP.FallbackURLs) > 0 {
+		gateways := []usecases.ModelGateway{llamaGateway}
+		for _, entry := range cfg.LlamaCPP.FallbackURLs {
+			mode, fallbackURL := llamaAPIMode, entry
+			if prefix, rest, ok := strings.Cut(entry, "="); ok && !strings.Contains(prefix, "/") {
+				mode, fallbackURL = prefix, rest
+			}
+			fallback, err := llm.NewGateway(mode, fallbackURL, gatewayLogger, gatewayOptions)
+			if err != nil {
+				appLogger.Fatal("Invalid LLAMA_FALLBACK_URLS entry %q: %v", entry, err)
+			}
+			gateways = append(gateways, llm.WithRetries(fallback, gatewayLogger, gatewayOptions))
+			appLogger.Info("Fallback Model Gateway (%s) added with base URL: %s", mode, fallbackURL)
+		}
+		llamaGateway = llm.NewCompositeGateway(gatewayLogger, gateways...)
+	}
+
+	// Прогрев модели, чтобы первый пользователь не ждал ее загрузки
+	if cfg.LlamaCPP.Warmup {
+		if warmer, ok := llamaGateway.(llm.Warmer); ok {
+			appLogger.Info("Warming up the model...")
+			if err := warmer.Warmup(ctx); err != nil {
+				appLogger.Warn("Model warm-up failed: %v", err)
+			}
+		}
+	}
 
 	// Инициализация User Interactor (Use Case)
-	userInteractor := usecases.NewUserInteractor(userRepo, llamaGateway, appLogger, 100) // 100 сообщений в истории чата
+	interactorOptions := usecases.DefaultUserInteractorOptions()
+	interactorOptions.ChatHistoryLimit = cfg.Chat.HistoryLimit
+	interactorOptions.MinLanguageConfidence = cfg.Chat.LanguageMinConfidence
+	interactorOptions.MaxConcurrentGenerations = cfg.Chat.MaxConcurrentGenerations
+	interactorOptions.QueueTimeout = time.Duration(cfg.Chat.QueueTimeoutSeconds) * time.Second
+	interactorOptions.MaxCharacters = cfg.Chat.MaxCharacters
+	interactorOptions.HistoryLoadLimit = cfg.Chat.HistoryLoadLimit
+	interactorOptions.EphemeralChatUsers = cfg.Chat.EphemeralChatUsers
+	interactorOptions.EphemeralChatTTL = time.Duration(cfg.Chat.EphemeralChatTTLMinutes) * time.Minute
+	interactorOptions.ConflictRetries = cfg.MongoDB.ConflictRetries
+	interactorOptions.DefaultPromptPosition = domain.PromptPosition(cfg.Chat.PromptPosition)
+	interactorOptions.MinRequestInterval = time.Duration(cfg.Chat.MinRequestIntervalSeconds) * time.Second
+	interactorOptions.ExemptAdminsFromRateLimit = cfg.Chat.AdminRateLimitExempt
+	interactorOptions.MinReplyLength = cfg.Chat.MinReplyLength
+	interactorOptions.DigestTime, _ = cfg.Chat.DigestTimeOfDay() // Проверено при загрузке конфигурации
+	interactorOptions.DigestSendInterval = time.Duration(cfg.Chat.DigestSendIntervalMS) * time.Millisecond
+	interactorOptions.StoreRawResponses = cfg.Chat.DebugRawResponses
+	interactorOptions.AutoNameCharacters = cfg.Chat.AutoNameCharacters
+	interactorOptions.InspectRequestsForAll = cfg.Chat.LastRequestAllUsers
+	interactorOptions.ResponseSaveRetries = cfg.Chat.ResponseSaveRetries
+	interactorOptions.DocumentContextTokens = cfg.Chat.DocumentContextTokens
+	interactorOptions.MaxContextTokens = cfg.Chat.MaxContextTokens
+	interactorOptions.CarryOverTurns = cfg.Chat.CarryOverTurns
+	interactorOptions.ModelAllowlist = cfg.Chat.ModelAllowlist
+	interactorOptions.AdminUserIDs = cfg.Chat.AdminUserIDs
+	interactorOptions.ModelLimits = cfg.Chat.ModelLimits
+	interactorOptions.AdminModelLimits = cfg.Chat.AdminModelLimits
+	if modelConfigFile := cfg.Chat.ModelConfigFile; modelConfigFile != "" {
+		modelConfig, err := config.LoadModelConfigFile(modelConfigFile)
+		if err != nil {
+			appLogger.Warn("Using built-in model defaults: %v", err)
+		} else {
+			appLogger.Info("Default model parameters loaded from %s", modelConfigFile)
+		}
+		interactorOptions.DefaultModelConfig = modelConfig
+	}
+	userInteractor := usecases.NewUserInteractorWithOptions(userRepo, llamaGateway, logLevels.For("usecases"), interactorOptions)
+	userInteractor.SetTranslator(llm.NewModelTranslator(llamaGateway, gatewayLogger))
+	userInteractor.SetLanguageDetector(langdetect.NewScriptDetector())
+	if cfg.MongoDB.AuditLog {
+		userInteractor.SetAuditor(persistence.NewMongoAuditor(userRepo))
+		appLogger.Info("Audit logging to MongoDB collection audit_log enabled.")
+	}
 	appLogger.Info("User Interactor initialized.")
 
 	// Инициализация Telegram Bot Controller
-	botController, err := telegram_adapter.NewTelegramBotController(telegramBotToken, appLogger, userInteractor) // Обновленный вызов
+	botController, err := telegram_adapter.NewTelegramBotController(cfg.Telegram.BotToken, logLevels.For("telegram"), userInteractor) // Обновленный вызов
 	if err != nil {
 		appLogger.Fatal("Failed to create Telegram Bot Controller: %v", err)
 	}
+	botController.SetVersion(version)
+	botController.SetDebug(cfg.Telegram.Debug)
+	botController.SetLogLevels(logLevels)
+	botController.SetStopButton(cfg.Telegram.StopButton)
+	botController.SetStreaming(cfg.Telegram.StreamReplies)
+	botController.SetMaxImportSize(int64(cfg.Telegram.MaxImportBytes))
+	botController.SetMaxDocumentSize(int64(cfg.Telegram.MaxDocumentBytes))
+	botController.SetMenuPersonalization(cfg.Telegram.PersonalizeMenu)
+	botController.SetPendingCommandTimeout(time.Duration(cfg.Telegram.PendingCommandTimeoutSeconds) * time.Second)
+	botController.SetOOCPrefix(cfg.Telegram.OOCPrefix)
 	appLogger.Info("Telegram Bot Controller initialized.")
 
+	// Ежедневная сводка для подписавшихся пользователей
+	if userInteractor.DigestEnabled() {
+		go runDigestScheduler(ctx, userInteractor, botController, appLogger)
+		appLogger.Info("Daily digest scheduler started.")
+	}
+
+	go runPendingSaveFlusher(ctx, userInteractor, appLogger)
+
 	// Запуск polling'а Telegram бота
 	appLogger.Info("Starting Telegram Bot Polling...")
-	botController.StartPolling(ctx)
-
-	// Ожидание завершения
-	<-ctx.Done()
+	botController.StartPolling(ctx) // Возвращает управление после сигнала завершения
 	appLogger.Info("Application shutting down.")
 }
+
+// runPendingSaveFlusher раз в минуту повторяет сохранение историй, которые не удалось сохранить
+// после получения ответа модели.
+func runPendingSaveFlusher(ctx context.Context, userInteractor *usecases.UserInteractor, appLogger logger.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			saved, remaining := userInteractor.FlushPendingSaves(ctx)
+			if saved > 0 || remaining > 0 {
+				appLogger.Info("Flushed queued histories: %d saved, %d still pending.", saved, remaining)
+			}
+		}
+	}
+}
+
+// runDigestScheduler раз в минуту отправляет ежедневную сводку пользователям,
+// у которых по их местному времени наступило время рассылки.
+func runDigestScheduler(ctx context.Context, userInteractor *usecases.UserInteractor, botController *telegram_adapter.TelegramBotController, appLogger logger.Logger) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			sent, err := userInteractor.SendDueDigests(ctx, now, botController.SendDigest)
+			if err != nil {
+				appLogger.Error("Daily digest run failed: %v", err)
+			} else if sent > 0 {
+				appLogger.Info("Sent %d daily digest(s).", sent)
+			}
+		}
+	}
+}
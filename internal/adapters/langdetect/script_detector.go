@@ -0,0 +1,108 @@
+package langdetect
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// minLetters минимальное количество букв, при котором определение языка имеет смысл.
+const minLetters = 3
+
+// ScriptDetector является эвристической реализацией usecases.LanguageDetector.
+// Язык определяется по преобладающей письменности (кириллица, латиница, CJK и т.д.),
+// а уверенность - как доля букв этой письменности среди всех букв сообщения.
+type ScriptDetector struct{}
+
+// NewScriptDetector создает новый экземпляр ScriptDetector.
+func NewScriptDetector() *ScriptDetector {
+	return &ScriptDetector{}
+}
+
+// scriptLanguage связывает письменность Unicode с языком по умолчанию для нее.
+type scriptLanguage struct {
+	table    *unicode.RangeTable
+	language string
+}
+
+var scripts = []scriptLanguage{
+	{unicode.Cyrillic, "Russian"},
+	{unicode.Latin, "English"},
+	{unicode.Greek, "Greek"},
+	{unicode.Arabic, "Arabic"},
+	{unicode.Hebrew, "Hebrew"},
+	{unicode.Devanagari, "Hindi"},
+	{unicode.Hangul, "Korean"},
+	{unicode.Hiragana, "Japanese"},
+	{unicode.Katakana, "Japanese"},
+	{unicode.Han, "Chinese"},
+	{unicode.Thai, "Thai"},
+	{unicode.Georgian, "Georgian"},
+	{unicode.Armenian, "Armenian"},
+}
+
+// Detect определяет язык текста по преобладающей письменности.
+func (d *ScriptDetector) Detect(text string) (string, float64) {
+	counts := make(map[string]int)
+	total := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		total++
+		for _, s := range scripts {
+			if unicode.Is(s.table, r) {
+				counts[s.language]++
+				break
+			}
+		}
+	}
+	if total < minLetters {
+		return "", 0
+	}
+
+	// Японский текст почти всегда содержит иероглифы, поэтому кана важнее Han.
+	if counts["Japanese"] > 0 {
+		counts["Japanese"] += counts["Chinese"]
+		delete(counts, "Chinese")
+	}
+
+	language, best := "", 0
+	for lang, count := range counts {
+		if count > best {
+			language, best = lang, count
+		}
+	}
+	if language == "" {
+		return "", 0
+	}
+
+	return refineLanguage(language, text), float64(best) / float64(total)
+}
+
+// refineLanguage уточняет язык внутри одной письменности по характерным буквам.
+func refineLanguage(language, text string) string {
+	lower := strings.ToLower(text)
+	switch language {
+	case "Russian":
+		if strings.ContainsAny(lower, "іїєґ") {
+			return "Ukrainian"
+		}
+	case "English":
+		switch {
+		case strings.ContainsAny(lower, "ñ¿¡"):
+			return "Spanish"
+		case strings.ContainsAny(lower, "äöüß"):
+			return "German"
+		case strings.ContainsAny(lower, "àâçèêëîïôùûœ"):
+			return "French"
+		case strings.ContainsAny(lower, "ąćęłńśźż"):
+			return "Polish"
+		}
+	}
+	return language
+}
+
+// Verify that ScriptDetector implements usecases.LanguageDetector
+var _ usecases.LanguageDetector = (*ScriptDetector)(nil)
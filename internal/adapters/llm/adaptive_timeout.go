@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencyWindowSize = 50 // Количество последних задержек, по которым считается p95
+	minLatencySamples = 5  // До набора стольких замеров используется максимальный таймаут
+)
+
+// latencyTracker хранит задержки последних успешных запросов и вычисляет по ним адаптивный таймаут:
+// p95 задержки, умноженный на multiplier и ограниченный диапазоном [minTimeout, maxTimeout].
+type latencyTracker struct {
+	mu         sync.Mutex
+	samples    []time.Duration // Кольцевой буфер задержек
+	next       int             // Позиция следующей записи в буфере
+	multiplier float64
+	minTimeout time.Duration
+	maxTimeout time.Duration
+}
+
+// newLatencyTracker создает новый экземпляр latencyTracker.
+func newLatencyTracker(multiplier float64, minTimeout, maxTimeout time.Duration) *latencyTracker {
+	return &latencyTracker{
+		samples:    make([]time.Duration, 0, latencyWindowSize),
+		multiplier: multiplier,
+		minTimeout: minTimeout,
+		maxTimeout: maxTimeout,
+	}
+}
+
+// Record добавляет задержку успешного запроса, вытесняя самую старую при заполненном окне.
+func (t *latencyTracker) Record(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.samples) < latencyWindowSize {
+		t.samples = append(t.samples, latency)
+		return
+	}
+	t.samples[t.next] = latency
+	t.next = (t.next + 1) % latencyWindowSize
+}
+
+// Timeout возвращает таймаут для следующего запроса.
+func (t *latencyTracker) Timeout() time.Duration {
+	t.mu.Lock()
+	if len(t.samples) < minLatencySamples {
+		t.mu.Unlock()
+		return t.maxTimeout
+	}
+	sorted := append([]time.Duration(nil), t.samples...)
+	t.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	p95 := sorted[(len(sorted)*95+99)/100-1]
+	timeout := time.Duration(float64(p95) * t.multiplier)
+	if timeout < t.minTimeout {
+		return t.minTimeout
+	}
+	if timeout > t.maxTimeout {
+		return t.maxTimeout
+	}
+	return timeout
+}
+
+// withTimeout возвращает контекст с адаптивным таймаутом. Для nil-трекера контекст не изменяется.
+func (t *latencyTracker) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t == nil {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, t.Timeout())
+}
+
+// record добавляет задержку запроса, начатого в start. Для nil-трекера ничего не делает.
+func (t *latencyTracker) record(start time.Time) {
+	if t != nil {
+		t.Record(time.Since(start))
+	}
+}
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLatencyTrackerTimeout(t *testing.T) {
+	// seconds возвращает задержки с заданным числом секунд
+	seconds := func(values ...float64) []time.Duration {
+		latencies := make([]time.Duration, len(values))
+		for i, v := range values {
+			latencies[i] = time.Duration(v * float64(time.Second))
+		}
+		return latencies
+	}
+	repeat := func(latency float64, n int) []float64 {
+		values := make([]float64, n)
+		for i := range values {
+			values[i] = latency
+		}
+		return values
+	}
+	ramp := make([]float64, 20)
+	for i := range ramp {
+		ramp[i] = float64(i + 1) // 1s..20s
+	}
+
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		want      time.Duration
+	}{
+		{name: "no samples", latencies: nil, want: 60 * time.Second},
+		{name: "too few samples", latencies: seconds(1, 1, 1, 1), want: 60 * time.Second},
+		{name: "p95 of a ramp", latencies: seconds(ramp...), want: 38 * time.Second}, // p95 = 19s, x2
+		{name: "single outlier is ignored", latencies: seconds(append(repeat(2, 19), 100)...), want: 4 * time.Second},
+		{name: "clamped to the minimum", latencies: seconds(repeat(0.1, 10)...), want: time.Second},
+		{name: "clamped to the maximum", latencies: seconds(repeat(45, 10)...), want: 60 * time.Second},
+		{name: "old samples leave the window", latencies: seconds(append(repeat(50, latencyWindowSize), repeat(3, latencyWindowSize)...)...), want: 6 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newLatencyTracker(2, time.Second, 60*time.Second)
+			for _, latency := range tt.latencies {
+				tracker.Record(latency)
+			}
+			if got := tracker.Timeout(); got != tt.want {
+				t.Errorf("Timeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// CompositeGateway является реализацией usecases.ModelGateway, которая перебирает шлюзы по порядку:
+// если основной бэкенд вернул ошибку или не ответил вовремя, запрос повторяется на следующем.
+type CompositeGateway struct {
+	gateways []usecases.ModelGateway
+	logger   logger.Logger
+	lastUsed atomic.Int32 // Индекс шлюза, ответившего последним (для ModelName)
+}
+
+// NewCompositeGateway создает новый экземпляр CompositeGateway. Первый шлюз считается основным.
+func NewCompositeGateway(logger logger.Logger, gateways ...usecases.ModelGateway) *CompositeGateway {
+	return &CompositeGateway{gateways: gateways, logger: logger}
+}
+
+// GetModelResponse возвращает ответ первого шлюза, обработавшего запрос без ошибки.
+// Отмена запроса вызывающей стороной не приводит к переходу на следующий шлюз.
+func (g *CompositeGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	var errs []error
+	for i, gateway := range g.gateways {
+		response, err := gateway.GetModelResponse(ctx, messages, config)
+		if err == nil {
+			if i > 0 {
+				g.logger.Warn("Model response served by fallback backend %s", gatewayName(gateway, i))
+			}
+			g.lastUsed.Store(int32(i))
+			return response, nil
+		}
+		if ctx.Err() != nil {
+			return "", err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", gatewayName(gateway, i), err))
+		if i+1 < len(g.gateways) {
+			g.logger.Warn("Backend %s failed, falling back to %s: %v", gatewayName(gateway, i), gatewayName(g.gateways[i+1], i+1), err)
+		}
+	}
+	return "", fmt.Errorf("all model backends failed: %w", errors.Join(errs...))
+}
+
+// GetModelResponseStream передает ответ по частям от первого шлюза, обработавшего запрос без ошибки.
+// Шлюз без потоковой передачи отдает ответ одной частью. Если шлюз успел передать часть ответа,
+// переход на следующий шлюз не выполняется, чтобы не смешивать ответы разных бэкендов.
+func (g *CompositeGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	var errs []error
+	for i, gateway := range g.gateways {
+		response, err := streamResponse(ctx, gateway, messages, config, chunks)
+		if err == nil {
+			if i > 0 {
+				g.logger.Warn("Model response served by fallback backend %s", gatewayName(gateway, i))
+			}
+			g.lastUsed.Store(int32(i))
+			return response, nil
+		}
+		if ctx.Err() != nil || response != "" {
+			return response, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", gatewayName(gateway, i), err))
+		if i+1 < len(g.gateways) {
+			g.logger.Warn("Backend %s failed, falling back to %s: %v", gatewayName(gateway, i), gatewayName(g.gateways[i+1], i+1), err)
+		}
+	}
+	return "", fmt.Errorf("all model backends failed: %w", errors.Join(errs...))
+}
+
+// streamResponse запрашивает ответ шлюза по частям, а у шлюза без потоковой передачи - целиком.
+func streamResponse(ctx context.Context, gateway usecases.ModelGateway, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	if streamer, ok := gateway.(usecases.StreamingGateway); ok {
+		return streamer.GetModelResponseStream(ctx, messages, config, chunks)
+	}
+	response, err := gateway.GetModelResponse(ctx, messages, config)
+	if err != nil {
+		return "", err
+	}
+	select {
+	case chunks <- response:
+	case <-ctx.Done():
+		return response, ctx.Err()
+	}
+	return response, nil
+}
+
+// gatewayName возвращает название шлюза для логов.
+func gatewayName(gateway usecases.ModelGateway, index int) string {
+	if namer, ok := gateway.(usecases.ModelNamer); ok {
+		return namer.ModelName()
+	}
+	return fmt.Sprintf("#%d", index+1)
+}
+
+// ModelName возвращает название бэкенда, ответившего последним.
+func (g *CompositeGateway) ModelName() string {
+	index := int(g.lastUsed.Load())
+	return gatewayName(g.gateways[index], index)
+}
+
+// Info возвращает сведения об основном бэкенде и резервных адресах для команды /about.
+func (g *CompositeGateway) Info() usecases.GatewayInfo {
+	var info usecases.GatewayInfo
+	var fallbacks []string
+	for i, gateway := range g.gateways {
+		describer, ok := gateway.(usecases.GatewayDescriber)
+		if !ok {
+			continue
+		}
+		if i == 0 {
+			info = describer.Info()
+		} else {
+			fallbacks = append(fallbacks, describer.Info().BaseURL)
+		}
+	}
+	if len(fallbacks) > 0 {
+		info.BaseURL += " (fallback: " + strings.Join(fallbacks, ", ") + ")"
+	}
+	return info
+}
+
+// Warmup прогревает все шлюзы цепочки, поддерживающие прогрев, и возвращает первую ошибку.
+func (g *CompositeGateway) Warmup(ctx context.Context) error {
+	var firstErr error
+	for _, gateway := range g.gateways {
+		if warmer, ok := gateway.(Warmer); ok {
+			if err := warmer.Warmup(ctx); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Verify that CompositeGateway implements usecases.ModelGateway
+var (
+	_ usecases.ModelGateway     = (*CompositeGateway)(nil)
+	_ usecases.StreamingGateway = (*CompositeGateway)(nil)
+	_ usecases.ModelNamer       = (*CompositeGateway)(nil)
+	_ usecases.GatewayDescriber = (*CompositeGateway)(nil)
+	_ Warmer                    = (*CompositeGateway)(nil)
+)
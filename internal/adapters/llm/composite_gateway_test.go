@@ -0,0 +1,201 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// fakeGateway возвращает заданный ответ или ошибку и считает вызовы.
+type fakeGateway struct {
+	name     string
+	response string
+	err      error
+	calls    int
+}
+
+func (g *fakeGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	g.calls++
+	if g.err != nil {
+		return "", g.err
+	}
+	return g.response, nil
+}
+
+func (g *fakeGateway) ModelName() string { return g.name }
+
+// fakeStreamingGateway передает partial частью ответа, после чего возвращает err (или response без ошибки).
+type fakeStreamingGateway struct {
+	fakeGateway
+	partial string
+}
+
+func (g *fakeStreamingGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	g.calls++
+	if g.partial != "" {
+		chunks <- g.partial
+	}
+	if g.err != nil {
+		return g.partial, g.err
+	}
+	chunks <- g.response
+	return g.partial + g.response, nil
+}
+
+func TestCompositeGatewayFallthrough(t *testing.T) {
+	errDown := errors.New("backend down")
+	errTimeout := errors.New("timeout")
+	tests := []struct {
+		name      string
+		gateways  []*fakeGateway
+		want      string
+		wantErrs  []error // Ошибки, которые должен содержать результат (nil - без ошибки)
+		wantCalls []int
+		wantModel string
+	}{
+		{
+			name:      "primary answers",
+			gateways:  []*fakeGateway{{name: "primary", response: "one"}, {name: "backup", response: "two"}},
+			want:      "one",
+			wantCalls: []int{1, 0},
+			wantModel: "primary",
+		},
+		{
+			name:      "primary fails",
+			gateways:  []*fakeGateway{{name: "primary", err: errDown}, {name: "backup", response: "two"}},
+			want:      "two",
+			wantCalls: []int{1, 1},
+			wantModel: "backup",
+		},
+		{
+			name:      "two failures",
+			gateways:  []*fakeGateway{{name: "a", err: errDown}, {name: "b", err: errTimeout}, {name: "c", response: "three"}},
+			want:      "three",
+			wantCalls: []int{1, 1, 1},
+			wantModel: "c",
+		},
+		{
+			name:      "all fail",
+			gateways:  []*fakeGateway{{name: "a", err: errDown}, {name: "b", err: errTimeout}},
+			wantErrs:  []error{errDown, errTimeout},
+			wantCalls: []int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateways := make([]usecases.ModelGateway, len(tt.gateways))
+			for i, gateway := range tt.gateways {
+				gateways[i] = gateway
+			}
+			composite := NewCompositeGateway(discardLogger(), gateways...)
+
+			got, err := composite.GetModelResponse(context.Background(), nil, usecases.ModelConfig{})
+			for _, want := range tt.wantErrs {
+				if !errors.Is(err, want) {
+					t.Errorf("error = %v, want it to contain %v", err, want)
+				}
+			}
+			if tt.wantErrs == nil {
+				if err != nil {
+					t.Fatalf("GetModelResponse: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("GetModelResponse() = %q, want %q", got, tt.want)
+				}
+				if name := composite.ModelName(); name != tt.wantModel {
+					t.Errorf("ModelName() = %q, want %q", name, tt.wantModel)
+				}
+			}
+			for i, gateway := range tt.gateways {
+				if gateway.calls != tt.wantCalls[i] {
+					t.Errorf("gateway %s called %d times, want %d", gateway.name, gateway.calls, tt.wantCalls[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompositeGatewayCancelledRequestDoesNotFallThrough(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	primary := &fakeGateway{name: "primary", err: context.Canceled}
+	backup := &fakeGateway{name: "backup", response: "two"}
+	composite := NewCompositeGateway(discardLogger(), primary, backup)
+
+	if _, err := composite.GetModelResponse(ctx, nil, usecases.ModelConfig{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if backup.calls != 0 {
+		t.Errorf("backup called %d times after cancellation, want 0", backup.calls)
+	}
+}
+
+func TestCompositeGatewayStream(t *testing.T) {
+	errDown := errors.New("backend down")
+	tests := []struct {
+		name        string
+		primary     usecases.ModelGateway
+		want        string
+		wantErr     bool
+		wantChunks  []string
+		wantBackups int // Сколько раз вызван резервный шлюз
+	}{
+		{
+			name:       "streaming primary answers",
+			primary:    &fakeStreamingGateway{fakeGateway: fakeGateway{name: "primary", response: "one"}},
+			want:       "one",
+			wantChunks: []string{"one"},
+		},
+		{
+			name:        "failure before any text falls through",
+			primary:     &fakeStreamingGateway{fakeGateway: fakeGateway{name: "primary", err: errDown}},
+			want:        "backup reply",
+			wantChunks:  []string{"backup reply"},
+			wantBackups: 1,
+		},
+		{
+			name:       "failure after partial text keeps it",
+			primary:    &fakeStreamingGateway{fakeGateway: fakeGateway{name: "primary", err: errDown}, partial: "Once upon"},
+			want:       "Once upon",
+			wantErr:    true,
+			wantChunks: []string{"Once upon"},
+		},
+		{
+			name:        "non-streaming primary fails",
+			primary:     &fakeGateway{name: "primary", err: errDown},
+			want:        "backup reply",
+			wantChunks:  []string{"backup reply"},
+			wantBackups: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backup := &fakeGateway{name: "backup", response: "backup reply"}
+			composite := NewCompositeGateway(discardLogger(), tt.primary, backup)
+			chunks := make(chan string, 10)
+
+			got, err := composite.GetModelResponseStream(context.Background(), nil, usecases.ModelConfig{}, chunks)
+			close(chunks)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetModelResponseStream() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetModelResponseStream() = %q, want %q", got, tt.want)
+			}
+			var received []string
+			for chunk := range chunks {
+				received = append(received, chunk)
+			}
+			if !reflect.DeepEqual(received, tt.wantChunks) {
+				t.Errorf("chunks = %q, want %q", received, tt.wantChunks)
+			}
+			if backup.calls != tt.wantBackups {
+				t.Errorf("backup called %d times, want %d", backup.calls, tt.wantBackups)
+			}
+		})
+	}
+}
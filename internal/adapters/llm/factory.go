@@ -0,0 +1,21 @@
+package llm
+
+import (
+	"fmt"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// NewGateway создает шлюз для режима API mode: "chat" (или пустая строка) - /v1/chat/completions,
+// "completions" - устаревший /v1/completions.
+func NewGateway(mode, baseURL string, logger logger.Logger, opts GatewayOptions) (usecases.ModelGateway, error) {
+	switch mode {
+	case "", "chat":
+		return NewLlamaCppGatewayWithOptions(baseURL, logger, opts), nil
+	case "completions":
+		return NewLegacyCompletionGatewayWithOptions(baseURL, logger, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown API mode: %s", mode)
+	}
+}
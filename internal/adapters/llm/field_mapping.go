@@ -0,0 +1,50 @@
+package llm
+
+// FieldMapping переименовывает поля тела запроса для бэкендов, которые называют параметры иначе.
+// Ключ - имя поля, которое формирует шлюз (например, "max_tokens"), значение - имя поля бэкенда
+// (например, "num_predict"). Пустое значение убирает поле из запроса. Поля без записи передаются как есть.
+type FieldMapping map[string]string
+
+// fieldMappingPresets содержит готовые сопоставления для распространенных бэкендов.
+var fieldMappingPresets = map[string]FieldMapping{
+	"llamacpp": {},
+	"ollama": {
+		"max_tokens": "num_predict",
+	},
+	"openai": {
+		"top_k":          "", // Не поддерживаются OpenAI API
+		"repeat_penalty": "",
+	},
+}
+
+// FieldMappingPreset возвращает копию готового сопоставления по названию бэкенда ("llamacpp", "ollama", "openai").
+func FieldMappingPreset(name string) (FieldMapping, bool) {
+	preset, ok := fieldMappingPresets[name]
+	if !ok {
+		return nil, false
+	}
+	mapping := make(FieldMapping, len(preset))
+	for from, to := range preset {
+		mapping[from] = to
+	}
+	return mapping, true
+}
+
+// apply возвращает тело запроса с переименованными полями. Исходная карта не изменяется.
+func (m FieldMapping) apply(body map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return body
+	}
+	mapped := make(map[string]interface{}, len(body))
+	for key, value := range body {
+		name, ok := m[key]
+		if !ok {
+			name = key
+		}
+		if name == "" {
+			continue
+		}
+		mapped[name] = value
+	}
+	return mapped
+}
@@ -0,0 +1,90 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+func TestFieldMappingRequestKeys(t *testing.T) {
+	ollama, _ := FieldMappingPreset("ollama")
+	openai, _ := FieldMappingPreset("openai")
+	tests := []struct {
+		name     string
+		mapping  FieldMapping
+		wantKeys []string
+		renamed  map[string]interface{} // Значения переименованных полей
+	}{
+		{
+			name:     "no mapping",
+			mapping:  nil,
+			wantKeys: []string{"max_tokens", "messages", "repeat_penalty", "temperature", "top_k", "top_p"},
+		},
+		{
+			name:     "ollama preset",
+			mapping:  ollama,
+			wantKeys: []string{"messages", "num_predict", "repeat_penalty", "temperature", "top_k", "top_p"},
+			renamed:  map[string]interface{}{"num_predict": 256.0},
+		},
+		{
+			name:     "openai preset drops unsupported fields",
+			mapping:  openai,
+			wantKeys: []string{"max_tokens", "messages", "temperature", "top_p"},
+		},
+		{
+			name:     "custom mapping",
+			mapping:  FieldMapping{"max_tokens": "max_completion_tokens", "temperature": "temp", "top_k": "", "unknown": "ignored"},
+			wantKeys: []string{"max_completion_tokens", "messages", "repeat_penalty", "temp", "top_p"},
+			renamed:  map[string]interface{}{"max_completion_tokens": 256.0, "temp": 0.5},
+		},
+	}
+	messages := []domain.ChatMessage{domain.NewChatMessage(domain.UserRole, "Hello")}
+	config := usecases.ModelConfig{MaxTokens: 256, Temperature: 0.5, TopP: 0.9, TopK: 40, RepeatPenalty: 1.1}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultGatewayOptions()
+			opts.FieldMapping = tt.mapping
+			gateway := NewLlamaCppGatewayWithOptions("http://localhost", discardLogger(), opts)
+
+			body, err := gateway.marshalRequest(messages, config, false)
+			if err != nil {
+				t.Fatalf("marshalRequest: %v", err)
+			}
+			var request map[string]interface{}
+			if err := json.Unmarshal(body, &request); err != nil {
+				t.Fatalf("request body is not JSON: %v", err)
+			}
+			keys := make([]string, 0, len(request))
+			for key := range request {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("request keys = %v, want %v", keys, tt.wantKeys)
+			}
+			for key, want := range tt.renamed {
+				if request[key] != want {
+					t.Errorf("%s = %v, want %v", key, request[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldMappingPreset(t *testing.T) {
+	if _, ok := FieldMappingPreset("unknown"); ok {
+		t.Error("FieldMappingPreset(\"unknown\") reported a preset")
+	}
+	mapping, ok := FieldMappingPreset("ollama")
+	if !ok {
+		t.Fatal("FieldMappingPreset(\"ollama\") reported no preset")
+	}
+	mapping["max_tokens"] = "changed"
+	if again, _ := FieldMappingPreset("ollama"); again["max_tokens"] != "num_predict" {
+		t.Errorf("changing a returned preset changed the built-in one: max_tokens = %q", again["max_tokens"])
+	}
+}
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// CompletionChoice представляет выбор ответа в устаревшем API /v1/completions.
+type CompletionChoice struct {
+	Text string `json:"text"`
+}
+
+// CompletionResponse представляет ответ устаревшего API /v1/completions.
+type CompletionResponse struct {
+	Choices []CompletionChoice `json:"choices"`
+}
+
+// LegacyCompletionGateway является реализацией usecases.ModelGateway для серверов,
+// предоставляющих только устаревший OpenAI-совместимый эндпоинт /v1/completions.
+// История чата собирается в единый текстовый промпт.
+type LegacyCompletionGateway struct {
+	httpClient *http.Client
+	logger     logger.Logger
+	baseURL    string // Базовый URL сервера
+	model      string // Название модели (пустая строка - модель по умолчанию на сервере)
+
+	latency      *latencyTracker // Адаптивный таймаут (nil - используется только таймаут HTTP-клиента)
+	fieldMapping FieldMapping    // Переименование полей запроса под бэкенд
+}
+
+// NewLegacyCompletionGateway создает новый экземпляр LegacyCompletionGateway.
+func NewLegacyCompletionGateway(baseURL string, logger logger.Logger, timeout time.Duration) *LegacyCompletionGateway {
+	opts := DefaultGatewayOptions()
+	opts.Timeout = timeout
+	return NewLegacyCompletionGatewayWithOptions(baseURL, logger, opts)
+}
+
+// NewLegacyCompletionGatewayWithOptions создает новый экземпляр LegacyCompletionGateway с заданными настройками.
+func NewLegacyCompletionGatewayWithOptions(baseURL string, logger logger.Logger, opts GatewayOptions) *LegacyCompletionGateway {
+	return &LegacyCompletionGateway{
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		logger:     logger,
+		baseURL:    baseURL,
+		model:      opts.Model,
+
+		latency:      opts.newLatencyTracker(),
+		fieldMapping: opts.FieldMapping,
+	}
+}
+
+// GetModelResponse отправляет запрос к /v1/completions и возвращает текст ответа модели.
+// Эндпоинт не поддерживает response_format, поэтому режим JSON обеспечивается только инструкцией в промпте.
+func (g *LegacyCompletionGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	requestBody := map[string]interface{}{
+		"prompt":            renderPrompt(messages),
+		"max_tokens":        config.MaxTokens,
+		"temperature":       config.Temperature,
+		"top_p":             config.TopP,
+		"presence_penalty":  config.PresencePenalty,
+		"frequency_penalty": config.FrequencyPenalty,
+		// Не даем модели писать за пользователя; добавляются стоп-последовательности из параметров
+		"stop": append([]string{"\nUser:", "\nSystem:"}, config.StopSequences...),
+	}
+
+	if g.model != "" {
+		requestBody["model"] = g.model
+	}
+
+	jsonBody, err := json.Marshal(g.fieldMapping.apply(requestBody))
+	if err != nil {
+		g.logger.Error("Failed to marshal request body: %v", err)
+		return "", fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/v1/completions", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		g.logger.Error("Failed to create HTTP request: %v", err)
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		g.logger.Error("HTTP Request Error to completions endpoint: %v", err)
+		return "", fmt.Errorf("HTTP request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		g.logger.Error("Completions endpoint returned non-OK status code: %d, Body: %s", resp.StatusCode, string(bodyBytes))
+		return "", &StatusError{Backend: "completions endpoint", StatusCode: resp.StatusCode}
+	}
+
+	var result CompletionResponse
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		g.logger.Error("Failed to decode completions response: %v", err)
+		return "", fmt.Errorf("failed to decode completions response: %w", err)
+	}
+	g.latency.record(start)
+
+	if len(result.Choices) > 0 {
+		return strings.TrimSpace(result.Choices[0].Text), nil
+	}
+
+	return "", fmt.Errorf("no response choices from completions endpoint")
+}
+
+// renderPrompt собирает сообщения чата в единый текстовый промпт, заканчивающийся репликой ассистента.
+func renderPrompt(messages []domain.ChatMessage) string {
+	var sb strings.Builder
+	for _, msg := range messages {
+		switch msg.Role {
+		case domain.System.String():
+			sb.WriteString("System: ")
+		case domain.Assistant.String():
+			sb.WriteString("Assistant: ")
+		default:
+			sb.WriteString("User: ")
+		}
+		sb.WriteString(msg.Content)
+		sb.WriteString("\n")
+	}
+	sb.WriteString("Assistant:")
+	return sb.String()
+}
+
+// ModelName возвращает название бэкенда для метаданных сообщений.
+func (g *LegacyCompletionGateway) ModelName() string {
+	if g.model != "" {
+		return g.model + " (completions)"
+	}
+	return "completions (" + g.baseURL + ")"
+}
+
+// Info возвращает сведения о бэкенде для команды /about.
+func (g *LegacyCompletionGateway) Info() usecases.GatewayInfo {
+	return usecases.GatewayInfo{Backend: "completions", Model: g.model, BaseURL: g.baseURL}
+}
+
+// Verify that LegacyCompletionGateway implements usecases.ModelGateway
+var (
+	_ usecases.ModelGateway     = (*LegacyCompletionGateway)(nil)
+	_ usecases.GatewayDescriber = (*LegacyCompletionGateway)(nil)
+)
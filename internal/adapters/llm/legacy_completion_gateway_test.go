@@ -0,0 +1,114 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// discardLogger возвращает логгер без вывода для тестов шлюзов.
+func discardLogger() logger.Logger {
+	return logger.NewWriterLogger(io.Discard, logger.None)
+}
+
+func TestLegacyCompletionGateway(t *testing.T) {
+	messages := []domain.ChatMessage{
+		domain.NewChatMessage(domain.System, "You are a pirate."),
+		domain.NewChatMessage(domain.UserRole, "Hello"),
+		domain.NewChatMessage(domain.Assistant, "Ahoy!"),
+		domain.NewChatMessage(domain.UserRole, "Where is the treasure?"),
+	}
+	config := usecases.ModelConfig{MaxTokens: 128, Temperature: 0.8, TopP: 0.95, StopSequences: []string{"Captain:"}}
+
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		want     string
+		wantErr  bool
+		wantCode int // Ожидаемый код StatusError (0 - не проверять)
+	}{
+		{
+			name:   "legacy response shape",
+			status: http.StatusOK,
+			body:   `{"id":"cmpl-1","object":"text_completion","created":1700000000,"model":"test","choices":[{"text":" Buried on the island.\n","index":0,"logprobs":null,"finish_reason":"stop"}],"usage":{"prompt_tokens":20,"completion_tokens":5,"total_tokens":25}}`,
+			want:   "Buried on the island.",
+		},
+		{name: "no choices", status: http.StatusOK, body: `{"choices":[]}`, wantErr: true},
+		{name: "malformed body", status: http.StatusOK, body: `{"choices":`, wantErr: true},
+		{name: "server error", status: http.StatusServiceUnavailable, body: `{"error":"loading model"}`, wantErr: true, wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/v1/completions" {
+					t.Errorf("request %s %s, want POST /v1/completions", r.Method, r.URL.Path)
+				}
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			opts := DefaultGatewayOptions()
+			opts.Timeout = 5 * time.Second
+			opts.Model = "test-model"
+			gateway, err := NewGateway("completions", server.URL, discardLogger(), opts)
+			if err != nil {
+				t.Fatalf("NewGateway: %v", err)
+			}
+			if _, ok := gateway.(*LegacyCompletionGateway); !ok {
+				t.Fatalf("NewGateway(\"completions\") returned %T, want *LegacyCompletionGateway", gateway)
+			}
+
+			got, err := gateway.GetModelResponse(context.Background(), messages, config)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetModelResponse() = %q, want an error", got)
+				}
+				var statusErr *StatusError
+				if tt.wantCode != 0 && (!errors.As(err, &statusErr) || statusErr.StatusCode != tt.wantCode) {
+					t.Errorf("error = %v, want StatusError with code %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetModelResponse: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("GetModelResponse() = %q, want %q", got, tt.want)
+			}
+
+			wantPrompt := "System: You are a pirate.\nUser: Hello\nAssistant: Ahoy!\nUser: Where is the treasure?\nAssistant:"
+			if request["prompt"] != wantPrompt {
+				t.Errorf("prompt = %q, want %q", request["prompt"], wantPrompt)
+			}
+			for key, want := range map[string]interface{}{"max_tokens": 128.0, "temperature": 0.8, "top_p": 0.95, "model": "test-model"} {
+				if request[key] != want {
+					t.Errorf("%s = %v, want %v", key, request[key], want)
+				}
+			}
+			wantStop := []interface{}{"\nUser:", "\nSystem:", "Captain:"}
+			if !reflect.DeepEqual(request["stop"], wantStop) {
+				t.Errorf("stop = %q, want %q", request["stop"], wantStop)
+			}
+			if _, ok := request["messages"]; ok {
+				t.Error("legacy request must not contain chat messages")
+			}
+		})
+	}
+}
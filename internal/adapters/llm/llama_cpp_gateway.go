@@ -35,46 +35,48 @@ type LlamaCppGateway struct {
 	httpClient *http.Client
 	logger     logger.Logger
 	baseURL    string // Базовый URL для llama-server
+	model      string // Название модели (пустая строка - модель по умолчанию на сервере)
+
+	mergeConsecutiveRoles bool            // Объединять идущие подряд сообщения с одной ролью
+	latency               *latencyTracker // Адаптивный таймаут (nil - используется только таймаут HTTP-клиента)
+	fieldMapping          FieldMapping    // Переименование полей запроса под бэкенд
 }
 
 // NewLlamaCppGateway создает новый экземпляр LlamaCppGateway.
 func NewLlamaCppGateway(baseURL string, logger logger.Logger, timeout time.Duration) *LlamaCppGateway {
+	opts := DefaultGatewayOptions()
+	opts.Timeout = timeout
+	return NewLlamaCppGatewayWithOptions(baseURL, logger, opts)
+}
+
+// NewLlamaCppGatewayWithOptions создает новый экземпляр LlamaCppGateway с заданными настройками.
+func NewLlamaCppGatewayWithOptions(baseURL string, logger logger.Logger, opts GatewayOptions) *LlamaCppGateway {
 	return &LlamaCppGateway{
-		httpClient: &http.Client{Timeout: timeout},
+		httpClient: &http.Client{Timeout: opts.Timeout},
 		logger:     logger,
 		baseURL:    baseURL,
+		model:      opts.Model,
+
+		mergeConsecutiveRoles: opts.MergeConsecutiveRoles,
+		latency:               opts.newLatencyTracker(),
+		fieldMapping:          opts.FieldMapping,
 	}
 }
 
 // GetModelResponse отправляет запрос к llama-server и возвращает ответ модели.
 func (g *LlamaCppGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
-	// Преобразуем domain.ChatMessage в ChatCompletionMessage для запроса
-	apiMessages := make([]ChatCompletionMessage, len(messages))
-	for i, msg := range messages {
-		apiMessages[i] = ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
-
-	requestBody := map[string]interface{}{
-		"messages":       apiMessages,
-		"temperature":    config.Temperature,
-		"top_p":          config.TopP,
-		"top_k":          config.TopK,
-		"max_tokens":     config.MaxTokens,
-		"repeat_penalty": config.RepeatPenalty,
-		// "min_p": config.MinP, // Llama.cpp doesn't directly support min_p in this API
-		// "presence_penalty": config.PresencePenalty, // Not directly supported
-		// "frequency_penalty": config.FrequencyPenalty, // Not directly supported
-		// "stop": config.StopSequences, // Uncomment if you add stop sequences to ModelConfig
+	if g.mergeConsecutiveRoles {
+		messages = mergeConsecutiveRoles(messages)
 	}
 
-	jsonBody, err := json.Marshal(requestBody)
+	jsonBody, err := g.marshalRequest(messages, config, false)
 	if err != nil {
-		g.logger.Error("Failed to marshal request body: %v", err)
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return "", err
 	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/v1/chat/completions", bytes.NewBuffer(jsonBody))
 	if err != nil {
@@ -83,6 +85,7 @@ func (g *LlamaCppGateway) GetModelResponse(ctx context.Context, messages []domai
 	}
 	req.Header.Set("Content-Type", "application/json")
 
+	start := time.Now()
 	resp, err := g.httpClient.Do(req)
 	if err != nil {
 		g.logger.Error("HTTP Request Error to Llama-server: %v", err)
@@ -90,10 +93,17 @@ func (g *LlamaCppGateway) GetModelResponse(ctx context.Context, messages []domai
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusBadRequest && config.JSONMode {
+		// Бэкенд не поддерживает response_format: повторяем без него, полагаясь на инструкцию в промпте
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		g.logger.Warn("Llama-server rejected response_format, retrying without JSON mode: %s", string(bodyBytes))
+		config.JSONMode = false
+		return g.GetModelResponse(ctx, messages, config)
+	}
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		g.logger.Error("Llama-server returned non-OK status code: %d, Body: %s", resp.StatusCode, string(bodyBytes))
-		return "", fmt.Errorf("llama-server returned non-OK status code: %d", resp.StatusCode)
+		return "", &StatusError{Backend: "llama-server", StatusCode: resp.StatusCode}
 	}
 
 	var result ChatCompletionResponse
@@ -102,6 +112,7 @@ func (g *LlamaCppGateway) GetModelResponse(ctx context.Context, messages []domai
 		g.logger.Error("Failed to decode Llama-server response: %v", err)
 		return "", fmt.Errorf("failed to decode Llama-server response: %w", err)
 	}
+	g.latency.record(start)
 
 	if len(result.Choices) > 0 {
 		return result.Choices[0].Message.Content, nil
@@ -110,5 +121,66 @@ func (g *LlamaCppGateway) GetModelResponse(ctx context.Context, messages []domai
 	return "", fmt.Errorf("no response choices from Llama-server")
 }
 
+// marshalRequest формирует тело запроса к /v1/chat/completions. stream включает потоковую передачу ответа.
+func (g *LlamaCppGateway) marshalRequest(messages []domain.ChatMessage, config usecases.ModelConfig, stream bool) ([]byte, error) {
+	// Преобразуем domain.ChatMessage в ChatCompletionMessage для запроса
+	apiMessages := make([]ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		apiMessages[i] = ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+	}
+
+	requestBody := map[string]interface{}{
+		"messages":       apiMessages,
+		"temperature":    config.Temperature,
+		"top_p":          config.TopP,
+		"top_k":          config.TopK,
+		"max_tokens":     config.MaxTokens,
+		"repeat_penalty": config.RepeatPenalty,
+		// "min_p": config.MinP, // Llama.cpp doesn't directly support min_p in this API
+		// "presence_penalty": config.PresencePenalty, // Not directly supported
+		// "frequency_penalty": config.FrequencyPenalty, // Not directly supported
+	}
+
+	if g.model != "" {
+		requestBody["model"] = g.model
+	}
+	if len(config.StopSequences) > 0 {
+		requestBody["stop"] = config.StopSequences
+	}
+	if config.JSONMode {
+		requestBody["response_format"] = map[string]string{"type": "json_object"}
+	}
+	if stream {
+		requestBody["stream"] = true
+	}
+
+	jsonBody, err := json.Marshal(g.fieldMapping.apply(requestBody))
+	if err != nil {
+		g.logger.Error("Failed to marshal request body: %v", err)
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return jsonBody, nil
+}
+
+// ModelName возвращает название бэкенда для метаданных сообщений.
+func (g *LlamaCppGateway) ModelName() string {
+	if g.model != "" {
+		return g.model + " (llama.cpp)"
+	}
+	return "llama.cpp (" + g.baseURL + ")"
+}
+
+// Info возвращает сведения о бэкенде для команды /about.
+func (g *LlamaCppGateway) Info() usecases.GatewayInfo {
+	return usecases.GatewayInfo{Backend: "llamacpp", Model: g.model, BaseURL: g.baseURL}
+}
+
 // Verify that LlamaCppGateway implements usecases.ModelGateway
-var _ usecases.ModelGateway = (*LlamaCppGateway)(nil)
+var (
+	_ usecases.ModelGateway     = (*LlamaCppGateway)(nil)
+	_ usecases.StreamingGateway = (*LlamaCppGateway)(nil)
+	_ usecases.GatewayDescriber = (*LlamaCppGateway)(nil)
+)
@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+func TestLlamaCppGatewayResponseFormat(t *testing.T) {
+	const reply = `{"choices":[{"message":{"role":"assistant","content":"{\"mood\":\"happy\"}"}}]}`
+	tests := []struct {
+		name         string
+		jsonMode     bool
+		rejectFormat bool // Сервер отвечает 400 на запросы с response_format (и на все запросы без режима JSON)
+		wantFormats  []bool
+	}{
+		{name: "JSON mode off", jsonMode: false, wantFormats: []bool{false}},
+		{name: "JSON mode on", jsonMode: true, wantFormats: []bool{true}},
+		{name: "unsupported response_format is dropped", jsonMode: true, rejectFormat: true, wantFormats: []bool{true, false}},
+		{name: "bad request without JSON mode is not retried", jsonMode: false, rejectFormat: true, wantFormats: []bool{false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var formats []bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request map[string]interface{}
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				format, hasFormat := request["response_format"]
+				if hasFormat {
+					if want := map[string]interface{}{"type": "json_object"}; !jsonEqual(format, want) {
+						t.Errorf("response_format = %v, want %v", format, want)
+					}
+				}
+				mu.Lock()
+				formats = append(formats, hasFormat)
+				mu.Unlock()
+				if tt.rejectFormat && (hasFormat || !tt.jsonMode) {
+					w.WriteHeader(http.StatusBadRequest)
+					io.WriteString(w, `{"error":"response_format is not supported"}`)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, reply)
+			}))
+			defer server.Close()
+
+			opts := DefaultGatewayOptions()
+			opts.Timeout = 5 * time.Second
+			gateway := NewLlamaCppGatewayWithOptions(server.URL, discardLogger(), opts)
+			config := usecases.ModelConfig{MaxTokens: 64, JSONMode: tt.jsonMode}
+			messages := []domain.ChatMessage{domain.NewChatMessage(domain.UserRole, "How are you?")}
+
+			got, err := gateway.GetModelResponse(context.Background(), messages, config)
+			wantErr := tt.rejectFormat && !tt.jsonMode
+			if (err != nil) != wantErr {
+				t.Fatalf("GetModelResponse() error = %v, want error %v", err, wantErr)
+			}
+			if !wantErr && got != `{"mood":"happy"}` {
+				t.Errorf("GetModelResponse() = %q, want the JSON reply", got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(formats) != len(tt.wantFormats) {
+				t.Fatalf("got %d requests %v, want %v", len(formats), formats, tt.wantFormats)
+			}
+			for i := range formats {
+				if formats[i] != tt.wantFormats[i] {
+					t.Errorf("request %d has response_format = %v, want %v", i+1, formats[i], tt.wantFormats[i])
+				}
+			}
+		})
+	}
+}
+
+// jsonEqual сравнивает значения после кодирования в JSON.
+func jsonEqual(a, b interface{}) bool {
+	first, err1 := json.Marshal(a)
+	second, err2 := json.Marshal(b)
+	return err1 == nil && err2 == nil && string(first) == string(second)
+}
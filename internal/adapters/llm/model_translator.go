@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// ModelTranslator является реализацией usecases.Translator, использующей ту же модель, что и чат.
+type ModelTranslator struct {
+	gateway usecases.ModelGateway
+	logger  logger.Logger
+}
+
+// NewModelTranslator создает новый экземпляр ModelTranslator.
+func NewModelTranslator(gateway usecases.ModelGateway, logger logger.Logger) *ModelTranslator {
+	return &ModelTranslator{
+		gateway: gateway,
+		logger:  logger,
+	}
+}
+
+// Translate переводит текст на язык с указанным кодом (например, "ru" или "en").
+func (t *ModelTranslator) Translate(ctx context.Context, text string, targetLanguage string) (string, error) {
+	instruction := fmt.Sprintf("Translate the user's text into the language with the IETF code %q. "+
+		"Keep placeholders like {{user}} and {{char}} unchanged. "+
+		"If the text is already in that language, return it unchanged. Output only the translation.", targetLanguage)
+	messages := []domain.ChatMessage{
+		domain.NewChatMessage(domain.System, instruction),
+		domain.NewChatMessage(domain.UserRole, text),
+	}
+	config := usecases.ModelConfig{
+		MaxTokens:     500,
+		Temperature:   0.1, // Перевод должен быть предсказуемым
+		TopP:          0.9,
+		RepeatPenalty: 1.0,
+	}
+
+	translation, err := t.gateway.GetModelResponse(ctx, messages, config)
+	if err != nil {
+		return "", fmt.Errorf("failed to translate text: %w", err)
+	}
+	translation = strings.TrimSpace(translation)
+	if translation == "" {
+		return "", fmt.Errorf("model returned an empty translation")
+	}
+	return translation, nil
+}
+
+// Verify that ModelTranslator implements usecases.Translator
+var _ usecases.Translator = (*ModelTranslator)(nil)
@@ -0,0 +1,20 @@
+package llm
+
+import (
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// mergeConsecutiveRoles объединяет идущие подряд сообщения с одинаковой ролью в одно,
+// чтобы роли чередовались, как требуют некоторые бэкенды (например, шаблоны чата llama.cpp).
+// Содержимое объединяемых сообщений разделяется пустой строкой.
+func mergeConsecutiveRoles(messages []domain.ChatMessage) []domain.ChatMessage {
+	merged := make([]domain.ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		if last := len(merged) - 1; last >= 0 && merged[last].Role == msg.Role {
+			merged[last].Content += "\n\n" + msg.Content
+			continue
+		}
+		merged = append(merged, msg)
+	}
+	return merged
+}
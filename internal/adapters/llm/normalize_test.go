@@ -0,0 +1,77 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+func TestMergeConsecutiveRoles(t *testing.T) {
+	messages := []domain.ChatMessage{
+		domain.NewChatMessage(domain.System, "rules"),
+		domain.NewChatMessage(domain.System, "prompt"),
+		domain.NewChatMessage(domain.UserRole, "hi"),
+		domain.NewChatMessage(domain.Assistant, "hello"),
+		domain.NewChatMessage(domain.UserRole, "one"),
+		domain.NewChatMessage(domain.UserRole, "two"),
+		domain.NewChatMessage(domain.System, "note"),
+	}
+	tests := []struct {
+		name  string
+		merge bool
+		want  []string // "роль: текст" сообщений в запросе
+	}{
+		{
+			name: "merging off", merge: false,
+			want: []string{"system: rules", "system: prompt", "user: hi", "assistant: hello", "user: one", "user: two", "system: note"},
+		},
+		{
+			name: "merging on", merge: true,
+			want: []string{"system: rules\n\nprompt", "user: hi", "assistant: hello", "user: one\n\ntwo", "system: note"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var request struct {
+					Messages []struct {
+						Role    string `json:"role"`
+						Content string `json:"content"`
+					} `json:"messages"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode request body: %v", err)
+				}
+				for _, msg := range request.Messages {
+					got = append(got, msg.Role+": "+msg.Content)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`)
+			}))
+			defer server.Close()
+
+			opts := DefaultGatewayOptions()
+			opts.Timeout = 5 * time.Second
+			opts.MergeConsecutiveRoles = tt.merge
+			gateway := NewLlamaCppGatewayWithOptions(server.URL, discardLogger(), opts)
+			if _, err := gateway.GetModelResponse(context.Background(), messages, usecases.ModelConfig{MaxTokens: 16}); err != nil {
+				t.Fatalf("GetModelResponse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request messages = %q, want %q", got, tt.want)
+			}
+			if messages[0].Content != "rules" || messages[4].Content != "one" {
+				t.Errorf("caller's messages were modified: %+v", messages)
+			}
+		})
+	}
+}
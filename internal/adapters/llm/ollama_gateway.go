@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// DefaultOllamaBaseURL адрес Ollama, используемый, если базовый URL не задан.
+const DefaultOllamaBaseURL = "http://localhost:11434"
+
+// OllamaChatChunk представляет одну строку потокового ответа /api/chat (NDJSON).
+type OllamaChatChunk struct {
+	Message ChatCompletionMessage `json:"message"`
+	Done    bool                  `json:"done"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// OllamaGateway является реализацией usecases.ModelGateway для собственного API Ollama (/api/chat).
+// Ответ всегда запрашивается потоком и собирается из частей до строки с "done": true.
+type OllamaGateway struct {
+	httpClient *http.Client
+	logger     logger.Logger
+	baseURL    string // Базовый URL сервера Ollama
+	model      string // Название модели, обязательное для /api/chat
+
+	mergeConsecutiveRoles bool            // Объединять идущие подряд сообщения с одной ролью
+	latency               *latencyTracker // Адаптивный таймаут (nil - используется только таймаут HTTP-клиента)
+}
+
+// NewOllamaGateway создает новый экземпляр OllamaGateway. Пустой baseURL означает DefaultOllamaBaseURL.
+func NewOllamaGateway(baseURL, model string, logger logger.Logger, opts GatewayOptions) (*OllamaGateway, error) {
+	if model == "" {
+		return nil, errors.New("Ollama model name is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultOllamaBaseURL
+	}
+	return &OllamaGateway{
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		logger:     logger,
+		baseURL:    baseURL,
+		model:      model,
+
+		mergeConsecutiveRoles: opts.MergeConsecutiveRoles,
+		latency:               opts.newLatencyTracker(),
+	}, nil
+}
+
+// GetModelResponse отправляет запрос к /api/chat и возвращает ответ модели целиком.
+func (g *OllamaGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	return g.chat(ctx, messages, config, nil)
+}
+
+// GetModelResponseStream отправляет запрос к /api/chat и передает в chunks части ответа по мере их получения.
+func (g *OllamaGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	return g.chat(ctx, messages, config, chunks)
+}
+
+// chat выполняет запрос к /api/chat и читает поток NDJSON до "done": true. Если chunks не nil,
+// в него передается каждая часть ответа. Если поток оборвался, возвращается уже полученный текст вместе с ошибкой.
+func (g *OllamaGateway) chat(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	if g.mergeConsecutiveRoles {
+		messages = mergeConsecutiveRoles(messages)
+	}
+
+	jsonBody, err := g.marshalRequest(messages, config)
+	if err != nil {
+		return "", err
+	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/api/chat", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		g.logger.Error("Failed to create HTTP request: %v", err)
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		g.logger.Error("HTTP Request Error to Ollama: %v", err)
+		return "", fmt.Errorf("HTTP request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		var chunk OllamaChatChunk
+		if json.Unmarshal(bodyBytes, &chunk) == nil && chunk.Error != "" {
+			bodyBytes = []byte(chunk.Error)
+		}
+		g.logger.Error("Ollama returned non-OK status code: %d, Body: %s", resp.StatusCode, string(bodyBytes))
+		return "", &StatusError{Backend: "ollama", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+
+	var response strings.Builder
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+	for !done && scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var chunk OllamaChatChunk
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			g.logger.Error("Failed to decode Ollama stream line: %v", err)
+			return response.String(), fmt.Errorf("failed to decode Ollama stream line: %w", err)
+		}
+		if chunk.Error != "" {
+			g.logger.Error("Ollama reported an error after %d bytes: %s", response.Len(), chunk.Error)
+			return response.String(), fmt.Errorf("ollama error: %s", chunk.Error)
+		}
+		done = chunk.Done
+		if chunk.Message.Content == "" {
+			continue
+		}
+		response.WriteString(chunk.Message.Content)
+		if chunks == nil {
+			continue
+		}
+		select {
+		case chunks <- chunk.Message.Content:
+		case <-ctx.Done():
+			return response.String(), ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		g.logger.Error("Ollama stream interrupted after %d bytes: %v", response.Len(), err)
+		return response.String(), fmt.Errorf("ollama stream interrupted: %w", err)
+	}
+	if !done {
+		return response.String(), fmt.Errorf("ollama stream ended without a final message")
+	}
+	g.latency.record(start)
+
+	if response.Len() == 0 {
+		return "", fmt.Errorf("no response content from Ollama")
+	}
+	return response.String(), nil
+}
+
+// marshalRequest формирует тело запроса к /api/chat. Параметры генерации передаются в "options"
+// под именами Ollama (max_tokens → num_predict).
+func (g *OllamaGateway) marshalRequest(messages []domain.ChatMessage, config usecases.ModelConfig) ([]byte, error) {
+	apiMessages := make([]ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		apiMessages[i] = ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+	}
+
+	options := map[string]interface{}{
+		"temperature":       config.Temperature,
+		"top_p":             config.TopP,
+		"top_k":             config.TopK,
+		"min_p":             config.MinP,
+		"repeat_penalty":    config.RepeatPenalty,
+		"presence_penalty":  config.PresencePenalty,
+		"frequency_penalty": config.FrequencyPenalty,
+		"num_predict":       config.MaxTokens,
+	}
+	if len(config.StopSequences) > 0 {
+		options["stop"] = config.StopSequences
+	}
+	requestBody := map[string]interface{}{
+		"model":    g.model,
+		"messages": apiMessages,
+		"options":  options,
+		"stream":   true,
+	}
+	if config.JSONMode {
+		requestBody["format"] = "json"
+	}
+
+	jsonBody, err := json.Marshal(requestBody)
+	if err != nil {
+		g.logger.Error("Failed to marshal request body: %v", err)
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return jsonBody, nil
+}
+
+// ModelName возвращает название бэкенда для метаданных сообщений.
+func (g *OllamaGateway) ModelName() string {
+	return g.model + " (Ollama)"
+}
+
+// Info возвращает сведения о бэкенде для команды /about.
+func (g *OllamaGateway) Info() usecases.GatewayInfo {
+	return usecases.GatewayInfo{Backend: "ollama", Model: g.model, BaseURL: g.baseURL}
+}
+
+// Verify that OllamaGateway implements usecases.ModelGateway
+var (
+	_ usecases.ModelGateway     = (*OllamaGateway)(nil)
+	_ usecases.StreamingGateway = (*OllamaGateway)(nil)
+	_ usecases.GatewayDescriber = (*OllamaGateway)(nil)
+)
@@ -0,0 +1,237 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+const (
+	// DefaultOpenAIBaseURL адрес OpenAI API, используемый, если базовый URL не задан.
+	DefaultOpenAIBaseURL = "https://api.openai.com"
+
+	// maxOpenAIStopSequences максимальное количество стоп-последовательностей, которое принимает OpenAI API.
+	maxOpenAIStopSequences = 4
+)
+
+// OpenAIError представляет ошибку из конверта {"error": {...}} ответа OpenAI API.
+type OpenAIError struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+	Type       string `json:"type"`
+	Code       string `json:"code"`
+}
+
+// Error возвращает текст ошибки.
+func (e *OpenAIError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("openai API error %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("openai API error %d: %s", e.StatusCode, e.Message)
+}
+
+// OpenAIGateway является реализацией usecases.ModelGateway для OpenAI API (/v1/chat/completions)
+// с авторизацией по ключу API.
+type OpenAIGateway struct {
+	httpClient *http.Client
+	logger     logger.Logger
+	baseURL    string // Базовый URL API
+	apiKey     string // Ключ API для заголовка Authorization
+	model      string // Название модели, обязательное для OpenAI API
+
+	latency      *latencyTracker // Адаптивный таймаут (nil - используется только таймаут HTTP-клиента)
+	fieldMapping FieldMapping    // Переименование полей запроса (например, max_tokens → max_completion_tokens)
+}
+
+// NewOpenAIGateway создает новый экземпляр OpenAIGateway. Пустой baseURL означает DefaultOpenAIBaseURL;
+// model переопределяет opts.Model.
+func NewOpenAIGateway(baseURL, apiKey, model string, logger logger.Logger, opts GatewayOptions) (*OpenAIGateway, error) {
+	if apiKey == "" {
+		return nil, errors.New("OpenAI API key is required")
+	}
+	if model == "" {
+		return nil, errors.New("OpenAI model name is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultOpenAIBaseURL
+	}
+	return &OpenAIGateway{
+		httpClient: &http.Client{Timeout: opts.Timeout},
+		logger:     logger,
+		baseURL:    baseURL,
+		apiKey:     apiKey,
+		model:      model,
+
+		latency:      opts.newLatencyTracker(),
+		fieldMapping: opts.FieldMapping,
+	}, nil
+}
+
+// GetModelResponse отправляет запрос к OpenAI API и возвращает ответ модели.
+func (g *OpenAIGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	jsonBody, err := g.marshalRequest(messages, config, false)
+	if err != nil {
+		return "", err
+	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := g.post(ctx, jsonBody, false)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result ChatCompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		g.logger.Error("Failed to decode OpenAI response: %v", err)
+		return "", fmt.Errorf("failed to decode OpenAI response: %w", err)
+	}
+	g.latency.record(start)
+
+	if len(result.Choices) > 0 {
+		return result.Choices[0].Message.Content, nil
+	}
+	return "", fmt.Errorf("no response choices from OpenAI")
+}
+
+// GetModelResponseStream отправляет запрос с "stream": true и передает в chunks части ответа по мере их получения.
+func (g *OpenAIGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	jsonBody, err := g.marshalRequest(messages, config, true)
+	if err != nil {
+		return "", err
+	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := g.post(ctx, jsonBody, true)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	response, err := readChatStream(ctx, resp.Body, chunks)
+	if err != nil {
+		if ctx.Err() == nil {
+			g.logger.Error("OpenAI stream failed after %d bytes: %v", len(response), err)
+			err = fmt.Errorf("openai stream: %w", err)
+		}
+		return response, err
+	}
+	g.latency.record(start)
+
+	if response == "" {
+		return "", fmt.Errorf("no response content in OpenAI stream")
+	}
+	return response, nil
+}
+
+// post отправляет тело запроса на /v1/chat/completions. Ответ с кодом, отличным от 200,
+// закрывается и возвращается как *OpenAIError.
+func (g *OpenAIGateway) post(ctx context.Context, jsonBody []byte, stream bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		g.logger.Error("Failed to create HTTP request: %v", err)
+		return nil, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		g.logger.Error("HTTP Request Error to OpenAI: %v", err)
+		return nil, fmt.Errorf("HTTP request error: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	apiErr := &OpenAIError{StatusCode: resp.StatusCode}
+	var envelope struct {
+		Error *OpenAIError `json:"error"`
+	}
+	if json.Unmarshal(bodyBytes, &envelope) == nil && envelope.Error != nil {
+		apiErr.Message, apiErr.Type, apiErr.Code = envelope.Error.Message, envelope.Error.Type, envelope.Error.Code
+	} else {
+		apiErr.Message = string(bodyBytes)
+	}
+	g.logger.Error("OpenAI returned status code %d: %s", resp.StatusCode, apiErr.Message)
+	return nil, apiErr
+}
+
+// marshalRequest формирует тело запроса к /v1/chat/completions с параметрами, которые поддерживает OpenAI API.
+func (g *OpenAIGateway) marshalRequest(messages []domain.ChatMessage, config usecases.ModelConfig, stream bool) ([]byte, error) {
+	apiMessages := make([]ChatCompletionMessage, len(messages))
+	for i, msg := range messages {
+		apiMessages[i] = ChatCompletionMessage{
+			Role:    msg.Role,
+			Content: msg.Content,
+		}
+	}
+
+	requestBody := map[string]interface{}{
+		"model":             g.model,
+		"messages":          apiMessages,
+		"temperature":       config.Temperature,
+		"top_p":             config.TopP,
+		"max_tokens":        config.MaxTokens,
+		"presence_penalty":  config.PresencePenalty,
+		"frequency_penalty": config.FrequencyPenalty,
+	}
+	if stops := config.StopSequences; len(stops) > 0 {
+		if len(stops) > maxOpenAIStopSequences {
+			stops = stops[len(stops)-maxOpenAIStopSequences:] // Стандартные стоп-строки с именем пользователя идут последними
+		}
+		requestBody["stop"] = stops
+	}
+	if config.JSONMode {
+		requestBody["response_format"] = map[string]string{"type": "json_object"}
+	}
+	if stream {
+		requestBody["stream"] = true
+	}
+
+	jsonBody, err := json.Marshal(g.fieldMapping.apply(requestBody))
+	if err != nil {
+		g.logger.Error("Failed to marshal request body: %v", err)
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
+	}
+	return jsonBody, nil
+}
+
+// ModelName возвращает название бэкенда для метаданных сообщений.
+func (g *OpenAIGateway) ModelName() string {
+	return g.model + " (OpenAI)"
+}
+
+// Info возвращает сведения о бэкенде для команды /about.
+func (g *OpenAIGateway) Info() usecases.GatewayInfo {
+	return usecases.GatewayInfo{Backend: "openai", Model: g.model, BaseURL: g.baseURL}
+}
+
+// Verify that OpenAIGateway implements usecases.ModelGateway
+var (
+	_ usecases.ModelGateway     = (*OpenAIGateway)(nil)
+	_ usecases.StreamingGateway = (*OpenAIGateway)(nil)
+	_ usecases.GatewayDescriber = (*OpenAIGateway)(nil)
+)
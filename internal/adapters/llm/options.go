@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"time"
+)
+
+// GatewayOptions содержит общие настройки HTTP-шлюзов к моделям.
+// Новые настройки добавляются сюда с разумным значением по умолчанию, не меняя сигнатуры конструкторов.
+type GatewayOptions struct {
+	Timeout time.Duration // Таймаут HTTP-запроса к серверу модели
+	Model   string        // Название модели для поля "model" запроса (пустая строка - модель по умолчанию на сервере)
+
+	// MergeConsecutiveRoles объединяет идущие подряд сообщения с одной ролью перед отправкой
+	// (для бэкендов, требующих строгого чередования ролей).
+	MergeConsecutiveRoles bool
+
+	// AdaptiveTimeout включает таймаут запроса, равный p95 недавних задержек, умноженному на TimeoutMultiplier,
+	// в пределах от MinTimeout до Timeout.
+	AdaptiveTimeout   bool
+	MinTimeout        time.Duration
+	TimeoutMultiplier float64
+
+	// FieldMapping переименовывает поля тела запроса под конкретный бэкенд (nil - имена llama.cpp).
+	FieldMapping FieldMapping
+
+	// Retries сколько раз повторять запрос при сетевой ошибке или ответе 5xx (0 - не повторять), см. WithRetries.
+	// RetryDelay пауза перед первым повтором; каждая следующая вдвое длиннее.
+	Retries    int
+	RetryDelay time.Duration
+}
+
+// DefaultGatewayOptions возвращает настройки шлюза по умолчанию.
+func DefaultGatewayOptions() GatewayOptions {
+	return GatewayOptions{
+		Timeout:           60 * time.Second,
+		MinTimeout:        10 * time.Second,
+		TimeoutMultiplier: 3,
+		Retries:           3,
+		RetryDelay:        250 * time.Millisecond,
+	}
+}
+
+// newLatencyTracker возвращает трекер задержек для адаптивного таймаута или nil, если он отключен.
+func (o GatewayOptions) newLatencyTracker() *latencyTracker {
+	if !o.AdaptiveTimeout {
+		return nil
+	}
+	return newLatencyTracker(o.TimeoutMultiplier, o.MinTimeout, o.Timeout)
+}
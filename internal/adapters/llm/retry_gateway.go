@@ -0,0 +1,155 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// StatusError возвращается шлюзами, когда сервер модели ответил кодом, отличным от 200.
+type StatusError struct {
+	Backend    string // Название сервера для текста ошибки, например "llama-server"
+	StatusCode int
+	Message    string // Сообщение об ошибке из ответа сервера (может быть пустым)
+}
+
+// Error возвращает текст ошибки.
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s returned status code %d: %s", e.Backend, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s returned non-OK status code: %d", e.Backend, e.StatusCode)
+}
+
+// RetryGateway является реализацией usecases.ModelGateway, которая повторяет запрос к шлюзу при временных
+// сбоях: сетевых ошибках (кроме таймаутов) и ответах 5xx. Пауза перед повтором удваивается с каждой попыткой
+// и увеличивается на случайную долю, чтобы повторы разных пользователей не совпадали. Ошибки 4xx не повторяются.
+type RetryGateway struct {
+	gateway   usecases.ModelGateway
+	logger    logger.Logger
+	retries   int           // Максимум повторов после первой попытки
+	baseDelay time.Duration // Пауза перед первым повтором
+}
+
+// NewRetryGateway создает новый экземпляр RetryGateway вокруг gateway.
+func NewRetryGateway(gateway usecases.ModelGateway, logger logger.Logger, retries int, baseDelay time.Duration) *RetryGateway {
+	return &RetryGateway{gateway: gateway, logger: logger, retries: retries, baseDelay: baseDelay}
+}
+
+// WithRetries оборачивает шлюз в RetryGateway согласно opts.Retries и opts.RetryDelay
+// или возвращает его без изменений, если повторы отключены.
+func WithRetries(gateway usecases.ModelGateway, logger logger.Logger, opts GatewayOptions) usecases.ModelGateway {
+	if opts.Retries <= 0 {
+		return gateway
+	}
+	return NewRetryGateway(gateway, logger, opts.Retries, opts.RetryDelay)
+}
+
+// GetModelResponse возвращает ответ шлюза, повторяя запрос при временных сбоях.
+func (g *RetryGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig) (string, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := g.gateway.GetModelResponse(ctx, messages, config)
+		if err == nil || !g.shouldRetry(ctx, attempt, err) {
+			return response, err
+		}
+		if err := g.wait(ctx, attempt, err); err != nil {
+			return "", err
+		}
+	}
+}
+
+// GetModelResponseStream передает ответ шлюза по частям, повторяя запрос при временных сбоях.
+// Если шлюз успел передать часть ответа, запрос не повторяется, чтобы не дублировать текст.
+func (g *RetryGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := streamResponse(ctx, g.gateway, messages, config, chunks)
+		if err == nil || response != "" || !g.shouldRetry(ctx, attempt, err) {
+			return response, err
+		}
+		if err := g.wait(ctx, attempt, err); err != nil {
+			return "", err
+		}
+	}
+}
+
+// shouldRetry сообщает, нужно ли повторить запрос после ошибки err на попытке attempt (с нуля).
+func (g *RetryGateway) shouldRetry(ctx context.Context, attempt int, err error) bool {
+	return attempt < g.retries && ctx.Err() == nil && isTransientError(err)
+}
+
+// wait выжидает паузу перед повтором attempt+1: baseDelay * 2^attempt плюс до половины этой величины.
+// Возвращает ошибку контекста, если запрос отменен во время ожидания.
+func (g *RetryGateway) wait(ctx context.Context, attempt int, err error) error {
+	delay := g.baseDelay << attempt
+	if delay > 0 {
+		delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	}
+	g.logger.Warn("Model request to %s failed (attempt %d of %d), retrying in %s: %v",
+		gatewayName(g.gateway, 0), attempt+1, g.retries+1, delay.Round(time.Millisecond), err)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// isTransientError определяет, что ошибка вызвана временным сбоем и запрос имеет смысл повторить.
+// Таймауты не повторяются: ответ уже занял все отведенное время.
+func isTransientError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var openAIErr *OpenAIError
+	if errors.As(err, &openAIErr) {
+		return openAIErr.StatusCode >= 500
+	}
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return !urlErr.Timeout()
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// ModelName возвращает название обернутого бэкенда.
+func (g *RetryGateway) ModelName() string {
+	return gatewayName(g.gateway, 0)
+}
+
+// Info возвращает сведения об обернутом бэкенде для команды /about.
+func (g *RetryGateway) Info() usecases.GatewayInfo {
+	if describer, ok := g.gateway.(usecases.GatewayDescriber); ok {
+		return describer.Info()
+	}
+	return usecases.GatewayInfo{}
+}
+
+// Warmup прогревает обернутый шлюз, если он поддерживает прогрев.
+func (g *RetryGateway) Warmup(ctx context.Context) error {
+	if warmer, ok := g.gateway.(Warmer); ok {
+		return warmer.Warmup(ctx)
+	}
+	return nil
+}
+
+// Verify that RetryGateway implements usecases.ModelGateway
+var (
+	_ usecases.ModelGateway     = (*RetryGateway)(nil)
+	_ usecases.StreamingGateway = (*RetryGateway)(nil)
+	_ usecases.ModelNamer       = (*RetryGateway)(nil)
+	_ usecases.GatewayDescriber = (*RetryGateway)(nil)
+	_ Warmer                    = (*RetryGateway)(nil)
+)
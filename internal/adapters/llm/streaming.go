@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// maxStreamLineBytes максимальная длина одной строки потока SSE.
+const maxStreamLineBytes = 1024 * 1024
+
+// ChatCompletionChunk представляет одно событие потокового ответа API завершения чата.
+type ChatCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// GetModelResponseStream отправляет запрос к llama-server с "stream": true и передает в chunks
+// части ответа из событий SSE (data: ...) по мере их получения. Отмена контекста прерывает чтение потока.
+// Если поток оборвался, возвращается уже полученный текст вместе с ошибкой.
+func (g *LlamaCppGateway) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config usecases.ModelConfig, chunks chan<- string) (string, error) {
+	if g.mergeConsecutiveRoles {
+		messages = mergeConsecutiveRoles(messages)
+	}
+
+	jsonBody, err := g.marshalRequest(messages, config, true)
+	if err != nil {
+		return "", err
+	}
+	usecases.RecordRequest(ctx, jsonBody)
+
+	ctx, cancel := g.latency.withTimeout(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/v1/chat/completions", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		g.logger.Error("Failed to create HTTP request: %v", err)
+		return "", fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	start := time.Now()
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		g.logger.Error("HTTP Request Error to Llama-server: %v", err)
+		return "", fmt.Errorf("HTTP request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		g.logger.Error("Llama-server returned non-OK status code: %d, Body: %s", resp.StatusCode, string(bodyBytes))
+		return "", &StatusError{Backend: "llama-server", StatusCode: resp.StatusCode}
+	}
+
+	response, err := readChatStream(ctx, resp.Body, chunks)
+	if err != nil {
+		if ctx.Err() == nil {
+			g.logger.Error("Llama-server stream failed after %d bytes: %v", len(response), err)
+			err = fmt.Errorf("llama-server stream: %w", err)
+		}
+		return response, err
+	}
+	g.latency.record(start)
+
+	if response == "" {
+		return "", fmt.Errorf("no response content in Llama-server stream")
+	}
+	return response, nil
+}
+
+// readChatStream читает события SSE (data: ...) потокового ответа /v1/chat/completions и передает
+// в chunks части ответа по мере их получения. Возвращает весь полученный текст, в том числе
+// при ошибке или отмене ctx.
+func readChatStream(ctx context.Context, body io.Reader, chunks chan<- string) (string, error) {
+	var response strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxStreamLineBytes)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue // Пустые строки-разделители, комментарии и другие поля SSE
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return response.String(), fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		content := chunk.Choices[0].Delta.Content
+		response.WriteString(content)
+		select {
+		case chunks <- content:
+		case <-ctx.Done():
+			return response.String(), ctx.Err()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return response.String(), fmt.Errorf("stream interrupted: %w", err)
+	}
+	return response.String(), ctx.Err()
+}
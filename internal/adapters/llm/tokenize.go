@@ -0,0 +1,97 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+const (
+	tokenizeTimeout = 5 * time.Second // Таймаут запроса к /tokenize: подсчет не должен задерживать генерацию
+
+	// chatTemplateOverhead оценка токенов разметки шаблона чата (роль, разделители) на одно сообщение,
+	// которую /tokenize не учитывает.
+	chatTemplateOverhead = 4
+)
+
+// CountTokens подсчитывает токены сообщений токенизатором модели через /tokenize llama-server.
+// Тексты всех сообщений токенизируются одним запросом, а разметка шаблона чата оценивается
+// в chatTemplateOverhead токенов на сообщение.
+func (g *LlamaCppGateway) CountTokens(ctx context.Context, messages []domain.ChatMessage) (int, error) {
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	contents := make([]string, len(messages))
+	for i, msg := range messages {
+		contents[i] = msg.Content
+	}
+	jsonBody, err := json.Marshal(map[string]string{"content": strings.Join(contents, "\n")})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal tokenize request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, tokenizeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", g.baseURL+"/tokenize", bytes.NewBuffer(jsonBody))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create HTTP request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("tokenize request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, &StatusError{Backend: "llama-server /tokenize", StatusCode: resp.StatusCode, Message: string(bodyBytes)}
+	}
+	var result struct {
+		Tokens []json.RawMessage `json:"tokens"` // Числа или объекты {id, piece} при with_pieces
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to decode tokenize response: %w", err)
+	}
+	return len(result.Tokens) + chatTemplateOverhead*len(messages), nil
+}
+
+// CountTokens подсчитывает токены первым шлюзом цепочки, который это поддерживает и ответил без ошибки.
+func (g *CompositeGateway) CountTokens(ctx context.Context, messages []domain.ChatMessage) (int, error) {
+	err := usecases.ErrTokenCountUnsupported
+	for _, gateway := range g.gateways {
+		counter, ok := gateway.(usecases.TokenCounter)
+		if !ok {
+			continue
+		}
+		var count int
+		if count, err = counter.CountTokens(ctx, messages); err == nil {
+			return count, nil
+		}
+	}
+	return 0, err
+}
+
+// CountTokens подсчитывает токены обернутым шлюзом. Подсчет не повторяется: при ошибке используется оценка.
+func (g *RetryGateway) CountTokens(ctx context.Context, messages []domain.ChatMessage) (int, error) {
+	if counter, ok := g.gateway.(usecases.TokenCounter); ok {
+		return counter.CountTokens(ctx, messages)
+	}
+	return 0, usecases.ErrTokenCountUnsupported
+}
+
+// Verify that gateways implement usecases.TokenCounter
+var (
+	_ usecases.TokenCounter = (*LlamaCppGateway)(nil)
+	_ usecases.TokenCounter = (*CompositeGateway)(nil)
+	_ usecases.TokenCounter = (*RetryGateway)(nil)
+)
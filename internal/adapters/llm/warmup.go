@@ -0,0 +1,50 @@
+package llm
+
+import (
+	"context"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// Warmer реализуется шлюзами, которые умеют заранее загрузить модель на сервере.
+type Warmer interface {
+	Warmup(ctx context.Context) error
+}
+
+// warmup отправляет минимальный запрос, чтобы сервер загрузил модель до первого запроса пользователя.
+func warmup(ctx context.Context, gateway usecases.ModelGateway, logger logger.Logger) error {
+	config := domain.DefaultModelConfig()
+	config.MaxTokens = 1
+
+	start := time.Now()
+	_, err := gateway.GetModelResponse(ctx, []domain.ChatMessage{domain.NewChatMessage(domain.UserRole, "Hi")}, config)
+	if err != nil {
+		return err
+	}
+	logger.Info("Model warm-up completed in %s", time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// Warmup загружает модель на llama-server тестовым запросом.
+func (g *LlamaCppGateway) Warmup(ctx context.Context) error {
+	return warmup(ctx, g, g.logger)
+}
+
+// Warmup загружает модель на сервере тестовым запросом к /v1/completions.
+func (g *LegacyCompletionGateway) Warmup(ctx context.Context) error {
+	return warmup(ctx, g, g.logger)
+}
+
+// Verify that gateways implement Warmer
+var (
+	_ Warmer = (*LlamaCppGateway)(nil)
+	_ Warmer = (*LegacyCompletionGateway)(nil)
+)
+
+// Warmup загружает модель в Ollama тестовым запросом к /api/chat.
+func (g *OllamaGateway) Warmup(ctx context.Context) error {
+	return warmup(ctx, g, g.logger)
+}
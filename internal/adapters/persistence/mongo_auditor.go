@@ -0,0 +1,33 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// MongoAuditor является реализацией usecases.Auditor, сохраняющей записи в коллекцию audit_log.
+type MongoAuditor struct {
+	auditCollection *mongo.Collection
+}
+
+// NewMongoAuditor создает новый экземпляр MongoAuditor в той же базе данных, что и репозиторий.
+func NewMongoAuditor(repo *MongoDbRepository) *MongoAuditor {
+	return &MongoAuditor{
+		auditCollection: repo.usersCollection.Database().Collection("audit_log"),
+	}
+}
+
+// Record сохраняет запись аудита.
+func (a *MongoAuditor) Record(ctx context.Context, entry usecases.AuditEntry) error {
+	if _, err := a.auditCollection.InsertOne(ctx, entry); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// Verify that MongoAuditor implements usecases.Auditor
+var _ usecases.Auditor = (*MongoAuditor)(nil)
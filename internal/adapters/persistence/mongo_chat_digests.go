@@ -0,0 +1,110 @@
+package persistence
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+)
+
+// defaultChatDigestUsers сколько пользователей помнит chatDigestCache по умолчанию.
+const defaultChatDigestUsers = 10000
+
+// chatDigestCache хранит отпечатки загруженной или записанной истории персонажей (по ID персонажа)
+// не более чем для limit недавно использованных пользователей. Отпечатки позволяют SaveUser не перезаписывать
+// неизменившуюся историю. Забытый пользователь только теряет эту оптимизацию: при следующем сохранении его
+// история перезаписывается целиком, поэтому размер кэша не влияет на корректность.
+type chatDigestCache struct {
+	mu    sync.Mutex
+	limit int
+	order *list.List              // Пользователи от недавно использованных к давно использованным
+	users map[int64]*list.Element // Элементы order по ID пользователя
+}
+
+// chatDigestEntry отпечатки истории персонажей одного пользователя.
+type chatDigestEntry struct {
+	userID  int64
+	digests map[int][sha256.Size]byte
+}
+
+// newChatDigestCache создает кэш отпечатков не более чем для limit пользователей.
+func newChatDigestCache(limit int) *chatDigestCache {
+	return &chatDigestCache{limit: limit, order: list.New(), users: make(map[int64]*list.Element)}
+}
+
+// set заменяет отпечатки всех персонажей пользователя.
+func (c *chatDigestCache) set(userID int64, digests map[int][sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(userID).digests = digests
+}
+
+// setChat запоминает отпечаток истории одного персонажа пользователя.
+func (c *chatDigestCache) setChat(userID int64, characterID int, digest [sha256.Size]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entry(userID).digests[characterID] = digest
+}
+
+// unchanged сообщает, что история персонажа совпадает с последней загруженной или записанной.
+func (c *chatDigestCache) unchanged(userID int64, characterID int, digest [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.users[userID]
+	if !ok {
+		return false
+	}
+	c.order.MoveToFront(element)
+	known, ok := element.Value.(*chatDigestEntry).digests[characterID]
+	return ok && known == digest
+}
+
+// forgetRemoved возвращает ID персонажей, история которых известна, но которых больше нет среди ids,
+// и забывает их. known равен false, если отпечатков пользователя в кэше нет.
+func (c *chatDigestCache) forgetRemoved(userID int64, ids []int) (removed []int, known bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.users[userID]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	current := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		current[id] = true
+	}
+	digests := element.Value.(*chatDigestEntry).digests
+	for id := range digests {
+		if !current[id] {
+			removed = append(removed, id)
+			delete(digests, id)
+		}
+	}
+	return removed, true
+}
+
+// forget удаляет отпечатки пользователя.
+func (c *chatDigestCache) forget(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.users[userID]; ok {
+		c.order.Remove(element)
+		delete(c.users, userID)
+	}
+}
+
+// entry возвращает запись пользователя, создавая ее при необходимости, и отмечает ее использованной.
+// Если пользователей больше limit, забывается давно использованный. Вызывается под c.mu.
+func (c *chatDigestCache) entry(userID int64) *chatDigestEntry {
+	if element, ok := c.users[userID]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*chatDigestEntry)
+	}
+	entry := &chatDigestEntry{userID: userID, digests: make(map[int][sha256.Size]byte)}
+	c.users[userID] = c.order.PushFront(entry)
+	for c.limit > 0 && c.order.Len() > c.limit {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.users, oldest.Value.(*chatDigestEntry).userID)
+	}
+	return entry
+}
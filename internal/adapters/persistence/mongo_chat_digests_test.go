@@ -0,0 +1,59 @@
+package persistence
+
+import (
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestChatDigestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newChatDigestCache(2)
+	digest := sha256.Sum256([]byte("chat"))
+	cache.setChat(1, 0, digest)
+	cache.setChat(2, 0, digest)
+	cache.unchanged(1, 0, digest) // Пользователь 1 использован позже пользователя 2
+	cache.setChat(3, 0, digest)
+
+	if !cache.unchanged(1, 0, digest) {
+		t.Error("recently used user 1 was evicted")
+	}
+	if cache.unchanged(2, 0, digest) {
+		t.Error("least recently used user 2 was kept")
+	}
+	if !cache.unchanged(3, 0, digest) {
+		t.Error("new user 3 was not remembered")
+	}
+	if len(cache.users) != 2 || cache.order.Len() != 2 {
+		t.Errorf("cache holds %d users (%d in order), want 2", len(cache.users), cache.order.Len())
+	}
+}
+
+func TestChatDigestCacheChanges(t *testing.T) {
+	cache := newChatDigestCache(defaultChatDigestUsers)
+	first, second := sha256.Sum256([]byte("first")), sha256.Sum256([]byte("second"))
+
+	if _, known := cache.forgetRemoved(1, []int{0}); known {
+		t.Error("unknown user reported as known")
+	}
+	cache.set(1, map[int][sha256.Size]byte{0: first, 1: first, 2: first})
+	if cache.unchanged(1, 0, second) {
+		t.Error("changed chat reported as unchanged")
+	}
+	cache.setChat(1, 0, second)
+	if !cache.unchanged(1, 0, second) {
+		t.Error("written chat reported as changed")
+	}
+
+	removed, known := cache.forgetRemoved(1, []int{0, 2})
+	if !known || !reflect.DeepEqual(removed, []int{1}) {
+		t.Errorf("forgetRemoved = %v, %t; want [1], true", removed, known)
+	}
+	if removed, _ := cache.forgetRemoved(1, []int{0, 2}); len(removed) != 0 {
+		t.Errorf("removed character reported again: %v", removed)
+	}
+
+	cache.forget(1)
+	if cache.unchanged(1, 0, second) {
+		t.Error("forgotten user still has digests")
+	}
+}
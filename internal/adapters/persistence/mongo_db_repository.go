@@ -2,7 +2,10 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,9 +18,15 @@ import (
 )
 
 // MongoDbRepository является реализацией usecases.UserRepository для MongoDB.
+// История чатов хранится в коллекции messages (документ на сообщение), а не в документе пользователя,
+// поэтому размер документа не растет с историей, а новые сообщения дописываются без перезаписи остальных.
+// История эфемерных персонажей не записывается: ее хранит в памяти слой сценариев.
 type MongoDbRepository struct {
-	usersCollection *mongo.Collection
-	logger          logger.Logger
+	usersCollection    *mongo.Collection
+	messagesCollection *mongo.Collection
+	logger             logger.Logger
+
+	chatDigests *chatDigestCache // Отпечатки загруженной или записанной истории недавних пользователей
 }
 
 // NewMongoDbRepository создает новый экземпляр MongoDbRepository.
@@ -41,30 +50,66 @@ func NewMongoDbRepository(connectionString, databaseName string, logger logger.L
 
 	logger.Info("Connected to MongoDB!")
 
-	usersCollection := client.Database(databaseName).Collection("users")
-
-	return &MongoDbRepository{
-		usersCollection: usersCollection,
-		logger:          logger,
-	}, nil
+	database := client.Database(databaseName)
+	repo := &MongoDbRepository{
+		usersCollection:    database.Collection("users"),
+		messagesCollection: database.Collection("messages"),
+		logger:             logger,
+		chatDigests:        newChatDigestCache(defaultChatDigestUsers),
+	}
+	repo.ensureIndexes(ctx)
+	return repo, nil
 }
 
 // SaveUser сохраняет или обновляет пользователя в базе данных.
+// Используется оптимистичная блокировка: документ обновляется, только если его версия совпадает
+// с версией загруженного пользователя. При несовпадении возвращается usecases.ErrVersionConflict,
+// а документ не изменяется: сценарий сам перечитывает пользователя и повторяет свое изменение.
+// После документа записывается история персонажей, изменившаяся с момента загрузки.
 func (r *MongoDbRepository) SaveUser(ctx context.Context, user *domain.User) error {
+	err := r.saveUserVersion(ctx, user)
+	if errors.Is(err, usecases.ErrVersionConflict) {
+		r.logger.Warn("Version conflict saving user %d (have version %d)", user.ID, user.Version)
+	}
+	return err
+}
+
+// saveUserVersion выполняет одну попытку сохранения с проверкой версии.
+func (r *MongoDbRepository) saveUserVersion(ctx context.Context, user *domain.User) error {
+	filter := bson.M{"_id": user.ID, "version": user.Version}
+	if user.Version == 0 {
+		// Документы, сохраненные до появления версий, не содержат поля version
+		filter = bson.M{"_id": user.ID, "$or": bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}}
+	}
+
+	persisted := stripChats(user)
+	persisted.Version = user.Version + 1
 	opts := options.Update().SetUpsert(true)
-	filter := bson.M{"_id": user.ID}
-	update := bson.M{"$set": user} // Используем $set для полного обновления документа
+	update := bson.M{"$set": persisted} // Используем $set для полного обновления документа
 
 	_, err := r.usersCollection.UpdateOne(ctx, filter, update, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		// Документ существует, но с другой версией: upsert попытался вставить дубликат _id
+		return usecases.ErrVersionConflict
+	}
 	if err != nil {
 		r.logger.Error("Error saving user %d: %v", user.ID, err)
 		return fmt.Errorf("error saving user %d: %w", user.ID, err)
 	}
-	return nil
+	user.Version = persisted.Version
+	return r.saveChats(ctx, user, false)
 }
 
-// LoadUser загружает пользователя по ID.
+// LoadUser загружает пользователя по ID вместе со всей историей чатов.
 func (r *MongoDbRepository) LoadUser(ctx context.Context, userID int64) (*domain.User, error) {
+	return r.loadUser(ctx, userID, 0)
+}
+
+// loadUser загружает документ пользователя и последние limit сообщений каждого персонажа (0 - всю историю).
+func (r *MongoDbRepository) loadUser(ctx context.Context, userID int64, limit int) (*domain.User, error) {
 	filter := bson.M{"_id": userID}
 	var user domain.User
 	err := r.usersCollection.FindOne(ctx, filter).Decode(&user)
@@ -75,42 +120,334 @@ func (r *MongoDbRepository) LoadUser(ctx context.Context, userID int64) (*domain
 		r.logger.Error("Error loading user %d: %v", userID, err)
 		return nil, fmt.Errorf("error loading user %d: %w", userID, err)
 	}
+	if err := r.loadChats(ctx, &user, limit); err != nil {
+		return nil, err
+	}
 	return &user, nil
 }
 
+// stripChats возвращает копию пользователя для записи в документ: история всех персонажей в ней пуста,
+// так как хранится в коллекции messages (история эфемерных персонажей не хранится вовсе).
+// Исходный объект пользователя не изменяется.
+func stripChats(user *domain.User) *domain.User {
+	persisted := *user
+	persisted.Characters = make([]*domain.CharacterPreset, len(user.Characters))
+	for i, char := range user.Characters {
+		charCopy := *char
+		charCopy.Chat = []domain.ChatMessage{}
+		persisted.Characters[i] = &charCopy
+	}
+	return &persisted
+}
+
+// LoadUserWithRecentHistory загружает пользователя с последними limit сообщениями каждого персонажа.
+// Остальные поля документа загружаются полностью.
+func (r *MongoDbRepository) LoadUserWithRecentHistory(ctx context.Context, userID int64, limit int) (*domain.User, error) {
+	return r.loadUser(ctx, userID, limit)
+}
+
+// DeleteUser удаляет документ пользователя и всю его историю сообщений. Сообщения удаляются первыми: иначе при сбое они остались бы без владельца
+// и подгрузились бы в чаты заново созданного пользователя с теми же ID персонажей.
+// Удаление отсутствующего пользователя не считается ошибкой.
+func (r *MongoDbRepository) DeleteUser(ctx context.Context, userID int64) error {
+	messages, err := r.messagesCollection.DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		r.logger.Error("Error deleting messages of user %d: %v", userID, err)
+		return fmt.Errorf("error deleting messages of user %d: %w", userID, err)
+	}
+	if _, err := r.usersCollection.DeleteOne(ctx, bson.M{"_id": userID}); err != nil {
+		r.logger.Error("Error deleting user %d: %v", userID, err)
+		return fmt.Errorf("error deleting user %d: %w", userID, err)
+	}
+
+	r.chatDigests.forget(userID)
+
+	r.logger.Info("Deleted user %d and %d message(s)", userID, messages.DeletedCount)
+	return nil
+}
+
+// ForEachUser последовательно передает fn всех пользователей с историей чатов, читая их из БД курсором,
+// чтобы не загружать всю коллекцию в память. Ошибка fn прерывает обход.
+func (r *MongoDbRepository) ForEachUser(ctx context.Context, fn func(user *domain.User) error) error {
+	cursor, err := r.usersCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		r.logger.Error("Error listing users: %v", err)
+		return fmt.Errorf("error listing users: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var user domain.User
+		if err := cursor.Decode(&user); err != nil {
+			r.logger.Error("Error decoding user while listing: %v", err)
+			return fmt.Errorf("error decoding user: %w", err)
+		}
+		if err := r.loadChats(ctx, &user, 0); err != nil {
+			return err
+		}
+		if err := fn(&user); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		r.logger.Error("Error reading users cursor: %v", err)
+		return fmt.Errorf("error reading users: %w", err)
+	}
+	return nil
+}
+
+// UpsertUsers записывает пользователей одним пакетом, заменяя существующие документы и историю чатов.
+// Версия документов увеличивается, поэтому обработчики, загрузившие пользователя до записи,
+// получат конфликт версий при сохранении.
+func (r *MongoDbRepository) UpsertUsers(ctx context.Context, users []*domain.User) error {
+	if len(users) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(users))
+	for _, user := range users {
+		document, err := bson.Marshal(stripChats(user))
+		if err != nil {
+			return fmt.Errorf("error encoding user %d: %w", user.ID, err)
+		}
+		var fields bson.M
+		if err := bson.Unmarshal(document, &fields); err != nil {
+			return fmt.Errorf("error encoding user %d: %w", user.ID, err)
+		}
+		delete(fields, "version")
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": user.ID}).
+			SetUpdate(bson.M{"$set": fields, "$inc": bson.M{"version": 1}}).
+			SetUpsert(true))
+	}
+
+	if _, err := r.usersCollection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+		r.logger.Error("Error upserting %d users: %v", len(users), err)
+		return fmt.Errorf("error upserting users: %w", err)
+	}
+	for _, user := range users {
+		if err := r.saveChats(ctx, user, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // AddChatMessage добавляет сообщение чата для указанного пользователя и персонажа.
+// Порядковый номер сообщения выдается атомарным увеличением счетчика персонажа (findAndModify),
+// поэтому одновременные добавления получают разные возрастающие номера. Если добавление
+// после выдачи номера не удалось, номер пропускается.
 func (r *MongoDbRepository) AddChatMessage(ctx context.Context, userID int64, characterIndex int, message domain.ChatMessage) error {
+	seq, characterID, err := r.nextMessageSeq(ctx, userID, characterIndex)
+	if err != nil {
+		return err
+	}
+	message.Seq = seq
+	if err := r.insertMessages(ctx, userID, characterID, []domain.ChatMessage{message}); err != nil {
+		return err
+	}
+
 	filter := bson.M{"_id": userID}
-	update := bson.M{"$push": bson.M{fmt.Sprintf("characters.%d.chat", characterIndex): message}}
+	update := bson.M{"$set": bson.M{fmt.Sprintf("characters.%d.updated_at", characterIndex): time.Now().UTC()}}
+	if _, err := r.usersCollection.UpdateOne(ctx, filter, update); err != nil {
+		r.logger.Error("Error updating character %d of user %d after adding a message: %v", characterID, userID, err)
+		return fmt.Errorf("error adding chat message for user %d, character index %d: %w", userID, characterIndex, err)
+	}
+	return nil
+}
 
-	result, err := r.usersCollection.UpdateOne(ctx, filter, update)
+// nextMessageSeq атомарно увеличивает счетчик номеров сообщений персонажа и возвращает новое значение
+// вместе с ID персонажа. Версия документа увеличивается вместе со счетчиком: иначе SaveUser ранее
+// загруженного пользователя перезаписал бы last_seq старым значением, и номера сообщений повторились бы.
+// Загруженные раньше пользователи из-за этого устаревают; usecases перечитывают их и применяют изменение
+// заново (UserInteractor.UpdateUser).
+func (r *MongoDbRepository) nextMessageSeq(ctx context.Context, userID int64, characterIndex int) (int64, int, error) {
+	filter := bson.M{"_id": userID, fmt.Sprintf("characters.%d", characterIndex): bson.M{"$exists": true}}
+	update := bson.M{"$inc": bson.M{fmt.Sprintf("characters.%d.last_seq", characterIndex): 1, "version": 1}}
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetProjection(bson.M{"characters.last_seq": 1, "characters.id": 1})
+
+	var result struct {
+		Characters []struct {
+			ID      int   `bson:"id"`
+			LastSeq int64 `bson:"last_seq"`
+		} `bson:"characters"`
+	}
+	err := r.usersCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, fmt.Errorf("user %d or character index %d not found when assigning message number", userID, characterIndex)
+	}
 	if err != nil {
-		r.logger.Error("Error adding chat message for user %d, character index %d: %v", userID, characterIndex, err)
-		return fmt.Errorf("error adding chat message for user %d, character index %d: %w", userID, characterIndex, err)
+		r.logger.Error("Error assigning message number for user %d, character index %d: %v", userID, characterIndex, err)
+		return 0, 0, fmt.Errorf("error assigning message number for user %d: %w", userID, err)
 	}
+	if characterIndex >= len(result.Characters) {
+		return 0, 0, fmt.Errorf("character index %d not found when assigning message number", characterIndex)
+	}
+	char := result.Characters[characterIndex]
+	return char.LastSeq, char.ID, nil
+}
 
-	if result.MatchedCount == 0 {
-		r.logger.Error("User %d not found when trying to add chat message.", userID)
-		return fmt.Errorf("user %d not found when trying to add chat message", userID)
+// CountMessages возвращает общее количество сообщений во всех чатах пользователя.
+// Подсчет выполняется по индексу коллекции messages, без передачи самих сообщений.
+func (r *MongoDbRepository) CountMessages(ctx context.Context, userID int64) (int, error) {
+	count, err := r.messagesCollection.CountDocuments(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		r.logger.Error("Error counting messages for user %d: %v", userID, err)
+		return 0, fmt.Errorf("error counting messages for user %d: %w", userID, err)
 	}
-	return nil
+	return int(count), nil
 }
 
-// EnsureChatHistoryLimit обрезает историю чата для указанного пользователя и персонажа.
+// EnsureChatHistoryLimit обрезает историю чата персонажа в БД до limit последних сообщений.
+// Документ пользователя не изменяется, поэтому граница контекста (context_start) не сдвигается;
+// при добавлении сообщений используйте AppendChatMessages.
 func (r *MongoDbRepository) EnsureChatHistoryLimit(ctx context.Context, userID int64, characterIndex int, limit int) error {
-	// This operation is more complex with MongoDB's $slice.
-	// It's often easier to manage chat history limits in the application layer (UserInteractor)
-	// by fetching the user, trimming the chat slice, and then saving the user.
-	// Direct MongoDB $slice for updates that keep only the last N elements is tricky if N is dynamic.
-	// If performance is an issue for very long chat histories, consider a separate collection for chat messages.
-
-	// For now, we'll rely on the application layer to manage the limit before saving the user.
-	// If you want a database-level enforcement, you'd typically pull, trim, and push.
-	// Or use aggregations or more complex updates.
-	// This method might be left empty or removed if the use case ensures the limit before SaveUser.
-	r.logger.Warn("EnsureChatHistoryLimit is not implemented for direct MongoDB operation. Handled by application layer.")
+	if limit <= 0 {
+		return nil
+	}
+	var result struct {
+		Characters []struct {
+			ID int `bson:"id"`
+		} `bson:"characters"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"characters.id": 1})
+	err := r.usersCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("user %d not found when trimming chat history", userID)
+	}
+	if err != nil {
+		r.logger.Error("Error trimming chat history for user %d, character index %d: %v", userID, characterIndex, err)
+		return fmt.Errorf("error trimming chat history for user %d: %w", userID, err)
+	}
+	if characterIndex < 0 || characterIndex >= len(result.Characters) {
+		return fmt.Errorf("character index %d not found when trimming chat history", characterIndex)
+	}
+	return r.trimChat(ctx, userID, result.Characters[characterIndex].ID, limit)
+}
+
+// AppendChatMessages дописывает сообщения в историю персонажа characterIndex в коллекции messages
+// и обрезает ее до limit последних сообщений, не перезаписывая историю целиком. Остальные поля пользователя
+// и этого персонажа сохраняются через $set; история других персонажей не затрагивается.
+// Версия документа проверяется так же, как в SaveUser: при несовпадении возвращается usecases.ErrVersionConflict,
+// а сообщения не записываются.
+func (r *MongoDbRepository) AppendChatMessages(ctx context.Context, user *domain.User, characterIndex int, messages []domain.ChatMessage, limit int) error {
+	if characterIndex < 0 || characterIndex >= len(user.Characters) {
+		return fmt.Errorf("character index %d out of range for user %d", characterIndex, user.ID)
+	}
+	char := user.Characters[characterIndex]
+	if char.Ephemeral {
+		return r.SaveUser(ctx, user) // История эфемерного персонажа не записывается
+	}
+
+	set, unset, err := documentFields(user, "", "_id", "version", "characters")
+	if err != nil {
+		return fmt.Errorf("error encoding user %d: %w", user.ID, err)
+	}
+	prefix := fmt.Sprintf("characters.%d.", characterIndex)
+	charSet, charUnset, err := documentFields(char, prefix, "chat")
+	if err != nil {
+		return fmt.Errorf("error encoding user %d: %w", user.ID, err)
+	}
+	for key, value := range charSet {
+		set[key] = value
+	}
+	for key := range charUnset {
+		unset[key] = ""
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{"version": 1},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset // Пустые поля с omitempty не кодируются, поэтому удаляются явно
+	}
+	filter := bson.M{"_id": user.ID, "version": user.Version}
+	if user.Version == 0 {
+		filter = bson.M{"_id": user.ID, "$or": bson.A{
+			bson.M{"version": 0},
+			bson.M{"version": bson.M{"$exists": false}},
+		}}
+	}
+
+	result, err := r.usersCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		r.logger.Error("Error appending chat messages for user %d, character index %d: %v", user.ID, characterIndex, err)
+		return fmt.Errorf("error appending chat messages for user %d: %w", user.ID, err)
+	}
+	if result.MatchedCount == 0 {
+		return usecases.ErrVersionConflict
+	}
+	user.Version++
+
+	if err := r.insertMessages(ctx, user.ID, char.ID, messages); err != nil {
+		return err
+	}
+	if err := r.trimChat(ctx, user.ID, char.ID, limit); err != nil {
+		return err
+	}
+	r.chatDigests.setChat(user.ID, char.ID, chatDigest(char.Chat))
 	return nil
 }
 
-// Verify that MongoDbRepository implements usecases.UserRepository
-var _ usecases.UserRepository = (*MongoDbRepository)(nil)
+// documentFields кодирует структуру value в BSON и возвращает поля для $set и $unset с префиксом prefix,
+// кроме skip. В $unset попадают поля структуры, пропущенные при кодировании (пустые с omitempty).
+func documentFields(value interface{}, prefix string, skip ...string) (set, unset bson.M, err error) {
+	document, err := bson.Marshal(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields bson.M
+	if err := bson.Unmarshal(document, &fields); err != nil {
+		return nil, nil, err
+	}
+
+	skipped := make(map[string]bool, len(skip))
+	for _, key := range skip {
+		skipped[key] = true
+	}
+	set, unset = bson.M{}, bson.M{}
+	for key, field := range fields {
+		if !skipped[key] {
+			set[prefix+key] = field
+		}
+	}
+	for _, key := range bsonFieldNames(reflect.TypeOf(value)) {
+		if _, ok := fields[key]; !ok && !skipped[key] {
+			unset[prefix+key] = ""
+		}
+	}
+	return set, unset, nil
+}
+
+// bsonFieldNames возвращает имена полей структуры в BSON по тегам bson (или имени поля в нижнем регистре).
+func bsonFieldNames(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	names := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("bson"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// Verify that MongoDbRepository implements usecases.UserRepository and usecases.UserBackupRepository
+var (
+	_ usecases.UserRepository       = (*MongoDbRepository)(nil)
+	_ usecases.ChatAppender         = (*MongoDbRepository)(nil)
+	_ usecases.UserBackupRepository = (*MongoDbRepository)(nil)
+)
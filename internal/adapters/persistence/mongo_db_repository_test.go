@@ -0,0 +1,324 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// newTestRepository подключается к MongoDB из MONGO_TEST_URI и создает репозиторий на отдельной базе,
+// которая удаляется после теста. Без MONGO_TEST_URI тест пропускается.
+func newTestRepository(t *testing.T) *MongoDbRepository {
+	t.Helper()
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	databaseName := fmt.Sprintf("neuro_chat_bot_test_%d", time.Now().UnixNano())
+	repo, err := NewMongoDbRepository(uri, databaseName, logger.NewWriterLogger(io.Discard, logger.None))
+	if err != nil {
+		t.Fatalf("NewMongoDbRepository: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		database := repo.usersCollection.Database()
+		if err := database.Drop(ctx); err != nil {
+			t.Errorf("failed to drop test database: %v", err)
+		}
+		database.Client().Disconnect(ctx)
+	})
+	return repo
+}
+
+// seedUser сохраняет пользователя, у которого персонаж i содержит chatSizes[i] сообщений.
+func seedUser(t *testing.T, repo *MongoDbRepository, userID int64, chatSizes ...int) {
+	t.Helper()
+	user := domain.NewUser(userID, fmt.Sprintf("user%d", userID))
+	user.Characters = nil
+	for i, size := range chatSizes {
+		char := domain.NewCharacterPreset()
+		char.ID = i
+		for m := 0; m < size; m++ {
+			char.AppendMessages(domain.NewChatMessage(domain.UserRole, fmt.Sprintf("message %d", m)))
+		}
+		user.Characters = append(user.Characters, char)
+	}
+	if err := repo.SaveUser(context.Background(), user); err != nil {
+		t.Fatalf("SaveUser(%d): %v", userID, err)
+	}
+}
+
+func TestCountMessages(t *testing.T) {
+	repo := newTestRepository(t)
+	seedUser(t, repo, 1, 3, 2, 0)
+	seedUser(t, repo, 2, 4)
+	seedUser(t, repo, 3)
+
+	tests := []struct {
+		name   string
+		userID int64
+		want   int
+	}{
+		{name: "several characters", userID: 1, want: 5},
+		{name: "other user is not counted", userID: 2, want: 4},
+		{name: "no characters", userID: 3, want: 0},
+		{name: "unknown user", userID: 4, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.CountMessages(context.Background(), tt.userID)
+			if err != nil {
+				t.Fatalf("CountMessages: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CountMessages(%d) = %d, want %d", tt.userID, got, tt.want)
+			}
+		})
+	}
+
+	t.Run("after adding a message", func(t *testing.T) {
+		ctx := context.Background()
+		if err := repo.AddChatMessage(ctx, 2, 0, domain.NewChatMessage(domain.Assistant, "reply")); err != nil {
+			t.Fatalf("AddChatMessage: %v", err)
+		}
+		if got, err := repo.CountMessages(ctx, 2); err != nil || got != 5 {
+			t.Errorf("CountMessages(2) = %d, %v, want 5", got, err)
+		}
+	})
+}
+
+// newMockRepository создает репозиторий на mock-клиенте mtest: ответы сервера задаются через mt.AddMockResponses.
+func newMockRepository(mt *mtest.T) *MongoDbRepository {
+	return &MongoDbRepository{
+		usersCollection:    mt.DB.Collection("users"),
+		messagesCollection: mt.DB.Collection("messages"),
+		logger:             logger.NewWriterLogger(io.Discard, logger.None),
+		chatDigests:        newChatDigestCache(defaultChatDigestUsers),
+	}
+}
+
+// commandNames возвращает имена команд, отправленных mock-клиенту после последнего mt.ClearEvents.
+func commandNames(mt *mtest.T) []string {
+	var names []string
+	for _, event := range mt.GetAllStartedEvents() {
+		names = append(names, event.CommandName)
+	}
+	return names
+}
+
+// TestSaveUserRewritesOnlyChangedChats проверяет, что после загрузки последних сообщений пользователя
+// SaveUser записывает только документ пользователя и историю изменившегося персонажа.
+func TestSaveUserRewritesOnlyChangedChats(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	mt.Run("bounded load", func(mt *mtest.T) {
+		ctx := context.Background()
+		repo := newMockRepository(mt)
+
+		stored := domain.NewUser(1, "alice")
+		second := domain.NewCharacterPreset()
+		second.ID = 1
+		stored.Characters = append(stored.Characters, second)
+		stored.Version = 3
+		var userDocument bson.D
+		raw, err := bson.Marshal(stripChats(stored))
+		if err != nil {
+			t.Fatalf("Marshal: %v", err)
+		}
+		if err := bson.Unmarshal(raw, &userDocument); err != nil {
+			t.Fatalf("Unmarshal: %v", err)
+		}
+		message := func(characterID int, seq int64) bson.D {
+			return bson.D{{Key: "user_id", Value: int64(1)}, {Key: "character_id", Value: characterID}, {Key: "order", Value: seq},
+				{Key: "role", Value: "user"}, {Key: "content", Value: fmt.Sprintf("message %d", seq)}, {Key: "seq", Value: seq}}
+		}
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "bot.users", mtest.FirstBatch, userDocument),
+			mtest.CreateCursorResponse(0, "bot.messages", mtest.FirstBatch, message(0, 9), message(0, 8)),
+			mtest.CreateCursorResponse(0, "bot.messages", mtest.FirstBatch, message(1, 4)),
+		)
+		user, err := repo.LoadUserWithRecentHistory(ctx, 1, 2)
+		if err != nil {
+			t.Fatalf("LoadUserWithRecentHistory: %v", err)
+		}
+		if got := len(user.Characters[0].Chat) + len(user.Characters[1].Chat); got != 3 {
+			t.Fatalf("loaded %d messages, want 3", got)
+		}
+
+		mt.ClearEvents()
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+		user.UserName = "alice2"
+		if err := repo.SaveUser(ctx, user); err != nil {
+			t.Fatalf("SaveUser: %v", err)
+		}
+		if got := commandNames(mt); !reflect.DeepEqual(got, []string{"update"}) {
+			t.Errorf("commands for unchanged chats = %q, want only the user document update", got)
+		}
+
+		mt.ClearEvents()
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 2}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}),
+		)
+		user.Characters[1].AppendMessages(domain.NewChatMessage(domain.Assistant, "reply"))
+		if err := repo.SaveUser(ctx, user); err != nil {
+			t.Fatalf("SaveUser: %v", err)
+		}
+		if got := commandNames(mt); !reflect.DeepEqual(got, []string{"update", "insert", "delete"}) {
+			t.Fatalf("commands for one changed chat = %q, want the update and one chat rewrite", got)
+		}
+		inserted, _ := mt.GetAllStartedEvents()[1].Command.Lookup("documents").Array().Values()
+		for _, document := range inserted {
+			if id := document.Document().Lookup("character_id").Int32(); id != 1 {
+				t.Errorf("rewritten message of character %d, want only character 1", id)
+			}
+		}
+		if len(inserted) != 2 {
+			t.Errorf("rewrote %d messages, want the 2 messages of character 1", len(inserted))
+		}
+	})
+}
+
+// TestCountMessagesMock проверяет запрос CountMessages без сервера MongoDB: ответы сервера подставляет
+// mock-клиент mtest, а тест проверяет отправленный фильтр и разбор результата.
+func TestCountMessagesMock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	tests := []struct {
+		name     string
+		response bson.D
+		want     int
+		wantErr  bool
+	}{
+		{name: "messages counted", response: mtest.CreateCursorResponse(0, "bot.messages", mtest.FirstBatch, bson.D{{Key: "n", Value: 5}}), want: 5},
+		{name: "no messages", response: mtest.CreateCursorResponse(0, "bot.messages", mtest.FirstBatch), want: 0},
+		{name: "server error", response: mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 11600, Message: "interrupted"}), wantErr: true},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			repo := newMockRepository(mt)
+			mt.AddMockResponses(tt.response)
+
+			got, err := repo.CountMessages(context.Background(), 2)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CountMessages error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CountMessages(2) = %d, want %d", got, tt.want)
+			}
+
+			pipeline, ok := mt.GetStartedEvent().Command.Lookup("pipeline").ArrayOK()
+			if !ok {
+				t.Fatal("count command has no pipeline")
+			}
+			match := pipeline.Index(0).Value().Document().Lookup("$match").Document()
+			if userID := match.Lookup("user_id"); userID.AsInt64() != 2 {
+				t.Errorf("count filter = %v, want messages of user 2", match)
+			}
+		})
+	}
+}
+
+func TestAddChatMessageConcurrentSeq(t *testing.T) {
+	const appends = 20
+	repo := newTestRepository(t)
+	seedUser(t, repo, 1, 2)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, appends)
+	for i := 0; i < appends; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.AddChatMessage(ctx, 1, 0, domain.NewChatMessage(domain.UserRole, fmt.Sprintf("concurrent %d", i)))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("AddChatMessage: %v", err)
+		}
+	}
+
+	user, err := repo.LoadUser(ctx, 1)
+	if err != nil || user == nil {
+		t.Fatalf("LoadUser = %v, %v", user, err)
+	}
+	chat := user.Characters[0].Chat
+	if len(chat) != 2+appends {
+		t.Fatalf("chat has %d messages, want %d", len(chat), 2+appends)
+	}
+	seqs := make([]int64, len(chat))
+	for i, msg := range chat {
+		seqs[i] = msg.Seq
+	}
+	if !sort.SliceIsSorted(seqs, func(i, j int) bool { return seqs[i] < seqs[j] }) {
+		t.Errorf("loaded messages are not ordered by number: %v", seqs)
+	}
+	for i, seq := range seqs {
+		if want := int64(i + 1); seq != want {
+			t.Fatalf("message numbers = %v, want 1..%d without gaps or duplicates", seqs, len(chat))
+		}
+	}
+
+	// Номер, выданный хранилищем, продолжается при следующем добавлении через персонажа
+	char := user.Characters[0]
+	char.AppendMessages(domain.NewChatMessage(domain.Assistant, "reply"))
+	if got := char.Chat[len(char.Chat)-1].Seq; got != int64(len(chat)+1) {
+		t.Errorf("next message number = %d, want %d", got, len(chat)+1)
+	}
+}
+
+func TestReplaceChatLeavesOneCopy(t *testing.T) {
+	repo := newTestRepository(t)
+	seedUser(t, repo, 1, 3)
+	ctx := context.Background()
+
+	for _, content := range []string{"edited", "edited again"} {
+		user, err := repo.LoadUser(ctx, 1)
+		if err != nil || user == nil {
+			t.Fatalf("LoadUser = %v, %v", user, err)
+		}
+		char := user.Characters[0]
+		char.Chat = char.Chat[:len(char.Chat)-1] // История изменена не только дописыванием
+		char.AppendMessages(domain.NewChatMessage(domain.UserRole, content))
+		if err := repo.SaveUser(ctx, user); err != nil {
+			t.Fatalf("SaveUser: %v", err)
+		}
+	}
+
+	count, err := repo.CountMessages(ctx, 1)
+	if err != nil || count != 3 {
+		t.Fatalf("CountMessages = %d, %v; want 3 without copies of the replaced history", count, err)
+	}
+	user, _ := repo.LoadUser(ctx, 1)
+	var contents []string
+	for _, msg := range user.Characters[0].Chat {
+		contents = append(contents, msg.Content)
+	}
+	if want := []string{"message 0", "message 1", "edited again"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("chat = %q, want %q", contents, want)
+	}
+	seqs := make([]int64, len(user.Characters[0].Chat))
+	for i, msg := range user.Characters[0].Chat {
+		seqs[i] = msg.Seq
+	}
+	if !sort.SliceIsSorted(seqs, func(i, j int) bool { return seqs[i] < seqs[j] }) {
+		t.Errorf("message numbers out of order: %v", seqs)
+	}
+}
@@ -0,0 +1,51 @@
+package persistence
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ensureIndexes создает индексы коллекций, если их еще нет. CreateMany идемпотентен: существующий индекс
+// с тем же ключом не пересоздается. Ошибка не прерывает запуск (например, у пользователя БД может не быть
+// прав на создание индексов), а только записывается в лог: запросы работают и без индексов, но медленнее.
+func (r *MongoDbRepository) ensureIndexes(ctx context.Context) {
+	r.ensureCollectionIndexes(ctx, r.usersCollection, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "request_time", Value: 1}}}, // Поиск неактивных пользователей
+	})
+	r.ensureCollectionIndexes(ctx, r.messagesCollection, []mongo.IndexModel{
+		// Загрузка и обрезка истории персонажа; префикс user_id используется для подсчета и удаления всех сообщений
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "character_id", Value: 1}, {Key: "order", Value: 1}}},
+	})
+}
+
+// ensureCollectionIndexes создает индексы models коллекции collection и записывает в лог имена созданных.
+func (r *MongoDbRepository) ensureCollectionIndexes(ctx context.Context, collection *mongo.Collection, models []mongo.IndexModel) {
+	existing := make(map[string]bool)
+	specs, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		r.logger.Warn("Failed to list indexes of the %s collection: %v", collection.Name(), err)
+	}
+	for _, spec := range specs {
+		existing[spec.Name] = true
+	}
+
+	names, err := collection.Indexes().CreateMany(ctx, models)
+	if err != nil {
+		r.logger.Warn("Failed to create indexes of the %s collection: %v", collection.Name(), err)
+		return
+	}
+	var created []string
+	for _, name := range names {
+		if !existing[name] {
+			created = append(created, name)
+		}
+	}
+	if len(created) > 0 {
+		r.logger.Info("Created indexes of the %s collection: %s", collection.Name(), strings.Join(created, ", "))
+	} else {
+		r.logger.DebugInfo("Indexes of the %s collection already exist", collection.Name())
+	}
+}
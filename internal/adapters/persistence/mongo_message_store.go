@@ -0,0 +1,272 @@
+package persistence
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// messageDocument представляет сообщение чата в коллекции messages: один документ на сообщение.
+// Сообщения привязаны к ID персонажа, а не к его номеру в списке, который меняется при перемещении и удалении.
+type messageDocument struct {
+	UserID      int64 `bson:"user_id"`
+	CharacterID int   `bson:"character_id"`
+	Order       int64 `bson:"order"` // Позиция сообщения в чате персонажа, см. messageOrders
+
+	// Generation поколение полной перезаписи истории (см. replaceChat); у дописанных сообщений не задано
+	Generation primitive.ObjectID `bson:"generation,omitempty"`
+
+	domain.ChatMessage `bson:",inline"`
+}
+
+// messageOrders возвращает позиции сообщений chat персонажа char при полной перезаписи его истории.
+// Последнее сообщение получает номер не меньше LastSeq, предыдущие - на единицу меньше каждое.
+// Дописываемые позже сообщения получают позицию, равную своему Seq, который AppendMessages выдает
+// больше LastSeq, поэтому порядок сохраняется и для сообщений без номеров (сохраненных до их появления).
+func messageOrders(char *domain.CharacterPreset, chat []domain.ChatMessage) []int64 {
+	top := char.LastSeq
+	if n := len(chat); n > 0 && chat[n-1].Seq > top {
+		top = chat[n-1].Seq
+	}
+	orders := make([]int64, len(chat))
+	for i := range chat {
+		orders[i] = top - int64(len(chat)-1-i)
+	}
+	return orders
+}
+
+// LoadChatHistory возвращает последние limit сообщений персонажа characterID пользователя в порядке добавления
+// (limit <= 0 - всю историю).
+func (r *MongoDbRepository) LoadChatHistory(ctx context.Context, userID int64, characterID int, limit int) ([]domain.ChatMessage, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "order", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cursor, err := r.messagesCollection.Find(ctx, bson.M{"user_id": userID, "character_id": characterID}, opts)
+	if err != nil {
+		r.logger.Error("Error loading chat history of user %d, character %d: %v", userID, characterID, err)
+		return nil, fmt.Errorf("error loading chat history of user %d: %w", userID, err)
+	}
+	var documents []messageDocument
+	if err := cursor.All(ctx, &documents); err != nil {
+		r.logger.Error("Error decoding chat history of user %d, character %d: %v", userID, characterID, err)
+		return nil, fmt.Errorf("error decoding chat history of user %d: %w", userID, err)
+	}
+
+	chat := make([]domain.ChatMessage, len(documents))
+	for i, document := range documents {
+		chat[len(documents)-1-i] = document.ChatMessage
+	}
+	return chat, nil
+}
+
+// loadChats заполняет историю персонажей пользователя из коллекции messages: последние limit сообщений
+// каждого персонажа (limit <= 0 - всю историю одним запросом). История, еще хранящаяся в документе
+// пользователя, предварительно переносится в коллекцию. Эфемерные персонажи пропускаются.
+func (r *MongoDbRepository) loadChats(ctx context.Context, user *domain.User, limit int) error {
+	if err := r.migrateEmbeddedChats(ctx, user); err != nil {
+		return err
+	}
+
+	chats := make(map[int][]domain.ChatMessage, len(user.Characters))
+	if limit > 0 {
+		for _, char := range user.Characters {
+			if char == nil || char.Ephemeral {
+				continue
+			}
+			chat, err := r.LoadChatHistory(ctx, user.ID, char.ID, limit)
+			if err != nil {
+				return err
+			}
+			chats[char.ID] = chat
+		}
+	} else {
+		opts := options.Find().SetSort(bson.D{{Key: "character_id", Value: 1}, {Key: "order", Value: 1}})
+		cursor, err := r.messagesCollection.Find(ctx, bson.M{"user_id": user.ID}, opts)
+		if err != nil {
+			r.logger.Error("Error loading chat history of user %d: %v", user.ID, err)
+			return fmt.Errorf("error loading chat history of user %d: %w", user.ID, err)
+		}
+		var documents []messageDocument
+		if err := cursor.All(ctx, &documents); err != nil {
+			r.logger.Error("Error decoding chat history of user %d: %v", user.ID, err)
+			return fmt.Errorf("error decoding chat history of user %d: %w", user.ID, err)
+		}
+		for _, document := range documents {
+			chats[document.CharacterID] = append(chats[document.CharacterID], document.ChatMessage)
+		}
+	}
+
+	for _, char := range user.Characters {
+		if char == nil || char.Ephemeral {
+			continue
+		}
+		char.Chat = chats[char.ID]
+		if char.Chat == nil {
+			char.Chat = []domain.ChatMessage{}
+		}
+	}
+	r.rememberChats(user)
+	return nil
+}
+
+// migrateEmbeddedChats переносит историю, сохраненную внутри документа пользователя (до появления коллекции
+// messages), в коллекцию и очищает ее в документе. Перенос повторяем: replaceChat заменяет историю
+// персонажа в коллекции целиком, поэтому прерванный перенос не создает дубликатов.
+func (r *MongoDbRepository) migrateEmbeddedChats(ctx context.Context, user *domain.User) error {
+	for i, char := range user.Characters {
+		if char == nil || len(char.Chat) == 0 {
+			continue
+		}
+		if !char.Ephemeral {
+			if err := r.replaceChat(ctx, user.ID, char, char.Chat); err != nil {
+				return err
+			}
+		}
+		filter := bson.M{"_id": user.ID, fmt.Sprintf("characters.%d.id", i): char.ID}
+		update := bson.M{"$set": bson.M{fmt.Sprintf("characters.%d.chat", i): bson.A{}}}
+		if _, err := r.usersCollection.UpdateOne(ctx, filter, update); err != nil {
+			r.logger.Error("Error clearing embedded chat of user %d, character %d: %v", user.ID, char.ID, err)
+			return fmt.Errorf("error clearing embedded chat of user %d: %w", user.ID, err)
+		}
+		r.logger.Info("Moved %d message(s) of character %d of user %d to the messages collection", len(char.Chat), char.ID, user.ID)
+	}
+	return nil
+}
+
+// saveChats записывает в коллекцию messages историю персонажей, изменившуюся с последней загрузки или записи
+// (эфемерные персонажи сохраняются с пустой историей), и удаляет историю удаленных персонажей.
+// force перезаписывает историю всех персонажей, например при восстановлении из резервной копии.
+func (r *MongoDbRepository) saveChats(ctx context.Context, user *domain.User, force bool) error {
+	ids := make([]int, len(user.Characters))
+	for i, char := range user.Characters {
+		ids[i] = char.ID
+	}
+	removed, known := r.chatDigests.forgetRemoved(user.ID, ids)
+
+	for _, char := range user.Characters {
+		chat := char.Chat
+		if char.Ephemeral {
+			chat = nil
+		}
+		digest := chatDigest(chat)
+		if !force && r.chatDigests.unchanged(user.ID, char.ID, digest) {
+			continue
+		}
+		if err := r.replaceChat(ctx, user.ID, char, chat); err != nil {
+			return err
+		}
+		r.chatDigests.setChat(user.ID, char.ID, digest)
+	}
+
+	filter := bson.M{"user_id": user.ID, "character_id": bson.M{"$nin": ids}}
+	if known && !force {
+		if len(removed) == 0 {
+			return nil
+		}
+		filter = bson.M{"user_id": user.ID, "character_id": bson.M{"$in": removed}}
+	}
+	if _, err := r.messagesCollection.DeleteMany(ctx, filter); err != nil {
+		r.logger.Error("Error deleting chat history of removed characters of user %d: %v", user.ID, err)
+		return fmt.Errorf("error deleting chat history of removed characters of user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// replaceChat заменяет историю персонажа char в коллекции messages сообщениями chat. Новые сообщения
+// записываются с новым поколением (generation), и только после этого удаляются сообщения остальных
+// поколений, поэтому сбой между шагами не теряет историю: лишние сообщения удалит следующая перезапись.
+// Сообщения, дописанные после перезаписи, поколения не имеют, но дописать их можно только с новой версией
+// пользователя, то есть уже после удаления.
+func (r *MongoDbRepository) replaceChat(ctx context.Context, userID int64, char *domain.CharacterPreset, chat []domain.ChatMessage) error {
+	generation := primitive.NewObjectID()
+	if len(chat) > 0 {
+		orders := messageOrders(char, chat)
+		documents := make([]interface{}, len(chat))
+		for i, msg := range chat {
+			documents[i] = messageDocument{UserID: userID, CharacterID: char.ID, Order: orders[i], Generation: generation, ChatMessage: msg}
+		}
+		if _, err := r.messagesCollection.InsertMany(ctx, documents); err != nil {
+			r.logger.Error("Error writing chat history of user %d, character %d: %v", userID, char.ID, err)
+			return fmt.Errorf("error writing chat history of user %d: %w", userID, err)
+		}
+	}
+	filter := bson.M{"user_id": userID, "character_id": char.ID, "generation": bson.M{"$ne": generation}}
+	if _, err := r.messagesCollection.DeleteMany(ctx, filter); err != nil {
+		r.logger.Error("Error replacing chat history of user %d, character %d: %v", userID, char.ID, err)
+		return fmt.Errorf("error replacing chat history of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// insertMessages дописывает сообщения в историю персонажа characterID. Позиция сообщения равна его Seq.
+func (r *MongoDbRepository) insertMessages(ctx context.Context, userID int64, characterID int, messages []domain.ChatMessage) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	documents := make([]interface{}, len(messages))
+	for i, msg := range messages {
+		documents[i] = messageDocument{UserID: userID, CharacterID: characterID, Order: msg.Seq, ChatMessage: msg}
+	}
+	if _, err := r.messagesCollection.InsertMany(ctx, documents); err != nil {
+		r.logger.Error("Error adding chat messages for user %d, character %d: %v", userID, characterID, err)
+		return fmt.Errorf("error adding chat messages for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// trimChat удаляет из истории персонажа characterID все сообщения, кроме limit последних.
+func (r *MongoDbRepository) trimChat(ctx context.Context, userID int64, characterID int, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	filter := bson.M{"user_id": userID, "character_id": characterID}
+	opts := options.FindOne().
+		SetSort(bson.D{{Key: "order", Value: -1}}).
+		SetSkip(int64(limit)).
+		SetProjection(bson.M{"order": 1})
+	var oldest struct {
+		Order int64 `bson:"order"`
+	}
+	err := r.messagesCollection.FindOne(ctx, filter, opts).Decode(&oldest)
+	if err == mongo.ErrNoDocuments {
+		return nil // История не длиннее лимита
+	}
+	if err == nil {
+		filter["order"] = bson.M{"$lte": oldest.Order}
+		_, err = r.messagesCollection.DeleteMany(ctx, filter)
+	}
+	if err != nil {
+		r.logger.Error("Error trimming chat history for user %d, character %d: %v", userID, characterID, err)
+		return fmt.Errorf("error trimming chat history for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// chatDigest возвращает отпечаток истории чата, по которому определяется, изменилась ли она.
+func chatDigest(chat []domain.ChatMessage) [sha256.Size]byte {
+	if len(chat) == 0 {
+		return [sha256.Size]byte{}
+	}
+	data, _ := json.Marshal(chat) // JSON сортирует ключи Meta, поэтому отпечаток детерминирован
+	return sha256.Sum256(data)
+}
+
+// rememberChats запоминает отпечатки загруженной истории всех персонажей пользователя.
+func (r *MongoDbRepository) rememberChats(user *domain.User) {
+	digests := make(map[int][sha256.Size]byte, len(user.Characters))
+	for _, char := range user.Characters {
+		if char != nil && !char.Ephemeral {
+			digests[char.ID] = chatDigest(char.Chat)
+		}
+	}
+	r.chatDigests.set(user.ID, digests)
+}
@@ -0,0 +1,1325 @@
+package telegram_adapter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// commandHandler обрабатывает команду и возвращает текст ответа и необязательную клавиатуру.
+type commandHandler func(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{})
+
+// PermissionLevel определяет, кому доступна команда.
+type PermissionLevel int
+
+const (
+	PermissionPublic          PermissionLevel = iota // Доступна всем пользователям
+	PermissionAdmin                                  // Доступна только администраторам (ADMIN_USER_IDS)
+	PermissionInspectRequests                        // Доступна администраторам, а при LAST_REQUEST_ALL_USERS - всем
+)
+
+// commandCategory определяет раздел /help, в котором выводится команда.
+type commandCategory int
+
+const (
+	categoryGeneral         commandCategory = iota // Общие команды бота
+	categoryCharacters                             // Создание и настройка персонажей
+	categoryPersonalization                        // Сведения о пользователе и его предпочтения
+	categoryModel                                  // Параметры модели и обработки ответов
+	categoryChat                                   // Работа с текущим разговором
+	categoryAdmin                                  // Команды администраторов
+)
+
+// commandCategoryTitles содержит заголовки разделов /help в порядке вывода.
+var commandCategoryTitles = []string{
+	categoryGeneral:         "General",
+	categoryCharacters:      "Characters",
+	categoryPersonalization: "Personalization",
+	categoryModel:           "Model settings",
+	categoryChat:            "Chat",
+	categoryAdmin:           "Administration",
+}
+
+// botCommand описывает команду бота в реестре команд.
+type botCommand struct {
+	name        string          // Команда вместе с "/", например "/menu"
+	description string          // Краткое описание для /help
+	category    commandCategory // Раздел /help, в котором выводится команда
+	menuLabel   string          // Надпись кнопки главного меню (пустая строка - команды нет в меню)
+	permission  PermissionLevel // Кому доступна команда
+	generates   bool            // Команда запрашивает ответ модели: на время выполнения показывается "печатает…"
+	handler     commandHandler
+}
+
+// hasPermission проверяет, может ли пользователь выполнять команды с уровнем доступа level.
+func (c *TelegramBotController) hasPermission(user *domain.User, level PermissionLevel) bool {
+	switch level {
+	case PermissionPublic:
+		return true
+	case PermissionAdmin:
+		return c.userUseCase.IsAdmin(user.ID)
+	case PermissionInspectRequests:
+		return c.userUseCase.CanInspectRequests(user.ID)
+	default:
+		return false // Неизвестный уровень доступа запрещает выполнение
+	}
+}
+
+// registerCommands возвращает реестр команд в порядке их вывода в /help.
+func (c *TelegramBotController) registerCommands() []botCommand {
+	return []botCommand{
+		{name: "/start", description: "Start the conversation with the bot", category: categoryGeneral, handler: c.cmdStart},
+		{name: "/help", description: "List all commands", category: categoryGeneral, handler: c.cmdHelp},
+		{name: "/about", description: "Show the bot version and active model backend", category: categoryGeneral, handler: c.cmdAbout},
+		{name: "/menu", description: "Open the main menu", category: categoryGeneral, handler: c.cmdMenu},
+		{name: "/cancel", description: "Cancel a command that is waiting for your input", category: categoryGeneral, handler: c.cmdCancel},
+		{name: "/newchar", description: "Create a new character", category: categoryCharacters, menuLabel: "New Character", handler: c.cmdNewCharacter},
+		{name: "/clone", description: "Fork the current conversation into a new character", category: categoryCharacters, handler: c.cmdClone},
+		{name: "/sharesafe", description: "Export the current character without your chat history or name", category: categoryCharacters, handler: c.cmdShareSafe},
+		{name: "/export", description: "Download the chat history of the current character as a file", category: categoryChat, handler: c.cmdExportChat},
+		{name: "/listchar", description: "List your characters (/listchar recent - most recently updated first)", category: categoryCharacters, menuLabel: "List Characters", handler: c.cmdListCharacters},
+		{name: "/switchchar", description: "Switch to another character", category: categoryCharacters, menuLabel: "Switch Character", handler: c.cmdSwitchCharacter},
+		{name: "/movechar", description: "Move a character to another position in the list", category: categoryCharacters, handler: c.cmdMoveCharacter},
+		{name: "/deletechar", description: "Delete a character and its chat history", category: categoryCharacters, handler: c.cmdDeleteCharacter},
+		{name: "/setprompt", description: "Set the persona prompt of the current character", category: categoryCharacters, menuLabel: "Set Prompt", handler: c.cmdSetPrompt},
+		{name: "/appendprompt", description: "Add a line to the end of the persona prompt", category: categoryCharacters, handler: c.cmdAppendPrompt},
+		{name: "/prependprompt", description: "Add a line to the start of the persona prompt", category: categoryCharacters, handler: c.cmdAppendPrompt},
+		{name: "/setrules", description: "Set behavior rules sent before the persona prompt", category: categoryCharacters, handler: c.cmdSetRules},
+		{name: "/setnote", description: "Set the author's note for the current character", category: categoryCharacters, handler: c.cmdSetNote},
+		{name: "/notedepth", description: "Set how deep in the history the author's note is inserted", category: categoryCharacters, handler: c.cmdNoteDepth},
+		{name: "/setgreeting", description: "Set the greeting of the current character", category: categoryCharacters, menuLabel: "Set Greeting", handler: c.cmdSetGreeting},
+		{name: "/addgreeting", description: "Add an alternate greeting to the current character", category: categoryCharacters, handler: c.cmdAddGreeting},
+		{name: "/greetings", description: "List greetings and their weights", category: categoryCharacters, handler: c.cmdGreetings},
+		{name: "/addexample", description: "Add an example exchange showing how the character talks", category: categoryCharacters, handler: c.cmdAddExample},
+		{name: "/examples", description: "Show or remove (/examples -) the example dialogue of the character", category: categoryCharacters, handler: c.cmdExamples},
+		{name: "/greetingweight", description: "Set how often a greeting is picked for new chats", category: categoryCharacters, handler: c.cmdGreetingWeight},
+		{name: "/delgreeting", description: "Delete an alternate greeting", category: categoryCharacters, handler: c.cmdGreetingWeight},
+		{name: "/setcharname", description: "Rename the current character", category: categoryCharacters, menuLabel: "Set Character Name", handler: c.cmdSetCharacterName},
+		{name: "/setdisplayname", description: "Set the name shown on the current character's replies", category: categoryCharacters, handler: c.cmdSetDisplayName},
+		{name: "/setusername", description: "Set your name", category: categoryPersonalization, menuLabel: "Set My Name", handler: c.cmdSetUserName},
+		{name: "/setuserdesc", description: "Set your description", category: categoryPersonalization, menuLabel: "Set My Description", handler: c.cmdSetUserDescription},
+		{name: "/clearchat", description: "Clear the chat history of the current character", category: categoryChat, menuLabel: "Clear Chat History", handler: c.cmdClearChat},
+		{name: "/clearcontext", description: "Start a fresh topic: keep the history but stop sending it to the model", category: categoryChat, handler: c.cmdClearContext},
+		{name: "/clearall", description: "Clear the chat history of all characters", category: categoryChat, handler: c.cmdClearAll},
+		{name: "/backup", description: "Export all users to JSON files", category: categoryAdmin, permission: PermissionAdmin, handler: c.cmdBackupRestore},
+		{name: "/restore", description: "Restore users from a backup", category: categoryAdmin, permission: PermissionAdmin, handler: c.cmdBackupRestore},
+		{name: "/loglevel", description: "Show or change log levels, globally or per component", category: categoryAdmin, permission: PermissionAdmin, handler: c.cmdLogLevel},
+		{name: "/cleanup", description: "Check your data for inconsistencies and fix them (/cleanup all - every user, admins only)", category: categoryGeneral, handler: c.cmdCleanup},
+		{name: "/forgetme", description: "Delete all your data: characters, chat histories and settings", category: categoryGeneral, handler: c.cmdForgetMe},
+		{name: "/autolang", description: "Toggle replying in the language of your messages", category: categoryPersonalization, handler: c.cmdAutoLanguage},
+		{name: "/ooc", description: "Give an out-of-character instruction for the next reply", category: categoryChat, handler: c.cmdOOC},
+		{name: "/document", description: "Show or remove (/document -) the document attached to the character", category: categoryChat, handler: c.cmdDocument},
+		{name: "/context", description: "Give a document or facts for the next reply only", category: categoryChat, handler: c.cmdContext},
+		{name: "/reapply", description: "Regenerate the reply to your last message", category: categoryChat, generates: true, handler: c.cmdReapply},
+		{name: "/ephemeral", description: "Toggle keeping the current chat in memory only", category: categoryChat, handler: c.cmdEphemeral},
+		{name: "/translategreeting", description: "Toggle translating the greeting to your language", category: categoryCharacters, handler: c.cmdTranslateGreeting},
+		{name: "/setparams", description: "Set model parameters as JSON", category: categoryModel, handler: c.cmdSetParams},
+		{name: "/settemp", description: "Set the current character's temperature (0-2)", category: categoryModel, handler: c.cmdSetTemperature},
+		{name: "/setmaxtokens", description: "Set the current character's maximum reply length in tokens", category: categoryModel, handler: c.cmdSetMaxTokens},
+		{name: "/getparams", description: "Show the model parameters", category: categoryModel, handler: c.cmdGetParams},
+		{name: "/seedgreeting", description: "Toggle starting new chats from the greeting", category: categoryCharacters, handler: c.cmdSeedGreeting},
+		{name: "/summarize", description: "Summarize old messages instead of dropping them when the history is full", category: categoryModel, handler: c.cmdSummarize},
+		{name: "/promptposition", description: "Choose where the system prompt is placed", category: categoryModel, handler: c.cmdPromptPosition},
+		{name: "/whisper", description: "Toggle private replies in group chats, or whisper one message", category: categoryPersonalization, generates: true, handler: c.cmdWhisper},
+		{name: "/setmode", description: "Switch the current character between assistant and roleplay mode", category: categoryCharacters, handler: c.cmdSetMode},
+		{name: "/labelturns", description: "Toggle speaker names in messages sent to the model", category: categoryModel, handler: c.cmdLabelTurns},
+		{name: "/minlength", description: "Set the minimum reply length and automatic continuations", category: categoryModel, handler: c.cmdMinLength},
+		{name: "/timebudget", description: "Set the maximum time the character may take to reply", category: categoryModel, handler: c.cmdTimeBudget},
+		{name: "/setstop", description: "Add a string that ends the character's reply (/setstop - to clear)", category: categoryModel, handler: c.cmdSetStop},
+		{name: "/trimuser", description: "Toggle cutting replies at the user's next line", category: categoryModel, handler: c.cmdTrimUser},
+		{name: "/placeholders", description: "Turn {{user}}/{{char}} substitution on or off for the current character", category: categoryModel, handler: c.cmdPlaceholders},
+		{name: "/normalize", description: "Toggle reply cleanup rules: blank lines, trailing spaces, Markdown", category: categoryModel, handler: c.cmdNormalize},
+		{name: "/jsonmode", description: "Toggle requiring replies as a JSON object", category: categoryModel, handler: c.cmdJSONMode},
+		{name: "/try", description: "Test the current prompt on a sample message without saving anything", category: categoryChat, generates: true, handler: c.cmdTry},
+		{name: "/retrywith", description: "Regenerate the last reply with one-off parameters", category: categoryChat, generates: true, handler: c.cmdRetryWith},
+		{name: "/lastrequest", description: "Show the last request sent to the model", category: categoryAdmin, permission: PermissionInspectRequests, handler: c.cmdLastRequest},
+		{name: "/sizes", description: "Show how much of the model context each prompt field takes", category: categoryChat, handler: c.cmdSizes},
+		{name: "/charinfo", description: "Show information about the current character", category: categoryCharacters, menuLabel: "Character Info", handler: c.cmdCharacterInfo},
+		{name: "/subscribe", description: "Receive a daily digest of your conversation, optionally in your timezone", category: categoryPersonalization, handler: c.cmdSubscribe},
+		{name: "/timezone", description: "Show or change your timezone (/timezone - resets it to UTC)", category: categoryPersonalization, handler: c.cmdTimezone},
+		{name: "/unsubscribe", description: "Stop receiving daily digests", category: categoryPersonalization, handler: c.cmdUnsubscribe},
+	}
+}
+
+// cmdHelp обрабатывает команду /help. Команды выводятся по разделам в порядке реестра;
+// показываются только команды, доступные пользователю, а пустые разделы пропускаются.
+func (c *TelegramBotController) cmdHelp(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	var sb strings.Builder
+	sb.WriteString("<b>Available commands:</b>\n")
+	for category, title := range commandCategoryTitles {
+		header := false
+		for _, cmd := range c.commands {
+			if cmd.category != commandCategory(category) || !c.hasPermission(user, cmd.permission) {
+				continue
+			}
+			if !header {
+				fmt.Fprintf(&sb, "\n<b>%s</b>\n", title)
+				header = true
+			}
+			fmt.Fprintf(&sb, "%s - %s\n", cmd.name, html.EscapeString(cmd.description))
+		}
+	}
+	return sb.String(), nil
+}
+
+// setPendingCommand запоминает команду, ожидающую ввода от пользователя следующим сообщением.
+func (c *TelegramBotController) setPendingCommand(ctx context.Context, user *domain.User, pending string) {
+	if err := c.userUseCase.UpdateUser(ctx, user, pendingCommandMutation(pending)); err != nil {
+		c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
+	}
+}
+
+// pendingCommandMutation возвращает изменение, задающее ожидаемую команду; пустая строка сбрасывает ее.
+func pendingCommandMutation(pending string) usecases.UserMutation {
+	setAt := time.Now()
+	if pending == "" {
+		setAt = time.Time{}
+	}
+	return func(user *domain.User) error {
+		user.PendingCommand, user.PendingCommandSetAt = pending, setAt
+		return nil
+	}
+}
+
+// lastMessageMutation возвращает изменение, запоминающее ID последнего сообщения бота пользователю.
+func lastMessageMutation(messageID int) usecases.UserMutation {
+	return func(user *domain.User) error {
+		user.LastMessageID = messageID
+		return nil
+	}
+}
+
+// cancelKeyboard возвращает клавиатуру с кнопкой отмены ожидающей команды.
+func cancelKeyboard() *telegrambotapi.InlineKeyboardMarkup {
+	keyboard := telegrambotapi.NewInlineKeyboardMarkup(
+		telegrambotapi.NewInlineKeyboardRow(telegrambotapi.NewInlineKeyboardButtonData("Cancel", "/cancel")),
+	)
+	return &keyboard
+}
+
+// cmdCancel обрабатывает команду /cancel: сбрасывает команду, ожидающую ввода.
+func (c *TelegramBotController) cmdCancel(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if user.PendingCommand == "" {
+		response = "There is nothing to cancel."
+		return response, markup
+	}
+	c.logger.Info("User %d cancelled pending command %s", user.ID, user.PendingCommand)
+	c.setPendingCommand(ctx, user, "")
+	response = "Cancelled."
+	return response, markup
+}
+
+// cmdStart обрабатывает команду /start.
+func (c *TelegramBotController) cmdStart(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	response = fmt.Sprintf("Hello, %s! I am your AI assistant. How can I help you today? You can use /menu to see available options.", html.EscapeString(user.UserName))
+	return response, markup
+}
+
+// cmdAbout обрабатывает команду /about.
+func (c *TelegramBotController) cmdAbout(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	info := c.userUseCase.BackendInfo()
+	model := info.Model
+	if model == "" {
+		model = "server default"
+	}
+	response = fmt.Sprintf("<b>Bot version:</b> %s\n<b>Backend:</b> %s\n<b>Model:</b> %s",
+		html.EscapeString(c.version), html.EscapeString(info.Backend), html.EscapeString(model))
+	return response, markup
+}
+
+// cmdMenu обрабатывает команду /menu.
+func (c *TelegramBotController) cmdMenu(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	response = "What would you like to do?"
+	markup = c.createMainMenu(user)
+	return response, markup
+}
+
+// cmdNewCharacter обрабатывает команду /newchar.
+func (c *TelegramBotController) cmdNewCharacter(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	newChar := domain.NewCharacterPreset()
+	err := c.userUseCase.AddCharacter(ctx, user, newChar)
+	if errors.Is(err, usecases.ErrCharacterLimit) {
+		response = "You have reached the maximum number of characters."
+	} else if err != nil {
+		c.logger.Error("Failed to add new character for user %d: %v", user.ID, err)
+		response = "Failed to add new character."
+	} else {
+		response = fmt.Sprintf("New character '%s' added and set as current. Use /setcharname to rename it.", html.EscapeString(newChar.Name))
+	}
+	return response, markup
+}
+
+// cmdClone обрабатывает команду /clone.
+func (c *TelegramBotController) cmdClone(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	fork, err := c.userUseCase.ForkConversation(ctx, user)
+	if errors.Is(err, usecases.ErrCharacterLimit) {
+		response = "You have reached the maximum number of characters."
+	} else if err != nil {
+		c.logger.Error("Failed to fork conversation for user %d: %v", user.ID, err)
+		response = "Failed to fork the conversation."
+	} else {
+		response = fmt.Sprintf("Conversation forked into '%s' with %d messages. It is now your current character; the original is unchanged.", html.EscapeString(fork.Name), len(fork.Chat))
+	}
+	return response, markup
+}
+
+// cmdShareSafe обрабатывает команду /sharesafe.
+func (c *TelegramBotController) cmdShareSafe(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := c.userUseCase.AnonymizeCharacter(user)
+	data, err := c.userUseCase.ExportCharacterCard(char)
+	if err != nil {
+		c.logger.Error("Failed to export character for user %d: %v", user.ID, err)
+		return "Failed to export the character.", markup
+	}
+	document := telegrambotapi.NewDocument(message.Chat.ID, telegrambotapi.FileBytes{
+		Name:  cardFileName(char.Name),
+		Bytes: data,
+	})
+	if _, err := c.botClient.Send(document); err != nil {
+		c.logger.Error("Failed to send character card to user %d: %v", user.ID, err)
+		return "Failed to send the character card.", markup
+	}
+	response = "Here is a shareable copy of the character: no chat history or model parameters, and your name is replaced with {{user}}. Please review it before publishing."
+	return response, markup
+}
+
+// cmdExportChat обрабатывает команду /export: отправляет историю чата текущего персонажа JSON-файлом.
+func (c *TelegramBotController) cmdExportChat(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	data, fileName, err := c.userUseCase.ExportChatHistory(ctx, user)
+	if errors.Is(err, usecases.ErrEmptyChatHistory) {
+		return fmt.Sprintf("There is nothing to export yet: the chat with '%s' is empty.", html.EscapeString(user.GetCurrentCharacter().Name)), markup
+	} else if err != nil {
+		c.logger.Error("Failed to export chat history for user %d: %v", user.ID, err)
+		return "Failed to export the chat history.", markup
+	}
+	document := telegrambotapi.NewDocument(message.Chat.ID, telegrambotapi.FileBytes{
+		Name:  fileName,
+		Bytes: data,
+	})
+	if _, err := c.botClient.Send(document); err != nil {
+		c.logger.Error("Failed to send chat history to user %d: %v", user.ID, err)
+		return "Failed to send the chat history.", markup
+	}
+	return fmt.Sprintf("Here is the chat history with '%s' (%d messages).", html.EscapeString(user.GetCurrentCharacter().Name), len(user.GetCurrentCharacter().Chat)), markup
+}
+
+// cmdLastRequest обрабатывает команду /lastrequest: отправляет тело последнего запроса к модели файлом.
+func (c *TelegramBotController) cmdLastRequest(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	body, err := c.userUseCase.LastRequest(user.ID)
+	if errors.Is(err, usecases.ErrNoLastRequest) {
+		return "No request to the model has been sent for you since the bot started.", markup
+	} else if err != nil {
+		c.logger.Error("Failed to get last request for user %d: %v", user.ID, err)
+		return "Failed to get the last request.", markup
+	}
+	document := telegrambotapi.NewDocument(message.Chat.ID, telegrambotapi.FileBytes{
+		Name:  "last_request.json",
+		Bytes: body,
+	})
+	if _, err := c.botClient.Send(document); err != nil {
+		c.logger.Error("Failed to send last request to user %d: %v", user.ID, err)
+		return "Failed to send the last request.", markup
+	}
+	return "This is the exact body of the last request sent to the model for you.", markup
+}
+
+// cardFileName возвращает имя файла карточки персонажа из букв, цифр, дефисов и подчеркиваний.
+func cardFileName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		if r == ' ' {
+			return '_'
+		}
+		return -1
+	}, name)
+	if safe == "" {
+		safe = "character"
+	}
+	return safe + ".json"
+}
+
+// cmdListCharacters обрабатывает команду /listchar [recent]. С аргументом recent персонажи
+// упорядочиваются по времени последнего изменения, но сохраняют свои номера для /switchchar.
+func (c *TelegramBotController) cmdListCharacters(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if len(user.Characters) == 0 {
+		return "You have no characters yet. Use /newchar to create one.", markup
+	}
+
+	order := make([]int, len(user.Characters))
+	for i := range order {
+		order[i] = i
+	}
+	recent := strings.EqualFold(strings.TrimSpace(args), "recent")
+	if recent {
+		sort.SliceStable(order, func(a, b int) bool {
+			return user.Characters[order[a]].LastActivity().After(user.Characters[order[b]].LastActivity())
+		})
+		response = "Your characters, most recently updated first:\n"
+	} else {
+		response = "Your characters:\n"
+	}
+	for _, i := range order {
+		char := user.Characters[i]
+		line := fmt.Sprintf("%d. %s", i+1, html.EscapeString(char.VisibleName()))
+		if recent {
+			line += " — " + formatCharacterTime(user, char.LastActivity())
+		}
+		if i == user.CurrentCharacterID {
+			line += " (current)"
+		}
+		response += line + "\n"
+	}
+	response += "\nUse /switchchar <number> to change, /movechar <from> <to> to reorder, /listchar recent to sort by last update."
+	return response, markup
+}
+
+// formatCharacterTime форматирует время создания или изменения персонажа в часовом поясе пользователя.
+func formatCharacterTime(user *domain.User, t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.In(user.Location()).Format("2006-01-02 15:04")
+}
+
+// cmdSwitchCharacter обрабатывает команду /switchchar.
+func (c *TelegramBotController) cmdSwitchCharacter(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "switch_character")
+	response = "Please enter the number of the character you want to switch to."
+	return response, markup
+}
+
+// cmdMoveCharacter обрабатывает команду /movechar <откуда> <куда> (номера из /listchar).
+func (c *TelegramBotController) cmdMoveCharacter(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	var from, to int
+	if _, err := fmt.Sscanf(args, "%d %d", &from, &to); err != nil {
+		response = "Usage: /movechar &lt;from&gt; &lt;to&gt;, using the numbers from /listchar."
+		return response, markup
+	}
+	if from < 1 || from > len(user.Characters) || to < 1 || to > len(user.Characters) {
+		response = fmt.Sprintf("Character numbers must be between 1 and %d.", len(user.Characters))
+		return response, markup
+	}
+	name := user.Characters[from-1].Name
+	if err := c.userUseCase.MoveCharacter(ctx, user, from-1, to-1); err != nil {
+		c.logger.Error("Failed to move character for user %d: %v", user.ID, err)
+		response = "Failed to move the character."
+	} else {
+		response = fmt.Sprintf("Moved '%s' to position %d.", html.EscapeString(name), to)
+	}
+	return response, markup
+}
+
+// cmdDeleteCharacter обрабатывает команду /deletechar [номер]. Удаление выполняется только после
+// подтверждения кнопкой; кнопка ссылается на ID персонажа, а не на номер, который мог измениться.
+func (c *TelegramBotController) cmdDeleteCharacter(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	action, value, _ := strings.Cut(args, " ")
+	switch action {
+	case "":
+		c.setPendingCommand(ctx, user, "delete_character")
+		response = "Please enter the number of the character you want to delete."
+	case "cancel":
+		response = "No character was deleted."
+	case "confirm":
+		response = c.deleteCharacterByID(ctx, user, value)
+	default:
+		response, markup = confirmCharacterDeletion(user, args)
+	}
+	return response, markup
+}
+
+// confirmCharacterDeletion проверяет номер персонажа из /listchar и возвращает вопрос с кнопками подтверждения.
+func confirmCharacterDeletion(user *domain.User, input string) (response string, markup interface{}) {
+	charIndex, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil || charIndex <= 0 || charIndex > len(user.Characters) {
+		return "Invalid character number. Please enter a valid number from the list.", nil
+	}
+	if len(user.Characters) == 1 {
+		return "You cannot delete your only character. Create another one with /newchar first.", nil
+	}
+	character := user.Characters[charIndex-1]
+	response = fmt.Sprintf("Delete '%s' and its chat history? This cannot be undone.", html.EscapeString(character.VisibleName()))
+	keyboard := telegrambotapi.NewInlineKeyboardMarkup(telegrambotapi.NewInlineKeyboardRow(
+		telegrambotapi.NewInlineKeyboardButtonData("Yes, delete", fmt.Sprintf("/deletechar confirm %d", character.ID)),
+		telegrambotapi.NewInlineKeyboardButtonData("Cancel", "/deletechar cancel"),
+	))
+	return response, &keyboard
+}
+
+// deleteCharacterByID удаляет персонажа с ID из подтверждения /deletechar.
+func (c *TelegramBotController) deleteCharacterByID(ctx context.Context, user *domain.User, value string) string {
+	id, err := strconv.Atoi(value)
+	if err != nil {
+		return "Invalid confirmation. Use /deletechar again."
+	}
+	charIndex := -1
+	for i, character := range user.Characters {
+		if character.ID == id {
+			charIndex = i
+			break
+		}
+	}
+	if charIndex == -1 {
+		return "This character no longer exists."
+	}
+	name := user.Characters[charIndex].VisibleName()
+	err = c.userUseCase.DeleteCharacter(ctx, user, charIndex)
+	if errors.Is(err, usecases.ErrLastCharacter) {
+		return "You cannot delete your only character. Create another one with /newchar first."
+	}
+	if err != nil {
+		c.logger.Error("Failed to delete character for user %d: %v", user.ID, err)
+		return "Failed to delete the character."
+	}
+	return fmt.Sprintf("Deleted '%s'. Current character: %s", html.EscapeString(name), html.EscapeString(user.GetCurrentCharacter().VisibleName()))
+}
+
+// cmdSetPrompt обрабатывает команду /setprompt.
+func (c *TelegramBotController) cmdSetPrompt(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_prompt")
+	response = "Please enter the new prompt for the current character:"
+	return response, markup
+}
+
+// cmdAppendPrompt обрабатывает команды /appendprompt и /prependprompt.
+func (c *TelegramBotController) cmdAppendPrompt(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if command == "/prependprompt" {
+		c.setPendingCommand(ctx, user, "prepend_prompt")
+		response = "Please enter the text to add at the start of the current character's prompt:"
+	} else {
+		c.setPendingCommand(ctx, user, "append_prompt")
+		response = "Please enter the text to add at the end of the current character's prompt:"
+	}
+	return response, markup
+}
+
+// cmdSetRules обрабатывает команду /setrules.
+func (c *TelegramBotController) cmdSetRules(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_rules")
+	response = "Please enter the behavior rules for the current character (sent to the model before the persona prompt). Send <b>-</b> to remove them:"
+	return response, markup
+}
+
+// cmdSetNote обрабатывает команду /setnote.
+func (c *TelegramBotController) cmdSetNote(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_authors_note")
+	response = fmt.Sprintf("Please enter the author's note for the current character. It is inserted %d message(s) before the end of the conversation (change with /notedepth). Send <b>-</b> to remove it:", user.GetCurrentCharacter().NoteDepth)
+	return response, markup
+}
+
+// cmdNoteDepth обрабатывает команду /notedepth.
+func (c *TelegramBotController) cmdNoteDepth(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args == "" {
+		response = fmt.Sprintf("The author's note for '%s' is inserted %d message(s) before the end of the conversation.\nUsage: /notedepth &lt;messages&gt;", html.EscapeString(char.Name), char.NoteDepth)
+		return response, markup
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "NoteDepth", args)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to set note depth for user %d: %v", user.ID, err)
+		response = "Failed to change the note depth."
+	} else {
+		response = fmt.Sprintf("The author's note will be inserted %d message(s) before the end of the conversation.", char.NoteDepth)
+	}
+	return response, markup
+}
+
+// cmdSetGreeting обрабатывает команду /setgreeting.
+func (c *TelegramBotController) cmdSetGreeting(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_greeting")
+	response = "Please enter the new greeting for the current character:"
+	return response, markup
+}
+
+// cmdAddGreeting обрабатывает команду /addgreeting.
+func (c *TelegramBotController) cmdAddGreeting(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "add_greeting")
+	response = "Please enter an alternate greeting for the current character:"
+	return response, markup
+}
+
+// cmdGreetings обрабатывает команду /greetings.
+func (c *TelegramBotController) cmdGreetings(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>Greetings of '%s':</b>\n", html.EscapeString(char.Name))
+	fmt.Fprintf(&sb, "1. (main, weight %g) %s\n", char.MainGreetingWeight(), html.EscapeString(char.Greeting))
+	for i, greeting := range char.AlternateGreetings {
+		fmt.Fprintf(&sb, "%d. (weight %g) %s\n", i+2, greeting.Weight, html.EscapeString(greeting.Text))
+	}
+	sb.WriteString("\nNew chats start with a greeting picked at random by weight (with /seedgreeting on). " +
+		"Use /addgreeting, /greetingweight &lt;number&gt; &lt;weight&gt; and /delgreeting &lt;number&gt;.")
+	return sb.String(), markup
+}
+
+// cmdAddExample обрабатывает команду /addexample.
+func (c *TelegramBotController) cmdAddExample(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if user.GetCurrentCharacter().ExamplePairs() >= domain.MaxExamplePairs {
+		return fmt.Sprintf("The character already has %d example exchanges. Use /examples - to remove them.", domain.MaxExamplePairs), markup
+	}
+	c.setPendingCommand(ctx, user, "add_example")
+	response = "Please send an example exchange for the current character, for example:\n" +
+		"<code>{{user}}: How are you?\n{{char}}: Splendid, as always!</code>"
+	return response, markup
+}
+
+// cmdExamples обрабатывает команду /examples. С аргументом "-" пример диалога удаляется.
+func (c *TelegramBotController) cmdExamples(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if len(char.ExampleDialogue) == 0 {
+		return "The character has no example dialogue. Use /addexample to add an exchange.", markup
+	}
+	if args == "-" {
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "ClearExamples", ""); err != nil {
+			c.logger.Error("Failed to clear example dialogue for user %d: %v", user.ID, err)
+			return "Failed to remove the example dialogue.", markup
+		}
+		return "The example dialogue was removed.", markup
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>Example dialogue of '%s':</b>\n", html.EscapeString(char.Name))
+	for _, msg := range char.ExampleDialogue {
+		label := "{{user}}"
+		if msg.RoleEnum() == domain.Assistant {
+			label = "{{char}}"
+		}
+		fmt.Fprintf(&sb, "\n<b>%s:</b> %s", label, html.EscapeString(msg.Content))
+	}
+	sb.WriteString("\n\nThe model sees these exchanges before the conversation. Use /addexample to add one or /examples - to remove all.")
+	return sb.String(), markup
+}
+
+// cmdGreetingWeight обрабатывает команды /greetingweight и /delgreeting.
+func (c *TelegramBotController) cmdGreetingWeight(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	prop, done := "GreetingWeight", "Greeting weight updated."
+	if command == "/delgreeting" {
+		prop, done = "DeleteGreeting", "Greeting deleted."
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, prop, args)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to update greetings for user %d: %v", user.ID, err)
+		response = "Failed to update the greetings."
+	} else {
+		response = done + " Use /greetings to review them."
+	}
+	return response, markup
+}
+
+// cmdSetCharacterName обрабатывает команду /setcharname.
+func (c *TelegramBotController) cmdSetCharacterName(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_character_name")
+	response = "Please enter the new name for the current character:"
+	return response, markup
+}
+
+// cmdSetDisplayName обрабатывает команду /setdisplayname.
+func (c *TelegramBotController) cmdSetDisplayName(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_display_name")
+	response = "Please enter the name shown on the current character's replies, or - to remove it. The prompt keeps using the character name."
+	return response, markup
+}
+
+// cmdSetUserName обрабатывает команду /setusername.
+func (c *TelegramBotController) cmdSetUserName(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_user_name")
+	response = "Please enter your new username:"
+	return response, markup
+}
+
+// cmdSetUserDescription обрабатывает команду /setuserdesc.
+func (c *TelegramBotController) cmdSetUserDescription(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_user_description")
+	response = "Please enter your new description:"
+	return response, markup
+}
+
+// cmdClearChat обрабатывает команду /clearchat.
+func (c *TelegramBotController) cmdClearChat(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	err := c.userUseCase.ClearChatHistory(ctx, user)
+	if err != nil {
+		c.logger.Error("Failed to clear chat history for user %d: %v", user.ID, err)
+		response = "Failed to clear chat history."
+	} else {
+		response = "Chat history cleared."
+	}
+	return response, markup
+}
+
+// cmdClearContext обрабатывает команду /clearcontext.
+func (c *TelegramBotController) cmdClearContext(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	hidden, err := c.userUseCase.ClearContext(ctx, user)
+	if err != nil {
+		c.logger.Error("Failed to clear context for user %d: %v", user.ID, err)
+		response = "Failed to clear the context."
+	} else {
+		response = fmt.Sprintf("Starting a fresh topic. %d earlier message(s) are kept in the history but no longer sent to the model. Use /clearchat to delete them.", hidden)
+	}
+	return response, markup
+}
+
+// cmdClearAll обрабатывает команду /clearall.
+func (c *TelegramBotController) cmdClearAll(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	switch args {
+	case "confirm":
+		cleared, err := c.userUseCase.ClearAllHistories(ctx, user)
+		if err != nil {
+			c.logger.Error("Failed to clear all chat histories for user %d: %v", user.ID, err)
+			response = "Failed to clear chat histories."
+		} else {
+			response = fmt.Sprintf("Cleared chat history for %d character(s). Character settings were kept.", cleared)
+		}
+	case "cancel":
+		response = "Nothing was cleared."
+	default:
+		response = fmt.Sprintf("Clear the chat history of <b>all %d characters</b>? Character settings will be kept. This cannot be undone.", len(user.Characters))
+		keyboard := telegrambotapi.NewInlineKeyboardMarkup(telegrambotapi.NewInlineKeyboardRow(
+			telegrambotapi.NewInlineKeyboardButtonData("Yes, clear all", "/clearall confirm"),
+			telegrambotapi.NewInlineKeyboardButtonData("Cancel", "/clearall cancel"),
+		))
+		markup = &keyboard
+	}
+	return response, markup
+}
+
+// cmdForgetMe обрабатывает команду /forgetme: после подтверждения удаляет все данные пользователя.
+// Следующее сообщение создаст пользователя заново с настройками по умолчанию.
+func (c *TelegramBotController) cmdForgetMe(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	switch args {
+	case "confirm":
+		if err := c.userUseCase.ForgetUser(ctx, user); err != nil {
+			c.logger.Error("Failed to delete data of user %d: %v", user.ID, err)
+			response = "Failed to delete your data. Please try again later."
+		} else {
+			response = "All your data has been deleted. Send any message to start over with default settings."
+		}
+	case "cancel":
+		response = "Nothing was deleted."
+	default:
+		response = fmt.Sprintf("Delete <b>all your data</b>: %d character(s), their chat histories and your settings? This cannot be undone.", len(user.Characters))
+		keyboard := telegrambotapi.NewInlineKeyboardMarkup(telegrambotapi.NewInlineKeyboardRow(
+			telegrambotapi.NewInlineKeyboardButtonData("Yes, delete everything", "/forgetme confirm"),
+			telegrambotapi.NewInlineKeyboardButtonData("Cancel", "/forgetme cancel"),
+		))
+		markup = &keyboard
+	}
+	return response, markup
+}
+
+// maxCleanupReportUsers сколько исправленных пользователей перечислять в ответе на /cleanup all.
+const maxCleanupReportUsers = 20
+
+// cmdCleanup обрабатывает команду /cleanup. С аргументом "all" администратор проверяет всех пользователей.
+func (c *TelegramBotController) cmdCleanup(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "all" {
+		report, err := c.userUseCase.CleanupAllUsers(ctx, user)
+		if errors.Is(err, usecases.ErrNotAdmin) {
+			return "Checking all users is only available to administrators.", markup
+		} else if err != nil {
+			c.logger.Error("Failed to clean up users for admin %d: %v", user.ID, err)
+			return fmt.Sprintf("Cleanup failed after checking %d user(s). Check the logs.", report.Checked), markup
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Checked %d user(s), fixed %d.", report.Checked, len(report.Fixed))
+		userIDs := make([]int64, 0, len(report.Fixed))
+		for userID := range report.Fixed {
+			userIDs = append(userIDs, userID)
+		}
+		sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+		for i, userID := range userIDs {
+			if i == maxCleanupReportUsers {
+				fmt.Fprintf(&sb, "\n...and %d more, see the logs.", len(userIDs)-i)
+				break
+			}
+			fmt.Fprintf(&sb, "\n<b>%d</b>: %s", userID, html.EscapeString(strings.Join(report.Fixed[userID], "; ")))
+		}
+		return sb.String(), markup
+	}
+
+	repairs, err := c.userUseCase.CleanupUser(ctx, user)
+	if err != nil {
+		c.logger.Error("Failed to clean up data for user %d: %v", user.ID, err)
+		return "Failed to save the fixes. Please try again.", markup
+	}
+	if len(repairs) == 0 {
+		return "Your data is consistent, nothing to fix.", markup
+	}
+	var sb strings.Builder
+	sb.WriteString("Fixed:")
+	for _, repair := range repairs {
+		sb.WriteString("\n- " + html.EscapeString(repair))
+	}
+	return sb.String(), markup
+}
+
+// cmdBackupRestore обрабатывает команды /backup и /restore.
+func (c *TelegramBotController) cmdBackupRestore(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	switch {
+	case args == "cancel":
+		response = "Cancelled."
+	case args == "confirm" && command == "/backup":
+		exported, err := c.sendBackup(ctx, user)
+		if err != nil {
+			c.logger.Error("Failed to create backup for admin %d: %v", user.ID, err)
+			response = "Failed to create the backup."
+		} else {
+			response = fmt.Sprintf("Backup of %d user(s) sent to your private chat.", exported)
+		}
+	case args == "confirm":
+		c.setPendingCommand(ctx, user, "restore_backup")
+		response = "Send the backup file(s) now. Existing users with the same IDs will be overwritten. Send any command to finish."
+	default:
+		if command == "/backup" {
+			response = "Export all users to JSON files? The files contain every user's characters and chat history and will be sent to your private chat."
+		} else {
+			response = "Restore users from a backup? <b>Users in the backup will overwrite existing data.</b>"
+		}
+		keyboard := telegrambotapi.NewInlineKeyboardMarkup(telegrambotapi.NewInlineKeyboardRow(
+			telegrambotapi.NewInlineKeyboardButtonData("Yes, continue", command+" confirm"),
+			telegrambotapi.NewInlineKeyboardButtonData("Cancel", command+" cancel"),
+		))
+		markup = &keyboard
+	}
+	return response, markup
+}
+
+// cmdAutoLanguage обрабатывает команду /autolang.
+func (c *TelegramBotController) cmdAutoLanguage(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.AutoLanguage {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "AutoLanguage", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle auto language for user %d: %v", user.ID, err)
+		response = "Failed to change automatic language detection."
+	} else {
+		response = fmt.Sprintf("Automatic response language is now %s for '%s'.", value, html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdOOC обрабатывает команду /ooc.
+func (c *TelegramBotController) cmdOOC(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "" {
+		c.setPendingCommand(ctx, user, "set_ooc")
+		response = "Please enter an out-of-character instruction for the next reply:"
+	} else {
+		response = c.setOOCInstruction(ctx, user, args)
+	}
+	return response, markup
+}
+
+// cmdContext обрабатывает команду /context.
+func (c *TelegramBotController) cmdContext(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "" {
+		c.setPendingCommand(ctx, user, "set_next_context")
+		response = "Please send the text (a document or facts) the character should know for the next reply, or '-' to drop the pending context:"
+	} else {
+		response = c.setNextContext(ctx, user, args)
+	}
+	return response, markup
+}
+
+// cmdDocument обрабатывает команду /document: показывает прикрепленный документ, а с аргументом "-" удаляет его.
+func (c *TelegramBotController) cmdDocument(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if char.Document == "" {
+		return "No document is attached. Send a .txt or .md file to attach one to the current character.", markup
+	}
+	if args == "-" {
+		if err := c.userUseCase.DetachDocument(ctx, user); err != nil {
+			c.logger.Error("Failed to detach document for user %d: %v", user.ID, err)
+			return "Failed to remove the document.", markup
+		}
+		return fmt.Sprintf("The document '%s' was removed.", html.EscapeString(char.DocumentName)), markup
+	}
+	response = fmt.Sprintf("'%s' has the document '%s' attached (%d characters). Use /document - to remove it.",
+		html.EscapeString(char.Name), html.EscapeString(char.DocumentName), utf8.RuneCountInString(char.Document))
+	return response, markup
+}
+
+// cmdReapply обрабатывает команду /reapply.
+func (c *TelegramBotController) cmdReapply(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	reply, err := c.userUseCase.ReapplyLastUserMessage(ctx, user)
+	if errors.Is(err, usecases.ErrNoUserMessage) {
+		response = "There is no message to reapply yet. Send something to the character first."
+	} else if truncated, ok := c.truncatedReply(user, reply, err); ok {
+		response = truncated
+	} else if err != nil {
+		response = c.modelErrorResponse(user, err)
+	} else {
+		response = formatReply(user.GetCurrentCharacter(), reply)
+	}
+	return response, markup
+}
+
+// cmdEphemeral обрабатывает команду /ephemeral.
+func (c *TelegramBotController) cmdEphemeral(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.Ephemeral {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "Ephemeral", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle ephemeral mode for user %d: %v", user.ID, err)
+		response = "Failed to change ephemeral mode."
+	} else if char.Ephemeral {
+		response = fmt.Sprintf("'%s' is now ephemeral: the conversation is kept in memory only and will be lost when the bot restarts.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("'%s' is no longer ephemeral: the conversation will be saved again.", html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdTranslateGreeting обрабатывает команду /translategreeting.
+func (c *TelegramBotController) cmdTranslateGreeting(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.TranslateGreeting {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "TranslateGreeting", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle greeting translation for user %d: %v", user.ID, err)
+		response = "Failed to change greeting translation."
+	} else {
+		response = fmt.Sprintf("Greeting translation is now %s for '%s'.", value, html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdSetParams обрабатывает команду /setparams.
+func (c *TelegramBotController) cmdSetParams(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_params")
+	response = "Please send the model parameters as a JSON object, for example:\n<code>{\"temperature\": 0.8, \"max_tokens\": 300}</code>\nUse /getparams to see the current values."
+	return response, markup
+}
+
+// cmdSetTemperature обрабатывает команду /settemp.
+func (c *TelegramBotController) cmdSetTemperature(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_temperature")
+	response = fmt.Sprintf("Current temperature of '%s': %g.\nPlease send a new value from 0 to 2 (lower is more focused, higher is more creative).",
+		html.EscapeString(user.GetCurrentCharacter().Name), c.userUseCase.GetModelConfig(user).Temperature)
+	return response, markup
+}
+
+// cmdSetMaxTokens обрабатывает команду /setmaxtokens.
+func (c *TelegramBotController) cmdSetMaxTokens(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_max_tokens")
+	response = fmt.Sprintf("Current reply limit of '%s': %d tokens.\nPlease send a new limit from 1 to 4096.",
+		html.EscapeString(user.GetCurrentCharacter().Name), c.userUseCase.GetModelConfig(user).MaxTokens)
+	return response, markup
+}
+
+// cmdGetParams обрабатывает команду /getparams.
+func (c *TelegramBotController) cmdGetParams(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	params, err := json.MarshalIndent(c.userUseCase.GetModelConfig(user), "", "  ")
+	if err != nil {
+		c.logger.Error("Failed to marshal model config for user %d: %v", user.ID, err)
+		response = "Failed to show model parameters."
+	} else {
+		response = fmt.Sprintf("<b>Model parameters for '%s':</b>\n<pre>%s</pre>", html.EscapeString(user.GetCurrentCharacter().Name), html.EscapeString(string(params)))
+	}
+	return response, markup
+}
+
+// cmdPlaceholders обрабатывает команду /placeholders on|off. Без аргумента показывает текущее состояние.
+func (c *TelegramBotController) cmdPlaceholders(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := strings.ToLower(strings.TrimSpace(args))
+	if value != "on" && value != "off" {
+		state := "on"
+		if !char.PlaceholdersEnabled() {
+			state = "off"
+		}
+		response = fmt.Sprintf("Placeholder substitution is %s for '%s'. Usage: /placeholders on|off", state, html.EscapeString(char.Name))
+		return response, markup
+	}
+	if err := c.userUseCase.UpdateUserProperty(ctx, user, "EnablePlaceholders", value); err != nil {
+		c.logger.Error("Failed to toggle placeholders for user %d: %v", user.ID, err)
+		response = "Failed to change placeholder substitution."
+	} else if value == "on" {
+		response = fmt.Sprintf("{{user}} and {{char}} will be replaced with names for '%s' again.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("Placeholder substitution is now off for '%s': prompts and messages are sent to the model as written.", html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdSummarize обрабатывает команду /summarize on|off. Без аргументов показывает состояние и текущее изложение.
+func (c *TelegramBotController) cmdSummarize(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := strings.ToLower(strings.TrimSpace(args))
+	if value != "on" && value != "off" {
+		state := "off: the oldest messages are dropped"
+		if char.SummarizeHistory {
+			state = "on: the oldest messages are summarized"
+		}
+		response = fmt.Sprintf("When the history of '%s' is full, %s. Usage: /summarize on|off", html.EscapeString(char.Name), state)
+		if char.HistorySummary != "" {
+			response += "\n\n<b>Summary of the earlier conversation:</b>\n" + html.EscapeString(char.HistorySummary)
+		}
+		return response, markup
+	}
+	if err := c.userUseCase.UpdateUserProperty(ctx, user, "SummarizeHistory", value); err != nil {
+		c.logger.Error("Failed to toggle history summarization for user %d: %v", user.ID, err)
+		response = "Failed to change history summarization."
+	} else if value == "on" {
+		response = fmt.Sprintf("When the history of '%s' reaches %d messages, the oldest ones will be summarized instead of dropped.",
+			html.EscapeString(char.Name), c.userUseCase.ChatHistoryLimit())
+	} else {
+		response = fmt.Sprintf("The oldest messages of '%s' will be dropped when the history is full. The existing summary is kept until the history is cleared.",
+			html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdNormalize обрабатывает команду /normalize <правило> on|off. Без аргументов показывает состояние правил.
+func (c *TelegramBotController) cmdNormalize(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args != "" {
+		err := c.userUseCase.UpdateUserProperty(ctx, user, "Normalization", args)
+		if errors.Is(err, usecases.ErrInvalidParameters) {
+			return html.EscapeString(err.Error()) + "\nUsage: /normalize &lt;rule&gt; on|off", markup
+		} else if err != nil {
+			c.logger.Error("Failed to update normalization for user %d: %v", user.ID, err)
+			return "Failed to change the normalization rules.", markup
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Reply normalization for '%s':\n", html.EscapeString(char.Name))
+	for _, rule := range domain.NormalizationRules() {
+		state := "off"
+		if char.Normalization.Enabled(rule) {
+			state = "on"
+		}
+		fmt.Fprintf(&sb, "%s: %s\n", rule, state)
+	}
+	sb.WriteString("\nblanklines collapses runs of empty lines, trailing removes spaces at line ends, " +
+		"markdown turns **bold**, *italic*, ~~strike~~ and `code` into Telegram formatting. Code blocks are left as is. " +
+		"Usage: /normalize &lt;rule&gt; on|off")
+	return sb.String(), markup
+}
+
+// cmdJSONMode обрабатывает команду /jsonmode.
+func (c *TelegramBotController) cmdJSONMode(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.ModelConfig != nil && char.ModelConfig.JSONMode {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "JSONMode", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle JSON mode for user %d: %v", user.ID, err)
+		response = "Failed to change the JSON mode."
+	} else if value == "on" {
+		response = fmt.Sprintf("JSON mode is now on for '%s': the model is asked to reply with a single JSON object, "+
+			"and invalid JSON is requested once more. Backends without response_format support get the instruction only.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("JSON mode is now off for '%s'.", html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdSeedGreeting обрабатывает команду /seedgreeting.
+func (c *TelegramBotController) cmdSeedGreeting(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.SeedGreeting {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "SeedGreeting", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle greeting seeding for user %d: %v", user.ID, err)
+		response = "Failed to change the greeting option."
+	} else {
+		response = fmt.Sprintf("Starting new chats from the greeting is now %s for '%s'.", value, html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdPromptPosition обрабатывает команду /promptposition.
+func (c *TelegramBotController) cmdPromptPosition(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	c.setPendingCommand(ctx, user, "set_prompt_position")
+	response = "Where should the system prompt be placed? Enter <b>start</b> (before the history), <b>end</b> (after the history) or <b>both</b>."
+	return response, markup
+}
+
+// cmdWhisper обрабатывает команду /whisper.
+func (c *TelegramBotController) cmdWhisper(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "" {
+		value := "on"
+		if user.WhisperMode {
+			value = "off"
+		}
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "WhisperMode", value); err != nil {
+			c.logger.Error("Failed to toggle whisper mode for user %d: %v", user.ID, err)
+			response = "Failed to change whisper mode."
+		} else {
+			response = fmt.Sprintf("Whisper mode is now %s: in group chats my replies to you will be sent privately. You can also use /whisper &lt;message&gt; for a single private reply.", value)
+		}
+		return response, markup
+	}
+	reply, err := c.userUseCase.GetModelResponseForUser(ctx, user, args)
+	if truncated, ok := c.truncatedReply(user, reply, err); ok {
+		reply, err = truncated, nil
+	}
+	if err != nil {
+		response = c.modelErrorResponse(user, err)
+	} else if isGroupChat(message.Chat) {
+		response = c.whisperReply(ctx, user, reply)
+	} else {
+		response = reply // В личном чате ответ и так приватный
+	}
+	return response, markup
+}
+
+// cmdSetMode обрабатывает команду /setmode.
+func (c *TelegramBotController) cmdSetMode(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args == "" {
+		response = fmt.Sprintf("'%s' is in <b>%s</b> mode.\nUsage: /setmode assistant or /setmode roleplay", html.EscapeString(char.Name), char.EffectiveMode())
+		return response, markup
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "Mode", args)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to set mode for user %d: %v", user.ID, err)
+		response = "Failed to change the character mode."
+	} else if char.EffectiveMode() == domain.ModeRoleplay {
+		response = fmt.Sprintf("'%s' is now in roleplay mode: speaker labels and the greeting as the first turn are on, temperature is %g.", html.EscapeString(char.Name), char.ModelConfig.Temperature)
+	} else {
+		response = fmt.Sprintf("'%s' is now in assistant mode: speaker labels and the greeting as the first turn are off, temperature is %g.", html.EscapeString(char.Name), char.ModelConfig.Temperature)
+	}
+	return response, markup
+}
+
+// cmdLabelTurns обрабатывает команду /labelturns.
+func (c *TelegramBotController) cmdLabelTurns(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.LabelTurns {
+		value = "off"
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "LabelTurns", value)
+	if err != nil {
+		c.logger.Error("Failed to toggle turn labels for user %d: %v", user.ID, err)
+		response = "Failed to change speaker labels."
+	} else if char.LabelTurns {
+		response = fmt.Sprintf("Speaker labels are now on for '%s'. The model will see the conversation like this:\n<pre>%s: Hi there!\n%s: Hello! How can I help you today?</pre>",
+			html.EscapeString(char.Name), html.EscapeString(user.UserName), html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("Speaker labels are now off for '%s'.", html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdMinLength обрабатывает команду /minlength.
+func (c *TelegramBotController) cmdMinLength(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args == "" {
+		response = fmt.Sprintf("Minimum reply length for '%s': %d characters, up to %d continuation(s).\nUsage: /minlength &lt;characters&gt; [continuations], /minlength 0 to disable.",
+			html.EscapeString(char.Name), char.MinResponseLength, char.MaxContinuations)
+		return response, markup
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "MinResponseLength", args)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to set minimum response length for user %d: %v", user.ID, err)
+		response = "Failed to change the minimum reply length."
+	} else if char.MinResponseLength == 0 {
+		response = fmt.Sprintf("Minimum reply length is now off for '%s'.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("Replies from '%s' shorter than %d characters will be continued up to %d time(s).",
+			html.EscapeString(char.Name), char.MinResponseLength, char.MaxContinuations)
+	}
+	return response, markup
+}
+
+// cmdTimeBudget обрабатывает команду /timebudget.
+func (c *TelegramBotController) cmdTimeBudget(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args == "" {
+		budget := "not limited"
+		if char.TimeBudget > 0 {
+			budget = fmt.Sprintf("%d second(s)", char.TimeBudget)
+		}
+		response = fmt.Sprintf("Reply time budget for '%s': %s.\nUsage: /timebudget &lt;seconds&gt;, /timebudget 0 to disable.",
+			html.EscapeString(char.Name), budget)
+		return response, markup
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "TimeBudget", args)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to set time budget for user %d: %v", user.ID, err)
+		response = "Failed to change the time budget."
+	} else if char.TimeBudget == 0 {
+		response = fmt.Sprintf("Reply time budget is now off for '%s'.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("'%s' now has %d second(s) to reply. When the time runs out, the text streamed so far is kept with a note that the reply was cut short; if nothing has arrived yet, the reply is cancelled.",
+			html.EscapeString(char.Name), char.TimeBudget)
+	}
+	return response, markup
+}
+
+// cmdSetStop обрабатывает команду /setstop [строка|-]: без аргумента показывает стоп-последовательности
+// текущего персонажа, со строкой добавляет ее, а "-" удаляет все собственные.
+func (c *TelegramBotController) cmdSetStop(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	if args == "" {
+		stops := c.userUseCase.GetModelConfig(user).StopSequences
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Stop sequences for '%s' (\"{{user}}:\" and \"%s:\" are always used):\n", html.EscapeString(char.Name), html.EscapeString(user.UserName))
+		if len(stops) == 0 {
+			sb.WriteString("no custom stop sequences\n")
+		}
+		for i, stop := range stops {
+			fmt.Fprintf(&sb, "%d. <code>%s</code>\n", i+1, html.EscapeString(stop))
+		}
+		sb.WriteString("Usage: /setstop &lt;text&gt; to add, /setstop - to remove all.")
+		return sb.String(), markup
+	}
+
+	prop, value := "AddStopSequence", args
+	if args == "-" {
+		prop, value = "ClearStopSequences", ""
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, prop, value)
+	if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if err != nil {
+		c.logger.Error("Failed to change stop sequences for user %d: %v", user.ID, err)
+		response = "Failed to change the stop sequences."
+	} else if prop == "ClearStopSequences" {
+		response = fmt.Sprintf("Custom stop sequences removed for '%s'.", html.EscapeString(char.Name))
+	} else {
+		response = fmt.Sprintf("'%s' will stop replying at <code>%s</code>.", html.EscapeString(char.Name), html.EscapeString(args))
+	}
+	return response, markup
+}
+
+// cmdTrimUser обрабатывает команду /trimuser.
+func (c *TelegramBotController) cmdTrimUser(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	value := "on"
+	if char.TrimUserLines && args == "" {
+		value = "off"
+	}
+	if args != "" {
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "TrimMarker", args); err != nil {
+			c.logger.Error("Failed to set trim marker for user %d: %v", user.ID, err)
+			response = "Failed to change the trim marker."
+			return response, markup
+		}
+	}
+	if err := c.userUseCase.UpdateUserProperty(ctx, user, "TrimUserLines", value); err != nil {
+		c.logger.Error("Failed to toggle user line trimming for user %d: %v", user.ID, err)
+		response = "Failed to change reply trimming."
+	} else if char.TrimUserLines {
+		marker := char.TrimMarker
+		if marker == "" {
+			marker = domain.DefaultTrimMarker
+		}
+		response = fmt.Sprintf("Replies from '%s' will now be cut at the first <code>%s</code>, so only the character's turn is kept. Use /trimuser &lt;marker&gt; to change the marker.",
+			html.EscapeString(char.Name), html.EscapeString(user.ReplacePlaceholders(marker)))
+	} else {
+		response = fmt.Sprintf("Reply trimming is now off for '%s'.", html.EscapeString(char.Name))
+	}
+	return response, markup
+}
+
+// cmdRetryWith обрабатывает команду /retrywith.
+func (c *TelegramBotController) cmdRetryWith(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "" {
+		response = "Usage: /retrywith temp=1.1 maxtokens=800\nThe parameters apply to this regeneration only."
+		return response, markup
+	}
+	reply, applied, err := c.userUseCase.RetryWithOverrides(ctx, user, args)
+	if errors.Is(err, usecases.ErrNoUserMessage) {
+		response = "There is no message to regenerate yet. Send something to the character first."
+	} else if errors.Is(err, usecases.ErrInvalidParameters) {
+		response = html.EscapeString(err.Error())
+	} else if truncated, ok := c.truncatedReply(user, reply, err); ok {
+		response = fmt.Sprintf("<i>Regenerated once with %s</i>\n\n%s", html.EscapeString(strings.Join(applied, ", ")), truncated)
+	} else if err != nil {
+		response = c.modelErrorResponse(user, err)
+	} else {
+		response = fmt.Sprintf("<i>Regenerated once with %s</i>\n\n%s", html.EscapeString(strings.Join(applied, ", ")), formatReply(user.GetCurrentCharacter(), reply))
+	}
+	return response, markup
+}
+
+// cmdTry обрабатывает команду /try <пробное сообщение>.
+func (c *TelegramBotController) cmdTry(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if strings.TrimSpace(args) == "" {
+		response = "Usage: /try &lt;sample message&gt;\nThe current character replies without your chat history, and nothing is saved."
+		return response, markup
+	}
+	reply, err := c.userUseCase.TryPrompt(ctx, user, args)
+	if err != nil {
+		response = c.modelErrorResponse(user, err)
+	} else {
+		response = fmt.Sprintf("<i>Test reply (not saved to the chat):</i>\n\n%s", escapeModelText(reply))
+	}
+	return response, markup
+}
+
+// cmdSizes обрабатывает команду /sizes.
+func (c *TelegramBotController) cmdSizes(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>Context sizes for '%s':</b>\n<pre>", html.EscapeString(user.GetCurrentCharacter().Name))
+	for _, size := range c.userUseCase.ContextSizes(user) {
+		fmt.Fprintf(&sb, "%-22s %7d chars %6d tokens\n", size.Name, size.Chars, size.Tokens)
+	}
+	sb.WriteString("</pre>Token counts are approximate.")
+	return sb.String(), markup
+}
+
+// cmdCharacterInfo обрабатывает команду /charinfo.
+func (c *TelegramBotController) cmdCharacterInfo(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	char := user.GetCurrentCharacter()
+	response = fmt.Sprintf("<b>Current Character Info:</b>\nName: %s\nGreeting: %s\nPrompt: %s\nChat Messages: %d/%d\nAuto Language: %s",
+		html.EscapeString(char.Name), html.EscapeString(c.userUseCase.GetGreeting(ctx, user)), html.EscapeString(char.Prompt), len(char.Chat), c.userUseCase.ChatHistoryLimit(), formatAutoLanguage(char))
+	response += fmt.Sprintf("\nMode: %s", char.EffectiveMode())
+	if inContext := len(char.ContextChat()); inContext < len(char.Chat) {
+		response += fmt.Sprintf("\nIn Context: %d of %d messages (/clearcontext)", inContext, len(char.Chat))
+	}
+	if char.DisplayName != "" {
+		response += "\nDisplay Name: " + html.EscapeString(char.DisplayName)
+	}
+	if char.SystemRules != "" {
+		response += "\nRules: " + html.EscapeString(char.SystemRules)
+	}
+	if char.AuthorsNote != "" {
+		response += fmt.Sprintf("\nAuthor's Note (depth %d): %s", char.NoteDepth, html.EscapeString(char.AuthorsNote))
+	}
+	response += fmt.Sprintf("\nCreated: %s\nUpdated: %s", formatCharacterTime(user, char.CreatedAt), formatCharacterTime(user, char.UpdatedAt))
+	if char.Ephemeral {
+		response += "\n<b>Ephemeral:</b> history is not saved and will be lost on restart"
+	}
+	return response, markup
+}
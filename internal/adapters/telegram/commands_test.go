@@ -0,0 +1,171 @@
+package telegram_adapter
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// fakeUserService реализует методы UserInteractorService, нужные обработчикам команд в тестах.
+// Вызов остальных методов приводит к панике.
+type fakeUserService struct {
+	UserInteractorService
+}
+
+func (fakeUserService) UpdateUserProperty(ctx context.Context, user *domain.User, prop string, value string) error {
+	return nil
+}
+
+func (fakeUserService) GetGreeting(ctx context.Context, user *domain.User) string {
+	return user.GetCurrentCharacter().Greeting
+}
+
+func (fakeUserService) ChatHistoryLimit() int { return 100 }
+
+func TestCommandsEscapeCharacterText(t *testing.T) {
+	const name = `<b>Ann & "Co"</b>`
+	c := &TelegramBotController{logger: logger.NewWriterLogger(io.Discard, logger.None), userUseCase: fakeUserService{}}
+	tests := []struct {
+		name    string
+		handler commandHandler
+	}{
+		{name: "/seedgreeting", handler: c.cmdSeedGreeting},
+		{name: "/ephemeral", handler: c.cmdEphemeral},
+		{name: "/translategreeting", handler: c.cmdTranslateGreeting},
+		{name: "/autolang", handler: c.cmdAutoLanguage},
+		{name: "/listchar", handler: c.cmdListCharacters},
+		{name: "/charinfo", handler: c.cmdCharacterInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := domain.NewUser(1, "alice")
+			char := user.GetCurrentCharacter()
+			char.Name = name
+			char.Greeting = "<i>Hi</i>"
+			char.Prompt = "Use <tags> & stuff"
+
+			response, _ := tt.handler(context.Background(), user, &telegrambotapi.Message{}, tt.name, "")
+			for _, raw := range []string{name, char.Greeting, char.Prompt} {
+				if strings.Contains(response, raw) {
+					t.Errorf("response contains unescaped %q: %q", raw, response)
+				}
+			}
+			if !strings.Contains(response, "&lt;b&gt;Ann &amp; &#34;Co&#34;&lt;/b&gt;") {
+				t.Errorf("response %q does not contain the escaped character name", response)
+			}
+		})
+	}
+}
+
+// permissionService разрешает администрирование и просмотр запросов только перечисленным пользователям.
+type permissionService struct {
+	fakeUserService
+	admins     map[int64]bool
+	inspectors map[int64]bool
+	cleanedAll *bool
+}
+
+func (s permissionService) IsAdmin(userID int64) bool { return s.admins[userID] }
+
+func (s permissionService) CanInspectRequests(userID int64) bool { return s.inspectors[userID] }
+
+func (s permissionService) CleanupAllUsers(ctx context.Context, admin *domain.User) (usecases.CleanupReport, error) {
+	if !s.admins[admin.ID] {
+		return usecases.CleanupReport{}, usecases.ErrNotAdmin
+	}
+	*s.cleanedAll = true
+	return usecases.CleanupReport{Checked: 3}, nil
+}
+
+func TestCommandPermissions(t *testing.T) {
+	const adminID, inspectorID, userID = 1, 2, 3
+	service := permissionService{
+		admins:     map[int64]bool{adminID: true},
+		inspectors: map[int64]bool{adminID: true, inspectorID: true},
+		cleanedAll: new(bool),
+	}
+	c := &TelegramBotController{logger: logger.NewWriterLogger(io.Discard, logger.None), userUseCase: service}
+	c.commands = c.registerCommands()
+
+	levels := []struct {
+		level PermissionLevel
+		want  map[int64]bool
+	}{
+		{PermissionPublic, map[int64]bool{adminID: true, inspectorID: true, userID: true}},
+		{PermissionAdmin, map[int64]bool{adminID: true}},
+		{PermissionInspectRequests, map[int64]bool{adminID: true, inspectorID: true}},
+		{PermissionLevel(100), map[int64]bool{}},
+	}
+	for _, tt := range levels {
+		for _, id := range []int64{adminID, inspectorID, userID} {
+			if got := c.hasPermission(domain.NewUser(id, "u"), tt.level); got != tt.want[id] {
+				t.Errorf("hasPermission(user %d, level %d) = %v, want %v", id, tt.level, got, tt.want[id])
+			}
+		}
+	}
+
+	t.Run("help", func(t *testing.T) {
+		for _, tt := range []struct {
+			id          int64
+			admin, last bool
+		}{
+			{adminID, true, true},
+			{inspectorID, false, true},
+			{userID, false, false},
+		} {
+			help, _ := c.cmdHelp(context.Background(), domain.NewUser(tt.id, "u"), &telegrambotapi.Message{}, "/help", "")
+			if !strings.Contains(help, "/cleanup ") {
+				t.Errorf("help for user %d does not list the public /cleanup command", tt.id)
+			}
+			for _, cmd := range []string{"/backup ", "/restore ", "/loglevel "} {
+				if got := strings.Contains(help, cmd); got != tt.admin {
+					t.Errorf("help for user %d lists %s = %v, want %v", tt.id, cmd, got, tt.admin)
+				}
+			}
+			if got := strings.Contains(help, "/lastrequest "); got != tt.last {
+				t.Errorf("help for user %d lists /lastrequest = %v, want %v", tt.id, got, tt.last)
+			}
+		}
+	})
+
+	t.Run("cleanup all", func(t *testing.T) {
+		response, _ := c.cmdCleanup(context.Background(), domain.NewUser(userID, "u"), &telegrambotapi.Message{}, "/cleanup", "all")
+		if response != "Checking all users is only available to administrators." || *service.cleanedAll {
+			t.Errorf("non-admin /cleanup all: response %q, cleaned all = %v", response, *service.cleanedAll)
+		}
+		response, _ = c.cmdCleanup(context.Background(), domain.NewUser(adminID, "u"), &telegrambotapi.Message{}, "/cleanup", "all")
+		if response != "Checked 3 user(s), fixed 0." || !*service.cleanedAll {
+			t.Errorf("admin /cleanup all: response %q, cleaned all = %v", response, *service.cleanedAll)
+		}
+	})
+}
+
+func TestUnknownCommand(t *testing.T) {
+	tests := []struct {
+		chatType string
+		want     []string
+	}{
+		{chatType: "private", want: []string{"Unknown command. Use /menu to see available options."}},
+		{chatType: "group"},
+		{chatType: "supergroup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.chatType, func(t *testing.T) {
+			c, api := newTestController(t, fakeUserService{})
+			message := &telegrambotapi.Message{MessageID: 10, From: &telegrambotapi.User{ID: 1}, Chat: &telegrambotapi.Chat{ID: -5, Type: tt.chatType}, Text: "/frobnicate"}
+
+			c.handleCommand(context.Background(), domain.NewUser(1, "alice"), message, message.Chat.ID, message.Text)
+			if got := sentTexts(api); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sent %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package telegram_adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// SendDigest отправляет ежедневную сводку в личный чат пользователя. Сводка - обычный текст, поэтому экранируется.
+// Подходит для использования в качестве usecases.DigestSendFunc.
+func (c *TelegramBotController) SendDigest(ctx context.Context, userID int64, text string) error {
+	// ID личного чата с пользователем совпадает с его ID в Telegram
+	if c.sendMessage(ctx, userID, escapeModelText(text)+"\n\n<i>Send /unsubscribe to stop daily digests.</i>", nil) == -1 {
+		return fmt.Errorf("failed to send digest to user %d", userID)
+	}
+	return nil
+}
+
+// cmdSubscribe обрабатывает команду /subscribe [часовой пояс].
+func (c *TelegramBotController) cmdSubscribe(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	err := c.userUseCase.SetDigestSubscription(ctx, user, true, args)
+	switch {
+	case errors.Is(err, usecases.ErrDigestDisabled):
+		response = "Daily digests are not enabled on this bot."
+	case errors.Is(err, usecases.ErrInvalidTimezone):
+		response = fmt.Sprintf("Unknown timezone '%s'. Use a name like Europe/Moscow or America/New_York.", html.EscapeString(args))
+	case err != nil:
+		c.logger.Error("Failed to subscribe user %d to digests: %v", user.ID, err)
+		response = "Failed to subscribe to daily digests."
+	default:
+		response = fmt.Sprintf("You will receive a daily digest of your conversation (timezone: %s). Send /unsubscribe to stop.", user.Location())
+	}
+	return response, markup
+}
+
+// cmdTimezone обрабатывает команду /timezone [часовой пояс | -].
+func (c *TelegramBotController) cmdTimezone(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if args == "" {
+		return fmt.Sprintf("Your timezone is %s. Use /timezone Europe/Moscow to change it or /timezone - to reset it to UTC.", user.Location()), markup
+	}
+	err := c.userUseCase.UpdateUserProperty(ctx, user, "Timezone", args)
+	switch {
+	case errors.Is(err, usecases.ErrInvalidTimezone):
+		response = fmt.Sprintf("Unknown timezone '%s'. Use a name like Europe/Moscow or America/New_York.", html.EscapeString(args))
+	case err != nil:
+		c.logger.Error("Failed to set timezone for user %d: %v", user.ID, err)
+		response = "Failed to change your timezone."
+	default:
+		response = fmt.Sprintf("Your timezone is now %s. It is used for daily digests and the {{date}} and {{time}} placeholders.", user.Location())
+	}
+	return response, markup
+}
+
+// cmdUnsubscribe обрабатывает команду /unsubscribe.
+func (c *TelegramBotController) cmdUnsubscribe(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if err := c.userUseCase.SetDigestSubscription(ctx, user, false, ""); err != nil {
+		c.logger.Error("Failed to unsubscribe user %d from digests: %v", user.ID, err)
+		return "Failed to unsubscribe from daily digests.", markup
+	}
+	return "You will no longer receive daily digests.", markup
+}
@@ -0,0 +1,210 @@
+package telegram_adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+const (
+	defaultMaxImportSize   = 512 * 1024       // Максимальный размер импортируемой карточки персонажа по умолчанию
+	defaultMaxDocumentSize = 512 * 1024       // Максимальный размер прикрепляемого текстового документа по умолчанию
+	maxBackupFileSize      = 19 * 1024 * 1024 // Части резервной копии меньше лимита загрузки файлов ботом (20 МБ)
+)
+
+// errFileTooLarge возвращается, когда загружаемый файл превышает допустимый размер.
+var errFileTooLarge = errors.New("file too large")
+
+// SetMaxImportSize задает максимальный размер импортируемого файла в байтах.
+func (c *TelegramBotController) SetMaxImportSize(size int64) {
+	if size > 0 {
+		c.maxImportSize = size
+	}
+}
+
+// SetMaxDocumentSize задает максимальный размер прикрепляемого текстового документа в байтах.
+func (c *TelegramBotController) SetMaxDocumentSize(size int64) {
+	if size > 0 {
+		c.maxDocumentSize = size
+	}
+}
+
+// isTextDocument определяет по расширению и MIME-типу, что файл - текстовый документ (.txt, .md),
+// а не карточка персонажа.
+func isTextDocument(document *telegrambotapi.Document) bool {
+	switch strings.ToLower(path.Ext(document.FileName)) {
+	case ".txt", ".md", ".markdown":
+		return true
+	case ".json", ".yaml", ".yml", ".png":
+		return false
+	}
+	return document.MimeType == "text/plain" || document.MimeType == "text/markdown"
+}
+
+// handleDocument импортирует присланный файл как карточку персонажа, а текстовые документы прикрепляет
+// к текущему персонажу. Размер проверяется до загрузки, а сама загрузка ограничена maxImportSize.
+func (c *TelegramBotController) handleDocument(ctx context.Context, user *domain.User, message *telegrambotapi.Message, chatID int64) {
+	if user.PendingCommand == "restore_backup" {
+		c.handleRestoreDocument(ctx, user, message, chatID)
+		return
+	}
+	if isTextDocument(message.Document) {
+		c.handleTextDocument(ctx, user, message, chatID)
+		return
+	}
+
+	var response string
+	data, err := c.downloadDocument(ctx, message.Document, c.maxImportSize)
+	if errors.Is(err, errFileTooLarge) {
+		response = fmt.Sprintf("File too large. Character cards must be at most %d KB.", c.maxImportSize/1024)
+	} else if err != nil {
+		c.logger.Error("Failed to download document from user %d: %v", user.ID, err)
+		response = "Failed to download the file. Please try again."
+	} else {
+		char, err := c.userUseCase.ImportCharacterCard(ctx, user, data, message.Document.MimeType)
+		if errors.Is(err, usecases.ErrInvalidCharacterCard) {
+			response = html.EscapeString(err.Error()) + "\nTo chat about a document, send it as a .txt or .md file."
+		} else if errors.Is(err, usecases.ErrCharacterLimit) {
+			response = "You have reached the maximum number of characters."
+		} else if err != nil {
+			c.logger.Error("Failed to import character for user %d: %v", user.ID, err)
+			response = "Failed to import the character."
+		} else {
+			response = fmt.Sprintf("Character '%s' imported and selected.", html.EscapeString(char.Name))
+		}
+	}
+
+	c.sendDocumentResponse(ctx, user, chatID, response)
+}
+
+// handleTextDocument прикрепляет присланный текстовый документ к текущему персонажу.
+func (c *TelegramBotController) handleTextDocument(ctx context.Context, user *domain.User, message *telegrambotapi.Message, chatID int64) {
+	var response string
+	data, err := c.downloadDocument(ctx, message.Document, c.maxDocumentSize)
+	if errors.Is(err, errFileTooLarge) {
+		response = fmt.Sprintf("File too large. Documents must be at most %d KB.", c.maxDocumentSize/1024)
+	} else if err != nil {
+		c.logger.Error("Failed to download document from user %d: %v", user.ID, err)
+		response = "Failed to download the file. Please try again."
+	} else {
+		name := message.Document.FileName
+		stopTyping := c.keepTyping(ctx, chatID) // Длинный документ излагается моделью
+		summarized, err := c.userUseCase.AttachDocument(ctx, user, name, data)
+		stopTyping()
+		if errors.Is(err, usecases.ErrNotTextDocument) {
+			response = "This file doesn't look like plain text. Please send a UTF-8 .txt or .md file."
+		} else if err != nil {
+			response = c.modelErrorResponse(user, err)
+		} else if summarized {
+			response = fmt.Sprintf("The document '%s' is too long to keep whole, so '%s' will use its summary. Use /document to check or remove it.",
+				html.EscapeString(name), html.EscapeString(user.GetCurrentCharacter().Name))
+		} else {
+			response = fmt.Sprintf("The document '%s' is attached: '%s' will see it with every message. Use /document to check or remove it.",
+				html.EscapeString(name), html.EscapeString(user.GetCurrentCharacter().Name))
+		}
+	}
+
+	c.sendDocumentResponse(ctx, user, chatID, response)
+}
+
+// handleRestoreDocument восстанавливает пользователей из присланной части резервной копии.
+// Режим восстановления остается включенным, чтобы можно было прислать несколько частей подряд.
+func (c *TelegramBotController) handleRestoreDocument(ctx context.Context, user *domain.User, message *telegrambotapi.Message, chatID int64) {
+	var response string
+	data, err := c.downloadDocument(ctx, message.Document, maxBackupFileSize)
+	if errors.Is(err, errFileTooLarge) {
+		response = fmt.Sprintf("File too large. Backup parts must be at most %d MB.", maxBackupFileSize/(1024*1024))
+	} else if err != nil {
+		c.logger.Error("Failed to download backup from user %d: %v", user.ID, err)
+		response = "Failed to download the file. Please try again."
+	} else {
+		restored, err := c.userUseCase.RestoreUsers(ctx, user, data)
+		if errors.Is(err, usecases.ErrInvalidBackup) {
+			response = html.EscapeString(err.Error())
+		} else if errors.Is(err, usecases.ErrNotAdmin) {
+			response = "This command is only available to administrators."
+		} else if err != nil {
+			c.logger.Error("Failed to restore backup for user %d: %v", user.ID, err)
+			response = fmt.Sprintf("Restore failed after %d user(s). Check the logs and send the file again.", restored)
+		} else {
+			response = fmt.Sprintf("Restored %d user(s). Send the next part, or any command to finish.", restored)
+		}
+	}
+
+	c.sendDocumentResponse(ctx, user, chatID, response)
+}
+
+// sendDocumentResponse отправляет ответ на присланный файл и запоминает его как последнее сообщение бота.
+func (c *TelegramBotController) sendDocumentResponse(ctx context.Context, user *domain.User, chatID int64, response string) {
+	sentMessageID := c.sendMessage(ctx, chatID, response, nil)
+	if sentMessageID != -1 {
+		if err := c.userUseCase.UpdateUser(ctx, user, lastMessageMutation(sentMessageID)); err != nil {
+			c.logger.Error("Failed to save LastMessageID for user %d: %v", user.ID, err)
+		}
+	}
+}
+
+// sendBackup выгружает всех пользователей и отправляет части резервной копии администратору в личный чат.
+func (c *TelegramBotController) sendBackup(ctx context.Context, user *domain.User) (int, error) {
+	timestamp := time.Now().UTC().Format("20060102-150405")
+	return c.userUseCase.ExportUsers(ctx, user, maxBackupFileSize, func(part int, data []byte) error {
+		document := telegrambotapi.NewDocument(user.ID, telegrambotapi.FileBytes{
+			Name:  fmt.Sprintf("backup-%s-part%d.json", timestamp, part),
+			Bytes: data,
+		})
+		if _, err := c.botClient.Send(document); err != nil {
+			return fmt.Errorf("failed to send backup part %d: %w", part, err)
+		}
+		return nil
+	})
+}
+
+// downloadDocument загружает файл из Telegram, читая не больше limit байт.
+func (c *TelegramBotController) downloadDocument(ctx context.Context, document *telegrambotapi.Document, limit int64) ([]byte, error) {
+	if int64(document.FileSize) > limit {
+		return nil, errFileTooLarge
+	}
+
+	fileURL, err := c.botClient.GetFileDirectURL(document.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file URL: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download request: %w", err)
+	}
+	resp, err := c.botClient.Client.Do(req) // Тот же HTTP-клиент, что и для запросов к Bot API
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("file download returned status code %d", resp.StatusCode)
+	}
+
+	return readLimited(resp.Body, limit)
+}
+
+// readLimited читает не больше limit байт и возвращает errFileTooLarge, если данных больше.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	// Читаем на байт больше лимита, чтобы отличить файл ровно на границе от слишком большого
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(data)) > limit {
+		return nil, errFileTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,138 @@
+package telegram_adapter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+func TestReadLimited(t *testing.T) {
+	tests := []struct {
+		name    string
+		reader  io.Reader
+		limit   int64
+		want    string
+		wantErr error
+	}{
+		{name: "smaller than the limit", reader: strings.NewReader("card"), limit: 10, want: "card"},
+		{name: "exactly the limit", reader: strings.NewReader("0123456789"), limit: 10, want: "0123456789"},
+		{name: "one byte over the limit", reader: strings.NewReader("0123456789A"), limit: 10, wantErr: errFileTooLarge},
+		{name: "much larger than the limit", reader: strings.NewReader(strings.Repeat("x", 1<<20)), limit: 10, wantErr: errFileTooLarge},
+		{name: "empty file", reader: strings.NewReader(""), limit: 10, want: ""},
+		{name: "read error", reader: iotest.ErrReader(io.ErrUnexpectedEOF), limit: 10, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLimited(tt.reader, tt.limit)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("readLimited() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readLimited: %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("readLimited() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDownloadDocumentChecksSizeFirst(t *testing.T) {
+	// Без клиента Telegram загрузка невозможна: ошибка размера должна вернуться до обращения к API
+	c := &TelegramBotController{}
+	document := &telegrambotapi.Document{FileID: "file", FileSize: defaultMaxImportSize + 1}
+	if _, err := c.downloadDocument(context.Background(), document, defaultMaxImportSize); !errors.Is(err, errFileTooLarge) {
+		t.Errorf("downloadDocument() error = %v, want errFileTooLarge", err)
+	}
+}
+
+func TestIsTextDocument(t *testing.T) {
+	tests := []struct {
+		fileName string
+		mimeType string
+		want     bool
+	}{
+		{fileName: "notes.txt", want: true},
+		{fileName: "README.MD", want: true},
+		{fileName: "story.markdown", want: true},
+		{fileName: "card.json", mimeType: "text/plain", want: false},
+		{fileName: "card.png", want: false},
+		{fileName: "card.yml", want: false},
+		{fileName: "untitled", mimeType: "text/plain", want: true},
+		{fileName: "untitled", mimeType: "application/octet-stream", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName+" "+tt.mimeType, func(t *testing.T) {
+			document := &telegrambotapi.Document{FileName: tt.fileName, MimeType: tt.mimeType}
+			if got := isTextDocument(document); got != tt.want {
+				t.Errorf("isTextDocument(%q, %q) = %v, want %v", tt.fileName, tt.mimeType, got, tt.want)
+			}
+		})
+	}
+}
+
+// documentService запоминает прикрепленные документы.
+type documentService struct {
+	fakeUserService
+	attached map[string][]byte
+}
+
+func (s documentService) AttachDocument(ctx context.Context, user *domain.User, name string, data []byte) (bool, error) {
+	s.attached[name] = data
+	return false, nil
+}
+
+func (documentService) UpdateUser(ctx context.Context, user *domain.User, mutate usecases.UserMutation) error {
+	return mutate(user)
+}
+
+func TestTextDocumentSizeLimit(t *testing.T) {
+	const limit = 2048
+	tests := []struct {
+		name     string
+		content  string
+		fileSize int // Размер, который сообщает Telegram (0 - неизвестен)
+		wantText string
+	}{
+		{name: "small document", content: "meeting at 5 pm", fileSize: 15, wantText: "The document 'notes.txt' is attached"},
+		{name: "exactly the limit", content: strings.Repeat("x", limit), fileSize: limit, wantText: "The document 'notes.txt' is attached"},
+		{name: "reported size over the limit", content: "short", fileSize: limit + 1, wantText: "File too large. Documents must be at most 2 KB."},
+		{name: "unknown size over the limit", content: strings.Repeat("x", limit+1), wantText: "File too large. Documents must be at most 2 KB."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := documentService{attached: make(map[string][]byte)}
+			c, api := newTestController(t, service)
+			c.SetMaxDocumentSize(limit) // MAX_DOCUMENT_BYTES
+			api.files["doc"] = []byte(tt.content)
+
+			c.handleDocument(context.Background(), domain.NewUser(1, "alice"), documentMessage("doc", "notes.txt", tt.fileSize), 1)
+
+			texts := sentTexts(api)
+			if len(texts) != 1 || !strings.HasPrefix(texts[0], tt.wantText) {
+				t.Fatalf("sent %q, want one message starting with %q", texts, tt.wantText)
+			}
+			data, attached := service.attached["notes.txt"]
+			if wantAttached := strings.HasPrefix(tt.wantText, "The document"); attached != wantAttached {
+				t.Fatalf("document attached = %v, want %v", attached, wantAttached)
+			}
+			if attached && string(data) != tt.content {
+				t.Errorf("attached %q, want %q", data, tt.content)
+			}
+			if tt.fileSize > limit && len(api.sent("getFile")) != 0 {
+				t.Error("the file was downloaded although Telegram reported it as too large")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package telegram_adapter
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// botRequest запрос к Bot API, выполненный контроллером.
+type botRequest struct {
+	method string
+	params url.Values
+}
+
+// fakeBotAPI подменяет HTTP-клиент Bot API: отвечает на запросы методов успешно, запоминает их
+// и отдает содержимое files при загрузке файлов.
+type fakeBotAPI struct {
+	mu       sync.Mutex
+	requests []botRequest
+	files    map[string][]byte // Содержимое файлов по FileID
+	nextID   int
+}
+
+func (f *fakeBotAPI) Do(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Файлы загружаются по адресу /file/bot<token>/<file_path>, где file_path совпадает с FileID
+	if strings.HasPrefix(req.URL.Path, "/file/") {
+		data, ok := f.files[req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]]
+		if !ok {
+			return response(http.StatusNotFound, "not found"), nil
+		}
+		return response(http.StatusOK, string(data)), nil
+	}
+
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	method := req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:]
+	f.requests = append(f.requests, botRequest{method: method, params: req.PostForm})
+
+	var result interface{} = true
+	switch method {
+	case "getMe":
+		result = map[string]interface{}{"id": 1, "is_bot": true, "first_name": "Bot", "username": "test_bot"}
+	case "getFile":
+		fileID := req.PostForm.Get("file_id")
+		result = map[string]interface{}{"file_id": fileID, "file_path": fileID}
+	case "sendMessage", "editMessageText":
+		f.nextID++
+		chatID := json.Number(req.PostForm.Get("chat_id"))
+		result = map[string]interface{}{"message_id": f.nextID, "chat": map[string]interface{}{"id": chatID}, "text": req.PostForm.Get("text")}
+	}
+	body, err := json.Marshal(map[string]interface{}{"ok": true, "result": result})
+	if err != nil {
+		return nil, err
+	}
+	return response(http.StatusOK, string(body)), nil
+}
+
+// response возвращает HTTP-ответ с кодом status и телом body.
+func response(status int, body string) *http.Response {
+	return &http.Response{StatusCode: status, Header: make(http.Header), Body: io.NopCloser(strings.NewReader(body))}
+}
+
+// sent возвращает параметры выполненных запросов метода method по порядку.
+func (f *fakeBotAPI) sent(method string) []url.Values {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var params []url.Values
+	for _, req := range f.requests {
+		if req.method == method {
+			params = append(params, req.params)
+		}
+	}
+	return params
+}
+
+// newTestController создает контроллер, обращающийся к Bot API через fakeBotAPI.
+func newTestController(t *testing.T, userUseCase UserInteractorService) (*TelegramBotController, *fakeBotAPI) {
+	t.Helper()
+	api := &fakeBotAPI{files: make(map[string][]byte)}
+	bot, err := telegrambotapi.NewBotAPIWithClient("token", telegrambotapi.APIEndpoint, api)
+	if err != nil {
+		t.Fatalf("NewBotAPIWithClient: %v", err)
+	}
+	return newTelegramBotController(bot, logger.NewWriterLogger(io.Discard, logger.None), userUseCase), api
+}
+
+// sentTexts возвращает тексты отправленных сообщений по порядку.
+func sentTexts(api *fakeBotAPI) []string {
+	var texts []string
+	for _, params := range api.sent("sendMessage") {
+		texts = append(texts, params.Get("text"))
+	}
+	return texts
+}
+
+// documentMessage возвращает личное сообщение пользователя 1 с документом fileID.
+func documentMessage(fileID, fileName string, fileSize int) *telegrambotapi.Message {
+	return &telegrambotapi.Message{
+		MessageID: 10,
+		From:      &telegrambotapi.User{ID: 1, UserName: "alice"},
+		Chat:      &telegrambotapi.Chat{ID: 1, Type: "private"},
+		Document:  &telegrambotapi.Document{FileID: fileID, FileName: fileName, FileSize: fileSize},
+	}
+}
@@ -0,0 +1,138 @@
+package telegram_adapter
+
+import (
+	"context"
+	"sync"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+)
+
+// stopGenerationCallback данные кнопки остановки генерации.
+const stopGenerationCallback = "stop_generation"
+
+// activeGeneration описывает генерацию, которую можно остановить кнопкой.
+type activeGeneration struct {
+	userID int64
+	cancel context.CancelFunc
+}
+
+// generationRegistry хранит функции отмены выполняющихся генераций по ID сообщения с кнопкой остановки.
+type generationRegistry struct {
+	mu          sync.Mutex
+	generations map[int]activeGeneration
+}
+
+// newGenerationRegistry создает новый экземпляр generationRegistry.
+func newGenerationRegistry() *generationRegistry {
+	return &generationRegistry{generations: make(map[int]activeGeneration)}
+}
+
+// add регистрирует генерацию, привязанную к сообщению messageID.
+func (r *generationRegistry) add(messageID int, userID int64, cancel context.CancelFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.generations[messageID] = activeGeneration{userID: userID, cancel: cancel}
+}
+
+// remove удаляет генерацию после ее завершения.
+func (r *generationRegistry) remove(messageID int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.generations, messageID)
+}
+
+// stop отменяет генерацию, если она принадлежит пользователю userID, и сообщает, была ли она найдена.
+func (r *generationRegistry) stop(messageID int, userID int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	generation, ok := r.generations[messageID]
+	if !ok || generation.userID != userID {
+		return false
+	}
+	generation.cancel()
+	delete(r.generations, messageID)
+	return true
+}
+
+// SetStopButton включает сообщение-заглушку с кнопкой "Stop" на время генерации ответа.
+func (c *TelegramBotController) SetStopButton(enabled bool) {
+	c.stopButton = enabled
+}
+
+// stopKeyboard возвращает клавиатуру с кнопкой остановки генерации.
+func stopKeyboard() *telegrambotapi.InlineKeyboardMarkup {
+	keyboard := telegrambotapi.NewInlineKeyboardMarkup(telegrambotapi.NewInlineKeyboardRow(
+		telegrambotapi.NewInlineKeyboardButtonData("⏹ Stop", stopGenerationCallback),
+	))
+	return &keyboard
+}
+
+// handleStopGeneration останавливает генерацию по нажатию кнопки "Stop".
+// Остановить генерацию может только пользователь, который ее запустил.
+func (c *TelegramBotController) handleStopGeneration(ctx context.Context, callbackQuery *telegrambotapi.CallbackQuery) {
+	notice := "This reply has already finished."
+	if c.generations.stop(callbackQuery.Message.MessageID, callbackQuery.From.ID) {
+		c.logger.Info("User %d stopped generation in message %d", callbackQuery.From.ID, callbackQuery.Message.MessageID)
+		notice = "Stopping…"
+	}
+	callbackConfig := telegrambotapi.NewCallback(callbackQuery.ID, notice)
+	if err := c.requestWithFloodRetry(ctx, callbackConfig); err != nil {
+		c.logger.Error("Failed to answer callback query: %v", err)
+	}
+}
+
+// generateWithPlaceholder генерирует ответ, показывая на время генерации сообщение-заглушку: с кнопкой "Stop",
+// если она включена, и с частичным ответом, если stream. Возвращает ответ (или сообщение об ошибке),
+// ID сообщения-заглушки (-1, если его не удалось отправить) и последний показанный частичный ответ.
+// Если генерацию остановили после начала ответа, generate сохраняет полученную часть в историю как прерванный
+// ответ (usecases.ErrResponseTruncated) и возвращает ее; если ответ еще не начался, история сохраняет
+// сообщение пользователя без ответа.
+func (c *TelegramBotController) generateWithPlaceholder(ctx context.Context, chatID int64, userID int64, stream bool, generate func(ctx context.Context) (string, error)) (string, int, string, error) {
+	var keyboard *telegrambotapi.InlineKeyboardMarkup
+	var markup interface{}
+	if c.stopButton {
+		keyboard = stopKeyboard()
+		markup = keyboard
+	}
+	placeholderID := c.sendMessage(ctx, chatID, "<i>Generating…</i>", markup)
+	if placeholderID == -1 {
+		response, err := generate(ctx)
+		return response, -1, "", err
+	}
+
+	generationCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if c.stopButton {
+		c.generations.add(placeholderID, userID, cancel)
+		defer c.generations.remove(placeholderID)
+	}
+
+	var live *liveMessage
+	if stream {
+		live = &liveMessage{controller: c, chatID: chatID, messageID: placeholderID, markup: keyboard}
+		liveCtx, stopLive := context.WithCancel(generationCtx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			live.run(liveCtx)
+		}()
+		// Дожидаемся последней правки, чтобы она не перезаписала окончательный ответ
+		defer func() {
+			stopLive()
+			<-done
+		}()
+		generationCtx = usecases.WithStreamHandler(generationCtx, live.update)
+	}
+
+	response, err := generate(generationCtx)
+	partial := ""
+	if live != nil {
+		partial = live.preview()
+	}
+	if err != nil && generationCtx.Err() != nil && ctx.Err() == nil {
+		return "<i>Generation stopped.</i>", placeholderID, "", nil
+	}
+	return response, placeholderID, partial, err
+}
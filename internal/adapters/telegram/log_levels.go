@@ -0,0 +1,79 @@
+package telegram_adapter
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// SetLogLevels задает реестр уровней логирования, которым управляет команда /loglevel.
+func (c *TelegramBotController) SetLogLevels(levels *logger.LevelRegistry) {
+	c.logLevels = levels
+}
+
+// cmdLogLevel обрабатывает команду /loglevel: без аргументов показывает уровни, "/loglevel <уровень>"
+// меняет общий уровень, "/loglevel <компонент> <уровень|default>" - уровень компонента.
+func (c *TelegramBotController) cmdLogLevel(ctx context.Context, user *domain.User, message *telegrambotapi.Message, command, args string) (response string, markup interface{}) {
+	if c.logLevels == nil {
+		return "Runtime log levels are not available.", markup
+	}
+
+	fields := strings.Fields(args)
+	switch len(fields) {
+	case 0:
+	case 1:
+		level, err := logger.ParseLevel(fields[0])
+		if err != nil {
+			return html.EscapeString(err.Error()), markup
+		}
+		c.logLevels.SetGlobal(level)
+		c.logger.Info("Admin %d set the global log level to %s", user.ID, fields[0])
+	case 2:
+		category := fields[0]
+		if !c.isLogCategory(category) {
+			return fmt.Sprintf("Unknown component %q. Known components: %s.",
+				html.EscapeString(category), strings.Join(c.logLevels.Categories(), ", ")), markup
+		}
+		if fields[1] == "default" {
+			c.logLevels.ResetCategory(category)
+		} else {
+			level, err := logger.ParseLevel(fields[1])
+			if err != nil {
+				return html.EscapeString(err.Error()), markup
+			}
+			c.logLevels.SetCategory(category, level)
+		}
+		c.logger.Info("Admin %d set the log level of %s to %s", user.ID, category, fields[1])
+	default:
+		return "Usage: /loglevel [level] or /loglevel &lt;component&gt; &lt;level|default&gt;", markup
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<b>Log levels</b> (global: %s)\n", logger.LevelName(c.logLevels.Global()))
+	for _, category := range c.logLevels.Categories() {
+		level, overridden := c.logLevels.Level(category)
+		if overridden {
+			fmt.Fprintf(&sb, "%s: %s\n", category, logger.LevelName(level))
+		} else {
+			fmt.Fprintf(&sb, "%s: %s (global)\n", category, logger.LevelName(level))
+		}
+	}
+	sb.WriteString("\nLevels: debug, info, warn, error, quiet. Use /loglevel &lt;component&gt; default to follow the global level again.")
+	return sb.String(), markup
+}
+
+// isLogCategory проверяет, что компонент известен реестру уровней.
+func (c *TelegramBotController) isLogCategory(category string) bool {
+	for _, known := range c.logLevels.Categories() {
+		if known == category {
+			return true
+		}
+	}
+	return false
+}
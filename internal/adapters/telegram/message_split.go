@@ -0,0 +1,157 @@
+package telegram_adapter
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxMessageLength максимальная длина сообщения Telegram в символах.
+const maxMessageLength = 4096
+
+// htmlTagPattern находит открывающие и закрывающие теги HTML; группа 1 - "/" закрывающего тега, группа 2 - имя тега.
+var htmlTagPattern = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^<>]*>`)
+
+// splitMessage делит текст с разметкой HTML на части не длиннее limit символов. Разрез делается
+// по возможности между абзацами, затем между строками, предложениями или словами, и никогда
+// внутри тега или HTML-сущности. Теги, открытые на момент разреза, закрываются в конце части
+// и открываются заново в начале следующей.
+func splitMessage(text string, limit int) []string {
+	if utf8.RuneCountInString(text) <= limit {
+		return []string{text}
+	}
+
+	var parts []string
+	reopen := "" // Теги, открытые в конце предыдущей части
+	rest := text
+	for rest != "" {
+		budget := limit - utf8.RuneCountInString(reopen)
+		if utf8.RuneCountInString(rest) <= budget {
+			parts = append(parts, reopen+rest)
+			break
+		}
+
+		for {
+			cut := splitPoint(rest, budget)
+			part := reopen + strings.TrimRight(rest[:cut], " \n")
+			open := openTags(part)
+			closing := closeTags(open)
+			if excess := utf8.RuneCountInString(part+closing) - limit; excess > 0 && budget > excess {
+				budget -= excess // Закрывающие теги не поместились: режем раньше
+				continue
+			}
+			if strings.TrimSpace(part) != "" {
+				parts = append(parts, part+closing)
+			}
+			reopen = strings.Join(open, "")
+			rest = strings.TrimLeft(rest[cut:], " \n")
+			break
+		}
+	}
+	return parts
+}
+
+// splitPoint возвращает байтовую позицию разреза в первых maxRunes символах text. Предпочитаются
+// границы абзаца, строки, предложения и слова во второй половине допустимого отрезка.
+func splitPoint(text string, maxRunes int) int {
+	end := len(text)
+	if maxRunes < utf8.RuneCountInString(text) {
+		end = 0
+		for i := 0; i < maxRunes; i++ {
+			_, size := utf8.DecodeRuneInString(text[end:])
+			end += size
+		}
+	}
+
+	// Последние безопасные позиции разреза каждого вида: абзац, строка, предложение, слово, любой символ
+	var boundaries [5]int
+	inTag, inEntity := false, false
+	for i := 0; i < end; {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		next := i + size
+		switch {
+		case inTag:
+			inTag = r != '>'
+		case inEntity:
+			inEntity = r != ';' && (r == '#' || r < utf8.RuneSelf && isAlphaNumeric(byte(r)))
+		case r == '<':
+			inTag = true
+		case r == '&':
+			inEntity = true
+		}
+		if !inTag && !inEntity {
+			boundaries[4] = next
+			switch {
+			case r == '\n' && strings.HasSuffix(text[:next], "\n\n"):
+				boundaries[0] = next
+				boundaries[1] = next
+			case r == '\n':
+				boundaries[1] = next
+			case r == ' ' && i > 0 && strings.ContainsRune(".!?…", lastRune(text[:i])):
+				boundaries[2] = next
+				boundaries[3] = next
+			case r == ' ':
+				boundaries[3] = next
+			}
+		}
+		i = next
+	}
+
+	for _, boundary := range boundaries[:4] {
+		if boundary > end/2 {
+			return boundary
+		}
+	}
+	if boundaries[4] > 0 {
+		return boundaries[4]
+	}
+	return end // Отрезок целиком занят тегом или сущностью: режем как есть
+}
+
+// isAlphaNumeric сообщает, является ли байт латинской буквой или цифрой.
+func isAlphaNumeric(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// lastRune возвращает последний символ строки.
+func lastRune(s string) rune {
+	r, _ := utf8.DecodeLastRuneInString(s)
+	return r
+}
+
+// openTags возвращает открывающие теги, не закрытые к концу текста, в порядке открытия.
+func openTags(text string) []string {
+	var stack []string
+	for _, match := range htmlTagPattern.FindAllStringSubmatch(text, -1) {
+		name := strings.ToLower(match[2])
+		if match[1] == "" {
+			stack = append(stack, match[0])
+			continue
+		}
+		for i := len(stack) - 1; i >= 0; i-- {
+			if tagName(stack[i]) == name {
+				stack = append(stack[:i], stack[i+1:]...)
+				break
+			}
+		}
+	}
+	return stack
+}
+
+// closeTags возвращает закрывающие теги для открытых тегов open в обратном порядке.
+func closeTags(open []string) string {
+	var sb strings.Builder
+	for i := len(open) - 1; i >= 0; i-- {
+		sb.WriteString("</" + tagName(open[i]) + ">")
+	}
+	return sb.String()
+}
+
+// tagName возвращает имя тега из открывающего тега, например "a" для `<a href="...">`.
+func tagName(tag string) string {
+	match := htmlTagPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	return strings.ToLower(match[2])
+}
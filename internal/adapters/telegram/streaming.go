@@ -0,0 +1,109 @@
+package telegram_adapter
+
+import (
+	"context"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	streamEditInterval = 700 * time.Millisecond // Минимальный интервал между правками сообщения с частичным ответом
+	maxStreamPreview   = 4000                   // Максимальная длина частичного ответа в символах (лимит Telegram - 4096)
+
+	typingInterval    = 4 * time.Second // Telegram убирает индикатор набора примерно через 5 секунд
+	maxTypingFailures = 3               // После стольких неудачных отправок подряд индикатор больше не отправляется
+)
+
+// SetStreaming включает показ ответа модели по мере генерации: сообщение-заглушка правится
+// не чаще раза в streamEditInterval накопленным текстом.
+func (c *TelegramBotController) SetStreaming(enabled bool) {
+	c.streamReplies = enabled
+}
+
+// keepTyping показывает в чате индикатор "печатает…", повторяя его каждые typingInterval,
+// пока не будет вызвана возвращенная функция или не отменен ctx. Если отправка раз за разом
+// не удается, горутина завершается сама.
+func (c *TelegramBotController) keepTyping(ctx context.Context, chatID int64) context.CancelFunc {
+	typingCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(typingInterval)
+		defer ticker.Stop()
+
+		failures := 0
+		for {
+			if _, err := c.botClient.Request(telegrambotapi.NewChatAction(chatID, telegrambotapi.ChatTyping)); err != nil {
+				failures++
+				if failures >= maxTypingFailures {
+					c.logger.Warn("Giving up the typing indicator in chat %d: %v", chatID, err)
+					return
+				}
+			} else {
+				failures = 0
+			}
+
+			select {
+			case <-typingCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cancel
+}
+
+// liveMessage сообщение, которое правится накопленным текстом ответа по мере генерации.
+type liveMessage struct {
+	controller *TelegramBotController
+	chatID     int64
+	messageID  int
+	markup     *telegrambotapi.InlineKeyboardMarkup // Клавиатура, сохраняемая при правках (например, кнопка "Stop")
+
+	mu    sync.Mutex
+	text  string // Последний полученный текст ответа
+	shown string // Текст, который сейчас показан в сообщении
+}
+
+// update запоминает накопленный текст ответа. Сообщение правится позже, в run.
+func (m *liveMessage) update(text string) {
+	m.mu.Lock()
+	m.text = text
+	m.mu.Unlock()
+}
+
+// preview возвращает накопленный текст, подготовленный для показа: экранированный и обрезанный до maxStreamPreview.
+// Ответ модели еще не закончен и может содержать незакрытые теги, поэтому HTML в нем не интерпретируется.
+func (m *liveMessage) preview() string {
+	m.mu.Lock()
+	text := m.text
+	m.mu.Unlock()
+	if utf8.RuneCountInString(text) > maxStreamPreview {
+		text = string([]rune(text)[:maxStreamPreview]) + "…"
+	}
+	return escapeModelText(text)
+}
+
+// run правит сообщение накопленным текстом не чаще раза в streamEditInterval, пока не отменен ctx.
+func (m *liveMessage) run(ctx context.Context) {
+	ticker := time.NewTicker(streamEditInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		preview := m.preview()
+		if preview == "" || preview == m.shown {
+			continue
+		}
+		if err := m.controller.editMessage(ctx, m.chatID, m.messageID, preview, m.markup); err != nil {
+			m.controller.logger.DebugInfo("Failed to update streamed reply in chat %d: %v", m.chatID, err)
+			continue
+		}
+		m.shown = preview
+	}
+}
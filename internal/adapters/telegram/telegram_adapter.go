@@ -2,24 +2,69 @@ package telegram_adapter // Имя пакета изменено на telegram_a
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
 	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
 	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"html"
+	"net/http"
+	"sort"
 	"strconv" // Добавлен импорт для strconv
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxFloodRetries = 1                // Сколько раз повторять запрос после ответа 429
+	maxFloodWait    = 10 * time.Second // Максимальная пауза Retry-After, которую готовы ждать
+
+	largePromptLength = 4000 // Длина промпта в символах, после которой пользователь получает предупреждение
+
+	defaultPendingCommandTimeout = 5 * time.Minute // Через сколько ожидаемая команда перестает ждать ввода по умолчанию
 )
 
 // UserInteractorService определяет интерфейс для взаимодействия с UserInteractor.
 type UserInteractorService interface {
 	GetOrCreateUser(ctx context.Context, userID int64, username string) (*domain.User, error) // Добавлен username
-	SaveUser(ctx context.Context, user *domain.User) error
+	UpdateUser(ctx context.Context, user *domain.User, mutate usecases.UserMutation) error
 	GetModelResponseForUser(ctx context.Context, user *domain.User, userMessage string) (string, error)
 	AddCharacter(ctx context.Context, user *domain.User, newChar *domain.CharacterPreset) error
 	ClearChatHistory(ctx context.Context, user *domain.User) error
+	ClearAllHistories(ctx context.Context, user *domain.User) (int, error)
+	ClearContext(ctx context.Context, user *domain.User) (int, error)
+	ImportCharacterCard(ctx context.Context, user *domain.User, data []byte, mimeType string) (*domain.CharacterPreset, error)
+	AttachDocument(ctx context.Context, user *domain.User, name string, data []byte) (bool, error)
+	DetachDocument(ctx context.Context, user *domain.User) error
+	AnonymizeCharacter(user *domain.User) *domain.CharacterPreset
+	ExportCharacterCard(char *domain.CharacterPreset) ([]byte, error)
+	ExportChatHistory(ctx context.Context, user *domain.User) ([]byte, string, error)
+	IsAdmin(userID int64) bool
+	CleanupUser(ctx context.Context, user *domain.User) ([]string, error)
+	CleanupAllUsers(ctx context.Context, admin *domain.User) (usecases.CleanupReport, error)
+	CanInspectRequests(userID int64) bool
+	LastRequest(userID int64) ([]byte, error)
+	ExportUsers(ctx context.Context, admin *domain.User, maxPartBytes int, emit func(part int, data []byte) error) (int, error)
+	RestoreUsers(ctx context.Context, admin *domain.User, data []byte) (int, error)
 	UpdateUserProperty(ctx context.Context, user *domain.User, prop string, value string) error
 	ChangeCurrentCharacter(ctx context.Context, user *domain.User, index int) error
+	MoveCharacter(ctx context.Context, user *domain.User, fromIndex, toIndex int) error
+	DeleteCharacter(ctx context.Context, user *domain.User, index int) error
+	ForgetUser(ctx context.Context, user *domain.User) error
+	ReapplyLastUserMessage(ctx context.Context, user *domain.User) (string, error)
+	RetryWithOverrides(ctx context.Context, user *domain.User, overrides string) (string, []string, error)
+	ForkConversation(ctx context.Context, user *domain.User) (*domain.CharacterPreset, error)
+	TryPrompt(ctx context.Context, user *domain.User, sample string) (string, error)
+	GetGreeting(ctx context.Context, user *domain.User) string
+	GetModelConfig(user *domain.User) domain.ModelConfig
+	UpdateModelConfig(ctx context.Context, user *domain.User, rawJSON string) ([]string, error)
 	ChatHistoryLimit() int
+	ContextSizes(user *domain.User) []usecases.FieldSize
+	SetDigestSubscription(ctx context.Context, user *domain.User, enabled bool, timezone string) error
+	BackendInfo() usecases.GatewayInfo
 }
 
 // TelegramBotController отвечает за взаимодействие с Telegram API и маршрутизацию запросов.
@@ -27,6 +72,23 @@ type TelegramBotController struct {
 	botClient   *telegrambotapi.BotAPI
 	logger      logger.Logger
 	userUseCase UserInteractorService // Зависимость от интерфейса Use Case
+
+	personalizeMenu bool   // Упорядочивать кнопки меню по частоте использования команд
+	oocPrefix       string // Префикс внеролевых (OOC) сообщений, пустая строка отключает префикс
+	version         string // Версия бота для команды /about
+	maxImportSize   int64  // Максимальный размер импортируемой карточки персонажа в байтах
+	maxDocumentSize int64  // Максимальный размер прикрепляемого текстового документа в байтах
+
+	pendingCommandTimeout time.Duration // Через сколько ожидаемая команда перестает ждать ввода (0 - не устаревает)
+
+	logLevels *logger.LevelRegistry // Уровни логирования, изменяемые командой /loglevel (nil - команда недоступна)
+
+	commands     []botCommand          // Реестр команд в порядке вывода в /help
+	commandIndex map[string]botCommand // Команды по имени
+
+	stopButton    bool                // Показывать кнопку остановки во время генерации
+	streamReplies bool                // Показывать ответ по мере генерации, правя сообщение-заглушку
+	generations   *generationRegistry // Выполняющиеся генерации, которые можно остановить
 }
 
 // NewTelegramBotController создает новый экземпляр TelegramBotController.
@@ -36,28 +98,110 @@ func NewTelegramBotController(botToken string, logger logger.Logger, userUseCase
 		logger.Error("Failed to create new Telegram Bot API: %v", err)
 		return nil, fmt.Errorf("failed to create new Telegram Bot API: %w", err)
 	}
-	bot.Debug = false // Отключите отладочные сообщения в продакшене
+	bot.Debug = false // Включается через SetDebug
 	logger.Info("Authorized on account %s", bot.Self.UserName)
+	return newTelegramBotController(bot, logger, userUseCase), nil
+}
 
-	return &TelegramBotController{
+// newTelegramBotController создает контроллер с настройками по умолчанию для уже авторизованного клиента Bot API.
+func newTelegramBotController(bot *telegrambotapi.BotAPI, logger logger.Logger, userUseCase UserInteractorService) *TelegramBotController {
+	controller := &TelegramBotController{
 		botClient:   bot,
 		logger:      logger,
 		userUseCase: userUseCase,
-	}, nil
+		version:     "dev",
+
+		maxImportSize:   defaultMaxImportSize,
+		maxDocumentSize: defaultMaxDocumentSize,
+		generations:     newGenerationRegistry(),
+
+		pendingCommandTimeout: defaultPendingCommandTimeout,
+	}
+	controller.commands = controller.registerCommands()
+	controller.commandIndex = make(map[string]botCommand, len(controller.commands))
+	for _, cmd := range controller.commands {
+		controller.commandIndex[cmd.name] = cmd
+	}
+	return controller
+}
+
+// SetPendingCommandTimeout задает, через сколько команда, ожидающая ввода (например, /setgreeting),
+// перестает его ждать и следующее сообщение отправляется персонажу. Нулевое значение отключает устаревание.
+func (c *TelegramBotController) SetPendingCommandTimeout(timeout time.Duration) {
+	c.pendingCommandTimeout = timeout
+}
+
+// expirePendingCommand сбрасывает устаревшую ожидаемую команду и сообщает пользователю,
+// что его сообщение будет обработано как обычное.
+func (c *TelegramBotController) expirePendingCommand(ctx context.Context, user *domain.User, chatID int64) {
+	if !user.PendingCommandExpired(c.pendingCommandTimeout, time.Now()) {
+		return
+	}
+	c.logger.Info("Pending command %s of user %d expired (set at %s)", user.PendingCommand, user.ID, user.PendingCommandSetAt.Format(time.RFC3339))
+	c.setPendingCommand(ctx, user, "")
+	c.sendMessage(ctx, chatID, "Your earlier command timed out waiting for input, so this message is handled as usual.", nil)
+}
+
+// SetMenuPersonalization включает или отключает упорядочивание меню по частоте использования команд.
+func (c *TelegramBotController) SetMenuPersonalization(enabled bool) {
+	c.personalizeMenu = enabled
 }
 
-// StartPolling начинает прослушивание входящих обновлений Telegram.
+// SetOOCPrefix задает префикс внеролевых (OOC) сообщений.
+// Такие сообщения не попадают в историю чата, а передаются модели как одноразовая системная инструкция
+// для следующей генерации. Пустой префикс оставляет доступной только команду /ooc.
+func (c *TelegramBotController) SetOOCPrefix(prefix string) {
+	c.oocPrefix = prefix
+}
+
+// SetVersion задает версию бота, которую показывает команда /about.
+func (c *TelegramBotController) SetVersion(version string) {
+	c.version = version
+}
+
+// SetDebug включает или отключает отладочный вывод запросов и ответов Telegram Bot API.
+// Вывод содержит тексты сообщений пользователей, поэтому в продакшене его следует отключать.
+func (c *TelegramBotController) SetDebug(enabled bool) {
+	c.botClient.Debug = enabled
+}
+
+// StartPolling прослушивает входящие обновления Telegram до отмены ctx. После отмены получение обновлений
+// останавливается, и StartPolling возвращает управление, дождавшись завершения запущенных обработчиков.
 func (c *TelegramBotController) StartPolling(ctx context.Context) {
 	u := telegrambotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := c.botClient.GetUpdatesChan(u)
+	defer c.botClient.StopReceivingUpdates()
+	c.dispatchUpdates(ctx, updates)
+}
 
-	for update := range updates {
-		if update.Message != nil { // Обработка входящих сообщений
-			go c.handleMessage(ctx, update.Message)
-		} else if update.CallbackQuery != nil { // Обработка callback-запросов от кнопок
-			go c.handleCallbackQuery(ctx, update.CallbackQuery)
+// dispatchUpdates передает обновления из updates обработчикам, пока не отменен ctx или не закрыт канал,
+// и ждет завершения запущенных обработчиков.
+func (c *TelegramBotController) dispatchUpdates(ctx context.Context, updates telegrambotapi.UpdatesChannel) {
+	var handlers sync.WaitGroup
+	defer handlers.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			if update.Message != nil { // Обработка входящих сообщений
+				handlers.Add(1)
+				go func() {
+					defer handlers.Done()
+					c.handleMessage(ctx, update.Message)
+				}()
+			} else if update.CallbackQuery != nil { // Обработка callback-запросов от кнопок
+				handlers.Add(1)
+				go func() {
+					defer handlers.Done()
+					c.handleCallbackQuery(ctx, update.CallbackQuery)
+				}()
+			}
 		}
 	}
 }
@@ -78,17 +222,24 @@ func (c *TelegramBotController) handleMessage(ctx context.Context, message *tele
 		c.sendMessage(ctx, chatID, "An error occurred while fetching your data. Please try again later.", nil)
 		return
 	}
+	user.LanguageCode = message.From.LanguageCode
 
 	// Обновляем LastMessageID, если это обычное сообщение
 	if user.LastMessageID != 0 {
 		c.deleteCommandMessage(ctx, chatID, user.LastMessageID)
-		user.LastMessageID = 0 // Сбрасываем после удаления
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
+		// Сбрасываем после удаления
+		if err := c.userUseCase.UpdateUser(ctx, user, lastMessageMutation(0)); err != nil {
 			c.logger.Error("Failed to save user %d after resetting LastMessageID: %v", userID, err)
 		}
 	}
 
-	if strings.HasPrefix(text, "/") {
+	if !strings.HasPrefix(text, "/") || c.isOOCMessage(text) {
+		c.expirePendingCommand(ctx, user, chatID) // Новая команда сама сбрасывает ожидаемую
+	}
+
+	if message.Document != nil {
+		c.handleDocument(ctx, user, message, chatID)
+	} else if strings.HasPrefix(text, "/") && !c.isOOCMessage(text) {
 		c.handleCommand(ctx, user, message, chatID, text)
 	} else {
 		c.handleTextMessage(ctx, user, message, chatID, text)
@@ -97,141 +248,131 @@ func (c *TelegramBotController) handleMessage(ctx context.Context, message *tele
 
 // handleCommand обрабатывает команды бота.
 func (c *TelegramBotController) handleCommand(ctx context.Context, user *domain.User, message *telegrambotapi.Message, chatID int64, command string) {
-	var response string
-	var markup interface{} = nil
-	commandHandled := true
+	command, args := splitCommand(command)
 
-	// Сбрасываем pending команду, если пользователь вводит новую команду
-	if user.PendingCommand != "" {
-		user.PendingCommand = ""
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
+	// Сбрасываем pending команду, если пользователь вводит новую команду (/cancel сбрасывает ее сам)
+	if user.PendingCommand != "" && command != "/cancel" {
+		if err := c.userUseCase.UpdateUser(ctx, user, pendingCommandMutation("")); err != nil {
 			c.logger.Error("Failed to save user %d after resetting pending command: %v", user.ID, err)
 		}
 	}
 
-	switch command {
-	case "/start":
-		response = fmt.Sprintf("Hello, %s! I am your AI assistant. How can I help you today? You can use /menu to see available options.", user.UserName)
-	case "/menu":
-		response = "What would you like to do?"
-		markup = c.createMainMenu()
-	case "/newchar":
-		newChar := domain.NewCharacterPreset()
-		err := c.userUseCase.AddCharacter(ctx, user, newChar)
-		if err != nil {
-			c.logger.Error("Failed to add new character for user %d: %v", user.ID, err)
-			response = "Failed to add new character."
-		} else {
-			response = fmt.Sprintf("New character '%s' added and set as current.", newChar.Name)
-		}
-	case "/listchar":
-		if len(user.Characters) == 0 {
-			response = "You have no characters yet. Use /newchar to create one."
-		} else {
-			response = "Your characters:\n"
-			for i, char := range user.Characters {
-				response += fmt.Sprintf("%d. %s %s\n", i+1, char.Name, func() string {
-					if i == user.CurrentCharacterID {
-						return "(current)"
-					}
-					return ""
-				}())
-			}
-			response += "\nUse /switchchar <number> to change."
-		}
-	case "/switchchar":
-		user.PendingCommand = "switch_character"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter the number of the character you want to switch to."
-	case "/setprompt":
-		user.PendingCommand = "set_prompt"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter the new prompt for the current character:"
-	case "/setgreeting":
-		user.PendingCommand = "set_greeting"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter the new greeting for the current character:"
-	case "/setcharname":
-		user.PendingCommand = "set_character_name"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter the new name for the current character:"
-	case "/setusername":
-		user.PendingCommand = "set_user_name"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter your new username:"
-	case "/setuserdesc":
-		user.PendingCommand = "set_user_description"
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-			c.logger.Error("Failed to save user %d after setting pending command: %v", user.ID, err)
-		}
-		response = "Please enter your new description:"
-	case "/clearchat":
-		err := c.userUseCase.ClearChatHistory(ctx, user)
-		if err != nil {
-			c.logger.Error("Failed to clear chat history for user %d: %v", user.ID, err)
-			response = "Failed to clear chat history."
-		} else {
-			response = "Chat history cleared."
+	cmd, ok := c.commandIndex[command]
+	if !ok {
+		// В группах команда могла предназначаться другому боту, поэтому там неизвестные команды игнорируются
+		if !isGroupChat(message.Chat) {
+			c.sendMessage(ctx, chatID, "Unknown command. Use /menu to see available options.", nil)
 		}
-	case "/charinfo":
-		char := user.GetCurrentCharacter()
-		response = fmt.Sprintf("<b>Current Character Info:</b>\nName: %s\nGreeting: %s\nPrompt: %s\nChat Messages: %d/%d",
-			char.Name, char.Greeting, char.Prompt, len(char.Chat), c.userUseCase.ChatHistoryLimit())
-	default:
-		response = "Unknown command. Use /menu to see available options."
-		commandHandled = false
+		return
+	}
+
+	var response string
+	var markup interface{}
+	if !c.hasPermission(user, cmd.permission) {
+		c.logger.Warn("User %d is not authorized to use %s", user.ID, command)
+		response = "You are not authorized to use this command."
+	} else if cmd.generates {
+		stopTyping := c.keepTyping(ctx, chatID)
+		response, markup = cmd.handler(ctx, user, message, command, args)
+		stopTyping()
+	} else {
+		response, markup = cmd.handler(ctx, user, message, command, args)
+	}
+	if markup == nil && user.PendingCommand != "" {
+		markup = cancelKeyboard() // Запрос ввода можно отменить кнопкой, не отправляя лишний текст
 	}
 
-	if commandHandled {
-		c.deleteCommandMessage(ctx, chatID, message.MessageID) // Удаляем сообщение с командой
-		sentMessageID := c.sendMessage(ctx, chatID, response, markup)
+	c.deleteCommandMessage(ctx, chatID, message.MessageID) // Удаляем сообщение с командой
+	sentMessageID := c.sendMessage(ctx, chatID, response, markup)
+	recordUsage := func(u *domain.User) error {
+		u.RecordCommandUsage(command) // Учитываются только известные команды, поэтому карта ограничена
 		if sentMessageID != -1 {
-			user.LastMessageID = sentMessageID // Сохраняем ID сообщения бота
-			if err := c.userUseCase.SaveUser(ctx, user); err != nil {
-				c.logger.Error("Failed to save LastMessageID for user %d: %v", user.ID, err)
-			}
+			u.LastMessageID = sentMessageID // Сохраняем ID сообщения бота
 		}
+		return nil
+	}
+	if err := c.userUseCase.UpdateUser(ctx, user, recordUsage); err != nil {
+		c.logger.Error("Failed to save command usage and LastMessageID for user %d: %v", user.ID, err)
 	}
 }
 
 // handleTextMessage обрабатывает обычные текстовые сообщения (не команды).
 func (c *TelegramBotController) handleTextMessage(ctx context.Context, user *domain.User, message *telegrambotapi.Message, chatID int64, text string) {
 	var response string
+	var markup interface{}
 	var err error
 
-	// Если есть ожидающая команда, обрабатываем ее
-	if user.PendingCommand != "" {
+	// Внеролевые сообщения не попадают в историю и применяются только к следующей генерации
+	if c.isOOCMessage(text) {
+		response = c.setOOCInstruction(ctx, user, strings.TrimSpace(strings.TrimPrefix(text, c.oocPrefix)))
+	} else if user.PendingCommand == "delete_character" {
+		// Номер персонажа только выбирает его: удаление подтверждается кнопкой
+		response, markup = confirmCharacterDeletion(user, text)
+		if err := c.userUseCase.UpdateUser(ctx, user, pendingCommandMutation("")); err != nil {
+			c.logger.Error("Failed to save user %d after handling pending command: %v", user.ID, err)
+		}
+	} else if user.PendingCommand != "" {
 		response, err = c.handlePendingCommand(ctx, user, text)
 		if err != nil {
 			c.logger.Error("Error handling pending command for user %d: %v", user.ID, err)
 			response = "An error occurred while processing your input. Please try again."
 		}
-		user.PendingCommand = "" // Сбрасываем ожидающую команду после обработки
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
+		// Сбрасываем ожидающую команду после обработки
+		if err := c.userUseCase.UpdateUser(ctx, user, pendingCommandMutation("")); err != nil {
 			c.logger.Error("Failed to save user %d after handling pending command: %v", user.ID, err)
 		}
 	} else {
 		// Иначе генерируем ответ от модели
-		response, err = c.userUseCase.GetModelResponseForUser(ctx, user, text)
+		placeholderID := -1
+		partial := ""
+		generate := func(ctx context.Context) (string, error) {
+			reply, err := c.userUseCase.GetModelResponseForUser(ctx, user, text)
+			if truncated, ok := c.truncatedReply(user, reply, err); ok {
+				return truncated, nil
+			}
+			if err != nil {
+				return "", err
+			}
+			return formatReply(user.GetCurrentCharacter(), reply), nil
+		}
+		whisper := user.WhisperMode && isGroupChat(message.Chat)
+		stream := c.streamReplies && !whisper // Частичный ответ в группе раскрыл бы ответ, отправляемый лично
+		stopTyping := c.keepTyping(ctx, chatID)
+		if c.stopButton || stream {
+			response, placeholderID, partial, err = c.generateWithPlaceholder(ctx, chatID, user.ID, stream, generate)
+		} else {
+			response, err = generate(ctx)
+		}
+		stopTyping()
 		if err != nil {
-			c.logger.Error("Error getting model response for user %d: %v", user.ID, err)
-			response = "I'm sorry, I couldn't process your request. Please try again."
+			response = c.modelErrorResponse(user, err)
+		} else if whisper {
+			response = c.whisperReply(ctx, user, response)
+		}
+
+		// Заменяем заглушку ответом, заодно убирая кнопку "Stop". Если генерация прервалась с ошибкой,
+		// уже показанная часть ответа остается в заглушке, а ошибка отправляется отдельным сообщением.
+		if placeholderID != -1 && err != nil && partial != "" {
+			if err := c.editMessage(ctx, chatID, placeholderID, partial, nil); err != nil {
+				c.logger.Error("Failed to finalize partial reply for user %d: %v", user.ID, err)
+			}
+		} else if placeholderID != -1 {
+			// Длинный ответ не поместится в заглушку: она удаляется, а ответ отправляется частями
+			if utf8.RuneCountInString(response) <= maxMessageLength {
+				if err := c.editMessage(ctx, chatID, placeholderID, response, nil); err == nil {
+					if err := c.userUseCase.UpdateUser(ctx, user, lastMessageMutation(placeholderID)); err != nil {
+						c.logger.Error("Failed to save LastMessageID for user %d: %v", user.ID, err)
+					}
+					return
+				}
+			}
+			c.deleteCommandMessage(ctx, chatID, placeholderID)
 		}
 	}
-	sentMessageID := c.sendMessage(ctx, chatID, response, nil)
+	sentMessageID := c.sendMessage(ctx, chatID, response, markup)
 	if sentMessageID != -1 {
-		user.LastMessageID = sentMessageID // Сохраняем ID сообщения бота
-		if err := c.userUseCase.SaveUser(ctx, user); err != nil {
+		// Сохраняем ID сообщения бота
+		if err := c.userUseCase.UpdateUser(ctx, user, lastMessageMutation(sentMessageID)); err != nil {
 			c.logger.Error("Failed to save LastMessageID for user %d: %v", user.ID, err)
 		}
 	}
@@ -249,13 +390,72 @@ func (c *TelegramBotController) handlePendingCommand(ctx context.Context, user *
 		if err != nil {
 			return fmt.Sprintf("Failed to switch character: %v", err), err
 		}
-		return fmt.Sprintf("Switched to character: %s", user.GetCurrentCharacter().Name), nil
+		return fmt.Sprintf("Switched to character: %s", html.EscapeString(user.GetCurrentCharacter().VisibleName())), nil
 	case "set_prompt":
 		err := c.userUseCase.UpdateUserProperty(ctx, user, "Prompt", input)
 		if err != nil {
 			return fmt.Sprintf("Failed to set prompt: %v", err), err
 		}
 		return "Prompt updated successfully!", nil
+	case "append_prompt", "prepend_prompt":
+		prop := "AppendPrompt"
+		if user.PendingCommand == "prepend_prompt" {
+			prop = "PrependPrompt"
+		}
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, prop, input); err != nil {
+			return fmt.Sprintf("Failed to update prompt: %v", err), err
+		}
+		length := utf8.RuneCountInString(user.GetCurrentCharacter().Prompt)
+		response := fmt.Sprintf("Prompt updated, it is now %d characters long.", length)
+		if length > largePromptLength {
+			response += fmt.Sprintf("\n<b>Warning:</b> prompts longer than %d characters take up a large part of the model context and leave less room for the conversation.", largePromptLength)
+		}
+		return response, nil
+	case "set_rules":
+		if strings.TrimSpace(input) == "-" {
+			input = ""
+		}
+		err := c.userUseCase.UpdateUserProperty(ctx, user, "SystemRules", input)
+		if err != nil {
+			return fmt.Sprintf("Failed to set rules: %v", err), err
+		}
+		if input == "" {
+			return "Rules removed.", nil
+		}
+		return "Rules updated successfully!", nil
+	case "set_authors_note":
+		if strings.TrimSpace(input) == "-" {
+			input = ""
+		}
+		err := c.userUseCase.UpdateUserProperty(ctx, user, "AuthorsNote", input)
+		if err != nil {
+			return fmt.Sprintf("Failed to set author's note: %v", err), err
+		}
+		if input == "" {
+			return "Author's note removed.", nil
+		}
+		return "Author's note updated successfully!", nil
+	case "add_greeting":
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "AddGreeting", input); err != nil {
+			return fmt.Sprintf("Failed to add greeting: %v", err), err
+		}
+		return fmt.Sprintf("Alternate greeting added as number %d with weight 1. Use /greetings to review them.", len(user.GetCurrentCharacter().AlternateGreetings)+1), nil
+	case "add_example":
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "AddExample", input); err != nil {
+			return fmt.Sprintf("Failed to add example: %v", err), err
+		}
+		return fmt.Sprintf("Example exchange %d added. Use /examples to review them.", user.GetCurrentCharacter().ExamplePairs()), nil
+	case "set_display_name":
+		if strings.TrimSpace(input) == "-" {
+			input = ""
+		}
+		if err := c.userUseCase.UpdateUserProperty(ctx, user, "DisplayName", input); err != nil {
+			return fmt.Sprintf("Failed to set display name: %v", err), err
+		}
+		if input == "" {
+			return "Display name removed. Replies are no longer signed.", nil
+		}
+		return fmt.Sprintf("Replies will now be signed as %s.", html.EscapeString(user.GetCurrentCharacter().DisplayName)), nil
 	case "set_greeting":
 		err := c.userUseCase.UpdateUserProperty(ctx, user, "Greeting", input)
 		if err != nil {
@@ -274,6 +474,40 @@ func (c *TelegramBotController) handlePendingCommand(ctx context.Context, user *
 			return fmt.Sprintf("Failed to set your username: %v", err), err
 		}
 		return "Your username updated successfully!", nil
+	case "set_ooc":
+		return c.setOOCInstruction(ctx, user, input), nil
+	case "set_next_context":
+		return c.setNextContext(ctx, user, input), nil
+	case "set_prompt_position":
+		err := c.userUseCase.UpdateUserProperty(ctx, user, "PromptPosition", input)
+		if err != nil {
+			return "Invalid prompt position. Please use start, end or both.", nil
+		}
+		return "Prompt position updated successfully!", nil
+	case "set_params":
+		notices, err := c.userUseCase.UpdateModelConfig(ctx, user, input)
+		if err != nil {
+			return fmt.Sprintf("Parameters were not changed: %s", html.EscapeString(err.Error())), nil
+		}
+		if len(notices) > 0 {
+			return fmt.Sprintf("Model parameters updated, but some exceed the limits set by the operator: %s.", strings.Join(notices, ", ")), nil
+		}
+		return "Model parameters updated successfully!", nil
+	case "set_temperature", "set_max_tokens":
+		prop := "Temperature"
+		if user.PendingCommand == "set_max_tokens" {
+			prop = "MaxTokens"
+		}
+		err := c.userUseCase.UpdateUserProperty(ctx, user, prop, input)
+		if errors.Is(err, usecases.ErrInvalidParameters) {
+			return fmt.Sprintf("Parameters were not changed: %s", html.EscapeString(err.Error())), nil
+		}
+		if err != nil {
+			return "Failed to update model parameters.", err
+		}
+		config := c.userUseCase.GetModelConfig(user)
+		return fmt.Sprintf("'%s' now uses temperature %g and at most %d tokens per reply.",
+			html.EscapeString(user.GetCurrentCharacter().Name), config.Temperature, config.MaxTokens), nil
 	case "set_user_description":
 		err := c.userUseCase.UpdateUserProperty(ctx, user, "UserDescription", input)
 		if err != nil {
@@ -285,35 +519,47 @@ func (c *TelegramBotController) handlePendingCommand(ctx context.Context, user *
 	}
 }
 
-// createMainMenu создает клавиатуру с главным меню.
-func (c *TelegramBotController) createMainMenu() *telegrambotapi.InlineKeyboardMarkup {
-	keyboard := telegrambotapi.NewInlineKeyboardMarkup(
-		telegrambotapi.NewInlineKeyboardRow(
-			telegrambotapi.NewInlineKeyboardButtonData("New Character", "/newchar"),
-			telegrambotapi.NewInlineKeyboardButtonData("List Characters", "/listchar"),
-		),
-		telegrambotapi.NewInlineKeyboardRow(
-			telegrambotapi.NewInlineKeyboardButtonData("Switch Character", "/switchchar"),
-			telegrambotapi.NewInlineKeyboardButtonData("Set Character Name", "/setcharname"),
-		),
-		telegrambotapi.NewInlineKeyboardRow(
-			telegrambotapi.NewInlineKeyboardButtonData("Set Prompt", "/setprompt"),
-			telegrambotapi.NewInlineKeyboardButtonData("Set Greeting", "/setgreeting"),
-		),
-		telegrambotapi.NewInlineKeyboardRow(
-			telegrambotapi.NewInlineKeyboardButtonData("Set My Name", "/setusername"),
-			telegrambotapi.NewInlineKeyboardButtonData("Set My Description", "/setuserdesc"),
-		),
-		telegrambotapi.NewInlineKeyboardRow(
-			telegrambotapi.NewInlineKeyboardButtonData("Clear Chat History", "/clearchat"),
-			telegrambotapi.NewInlineKeyboardButtonData("Character Info", "/charinfo"),
-		),
-	)
+// createMainMenu создает клавиатуру с главным меню из команд реестра, у которых задан menuLabel.
+// Если персонализация включена, кнопки упорядочиваются по частоте использования,
+// а самая используемая команда помечается звездочкой.
+func (c *TelegramBotController) createMainMenu(user *domain.User) *telegrambotapi.InlineKeyboardMarkup {
+	var buttons []botCommand
+	for _, cmd := range c.commands {
+		if cmd.menuLabel != "" && c.hasPermission(user, cmd.permission) {
+			buttons = append(buttons, cmd)
+		}
+	}
+
+	if c.personalizeMenu && len(user.CommandUsage) > 0 && len(buttons) > 0 {
+		sort.SliceStable(buttons, func(i, j int) bool {
+			return user.CommandUsage[buttons[i].name] > user.CommandUsage[buttons[j].name]
+		})
+		if user.CommandUsage[buttons[0].name] > 0 {
+			buttons[0].menuLabel = "⭐ " + buttons[0].menuLabel
+		}
+	}
+
+	var rows [][]telegrambotapi.InlineKeyboardButton
+	for i := 0; i < len(buttons); i += 2 {
+		row := []telegrambotapi.InlineKeyboardButton{
+			telegrambotapi.NewInlineKeyboardButtonData(buttons[i].menuLabel, buttons[i].name),
+		}
+		if i+1 < len(buttons) {
+			row = append(row, telegrambotapi.NewInlineKeyboardButtonData(buttons[i+1].menuLabel, buttons[i+1].name))
+		}
+		rows = append(rows, row)
+	}
+	keyboard := telegrambotapi.NewInlineKeyboardMarkup(rows...)
 	return &keyboard
 }
 
 // handleCallbackQuery обрабатывает callback-запросы от инлайн-кнопок.
 func (c *TelegramBotController) handleCallbackQuery(ctx context.Context, callbackQuery *telegrambotapi.CallbackQuery) {
+	if callbackQuery.Data == stopGenerationCallback {
+		c.handleStopGeneration(ctx, callbackQuery)
+		return
+	}
+
 	userID := callbackQuery.From.ID
 	chatID := callbackQuery.Message.Chat.ID
 	command := callbackQuery.Data
@@ -328,6 +574,14 @@ func (c *TelegramBotController) handleCallbackQuery(ctx context.Context, callbac
 		c.sendMessage(ctx, chatID, "An error occurred. Please try again.", nil)
 		return
 	}
+	user.LanguageCode = callbackQuery.From.LanguageCode
+
+	// Меню старше последнего сообщения бота (например, оставшееся после перезапуска) считаем устаревшим:
+	// команду не выполняем, а показываем актуальное меню
+	if user.LastMessageID != 0 && callbackQuery.Message.MessageID < user.LastMessageID {
+		c.refreshStaleMenu(ctx, user, callbackQuery)
+		return
+	}
 
 	// Обновляем LastMessageID, если это сообщение с меню
 	if user.LastMessageID != 0 && user.LastMessageID != callbackQuery.Message.MessageID {
@@ -353,38 +607,249 @@ func (c *TelegramBotController) handleCallbackQuery(ctx context.Context, callbac
 
 	// Отвечаем на callback query, чтобы убрать индикатор загрузки на кнопке
 	callbackConfig := telegrambotapi.NewCallback(callbackQuery.ID, "")
-	_, err = c.botClient.Request(callbackConfig)
+	err = c.requestWithFloodRetry(ctx, callbackConfig)
 	if err != nil {
 		c.logger.Error("Failed to answer callback query: %v", err)
 	}
 }
 
-// sendMessage отправляет сообщение в чат.
-func (c *TelegramBotController) sendMessage(ctx context.Context, chatID int64, response string, markup interface{}) int {
-	msg := telegrambotapi.NewMessage(chatID, response)
+// refreshStaleMenu заменяет устаревшее меню актуальным. Сначала пытается отредактировать сообщение,
+// на кнопку которого нажали, а если это не удалось (сообщение удалено или слишком старое), отправляет новое.
+func (c *TelegramBotController) refreshStaleMenu(ctx context.Context, user *domain.User, callbackQuery *telegrambotapi.CallbackQuery) {
+	chatID := callbackQuery.Message.Chat.ID
+	staleMessageID := callbackQuery.Message.MessageID
+	c.logger.Info("Stale menu callback %q from user %d (message %d, last %d)", callbackQuery.Data, user.ID, staleMessageID, user.LastMessageID)
+
+	response := "This menu is outdated. What would you like to do?"
+	markup := c.createMainMenu(user)
+	menuMessageID := staleMessageID
+	if err := c.editMessage(ctx, chatID, staleMessageID, response, markup); err != nil {
+		c.logger.Warn("Failed to edit stale menu %d in chat %d, sending a new one: %v", staleMessageID, chatID, err)
+		menuMessageID = c.sendMessage(ctx, chatID, response, markup)
+	}
+
+	if menuMessageID != -1 {
+		if user.LastMessageID != menuMessageID {
+			c.deleteCommandMessage(ctx, chatID, user.LastMessageID)
+		}
+		if err := c.userUseCase.UpdateUser(ctx, user, lastMessageMutation(menuMessageID)); err != nil {
+			c.logger.Error("Failed to save LastMessageID for user %d: %v", user.ID, err)
+		}
+	}
+
+	callbackConfig := telegrambotapi.NewCallback(callbackQuery.ID, "This menu was outdated and has been refreshed.")
+	if err := c.requestWithFloodRetry(ctx, callbackConfig); err != nil {
+		c.logger.Error("Failed to answer callback query: %v", err)
+	}
+}
+
+// editMessage заменяет текст и инлайн-клавиатуру ранее отправленного сообщения.
+func (c *TelegramBotController) editMessage(ctx context.Context, chatID int64, messageID int, response string, markup *telegrambotapi.InlineKeyboardMarkup) error {
+	var edit telegrambotapi.EditMessageTextConfig
 	if markup != nil {
-		switch m := markup.(type) {
-		case *telegrambotapi.InlineKeyboardMarkup:
-			msg.ReplyMarkup = m
-		case *telegrambotapi.ReplyKeyboardMarkup:
-			msg.ReplyMarkup = m
+		edit = telegrambotapi.NewEditMessageTextAndMarkup(chatID, messageID, response, *markup)
+	} else {
+		edit = telegrambotapi.NewEditMessageText(chatID, messageID, response)
+	}
+	edit.ParseMode = telegrambotapi.ModeHTML
+	return c.requestWithFloodRetry(ctx, edit)
+}
+
+// sendMessage отправляет сообщение в чат. Текст длиннее maxMessageLength отправляется несколькими
+// сообщениями по порядку (см. splitMessage); клавиатура прикрепляется к последнему из них.
+// Возвращает ID последнего отправленного сообщения или -1, если не удалось отправить ни одного.
+func (c *TelegramBotController) sendMessage(ctx context.Context, chatID int64, response string, markup interface{}) int {
+	parts := splitMessage(response, maxMessageLength)
+	lastID := -1
+	for i, part := range parts {
+		msg := telegrambotapi.NewMessage(chatID, part)
+		if markup != nil && i == len(parts)-1 {
+			switch m := markup.(type) {
+			case *telegrambotapi.InlineKeyboardMarkup:
+				msg.ReplyMarkup = m
+			case *telegrambotapi.ReplyKeyboardMarkup:
+				msg.ReplyMarkup = m
+			}
+		}
+		msg.ParseMode = telegrambotapi.ModeHTML // Или ModeMarkdown, если вы используете Markdown
+
+		sentMessage, err := c.botClient.Send(msg)
+		if err != nil {
+			c.logger.Error("Error sending message to chat %d (part %d of %d): %v", chatID, i+1, len(parts), err)
+			return lastID
 		}
+		lastID = sentMessage.MessageID
 	}
-	msg.ParseMode = telegrambotapi.ModeHTML // Или ModeMarkdown, если вы используете Markdown
+	return lastID
+}
 
-	sentMessage, err := c.botClient.Send(msg)
-	if err != nil {
-		c.logger.Error("Error sending message to chat %d: %v", chatID, err)
-		return -1
+// requestWithFloodRetry выполняет запрос к Telegram API и при ошибке 429 (flood limit)
+// повторяет его после паузы Retry-After. Количество повторов и длительность паузы ограничены,
+// чтобы не блокировать горутину обработчика надолго.
+func (c *TelegramBotController) requestWithFloodRetry(ctx context.Context, config telegrambotapi.Chattable) error {
+	_, err := c.botClient.Request(config)
+	for attempt := 0; attempt < maxFloodRetries && err != nil; attempt++ {
+		var apiErr *telegrambotapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusTooManyRequests {
+			return err
+		}
+		wait := time.Duration(apiErr.RetryAfter) * time.Second
+		if wait <= 0 || wait > maxFloodWait {
+			return err
+		}
+
+		c.logger.Warn("Telegram flood limit hit, retrying in %s", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		_, err = c.botClient.Request(config)
 	}
-	return sentMessage.MessageID
+	return err
 }
 
 // deleteCommandMessage удаляет сообщение.
 func (c *TelegramBotController) deleteCommandMessage(ctx context.Context, chatID int64, messageID int) {
 	deleteConfig := telegrambotapi.NewDeleteMessage(chatID, messageID)
-	_, err := c.botClient.Request(deleteConfig)
+	err := c.requestWithFloodRetry(ctx, deleteConfig)
 	if err != nil {
-		c.logger.Error("Failed to delete message %d in chat %d: %v", messageID, chatID, err)
+		c.logger.Error("Failed to delete message %d in chat %d after retries: %v", messageID, chatID, err)
+	}
+}
+
+// formatAutoLanguage возвращает описание режима автоопределения языка для /charinfo.
+func formatAutoLanguage(char *domain.CharacterPreset) string {
+	if !char.AutoLanguage {
+		return "off"
+	}
+	if char.DetectedLanguage == "" {
+		return "on"
+	}
+	return fmt.Sprintf("on (%s)", char.DetectedLanguage)
+}
+
+// splitCommand разделяет текст команды на имя (без суффикса @botname) и аргументы.
+func splitCommand(text string) (string, string) {
+	name, args, _ := strings.Cut(strings.TrimSpace(text), " ")
+	if at := strings.Index(name, "@"); at != -1 {
+		name = name[:at]
+	}
+	return name, strings.TrimSpace(args)
+}
+
+// isOOCMessage проверяет, является ли сообщение внеролевым (OOC).
+func (c *TelegramBotController) isOOCMessage(text string) bool {
+	return c.oocPrefix != "" && strings.HasPrefix(text, c.oocPrefix)
+}
+
+// setOOCInstruction сохраняет одноразовую внеролевую инструкцию и возвращает ответ пользователю.
+func (c *TelegramBotController) setOOCInstruction(ctx context.Context, user *domain.User, instruction string) string {
+	if instruction == "" {
+		return "The out-of-character instruction is empty."
+	}
+	if err := c.userUseCase.UpdateUserProperty(ctx, user, "OOCInstruction", instruction); err != nil {
+		c.logger.Error("Failed to save OOC instruction for user %d: %v", user.ID, err)
+		return "Failed to save the out-of-character instruction."
+	}
+	return "Noted. This instruction will apply to the next reply only and won't be saved in the chat history."
+}
+
+// setNextContext сохраняет одноразовый контекст для следующей генерации ("-" удаляет его) и возвращает ответ пользователю.
+func (c *TelegramBotController) setNextContext(ctx context.Context, user *domain.User, text string) string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "The context is empty."
+	}
+	if text == "-" {
+		text = ""
+	}
+	if err := c.userUseCase.UpdateUserProperty(ctx, user, "NextContext", text); err != nil {
+		c.logger.Error("Failed to save next context for user %d: %v", user.ID, err)
+		return "Failed to save the context."
+	}
+	if text == "" {
+		return "The pending context was dropped."
+	}
+	return fmt.Sprintf("Got it: %d characters of context will be given to the model with your next message only and won't be saved in the chat history.", utf8.RuneCountInString(text))
+}
+
+// modelErrorResponse логирует ошибку генерации и возвращает понятное пользователю сообщение.
+func (c *TelegramBotController) modelErrorResponse(user *domain.User, err error) string {
+	var rateLimitErr *usecases.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		c.logger.Info("Rate limiting user %d for %s", user.ID, rateLimitErr.RetryAfter)
+		return fmt.Sprintf("Please wait a moment before sending another message. You can try again in %d second(s).", rateLimitErr.RetryAfterSeconds())
+	}
+	if errors.Is(err, usecases.ErrBackendBusy) {
+		c.logger.Warn("Model backend is busy, rejecting request from user %d", user.ID)
+		return "I'm a bit overloaded right now and talking to too many people at once. Please try again in a minute."
+	}
+	if errors.Is(err, usecases.ErrTimeBudgetExceeded) {
+		c.logger.Warn("Model reply for user %d exceeded the character's time budget: %v", user.ID, err)
+		return fmt.Sprintf("The model didn't reply within %d second(s), the time budget of this character. Try again, ask for a shorter answer or change the budget with /timebudget.",
+			user.GetCurrentCharacter().TimeBudget)
+	}
+	if errors.Is(err, usecases.ErrEmptyResponse) {
+		c.logger.Warn("Model returned an empty reply twice for user %d", user.ID)
+		return "The model returned an empty reply. Please try again or rephrase your message."
+	}
+	c.logger.Error("Error getting model response for user %d: %v", user.ID, err)
+	return "I'm sorry, I couldn't process your request. Please try again."
+}
+
+// truncatedReply возвращает часть ответа, которая сохранена в историю после прерывания генерации
+// (usecases.ErrResponseTruncated), с пояснением, почему ответ неполный. ok ложно, если ответ не прерывался.
+func (c *TelegramBotController) truncatedReply(user *domain.User, reply string, err error) (response string, ok bool) {
+	if !errors.Is(err, usecases.ErrResponseTruncated) {
+		return "", false
+	}
+	c.logger.Warn("Showing a cut short reply to user %d: %v", user.ID, err)
+	notice := "The connection to the model was lost, so the reply is incomplete."
+	if errors.Is(err, usecases.ErrTimeBudgetExceeded) {
+		notice = fmt.Sprintf("Cut short: this character's time budget of %d second(s) ran out.", user.GetCurrentCharacter().TimeBudget)
+	} else if errors.Is(err, context.Canceled) {
+		notice = "Generation stopped."
+	}
+	return formatReply(user.GetCurrentCharacter(), reply) + "\n\n<i>" + notice + "</i>", true
+}
+
+// escapeModelText подготавливает текст модели к отправке с разметкой HTML. Модель пишет обычный текст:
+// символы "<" и "&" в нем Telegram принял бы за разметку и мог бы отклонить сообщение.
+// Используется и для окончательного ответа, и для частичного при потоковой генерации, чтобы они выглядели одинаково.
+func escapeModelText(text string) string {
+	return html.EscapeString(text)
+}
+
+// formatReply экранирует ответ персонажа и подписывает его DisplayName, если оно задано.
+// В режиме JSON ответ показывается блоком кода.
+func formatReply(char *domain.CharacterPreset, reply string) string {
+	reply = escapeModelText(reply)
+	if char.ModelConfig != nil && char.ModelConfig.JSONMode {
+		reply = "<pre>" + reply + "</pre>"
+	}
+	if char.DisplayName == "" {
+		return reply
+	}
+	return fmt.Sprintf("<b>%s:</b>\n%s", html.EscapeString(char.DisplayName), reply)
+}
+
+// isGroupChat проверяет, является ли чат групповым.
+func isGroupChat(chat *telegrambotapi.Chat) bool {
+	return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// whisperReply отправляет ответ пользователю в личный чат и возвращает короткую заметку для группы.
+// Бот не может первым написать пользователю, который не начал с ним личный диалог, поэтому
+// в этом случае заметка объясняет, что нужно сделать.
+func (c *TelegramBotController) whisperReply(ctx context.Context, user *domain.User, reply string) string {
+	// ID личного чата с пользователем совпадает с его ID в Telegram
+	if c.sendMessage(ctx, user.ID, reply, nil) == -1 {
+		c.logger.Warn("Failed to whisper reply to user %d, probably the bot was not started privately", user.ID)
+		return fmt.Sprintf("%s, I couldn't message you privately. Please open a private chat with @%s, press Start and try again.",
+			html.EscapeString(user.UserName), c.botClient.Self.UserName)
 	}
+	return fmt.Sprintf("%s, I sent you my reply in a private message.", html.EscapeString(user.UserName))
 }
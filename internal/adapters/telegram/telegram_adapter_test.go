@@ -0,0 +1,161 @@
+package telegram_adapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	telegrambotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/internal/usecases"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+func TestTruncatedReply(t *testing.T) {
+	c := &TelegramBotController{logger: logger.NewWriterLogger(io.Discard, logger.None)}
+	user := domain.NewUser(1, "alice")
+	user.GetCurrentCharacter().TimeBudget = 15
+
+	tests := []struct {
+		name       string
+		err        error
+		wantOK     bool
+		wantNotice string
+	}{
+		{name: "complete reply", err: nil, wantOK: false},
+		{name: "other error", err: errors.New("backend down"), wantOK: false},
+		{
+			name:       "time budget",
+			err:        fmt.Errorf("%w: %w", usecases.ErrResponseTruncated, fmt.Errorf("%w: deadline", usecases.ErrTimeBudgetExceeded)),
+			wantOK:     true,
+			wantNotice: "time budget of 15 second(s) ran out",
+		},
+		{
+			name:       "stopped",
+			err:        fmt.Errorf("%w: %w", usecases.ErrResponseTruncated, context.Canceled),
+			wantOK:     true,
+			wantNotice: "Generation stopped.",
+		},
+		{
+			name:       "stream broke",
+			err:        fmt.Errorf("%w: %w", usecases.ErrResponseTruncated, io.ErrUnexpectedEOF),
+			wantOK:     true,
+			wantNotice: "the reply is incomplete",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, ok := c.truncatedReply(user, "Partial text", tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if !strings.HasPrefix(response, "Partial text") {
+				t.Errorf("response %q does not start with the partial text", response)
+			}
+			if !strings.Contains(response, tt.wantNotice) {
+				t.Errorf("response %q does not contain %q", response, tt.wantNotice)
+			}
+		})
+	}
+}
+
+func TestDispatchUpdatesStops(t *testing.T) {
+	c := &TelegramBotController{logger: logger.NewWriterLogger(io.Discard, logger.None)}
+	tests := []struct {
+		name string
+		stop func(cancel context.CancelFunc, updates chan telegrambotapi.Update)
+	}{
+		{name: "context canceled", stop: func(cancel context.CancelFunc, _ chan telegrambotapi.Update) { cancel() }},
+		{name: "updates closed", stop: func(_ context.CancelFunc, updates chan telegrambotapi.Update) { close(updates) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			updates := make(chan telegrambotapi.Update)
+			done := make(chan struct{})
+			go func() {
+				c.dispatchUpdates(ctx, updates)
+				close(done)
+			}()
+
+			tt.stop(cancel, updates)
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("dispatchUpdates did not return")
+			}
+		})
+	}
+}
+
+// replyService отвечает на сообщения пользователя заданным текстом модели.
+type replyService struct {
+	fakeUserService
+	reply string
+}
+
+func (s replyService) GetModelResponseForUser(ctx context.Context, user *domain.User, userMessage string) (string, error) {
+	return s.reply, nil
+}
+
+func (replyService) UpdateUser(ctx context.Context, user *domain.User, mutate usecases.UserMutation) error {
+	return mutate(user)
+}
+
+func TestModelReplyIsEscaped(t *testing.T) {
+	const reply = `if a < b && c > d { print("<b>") }`
+	const escaped = `if a &lt; b &amp;&amp; c &gt; d { print(&#34;&lt;b&gt;&#34;) }`
+	tests := []struct {
+		name        string
+		stream      bool
+		displayName string
+		want        string
+	}{
+		{name: "plain reply", want: escaped},
+		{name: "signed reply", displayName: "R&D <bot>", want: "<b>R&amp;D &lt;bot&gt;:</b>\n" + escaped},
+		{name: "streamed reply", stream: true, want: escaped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, api := newTestController(t, replyService{reply: reply})
+			c.SetStreaming(tt.stream)
+			user := domain.NewUser(1, "alice")
+			user.GetCurrentCharacter().DisplayName = tt.displayName
+			message := &telegrambotapi.Message{MessageID: 10, From: &telegrambotapi.User{ID: 1}, Chat: &telegrambotapi.Chat{ID: 1, Type: "private"}, Text: "hi"}
+
+			c.handleTextMessage(context.Background(), user, message, 1, message.Text)
+
+			// Окончательный ответ отправляется новым сообщением, а при потоковой генерации заменяет заглушку
+			method := "sendMessage"
+			if tt.stream {
+				method = "editMessageText"
+			}
+			sent := api.sent(method)
+			if len(sent) == 0 {
+				t.Fatalf("no %s request was made", method)
+			}
+			final := sent[len(sent)-1]
+			if got := final.Get("text"); got != tt.want {
+				t.Errorf("final reply = %q, want %q", got, tt.want)
+			}
+			if got := final.Get("parse_mode"); got != telegrambotapi.ModeHTML {
+				t.Errorf("parse_mode = %q, want HTML", got)
+			}
+		})
+	}
+
+	// Частичный ответ экранируется так же, как окончательный
+	live := &liveMessage{text: reply}
+	if got := live.preview(); got != escaped {
+		t.Errorf("streaming preview = %q, want %q", got, escaped)
+	}
+}
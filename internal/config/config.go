@@ -1,96 +1,477 @@
 package config
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
 )
 
-// Config содержит все настройки приложения
+// Config содержит все настройки приложения.
+// Теги задают имена полей в файле конфигурации (YAML или JSON), см. LoadConfigFromFile.
 type Config struct {
-	Telegram TelegramConfig
-	MongoDB  MongoDBConfig
-	LlamaCPP LlamaCPPConfig
-	Chat     ChatConfig
+	Telegram TelegramConfig `yaml:"telegram" json:"telegram"`
+	MongoDB  MongoDBConfig  `yaml:"mongodb" json:"mongodb"`
+	LlamaCPP LlamaCPPConfig `yaml:"llamacpp" json:"llamacpp"`
+	OpenAI   OpenAIConfig   `yaml:"openai" json:"openai"`
+	Ollama   OllamaConfig   `yaml:"ollama" json:"ollama"`
+	Chat     ChatConfig     `yaml:"chat" json:"chat"`
+	Logging  LoggingConfig  `yaml:"logging" json:"logging"`
+}
+
+// LoggingConfig настройки логирования
+type LoggingConfig struct {
+	Async          bool   `yaml:"async" json:"async"`                     // Писать логи асинхронно через буфер
+	BufferSize     int    `yaml:"buffer_size" json:"buffer_size"`         // Размер буфера асинхронного логгера
+	OverflowPolicy string `yaml:"overflow_policy" json:"overflow_policy"` // "drop" или "block" при заполненном буфере
+
+	Level  string            `yaml:"level" json:"level"`   // Общий уровень логирования: debug, info, warn, error или quiet
+	Levels map[string]string `yaml:"levels" json:"levels"` // Уровни отдельных компонентов, например {"gateway": "debug"}
+
+	File        string `yaml:"file" json:"file"`                 // Дополнительный файл логов (пусто - только консоль)
+	FileMaxMB   int    `yaml:"file_max_mb" json:"file_max_mb"`   // Размер файла логов до ротации (0 - без ротации)
+	FileBackups int    `yaml:"file_backups" json:"file_backups"` // Сколько старых файлов логов хранить
 }
 
 // TelegramConfig настройки для Telegram бота
 type TelegramConfig struct {
-	BotToken string
-	Debug    bool
+	BotToken        string `yaml:"bot_token" json:"bot_token"`
+	Debug           bool   `yaml:"debug" json:"debug"`
+	PersonalizeMenu bool   `yaml:"personalize_menu" json:"personalize_menu"` // Упорядочивать меню по частоте использования команд
+	OOCPrefix       string `yaml:"ooc_prefix" json:"ooc_prefix"`             // Префикс внеролевых сообщений (пустая строка - только команда /ooc)
+
+	StopButton                   bool `yaml:"stop_button" json:"stop_button"`                                         // Кнопка «Stop» во время генерации
+	StreamReplies                bool `yaml:"stream_replies" json:"stream_replies"`                                   // Показывать ответ по мере генерации
+	MaxImportBytes               int  `yaml:"max_import_bytes" json:"max_import_bytes"`                               // Максимальный размер импортируемой карточки
+	MaxDocumentBytes             int  `yaml:"max_document_bytes" json:"max_document_bytes"`                           // Максимальный размер прикрепляемого документа
+	PendingCommandTimeoutSeconds int  `yaml:"pending_command_timeout_seconds" json:"pending_command_timeout_seconds"` // Ожидание ввода командой (0 - бесконечно)
 }
 
 // MongoDBConfig настройки для MongoDB
 type MongoDBConfig struct {
-	ConnectionString string
-	DatabaseName     string
+	ConnectionString string `yaml:"connection_string" json:"connection_string"`
+	DatabaseName     string `yaml:"database_name" json:"database_name"`
+	ConflictRetries  int    `yaml:"conflict_retries" json:"conflict_retries"` // Повторы сохранения пользователя при конфликте версий
+	AuditLog         bool   `yaml:"audit_log" json:"audit_log"`               // Записывать изменяющие операции в коллекцию audit_log
 }
 
 // LlamaCPPConfig настройки для Llama.cpp gateway
 type LlamaCPPConfig struct {
-	BaseURL        string
-	TimeoutSeconds int
+	Provider       string `yaml:"provider" json:"provider"` // Бэкенд модели: llamacpp, openai или ollama
+	BaseURL        string `yaml:"base_url" json:"base_url"`
+	TimeoutSeconds int    `yaml:"timeout_seconds" json:"timeout_seconds"`
+	APIMode        string `yaml:"api_mode" json:"api_mode"` // "chat" (/v1/chat/completions) или "completions" (устаревший /v1/completions)
+
+	Model             string   `yaml:"model" json:"model"`                             // Название модели для поля model запросов
+	MergeSameRole     bool     `yaml:"merge_same_role" json:"merge_same_role"`         // Объединять идущие подряд сообщения с одной ролью
+	AdaptiveTimeout   bool     `yaml:"adaptive_timeout" json:"adaptive_timeout"`       // Таймаут по p95 задержек последних ответов
+	MinTimeoutSeconds int      `yaml:"min_timeout_seconds" json:"min_timeout_seconds"` // Нижняя граница адаптивного таймаута
+	Retries           int      `yaml:"retries" json:"retries"`                         // Повторы при сетевых ошибках и ответах 5xx
+	RetryDelayMS      int      `yaml:"retry_delay_ms" json:"retry_delay_ms"`           // Пауза перед первым повтором
+	FallbackURLs      []string `yaml:"fallback_urls" json:"fallback_urls"`             // Резервные серверы: "url" или "режим=url"
+	FieldPreset       string   `yaml:"field_preset" json:"field_preset"`               // Готовое переименование полей: llamacpp, ollama или openai
+	FieldMappingFile  string   `yaml:"field_mapping_file" json:"field_mapping_file"`   // JSON-файл с переименованием полей запроса
+	Warmup            bool     `yaml:"warmup" json:"warmup"`                           // Тестовый запрос при запуске
+}
+
+// OpenAIConfig настройки бэкенда OpenAI API (LLM_PROVIDER=openai)
+type OpenAIConfig struct {
+	APIKey  string `yaml:"api_key" json:"api_key"`
+	Model   string `yaml:"model" json:"model"`
+	BaseURL string `yaml:"base_url" json:"base_url"` // Пусто - https://api.openai.com
+}
+
+// OllamaConfig настройки бэкенда Ollama (LLM_PROVIDER=ollama)
+type OllamaConfig struct {
+	Model   string `yaml:"model" json:"model"`
+	BaseURL string `yaml:"base_url" json:"base_url"` // Пусто - http://localhost:11434
 }
 
 // ChatConfig настройки для логики чата
 type ChatConfig struct {
-	HistoryLimit int
+	HistoryLimit          int     `yaml:"history_limit" json:"history_limit"`
+	LanguageMinConfidence float64 `yaml:"language_min_confidence" json:"language_min_confidence"` // Порог уверенности автоопределения языка
+	PromptPosition        string  `yaml:"prompt_position" json:"prompt_position"`                 // Позиция системного промпта по умолчанию: start, end или both
+	ModelConfigFile       string  `yaml:"model_config_file" json:"model_config_file"`             // JSON-файл с параметрами модели по умолчанию (пусто - встроенные значения)
+
+	HistoryLoadLimit         int `yaml:"history_load_limit" json:"history_load_limit"`                 // Сколько последних сообщений персонажа загружать из БД (0 - всю историю)
+	MaxCharacters            int `yaml:"max_characters" json:"max_characters"`                         // Максимум персонажей у пользователя (0 - без ограничений)
+	MaxConcurrentGenerations int `yaml:"max_concurrent_generations" json:"max_concurrent_generations"` // Глобальный лимит одновременных генераций (0 - без ограничений)
+	QueueTimeoutSeconds      int `yaml:"queue_timeout_seconds" json:"queue_timeout_seconds"`           // Ожидание свободного слота генерации (0 - сразу сообщать о занятости)
+	EphemeralChatUsers       int `yaml:"ephemeral_chat_users" json:"ephemeral_chat_users"`             // Для скольких пользователей хранить эфемерную историю (0 - без ограничений)
+	EphemeralChatTTLMinutes  int `yaml:"ephemeral_chat_ttl_minutes" json:"ephemeral_chat_ttl_minutes"` // Через сколько минут неактивности забывать эфемерную историю (0 - до перезапуска)
+
+	MinRequestIntervalSeconds int  `yaml:"min_request_interval_seconds" json:"min_request_interval_seconds"` // Минимальный интервал между запросами пользователя (0 - без ограничений)
+	AdminRateLimitExempt      bool `yaml:"admin_rate_limit_exempt" json:"admin_rate_limit_exempt"`           // Не ограничивать частоту запросов администраторов
+	MinReplyLength            int  `yaml:"min_reply_length" json:"min_reply_length"`                         // Более короткие ответы запрашиваются повторно
+	ResponseSaveRetries       int  `yaml:"response_save_retries" json:"response_save_retries"`               // Повторы сохранения истории после ответа модели
+	DocumentContextTokens     int  `yaml:"document_context_tokens" json:"document_context_tokens"`           // Бюджет токенов прикрепленного документа
+	MaxContextTokens          int  `yaml:"max_context_tokens" json:"max_context_tokens"`                     // Размер контекста модели (0 - не ограничивать)
+	CarryOverTurns            int  `yaml:"carry_over_turns" json:"carry_over_turns"`                         // Сообщения, переносимые новому персонажу при переключении
+
+	DigestTime           string `yaml:"digest_time" json:"digest_time"`                         // Время ежедневной сводки "ЧЧ:ММ" (пусто - отключена)
+	DigestSendIntervalMS int    `yaml:"digest_send_interval_ms" json:"digest_send_interval_ms"` // Пауза между отправками сводок
+
+	DebugRawResponses   bool `yaml:"debug_raw_responses" json:"debug_raw_responses"`       // Сохранять необработанный ответ модели
+	AutoNameCharacters  bool `yaml:"auto_name_characters" json:"auto_name_characters"`     // Имена "Character N" и суффиксы для повторяющихся имен
+	LastRequestAllUsers bool `yaml:"last_request_all_users" json:"last_request_all_users"` // /lastrequest для всех пользователей
+
+	AdminUserIDs     []int64                  `yaml:"admin_user_ids" json:"admin_user_ids"`         // Администраторы бота
+	ModelAllowlist   map[string][]string      `yaml:"model_allowlist" json:"model_allowlist"`       // Уровни пользователей, которым доступна модель
+	ModelLimits      domain.ModelConfigLimits `yaml:"model_limits" json:"model_limits"`             // Верхние границы параметров модели для пользователей
+	AdminModelLimits domain.ModelConfigLimits `yaml:"admin_model_limits" json:"admin_model_limits"` // То же для администраторов (пусто - без ограничений)
+}
+
+// DefaultConfig возвращает конфигурацию со значениями по умолчанию. Обязательные параметры
+// (токен бота, адрес и имя базы MongoDB) в ней не заданы.
+func DefaultConfig() *Config {
+	return &Config{
+		Telegram: TelegramConfig{
+			PersonalizeMenu:              true,
+			OOCPrefix:                    "//",
+			MaxImportBytes:               512 * 1024,
+			MaxDocumentBytes:             512 * 1024,
+			PendingCommandTimeoutSeconds: 300,
+		},
+		MongoDB: MongoDBConfig{
+			ConflictRetries: 3,
+		},
+		LlamaCPP: LlamaCPPConfig{
+			Provider:          "llamacpp",
+			TimeoutSeconds:    60,
+			APIMode:           "chat",
+			MinTimeoutSeconds: 10,
+			Retries:           3,
+			RetryDelayMS:      250,
+		},
+		OpenAI: OpenAIConfig{
+			Model: "gpt-4o-mini",
+		},
+		Chat: ChatConfig{
+			HistoryLimit:            100,
+			LanguageMinConfidence:   0.7,
+			PromptPosition:          "start",
+			MaxCharacters:           20,
+			QueueTimeoutSeconds:     30,
+			EphemeralChatUsers:      1000,
+			EphemeralChatTTLMinutes: 1440,
+			AdminRateLimitExempt:    true,
+			MinReplyLength:          1,
+			ResponseSaveRetries:     2,
+			DocumentContextTokens:   2000,
+			DigestSendIntervalMS:    1000,
+			AutoNameCharacters:      true,
+		},
+		Logging: LoggingConfig{
+			BufferSize:     1024,
+			OverflowPolicy: "drop",
+			Level:          "debug",
+			FileMaxMB:      10,
+			FileBackups:    5,
+		},
+	}
 }
 
 // LoadConfig загружает конфигурацию из переменных окружения.
 func LoadConfig() (*Config, error) {
-	botToken := os.Getenv("TELEGRAM_BOT_TOKEN")
-	if botToken == "" {
-		return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN environment variable not set")
+	cfg := DefaultConfig()
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadConfigFromFile загружает конфигурацию из YAML-файла (.yaml, .yml) или JSON-файла (.json).
+// Поля, отсутствующие в файле, получают значения по умолчанию, а заданные переменные окружения
+// переопределяют значения из файла. Неизвестные поля в файле считаются ошибкой, чтобы опечатка
+// в имени параметра не оставалась незамеченной.
+func LoadConfigFromFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg := DefaultConfig()
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .json", ext)
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyEnv переопределяет параметры заданными переменными окружения. Пустая переменная считается
+// незаданной, кроме OOC_PREFIX, где пустая строка отключает префикс.
+func (c *Config) applyEnv() error {
+	envString("TELEGRAM_BOT_TOKEN", &c.Telegram.BotToken)
+	envString("MONGO_URI", &c.MongoDB.ConnectionString)
+	envString("MONGO_DB_NAME", &c.MongoDB.DatabaseName)
+	envString("LLM_PROVIDER", &c.LlamaCPP.Provider)
+	envString("LLAMA_BASE_URL", &c.LlamaCPP.BaseURL)
+	envString("LLAMA_API_MODE", &c.LlamaCPP.APIMode)
+	envString("LLAMA_MODEL", &c.LlamaCPP.Model)
+	envString("LLAMA_FIELD_PRESET", &c.LlamaCPP.FieldPreset)
+	envString("LLAMA_FIELD_MAPPING_FILE", &c.LlamaCPP.FieldMappingFile)
+	envString("OPENAI_API_KEY", &c.OpenAI.APIKey)
+	envString("OPENAI_MODEL", &c.OpenAI.Model)
+	envString("OPENAI_BASE_URL", &c.OpenAI.BaseURL)
+	envString("OLLAMA_MODEL", &c.Ollama.Model)
+	envString("OLLAMA_BASE_URL", &c.Ollama.BaseURL)
+	envString("PROMPT_POSITION", &c.Chat.PromptPosition)
+	envString("MODEL_CONFIG_FILE", &c.Chat.ModelConfigFile)
+	envString("DIGEST_TIME", &c.Chat.DigestTime)
+	envString("LOG_OVERFLOW_POLICY", &c.Logging.OverflowPolicy)
+	envString("LOG_LEVEL", &c.Logging.Level)
+	envString("LOG_FILE", &c.Logging.File)
+	if value, ok := os.LookupEnv("OOC_PREFIX"); ok {
+		c.Telegram.OOCPrefix = value
+	}
+
+	return errors.Join(
+		envBool("TELEGRAM_DEBUG", &c.Telegram.Debug),
+		envBool("MENU_PERSONALIZATION", &c.Telegram.PersonalizeMenu),
+		envBool("STOP_BUTTON", &c.Telegram.StopButton),
+		envBool("STREAM_REPLIES", &c.Telegram.StreamReplies),
+		envInt("MAX_IMPORT_BYTES", &c.Telegram.MaxImportBytes),
+		envInt("MAX_DOCUMENT_BYTES", &c.Telegram.MaxDocumentBytes),
+		envInt("PENDING_COMMAND_TIMEOUT_SECONDS", &c.Telegram.PendingCommandTimeoutSeconds),
+		envInt("SAVE_CONFLICT_RETRIES", &c.MongoDB.ConflictRetries),
+		envBool("AUDIT_LOG", &c.MongoDB.AuditLog),
+		envInt("LLAMA_TIMEOUT_SECONDS", &c.LlamaCPP.TimeoutSeconds),
+		envBool("LLAMA_MERGE_SAME_ROLE", &c.LlamaCPP.MergeSameRole),
+		envBool("LLAMA_ADAPTIVE_TIMEOUT", &c.LlamaCPP.AdaptiveTimeout),
+		envInt("LLAMA_MIN_TIMEOUT_SECONDS", &c.LlamaCPP.MinTimeoutSeconds),
+		envInt("LLAMA_RETRIES", &c.LlamaCPP.Retries),
+		envInt("LLAMA_RETRY_DELAY_MS", &c.LlamaCPP.RetryDelayMS),
+		envParsed("LLAMA_FALLBACK_URLS", &c.LlamaCPP.FallbackURLs, parseList),
+		envBool("WARMUP", &c.LlamaCPP.Warmup),
+		envInt("CHAT_HISTORY_LIMIT", &c.Chat.HistoryLimit),
+		envInt("HISTORY_LOAD_LIMIT", &c.Chat.HistoryLoadLimit),
+		envInt("MAX_CHARACTERS", &c.Chat.MaxCharacters),
+		envInt("MAX_CONCURRENT_GENERATIONS", &c.Chat.MaxConcurrentGenerations),
+		envInt("GENERATION_QUEUE_TIMEOUT_SECONDS", &c.Chat.QueueTimeoutSeconds),
+		envInt("EPHEMERAL_CHAT_USERS", &c.Chat.EphemeralChatUsers),
+		envInt("EPHEMERAL_CHAT_TTL_MINUTES", &c.Chat.EphemeralChatTTLMinutes),
+		envFloat("LANG_DETECT_MIN_CONFIDENCE", &c.Chat.LanguageMinConfidence),
+		envInt("MIN_REQUEST_INTERVAL_SECONDS", &c.Chat.MinRequestIntervalSeconds),
+		envBool("ADMIN_RATE_LIMIT_EXEMPT", &c.Chat.AdminRateLimitExempt),
+		envInt("MIN_REPLY_LENGTH", &c.Chat.MinReplyLength),
+		envInt("RESPONSE_SAVE_RETRIES", &c.Chat.ResponseSaveRetries),
+		envInt("DOCUMENT_CONTEXT_TOKENS", &c.Chat.DocumentContextTokens),
+		envInt("MAX_CONTEXT_TOKENS", &c.Chat.MaxContextTokens),
+		envInt("CARRY_OVER_TURNS", &c.Chat.CarryOverTurns),
+		envInt("DIGEST_SEND_INTERVAL_MS", &c.Chat.DigestSendIntervalMS),
+		envBool("DEBUG_RAW_RESPONSES", &c.Chat.DebugRawResponses),
+		envBool("AUTO_NAME_CHARACTERS", &c.Chat.AutoNameCharacters),
+		envBool("LAST_REQUEST_ALL_USERS", &c.Chat.LastRequestAllUsers),
+		envParsed("ADMIN_USER_IDS", &c.Chat.AdminUserIDs, ParseUserIDs),
+		envParsed("MODEL_ALLOWLIST", &c.Chat.ModelAllowlist, ParseModelAllowlist),
+		envParsed("MODEL_LIMITS", &c.Chat.ModelLimits, ParseModelLimits),
+		envParsed("ADMIN_MODEL_LIMITS", &c.Chat.AdminModelLimits, ParseModelLimits),
+		envBool("LOG_ASYNC", &c.Logging.Async),
+		envInt("LOG_BUFFER_SIZE", &c.Logging.BufferSize),
+		envParsed("LOG_LEVELS", &c.Logging.Levels, parseLogLevelNames),
+		envInt("LOG_FILE_MAX_MB", &c.Logging.FileMaxMB),
+		envInt("LOG_FILE_BACKUPS", &c.Logging.FileBackups),
+	)
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки сразу. В сообщениях указано
+// имя параметра в файле и соответствующая переменная окружения.
+func (c *Config) Validate() error {
+	var errs []error
+	require := func(value, field, env string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s (%s) is required", field, env))
+		}
+	}
+	oneOf := func(value, field, env string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s (%s) must be one of %s, got %q", field, env, strings.Join(allowed, ", "), value))
+	}
+	atLeast := func(value, min int, field, env string) {
+		if value < min {
+			errs = append(errs, fmt.Errorf("%s (%s) must be at least %d, got %d", field, env, min, value))
+		}
+	}
+
+	require(c.Telegram.BotToken, "telegram.bot_token", "TELEGRAM_BOT_TOKEN")
+	atLeast(c.Telegram.MaxImportBytes, 1, "telegram.max_import_bytes", "MAX_IMPORT_BYTES")
+	atLeast(c.Telegram.MaxDocumentBytes, 1, "telegram.max_document_bytes", "MAX_DOCUMENT_BYTES")
+	atLeast(c.Telegram.PendingCommandTimeoutSeconds, 0, "telegram.pending_command_timeout_seconds", "PENDING_COMMAND_TIMEOUT_SECONDS")
+
+	require(c.MongoDB.ConnectionString, "mongodb.connection_string", "MONGO_URI")
+	require(c.MongoDB.DatabaseName, "mongodb.database_name", "MONGO_DB_NAME")
+	atLeast(c.MongoDB.ConflictRetries, 0, "mongodb.conflict_retries", "SAVE_CONFLICT_RETRIES")
+
+	oneOf(c.LlamaCPP.Provider, "llamacpp.provider", "LLM_PROVIDER", "llamacpp", "openai", "ollama")
+	if c.LlamaCPP.Provider == "llamacpp" {
+		require(c.LlamaCPP.BaseURL, "llamacpp.base_url", "LLAMA_BASE_URL") // Остальным бэкендам адрес не нужен
+	}
+	atLeast(c.LlamaCPP.TimeoutSeconds, 1, "llamacpp.timeout_seconds", "LLAMA_TIMEOUT_SECONDS")
+	oneOf(c.LlamaCPP.APIMode, "llamacpp.api_mode", "LLAMA_API_MODE", "chat", "completions")
+	atLeast(c.LlamaCPP.MinTimeoutSeconds, 1, "llamacpp.min_timeout_seconds", "LLAMA_MIN_TIMEOUT_SECONDS")
+	atLeast(c.LlamaCPP.Retries, 0, "llamacpp.retries", "LLAMA_RETRIES")
+	atLeast(c.LlamaCPP.RetryDelayMS, 0, "llamacpp.retry_delay_ms", "LLAMA_RETRY_DELAY_MS")
+	if c.LlamaCPP.FieldPreset != "" {
+		oneOf(c.LlamaCPP.FieldPreset, "llamacpp.field_preset", "LLAMA_FIELD_PRESET", "llamacpp", "ollama", "openai")
+	}
+	switch c.LlamaCPP.Provider {
+	case "openai":
+		require(c.OpenAI.APIKey, "openai.api_key", "OPENAI_API_KEY")
+		require(c.OpenAI.Model, "openai.model", "OPENAI_MODEL")
+	case "ollama":
+		require(c.Ollama.Model, "ollama.model", "OLLAMA_MODEL")
 	}
 
-	mongoURI := os.Getenv("MONGO_URI")
-	if mongoURI == "" {
-		return nil, fmt.Errorf("MONGO_URI environment variable not set")
+	atLeast(c.Chat.HistoryLimit, 1, "chat.history_limit", "CHAT_HISTORY_LIMIT")
+	if c.Chat.LanguageMinConfidence < 0 || c.Chat.LanguageMinConfidence > 1 {
+		errs = append(errs, fmt.Errorf("chat.language_min_confidence (LANG_DETECT_MIN_CONFIDENCE) must be between 0 and 1, got %g", c.Chat.LanguageMinConfidence))
+	}
+	oneOf(c.Chat.PromptPosition, "chat.prompt_position", "PROMPT_POSITION", "start", "end", "both")
+	atLeast(c.Chat.HistoryLoadLimit, 0, "chat.history_load_limit", "HISTORY_LOAD_LIMIT")
+	atLeast(c.Chat.MaxCharacters, 0, "chat.max_characters", "MAX_CHARACTERS")
+	atLeast(c.Chat.MaxConcurrentGenerations, 0, "chat.max_concurrent_generations", "MAX_CONCURRENT_GENERATIONS")
+	atLeast(c.Chat.QueueTimeoutSeconds, 0, "chat.queue_timeout_seconds", "GENERATION_QUEUE_TIMEOUT_SECONDS")
+	atLeast(c.Chat.EphemeralChatUsers, 0, "chat.ephemeral_chat_users", "EPHEMERAL_CHAT_USERS")
+	atLeast(c.Chat.EphemeralChatTTLMinutes, 0, "chat.ephemeral_chat_ttl_minutes", "EPHEMERAL_CHAT_TTL_MINUTES")
+	atLeast(c.Chat.MinRequestIntervalSeconds, 0, "chat.min_request_interval_seconds", "MIN_REQUEST_INTERVAL_SECONDS")
+	atLeast(c.Chat.MinReplyLength, 0, "chat.min_reply_length", "MIN_REPLY_LENGTH")
+	atLeast(c.Chat.ResponseSaveRetries, 0, "chat.response_save_retries", "RESPONSE_SAVE_RETRIES")
+	atLeast(c.Chat.DocumentContextTokens, 1, "chat.document_context_tokens", "DOCUMENT_CONTEXT_TOKENS")
+	atLeast(c.Chat.MaxContextTokens, 0, "chat.max_context_tokens", "MAX_CONTEXT_TOKENS")
+	atLeast(c.Chat.CarryOverTurns, 0, "chat.carry_over_turns", "CARRY_OVER_TURNS")
+	atLeast(c.Chat.DigestSendIntervalMS, 0, "chat.digest_send_interval_ms", "DIGEST_SEND_INTERVAL_MS")
+	if _, err := c.Chat.DigestTimeOfDay(); err != nil {
+		errs = append(errs, fmt.Errorf("chat.digest_time (DIGEST_TIME): %w", err))
 	}
-	mongoDBName := os.Getenv("MONGO_DB_NAME")
-	if mongoDBName == "" {
-		return nil, fmt.Errorf("MONGO_DB_NAME environment variable not set")
+	if err := validateModelLimits(c.Chat.ModelLimits); err != nil {
+		errs = append(errs, fmt.Errorf("chat.model_limits (MODEL_LIMITS): %w", err))
+	}
+	if err := validateModelLimits(c.Chat.AdminModelLimits); err != nil {
+		errs = append(errs, fmt.Errorf("chat.admin_model_limits (ADMIN_MODEL_LIMITS): %w", err))
 	}
 
-	llamaBaseURL := os.Getenv("LLAMA_BASE_URL")
-	if llamaBaseURL == "" {
-		return nil, fmt.Errorf("LLAMA_BASE_URL environment variable not set")
+	atLeast(c.Logging.BufferSize, 1, "logging.buffer_size", "LOG_BUFFER_SIZE")
+	oneOf(c.Logging.OverflowPolicy, "logging.overflow_policy", "LOG_OVERFLOW_POLICY", "drop", "block")
+	if _, err := logger.ParseLevel(c.Logging.Level); err != nil {
+		errs = append(errs, fmt.Errorf("logging.level (LOG_LEVEL): %w", err))
+	}
+	if _, err := c.Logging.CategoryLevels(); err != nil {
+		errs = append(errs, fmt.Errorf("logging.levels (LOG_LEVELS): %w", err))
 	}
+	atLeast(c.Logging.FileMaxMB, 0, "logging.file_max_mb", "LOG_FILE_MAX_MB")
+	atLeast(c.Logging.FileBackups, 0, "logging.file_backups", "LOG_FILE_BACKUPS")
 
-	llamaTimeoutStr := os.Getenv("LLAMA_TIMEOUT_SECONDS")
-	llamaTimeout, err := strconv.Atoi(llamaTimeoutStr)
+	return errors.Join(errs...)
+}
+
+// envString записывает в target значение переменной окружения name, если она не пуста.
+func envString(name string, target *string) {
+	if value := os.Getenv(name); value != "" {
+		*target = value
+	}
+}
+
+// envInt записывает в target целое значение переменной окружения name, если она не пуста.
+func envInt(name string, target *int) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.Atoi(value)
 	if err != nil {
-		llamaTimeout = 30 // Дефолтное значение
+		return fmt.Errorf("%s must be an integer, got %q", name, value)
 	}
+	*target = parsed
+	return nil
+}
 
-	chatHistoryLimitStr := os.Getenv("CHAT_HISTORY_LIMIT")
-	chatHistoryLimit, err := strconv.Atoi(chatHistoryLimitStr)
+// envParsed записывает в target значение переменной окружения name, разобранное функцией parse,
+// если переменная не пуста.
+func envParsed[T any](name string, target *T, parse func(string) (T, error)) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := parse(value)
 	if err != nil {
-		chatHistoryLimit = 10 // Дефолтное значение
+		return fmt.Errorf("invalid %s: %w", name, err)
 	}
+	*target = parsed
+	return nil
+}
 
-	debugStr := os.Getenv("TELEGRAM_DEBUG")
-	debug := false
-	if debugStr == "true" {
-		debug = true
+// parseList разбирает список значений через запятую, пропуская пустые элементы.
+func parseList(value string) ([]string, error) {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items, nil
+}
 
-	return &Config{
-		Telegram: TelegramConfig{
-			BotToken: botToken,
-			Debug:    debug,
-		},
-		MongoDB: MongoDBConfig{
-			ConnectionString: mongoURI,
-			DatabaseName:     mongoDBName,
-		},
-		LlamaCPP: LlamaCPPConfig{
-			BaseURL:        llamaBaseURL,
-			TimeoutSeconds: llamaTimeout,
-		},
-		Chat: ChatConfig{
-			HistoryLimit: chatHistoryLimit,
-		},
-	}, nil
+// envFloat записывает в target дробное значение переменной окружения name, если она не пуста.
+func envFloat(name string, target *float64) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+	*target = parsed
+	return nil
+}
+
+// envBool записывает в target логическое значение переменной окружения name, если она не пуста.
+func envBool(name string, target *bool) error {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("%s must be true or false, got %q", name, value)
+	}
+	*target = parsed
+	return nil
 }
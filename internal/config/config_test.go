@@ -0,0 +1,606 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// configEnv переменные окружения, которые читает applyEnv.
+var configEnv = []string{
+	"TELEGRAM_BOT_TOKEN", "TELEGRAM_DEBUG", "MENU_PERSONALIZATION", "OOC_PREFIX",
+	"MONGO_URI", "MONGO_DB_NAME", "SAVE_CONFLICT_RETRIES",
+	"LLM_PROVIDER", "LLAMA_BASE_URL", "LLAMA_TIMEOUT_SECONDS", "LLAMA_API_MODE",
+	"CHAT_HISTORY_LIMIT", "LANG_DETECT_MIN_CONFIDENCE", "PROMPT_POSITION", "MODEL_CONFIG_FILE",
+	"HISTORY_LOAD_LIMIT", "MAX_CHARACTERS", "MAX_CONCURRENT_GENERATIONS", "GENERATION_QUEUE_TIMEOUT_SECONDS",
+	"EPHEMERAL_CHAT_USERS", "EPHEMERAL_CHAT_TTL_MINUTES",
+	"LOG_ASYNC", "LOG_BUFFER_SIZE", "LOG_OVERFLOW_POLICY",
+	"STOP_BUTTON", "STREAM_REPLIES", "MAX_IMPORT_BYTES", "MAX_DOCUMENT_BYTES", "PENDING_COMMAND_TIMEOUT_SECONDS", "AUDIT_LOG",
+	"LLAMA_MODEL", "LLAMA_MERGE_SAME_ROLE", "LLAMA_ADAPTIVE_TIMEOUT", "LLAMA_MIN_TIMEOUT_SECONDS", "LLAMA_RETRIES",
+	"LLAMA_RETRY_DELAY_MS", "LLAMA_FALLBACK_URLS", "LLAMA_FIELD_PRESET", "LLAMA_FIELD_MAPPING_FILE", "WARMUP",
+	"OPENAI_API_KEY", "OPENAI_MODEL", "OPENAI_BASE_URL", "OLLAMA_MODEL", "OLLAMA_BASE_URL",
+	"MIN_REQUEST_INTERVAL_SECONDS", "ADMIN_RATE_LIMIT_EXEMPT", "MIN_REPLY_LENGTH", "RESPONSE_SAVE_RETRIES",
+	"DOCUMENT_CONTEXT_TOKENS", "MAX_CONTEXT_TOKENS", "CARRY_OVER_TURNS", "DIGEST_TIME", "DIGEST_SEND_INTERVAL_MS",
+	"DEBUG_RAW_RESPONSES", "AUTO_NAME_CHARACTERS", "LAST_REQUEST_ALL_USERS",
+	"ADMIN_USER_IDS", "MODEL_ALLOWLIST", "MODEL_LIMITS", "ADMIN_MODEL_LIMITS",
+	"LOG_LEVEL", "LOG_LEVELS", "LOG_FILE", "LOG_FILE_MAX_MB", "LOG_FILE_BACKUPS",
+}
+
+// clearConfigEnv удаляет переменные окружения конфигурации на время теста.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range configEnv {
+		t.Setenv(name, "") // Восстанавливает прежнее значение после теста
+		os.Unsetenv(name)
+	}
+}
+
+// setEnv задает переменные окружения на время теста.
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+}
+
+func TestLoadConfigFullEnvironment(t *testing.T) {
+	clearConfigEnv(t)
+	setEnv(t, map[string]string{
+		"TELEGRAM_BOT_TOKEN":               "123:abc",
+		"TELEGRAM_DEBUG":                   "true",
+		"MENU_PERSONALIZATION":             "false",
+		"OOC_PREFIX":                       "",
+		"MONGO_URI":                        "mongodb://db:27017",
+		"MONGO_DB_NAME":                    "bot",
+		"SAVE_CONFLICT_RETRIES":            "5",
+		"LLM_PROVIDER":                     "openai",
+		"LLAMA_BASE_URL":                   "http://llm:8080",
+		"LLAMA_TIMEOUT_SECONDS":            "90",
+		"LLAMA_API_MODE":                   "completions",
+		"CHAT_HISTORY_LIMIT":               "50",
+		"LANG_DETECT_MIN_CONFIDENCE":       "0.5",
+		"PROMPT_POSITION":                  "both",
+		"MODEL_CONFIG_FILE":                "model.json",
+		"HISTORY_LOAD_LIMIT":               "60",
+		"MAX_CHARACTERS":                   "7",
+		"MAX_CONCURRENT_GENERATIONS":       "2",
+		"GENERATION_QUEUE_TIMEOUT_SECONDS": "10",
+		"EPHEMERAL_CHAT_USERS":             "20",
+		"EPHEMERAL_CHAT_TTL_MINUTES":       "90",
+		"LOG_ASYNC":                        "true",
+		"LOG_BUFFER_SIZE":                  "256",
+		"LOG_OVERFLOW_POLICY":              "block",
+		"STOP_BUTTON":                      "true",
+		"STREAM_REPLIES":                   "true",
+		"MAX_IMPORT_BYTES":                 "1024",
+		"MAX_DOCUMENT_BYTES":               "2048",
+		"PENDING_COMMAND_TIMEOUT_SECONDS":  "0",
+		"AUDIT_LOG":                        "true",
+		"LLAMA_MODEL":                      "mistral",
+		"LLAMA_MERGE_SAME_ROLE":            "true",
+		"LLAMA_ADAPTIVE_TIMEOUT":           "true",
+		"LLAMA_MIN_TIMEOUT_SECONDS":        "5",
+		"LLAMA_RETRIES":                    "0",
+		"LLAMA_RETRY_DELAY_MS":             "100",
+		"LLAMA_FALLBACK_URLS":              "http://b:8081, completions=http://c:8082",
+		"LLAMA_FIELD_PRESET":               "ollama",
+		"LLAMA_FIELD_MAPPING_FILE":         "mapping.json",
+		"WARMUP":                           "true",
+		"OPENAI_API_KEY":                   "sk-test",
+		"OPENAI_MODEL":                     "gpt-4o",
+		"OPENAI_BASE_URL":                  "http://proxy",
+		"OLLAMA_MODEL":                     "llama3.1",
+		"OLLAMA_BASE_URL":                  "http://ollama:11434",
+		"MIN_REQUEST_INTERVAL_SECONDS":     "3",
+		"ADMIN_RATE_LIMIT_EXEMPT":          "false",
+		"MIN_REPLY_LENGTH":                 "4",
+		"RESPONSE_SAVE_RETRIES":            "0",
+		"DOCUMENT_CONTEXT_TOKENS":          "500",
+		"MAX_CONTEXT_TOKENS":               "4096",
+		"CARRY_OVER_TURNS":                 "6",
+		"DIGEST_TIME":                      "21:30",
+		"DIGEST_SEND_INTERVAL_MS":          "50",
+		"DEBUG_RAW_RESPONSES":              "true",
+		"AUTO_NAME_CHARACTERS":             "false",
+		"LAST_REQUEST_ALL_USERS":           "true",
+		"ADMIN_USER_IDS":                   "1, 2",
+		"MODEL_ALLOWLIST":                  "big=premium;small=",
+		"MODEL_LIMITS":                     "max_tokens=1000,temp=1.2",
+		"ADMIN_MODEL_LIMITS":               "max_tokens=4000",
+		"LOG_LEVEL":                        "info",
+		"LOG_LEVELS":                       "gateway=debug, persistence=warn",
+		"LOG_FILE":                         "bot.log",
+		"LOG_FILE_MAX_MB":                  "0",
+		"LOG_FILE_BACKUPS":                 "2",
+	})
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	want := &Config{
+		Telegram: TelegramConfig{
+			BotToken:                     "123:abc",
+			Debug:                        true,
+			PersonalizeMenu:              false,
+			OOCPrefix:                    "",
+			StopButton:                   true,
+			StreamReplies:                true,
+			MaxImportBytes:               1024,
+			MaxDocumentBytes:             2048,
+			PendingCommandTimeoutSeconds: 0,
+		},
+		MongoDB: MongoDBConfig{ConnectionString: "mongodb://db:27017", DatabaseName: "bot", ConflictRetries: 5, AuditLog: true},
+		LlamaCPP: LlamaCPPConfig{
+			Provider:          "openai",
+			BaseURL:           "http://llm:8080",
+			TimeoutSeconds:    90,
+			APIMode:           "completions",
+			Model:             "mistral",
+			MergeSameRole:     true,
+			AdaptiveTimeout:   true,
+			MinTimeoutSeconds: 5,
+			Retries:           0,
+			RetryDelayMS:      100,
+			FallbackURLs:      []string{"http://b:8081", "completions=http://c:8082"},
+			FieldPreset:       "ollama",
+			FieldMappingFile:  "mapping.json",
+			Warmup:            true,
+		},
+		OpenAI: OpenAIConfig{APIKey: "sk-test", Model: "gpt-4o", BaseURL: "http://proxy"},
+		Ollama: OllamaConfig{Model: "llama3.1", BaseURL: "http://ollama:11434"},
+		Chat: ChatConfig{
+			HistoryLimit:              50,
+			LanguageMinConfidence:     0.5,
+			PromptPosition:            "both",
+			ModelConfigFile:           "model.json",
+			HistoryLoadLimit:          60,
+			MaxCharacters:             7,
+			MaxConcurrentGenerations:  2,
+			QueueTimeoutSeconds:       10,
+			EphemeralChatUsers:        20,
+			EphemeralChatTTLMinutes:   90,
+			MinRequestIntervalSeconds: 3,
+			AdminRateLimitExempt:      false,
+			MinReplyLength:            4,
+			ResponseSaveRetries:       0,
+			DocumentContextTokens:     500,
+			MaxContextTokens:          4096,
+			CarryOverTurns:            6,
+			DigestTime:                "21:30",
+			DigestSendIntervalMS:      50,
+			DebugRawResponses:         true,
+			AutoNameCharacters:        false,
+			LastRequestAllUsers:       true,
+			AdminUserIDs:              []int64{1, 2},
+			ModelAllowlist:            map[string][]string{"big": {"premium"}, "small": {""}},
+			ModelLimits:               domain.ModelConfigLimits{"max_tokens": 1000, "temperature": 1.2},
+			AdminModelLimits:          domain.ModelConfigLimits{"max_tokens": 4000},
+		},
+		Logging: LoggingConfig{
+			Async:          true,
+			BufferSize:     256,
+			OverflowPolicy: "block",
+			Level:          "info",
+			Levels:         map[string]string{"gateway": "debug", "persistence": "warn"},
+			File:           "bot.log",
+			FileMaxMB:      0,
+			FileBackups:    2,
+		},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() =\n%+v\nwant\n%+v", cfg, want)
+	}
+}
+
+func TestLoadConfigInvalidEnvironment(t *testing.T) {
+	required := map[string]string{
+		"TELEGRAM_BOT_TOKEN": "t",
+		"MONGO_URI":          "mongodb://db",
+		"MONGO_DB_NAME":      "bot",
+		"LLAMA_BASE_URL":     "http://llm",
+	}
+	tests := []struct {
+		env     string
+		value   string
+		wantErr string
+	}{
+		{env: "MIN_REQUEST_INTERVAL_SECONDS", value: "soon", wantErr: "MIN_REQUEST_INTERVAL_SECONDS must be an integer"},
+		{env: "MIN_REQUEST_INTERVAL_SECONDS", value: "-5", wantErr: "chat.min_request_interval_seconds (MIN_REQUEST_INTERVAL_SECONDS) must be at least 0"},
+		{env: "MIN_REPLY_LENGTH", value: "-1", wantErr: "chat.min_reply_length (MIN_REPLY_LENGTH) must be at least 0"},
+		{env: "CARRY_OVER_TURNS", value: "-3", wantErr: "chat.carry_over_turns (CARRY_OVER_TURNS) must be at least 0"},
+		{env: "STREAM_REPLIES", value: "yes please", wantErr: "STREAM_REPLIES must be true or false"},
+		{env: "MAX_IMPORT_BYTES", value: "1MB", wantErr: "MAX_IMPORT_BYTES must be an integer"},
+		{env: "LLAMA_RETRIES", value: "-1", wantErr: "llamacpp.retries (LLAMA_RETRIES)"},
+		{env: "ADMIN_USER_IDS", value: "1,admin", wantErr: "invalid ADMIN_USER_IDS"},
+		{env: "MODEL_ALLOWLIST", value: "=premium", wantErr: "invalid MODEL_ALLOWLIST"},
+		{env: "MODEL_LIMITS", value: "volume=3", wantErr: "invalid MODEL_LIMITS"},
+		{env: "LOG_LEVELS", value: "gateway", wantErr: "invalid LOG_LEVELS"},
+		{env: "LOG_LEVELS", value: "gateway=verbose", wantErr: "logging.levels (LOG_LEVELS)"},
+		{env: "LOG_LEVEL", value: "loud", wantErr: "logging.level (LOG_LEVEL)"},
+		{env: "DIGEST_TIME", value: "9pm", wantErr: "chat.digest_time (DIGEST_TIME)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env+"="+tt.value, func(t *testing.T) {
+			clearConfigEnv(t)
+			setEnv(t, required)
+			t.Setenv(tt.env, tt.value)
+			if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadConfig() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// writeConfigFile создает файл конфигурации name во временном каталоге теста.
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	const yamlConfig = `
+telegram:
+  bot_token: "123:abc"
+  ooc_prefix: "!!"
+mongodb:
+  connection_string: mongodb://db:27017
+  database_name: bot
+llamacpp:
+  base_url: http://llm:8080
+chat:
+  history_limit: 40
+  prompt_position: end
+`
+	const jsonConfig = `{
+  "telegram": {"bot_token": "123:abc", "ooc_prefix": "!!"},
+  "mongodb": {"connection_string": "mongodb://db:27017", "database_name": "bot"},
+  "llamacpp": {"base_url": "http://llm:8080"},
+  "chat": {"history_limit": 40, "prompt_position": "end"}
+}`
+
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		env     map[string]string
+		check   func(t *testing.T, cfg *Config)
+		wantErr string
+	}{
+		{
+			name: "yaml with defaults", file: "config.yaml", content: yamlConfig,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Telegram.BotToken != "123:abc" || cfg.Telegram.OOCPrefix != "!!" || cfg.Chat.HistoryLimit != 40 || cfg.Chat.PromptPosition != "end" {
+					t.Errorf("values from the file were not loaded: %+v", cfg)
+				}
+				if cfg.LlamaCPP.TimeoutSeconds != 60 || cfg.Chat.MaxCharacters != 20 || !cfg.Telegram.PersonalizeMenu {
+					t.Errorf("missing fields did not get defaults: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "json with defaults", file: "config.json", content: jsonConfig,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.MongoDB.DatabaseName != "bot" || cfg.Chat.HistoryLimit != 40 || cfg.MongoDB.ConflictRetries != 3 {
+					t.Errorf("unexpected config: %+v", cfg)
+				}
+			},
+		},
+		{
+			name: "yml extension", file: "config.yml", content: yamlConfig,
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.LlamaCPP.BaseURL != "http://llm:8080" {
+					t.Errorf("base URL = %q", cfg.LlamaCPP.BaseURL)
+				}
+			},
+		},
+		{
+			name: "environment overrides the file", file: "config.yaml", content: yamlConfig,
+			env: map[string]string{"CHAT_HISTORY_LIMIT": "75", "MONGO_DB_NAME": "other", "OOC_PREFIX": "", "TELEGRAM_DEBUG": "true"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Chat.HistoryLimit != 75 || cfg.MongoDB.DatabaseName != "other" || cfg.Telegram.OOCPrefix != "" || !cfg.Telegram.Debug {
+					t.Errorf("environment did not override the file: %+v", cfg)
+				}
+				if cfg.Chat.PromptPosition != "end" {
+					t.Errorf("unset variable overrode the file: prompt position = %q", cfg.Chat.PromptPosition)
+				}
+			},
+		},
+		{
+			name: "empty file uses environment", file: "config.yaml", content: "",
+			env: map[string]string{"TELEGRAM_BOT_TOKEN": "t", "MONGO_URI": "mongodb://db", "MONGO_DB_NAME": "bot", "LLAMA_BASE_URL": "http://llm"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Telegram.BotToken != "t" || cfg.Chat.HistoryLimit != 100 {
+					t.Errorf("unexpected config: %+v", cfg)
+				}
+			},
+		},
+		{name: "unknown yaml field", file: "config.yaml", content: yamlConfig + "unknown_section: 1\n", wantErr: "unknown_section"},
+		{name: "unknown json field", file: "config.json", content: `{"chat": {"history_limt": 10}}`, wantErr: "history_limt"},
+		{name: "malformed json", file: "config.json", content: `{"chat": `, wantErr: "failed to parse config file"},
+		{name: "unsupported extension", file: "config.toml", content: "", wantErr: "unsupported config file extension"},
+		{name: "invalid value in file", file: "config.yaml", content: yamlConfig + "logging:\n  overflow_policy: wait\n", wantErr: "logging.overflow_policy (LOG_OVERFLOW_POLICY)"},
+		{name: "invalid environment value", file: "config.yaml", content: yamlConfig, env: map[string]string{"LLAMA_TIMEOUT_SECONDS": "soon"}, wantErr: "LLAMA_TIMEOUT_SECONDS must be an integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			setEnv(t, tt.env)
+			cfg, err := LoadConfigFromFile(writeConfigFile(t, tt.file, tt.content))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigFromFile: %v", err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfigFromFileAllGroups(t *testing.T) {
+	const yamlConfig = `
+telegram:
+  bot_token: "123:abc"
+  debug: true
+  personalize_menu: false
+  ooc_prefix: "!!"
+  stop_button: true
+  stream_replies: true
+  max_import_bytes: 1024
+  max_document_bytes: 2048
+  pending_command_timeout_seconds: 60
+mongodb:
+  connection_string: mongodb://db:27017
+  database_name: bot
+  conflict_retries: 5
+  audit_log: true
+llamacpp:
+  provider: ollama
+  base_url: http://llm:8080
+  timeout_seconds: 90
+  api_mode: completions
+  model: mistral
+  merge_same_role: true
+  adaptive_timeout: true
+  min_timeout_seconds: 5
+  retries: 1
+  retry_delay_ms: 100
+  fallback_urls: [http://b:8081, completions=http://c:8082]
+  field_preset: ollama
+  field_mapping_file: mapping.json
+  warmup: true
+openai:
+  api_key: sk-test
+  model: gpt-4o
+  base_url: http://proxy
+ollama:
+  model: llama3.1
+  base_url: http://ollama:11434
+chat:
+  history_limit: 50
+  language_min_confidence: 0.5
+  prompt_position: both
+  model_config_file: model.json
+  history_load_limit: 60
+  max_characters: 7
+  max_concurrent_generations: 2
+  queue_timeout_seconds: 10
+  ephemeral_chat_users: 20
+  ephemeral_chat_ttl_minutes: 90
+  min_request_interval_seconds: 3
+  admin_rate_limit_exempt: false
+  min_reply_length: 4
+  response_save_retries: 1
+  document_context_tokens: 500
+  max_context_tokens: 4096
+  carry_over_turns: 6
+  digest_time: "21:30"
+  digest_send_interval_ms: 50
+  debug_raw_responses: true
+  auto_name_characters: false
+  last_request_all_users: true
+  admin_user_ids: [1, 2]
+  model_allowlist:
+    big: [premium]
+  model_limits:
+    max_tokens: 1000
+  admin_model_limits:
+    temperature: 1.5
+logging:
+  async: true
+  buffer_size: 256
+  overflow_policy: block
+  level: info
+  levels:
+    gateway: debug
+  file: bot.log
+  file_max_mb: 20
+  file_backups: 2
+`
+	clearConfigEnv(t)
+	cfg, err := LoadConfigFromFile(writeConfigFile(t, "config.yaml", yamlConfig))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile: %v", err)
+	}
+
+	// Каждое поле каждой группы должно отличаться от значения по умолчанию, то есть читаться из файла
+	defaults := reflect.ValueOf(DefaultConfig()).Elem()
+	loaded := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < loaded.NumField(); i++ {
+		group := loaded.Type().Field(i)
+		t.Run(group.Name, func(t *testing.T) {
+			for j := 0; j < group.Type.NumField(); j++ {
+				field := group.Type.Field(j)
+				if reflect.DeepEqual(loaded.Field(i).Field(j).Interface(), defaults.Field(i).Field(j).Interface()) {
+					t.Errorf("%s.%s (%s) was not loaded from the file", group.Name, field.Name, field.Tag.Get("yaml"))
+				}
+			}
+		})
+	}
+	if !reflect.DeepEqual(cfg.Chat.ModelAllowlist, map[string][]string{"big": {"premium"}}) ||
+		!reflect.DeepEqual(cfg.Chat.AdminUserIDs, []int64{1, 2}) ||
+		cfg.Chat.ModelLimits["max_tokens"] != 1000 ||
+		cfg.Logging.Levels["gateway"] != "debug" ||
+		len(cfg.LlamaCPP.FallbackURLs) != 2 {
+		t.Errorf("lists and maps were not loaded: %+v %+v", cfg.Chat, cfg.Logging)
+	}
+
+	// Тот же файл в формате JSON дает ту же конфигурацию
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(yamlConfig), &raw); err != nil {
+		t.Fatalf("yaml.Unmarshal: %v", err)
+	}
+	jsonConfig, err := json.Marshal(raw)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	fromJSON, err := LoadConfigFromFile(writeConfigFile(t, "config.json", string(jsonConfig)))
+	if err != nil {
+		t.Fatalf("LoadConfigFromFile(json): %v", err)
+	}
+	if !reflect.DeepEqual(fromJSON, cfg) {
+		t.Errorf("JSON config =\n%+v\nwant\n%+v", fromJSON, cfg)
+	}
+
+	t.Run("invalid value in a new group", func(t *testing.T) {
+		clearConfigEnv(t)
+		invalid := strings.Replace(yamlConfig, "carry_over_turns: 6", "carry_over_turns: -1", 1)
+		if _, err := LoadConfigFromFile(writeConfigFile(t, "config.yaml", invalid)); err == nil || !strings.Contains(err.Error(), "chat.carry_over_turns") {
+			t.Errorf("error = %v, want a carry_over_turns error", err)
+		}
+	})
+}
+
+func TestLoadConfigFromMissingFile(t *testing.T) {
+	if _, err := LoadConfigFromFile(filepath.Join(t.TempDir(), "missing.yaml")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("error = %v, want a not-exist error", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := DefaultConfig()
+		cfg.Telegram.BotToken = "t"
+		cfg.MongoDB.ConnectionString = "mongodb://db"
+		cfg.MongoDB.DatabaseName = "bot"
+		cfg.LlamaCPP.BaseURL = "http://llm"
+		return cfg
+	}
+
+	tests := []struct {
+		name   string
+		modify func(cfg *Config)
+		want   []string // Фрагменты, которые должны быть в ошибке (пусто - конфигурация корректна)
+	}{
+		{name: "valid", modify: func(cfg *Config) {}},
+		{name: "defaults lack required values", modify: func(cfg *Config) { *cfg = *DefaultConfig() }, want: []string{
+			"telegram.bot_token (TELEGRAM_BOT_TOKEN) is required",
+			"mongodb.connection_string (MONGO_URI) is required",
+			"mongodb.database_name (MONGO_DB_NAME) is required",
+			"llamacpp.base_url (LLAMA_BASE_URL) is required",
+		}},
+		{name: "base URL optional for other providers", modify: func(cfg *Config) {
+			cfg.LlamaCPP.Provider = "ollama"
+			cfg.LlamaCPP.BaseURL = ""
+			cfg.Ollama.Model = "llama3.1"
+		}},
+		{name: "provider settings required", modify: func(cfg *Config) { cfg.LlamaCPP.Provider = "openai" }, want: []string{"openai.api_key (OPENAI_API_KEY) is required"}},
+		{name: "ollama model required", modify: func(cfg *Config) { cfg.LlamaCPP.Provider = "ollama" }, want: []string{"ollama.model (OLLAMA_MODEL) is required"}},
+		{name: "unknown provider", modify: func(cfg *Config) { cfg.LlamaCPP.Provider = "gpt" }, want: []string{`llamacpp.provider (LLM_PROVIDER) must be one of llamacpp, openai, ollama, got "gpt"`}},
+		{name: "unknown api mode", modify: func(cfg *Config) { cfg.LlamaCPP.APIMode = "edit" }, want: []string{"llamacpp.api_mode (LLAMA_API_MODE)"}},
+		{name: "zero timeout", modify: func(cfg *Config) { cfg.LlamaCPP.TimeoutSeconds = 0 }, want: []string{"llamacpp.timeout_seconds (LLAMA_TIMEOUT_SECONDS) must be at least 1, got 0"}},
+		{name: "confidence out of range", modify: func(cfg *Config) { cfg.Chat.LanguageMinConfidence = 1.5 }, want: []string{"chat.language_min_confidence (LANG_DETECT_MIN_CONFIDENCE) must be between 0 and 1"}},
+		{name: "negative limits", modify: func(cfg *Config) {
+			cfg.Chat.HistoryLoadLimit = -1
+			cfg.Chat.MaxCharacters = -1
+			cfg.MongoDB.ConflictRetries = -1
+		}, want: []string{"chat.history_load_limit", "chat.max_characters", "mongodb.conflict_retries"}},
+		{name: "negative chat settings", modify: func(cfg *Config) {
+			cfg.Chat.MinRequestIntervalSeconds = -1
+			cfg.Chat.MinReplyLength = -1
+			cfg.Chat.CarryOverTurns = -2
+			cfg.Chat.ResponseSaveRetries = -1
+			cfg.Chat.MaxContextTokens = -1
+			cfg.Chat.DigestSendIntervalMS = -1
+			cfg.Chat.DocumentContextTokens = 0
+		}, want: []string{
+			"chat.min_request_interval_seconds (MIN_REQUEST_INTERVAL_SECONDS) must be at least 0, got -1",
+			"chat.min_reply_length (MIN_REPLY_LENGTH)",
+			"chat.carry_over_turns (CARRY_OVER_TURNS) must be at least 0, got -2",
+			"chat.response_save_retries (RESPONSE_SAVE_RETRIES)",
+			"chat.max_context_tokens (MAX_CONTEXT_TOKENS)",
+			"chat.digest_send_interval_ms (DIGEST_SEND_INTERVAL_MS)",
+			"chat.document_context_tokens (DOCUMENT_CONTEXT_TOKENS)",
+		}},
+		{name: "negative gateway and telegram settings", modify: func(cfg *Config) {
+			cfg.LlamaCPP.Retries = -1
+			cfg.LlamaCPP.RetryDelayMS = -1
+			cfg.LlamaCPP.MinTimeoutSeconds = 0
+			cfg.Telegram.MaxImportBytes = 0
+			cfg.Telegram.MaxDocumentBytes = -5
+			cfg.Telegram.PendingCommandTimeoutSeconds = -1
+		}, want: []string{
+			"llamacpp.retries (LLAMA_RETRIES)",
+			"llamacpp.retry_delay_ms (LLAMA_RETRY_DELAY_MS)",
+			"llamacpp.min_timeout_seconds (LLAMA_MIN_TIMEOUT_SECONDS)",
+			"telegram.max_import_bytes (MAX_IMPORT_BYTES)",
+			"telegram.max_document_bytes (MAX_DOCUMENT_BYTES)",
+			"telegram.pending_command_timeout_seconds (PENDING_COMMAND_TIMEOUT_SECONDS)",
+		}},
+		{name: "unknown field preset", modify: func(cfg *Config) { cfg.LlamaCPP.FieldPreset = "vllm" }, want: []string{"llamacpp.field_preset (LLAMA_FIELD_PRESET)"}},
+		{name: "digest time", modify: func(cfg *Config) { cfg.Chat.DigestTime = "25:00" }, want: []string{"chat.digest_time (DIGEST_TIME)"}},
+		{name: "model limits", modify: func(cfg *Config) {
+			cfg.Chat.ModelLimits = domain.ModelConfigLimits{"temp": 1}
+			cfg.Chat.AdminModelLimits = domain.ModelConfigLimits{"volume": 1}
+		}, want: []string{`chat.model_limits (MODEL_LIMITS): limit "temp" must be named "temperature"`, "chat.admin_model_limits (ADMIN_MODEL_LIMITS)"}},
+		{name: "log levels", modify: func(cfg *Config) {
+			cfg.Logging.Level = "loud"
+			cfg.Logging.Levels = map[string]string{"gateway": "verbose"}
+			cfg.Logging.FileMaxMB = -1
+			cfg.Logging.FileBackups = -1
+		}, want: []string{"logging.level (LOG_LEVEL)", "logging.levels (LOG_LEVELS): component gateway", "logging.file_max_mb (LOG_FILE_MAX_MB)", "logging.file_backups (LOG_FILE_BACKUPS)"}},
+		{name: "prompt position", modify: func(cfg *Config) { cfg.Chat.PromptPosition = "middle" }, want: []string{"chat.prompt_position (PROMPT_POSITION)"}},
+		{name: "logging", modify: func(cfg *Config) {
+			cfg.Logging.BufferSize = 0
+			cfg.Logging.OverflowPolicy = "wait"
+		}, want: []string{"logging.buffer_size (LOG_BUFFER_SIZE)", "logging.overflow_policy (LOG_OVERFLOW_POLICY)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			err := cfg.Validate()
+			if len(tt.want) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() = nil, want errors %q", tt.want)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Validate() = %q, want it to contain %q", err, want)
+				}
+			}
+		})
+	}
+}
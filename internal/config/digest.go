@@ -0,0 +1,23 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ParseTimeOfDay разбирает время суток в формате "ЧЧ:ММ" и возвращает смещение от полуночи.
+func ParseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM: %w", value, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// DigestTimeOfDay возвращает время ежедневной сводки от полуночи или -1, если сводка отключена.
+func (c ChatConfig) DigestTimeOfDay() (time.Duration, error) {
+	if c.DigestTime == "" {
+		return -1, nil
+	}
+	return ParseTimeOfDay(c.DigestTime)
+}
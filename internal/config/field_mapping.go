@@ -0,0 +1,21 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// LoadFieldMappingFile загружает сопоставление имен полей запроса к модели из JSON-файла
+// вида {"max_tokens": "num_predict", "top_k": ""}.
+func LoadFieldMappingFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read field mapping file: %w", err)
+	}
+	var mapping map[string]string
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("failed to parse field mapping file %s: %w", path, err)
+	}
+	return mapping, nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadFieldMappingFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "rename and drop", content: `{"max_tokens": "num_predict", "top_k": ""}`, want: map[string]string{"max_tokens": "num_predict", "top_k": ""}},
+		{name: "empty object", content: `{}`, want: map[string]string{}},
+		{name: "malformed JSON", content: `{"max_tokens": `, wantErr: true},
+		{name: "non-string value", content: `{"max_tokens": 5}`, wantErr: true},
+		{name: "not an object", content: `["max_tokens"]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadFieldMappingFile(writeConfigFile(t, "mapping.json", tt.content))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("LoadFieldMappingFile() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFieldMappingFile: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadFieldMappingFile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadFieldMappingFile(filepath.Join(t.TempDir(), "missing.json"))
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("error = %v, want fs.ErrNotExist", err)
+		}
+	})
+}
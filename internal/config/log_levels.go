@@ -0,0 +1,40 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// parseLogLevelNames разбирает уровни логирования компонентов в формате "gateway=debug,persistence=warn".
+// Имена уровней проверяет LoggingConfig.CategoryLevels.
+func parseLogLevelNames(value string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		category, name, ok := strings.Cut(entry, "=")
+		category = strings.TrimSpace(category)
+		if !ok || category == "" {
+			return nil, fmt.Errorf("invalid log level entry %q, expected component=level", entry)
+		}
+		levels[category] = strings.TrimSpace(name)
+	}
+	return levels, nil
+}
+
+// CategoryLevels возвращает уровни логирования отдельных компонентов.
+func (c LoggingConfig) CategoryLevels() (map[string]logger.LogLevel, error) {
+	levels := make(map[string]logger.LogLevel, len(c.Levels))
+	for category, name := range c.Levels {
+		level, err := logger.ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("component %s: %w", category, err)
+		}
+		levels[category] = level
+	}
+	return levels, nil
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ParseModelAllowlist разбирает список доступа к моделям в формате "модель=уровень1,уровень2;модель2=уровень".
+// Пустой уровень в списке (например, "модель=,premium") разрешает модель пользователям без уровня.
+func ParseModelAllowlist(value string) (map[string][]string, error) {
+	allowlist := map[string][]string{}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		model, tiers, ok := strings.Cut(entry, "=")
+		model = strings.TrimSpace(model)
+		if !ok || model == "" {
+			return nil, fmt.Errorf("invalid model allowlist entry %q, expected model=tier1,tier2", entry)
+		}
+		for _, tier := range strings.Split(tiers, ",") {
+			allowlist[model] = append(allowlist[model], strings.TrimSpace(tier))
+		}
+	}
+	return allowlist, nil
+}
+
+// ParseUserIDs разбирает список идентификаторов пользователей Telegram, разделенных запятыми.
+func ParseUserIDs(value string) ([]int64, error) {
+	var ids []int64
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid user ID %q: %w", field, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ParseModelLimits разбирает ограничения в формате "max_tokens=1000,temperature=1.2".
+// Имена параметров принимаются в тех же вариантах, что и в domain.ModelConfig.ApplyOverride.
+func ParseModelLimits(value string) (domain.ModelConfigLimits, error) {
+	limits := domain.ModelConfigLimits{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		key, raw, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid limit %q, expected name=value", entry)
+		}
+		var probe domain.ModelConfig
+		name, err := probe.ApplyOverride(strings.TrimSpace(key), strings.TrimSpace(raw))
+		if err != nil {
+			return nil, err
+		}
+		ceiling, _ := strconv.ParseFloat(strings.TrimSpace(raw), 64) // Уже проверено ApplyOverride
+		limits[name] = ceiling
+	}
+	return limits, nil
+}
+
+// validateModelLimits проверяет, что ограничения заданы для известных параметров под каноническими
+// именами (как их возвращает ParseModelLimits), иначе ограничение не применялось бы.
+func validateModelLimits(limits domain.ModelConfigLimits) error {
+	for key, ceiling := range limits {
+		var probe domain.ModelConfig
+		name, err := probe.ApplyOverride(key, strconv.FormatFloat(ceiling, 'g', -1, 64))
+		if err != nil {
+			return err
+		}
+		if name != key {
+			return fmt.Errorf("limit %q must be named %q", key, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestParseModelLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    domain.ModelConfigLimits
+		wantErr bool
+	}{
+		{name: "empty", value: "", want: domain.ModelConfigLimits{}},
+		{name: "canonical names", value: "max_tokens=1000,temperature=1.2", want: domain.ModelConfigLimits{"max_tokens": 1000, "temperature": 1.2}},
+		{name: "aliases and spaces", value: " tokens = 800 , temp=0.9 ,", want: domain.ModelConfigLimits{"max_tokens": 800, "temperature": 0.9}},
+		{name: "missing value", value: "max_tokens", wantErr: true},
+		{name: "unknown parameter", value: "context=10", wantErr: true},
+		{name: "fractional tokens", value: "max_tokens=10.5", wantErr: true},
+		{name: "not a number", value: "temperature=hot", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseModelLimits(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseModelLimits(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseModelLimits(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// LoadModelConfigFile загружает параметры модели по умолчанию из JSON-файла.
+// Поля, отсутствующие в файле, берутся из встроенных значений по умолчанию.
+func LoadModelConfigFile(path string) (domain.ModelConfig, error) {
+	modelConfig := domain.DefaultModelConfig()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return modelConfig, fmt.Errorf("failed to open model config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&modelConfig); err != nil {
+		return domain.DefaultModelConfig(), fmt.Errorf("failed to parse model config file %s: %w", path, err)
+	}
+	if err := modelConfig.Validate(); err != nil {
+		return domain.DefaultModelConfig(), fmt.Errorf("invalid model config file %s: %w", path, err)
+	}
+	return modelConfig, nil
+}
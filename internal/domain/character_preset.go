@@ -1,45 +1,329 @@
 package domain
 
 import (
-	"strings"
+	"fmt"
+	"time"
 )
 
+// PromptPosition определяет, где в контексте модели размещается системный промпт.
+type PromptPosition string
+
+const (
+	PromptAtStart PromptPosition = "start" // Только перед историей чата (поведение по умолчанию)
+	PromptAtEnd   PromptPosition = "end"   // Только после истории чата
+	PromptAtBoth  PromptPosition = "both"  // Перед историей и повторно после нее
+)
+
+// IsValid проверяет, что позиция промпта известна.
+func (p PromptPosition) IsValid() bool {
+	switch p {
+	case PromptAtStart, PromptAtEnd, PromptAtBoth:
+		return true
+	default:
+		return false
+	}
+}
+
+// CharacterMode определяет набор настроек персонажа по умолчанию: помощник или ролевая игра.
+type CharacterMode string
+
+const (
+	ModeAssistant CharacterMode = "assistant" // Фактологичный помощник (поведение по умолчанию)
+	ModeRoleplay  CharacterMode = "roleplay"  // Ролевая игра: метки говорящих, приветствие в истории, более высокая температура
+)
+
+// IsValid проверяет, является ли режим допустимым значением.
+func (m CharacterMode) IsValid() bool {
+	return m == ModeAssistant || m == ModeRoleplay
+}
+
+// Настройки, которые задает режим персонажа.
+const (
+	DefaultAssistantPrompt = "You are a helpful AI assistant."
+	DefaultRoleplayPrompt  = "You are {{char}}. Stay in character and reply only as {{char}}, in a vivid and immersive style."
+
+	assistantTemperature = 0.7
+	roleplayTemperature  = 0.9
+)
+
+// DefaultTrimMarker маркер реплики пользователя, на котором обрезается ответ модели при включенном TrimUserLines.
+const DefaultTrimMarker = "{{user}}:"
+
 // CharacterPreset содержит настройки для конкретного персонажа.
 type CharacterPreset struct {
-	ID       int           `json:"id" bson:"id"`             // ID персонажа, например, для выбора из списка
-	Name     string        `json:"name" bson:"name"`         // Имя персонажа
-	Greeting string        `json:"greeting" bson:"greeting"` // Приветствие персонажа
-	Prompt   string        `json:"prompt" bson:"prompt"`     // Системный промпт для персонажа
-	Chat     []ChatMessage `json:"chat" bson:"chat"`         // История чата с этим персонажем
+	ID       int           `json:"id" bson:"id"`                                 // ID персонажа, например, для выбора из списка
+	Name     string        `json:"name" bson:"name"`                             // Имя персонажа
+	Greeting string        `json:"greeting" bson:"greeting"`                     // Приветствие персонажа
+	Prompt   string        `json:"prompt" bson:"prompt"`                         // Системный промпт для персонажа
+	Chat     []ChatMessage `json:"chat" bson:"chat"`                             // История чата с этим персонажем
+	LastSeq  int64         `json:"last_seq,omitempty" bson:"last_seq,omitempty"` // Последний выданный номер сообщения (ChatMessage.Seq)
+
+	SystemRules string `json:"system_rules" bson:"system_rules"` // Правила поведения модели, отдельные от описания персонажа (Prompt)
+
+	AuthorsNote string `json:"authors_note" bson:"authors_note"` // Заметка автора, направляющая ход беседы
+	NoteDepth   int    `json:"note_depth" bson:"note_depth"`     // За сколько сообщений до конца истории вставлять заметку
+
+	TrimUserLines bool   `json:"trim_user_lines" bson:"trim_user_lines"`             // Обрезать ответ на первой реплике пользователя
+	TrimMarker    string `json:"trim_marker,omitempty" bson:"trim_marker,omitempty"` // Маркер реплики пользователя (пусто - DefaultTrimMarker)
+
+	AutoLanguage     bool   `json:"auto_language" bson:"auto_language"`         // Отвечать на языке сообщения пользователя
+	DetectedLanguage string `json:"detected_language" bson:"detected_language"` // Последний уверенно определенный язык пользователя
+
+	Ephemeral         bool `json:"ephemeral" bson:"ephemeral"`                   // История чата хранится только в памяти и не сохраняется в БД
+	TranslateGreeting bool `json:"translate_greeting" bson:"translate_greeting"` // Переводить приветствие на язык пользователя
+	SeedGreeting      bool `json:"seed_greeting" bson:"seed_greeting"`           // Начинать пустой чат с приветствия от имени персонажа
+	LabelTurns        bool `json:"label_turns" bson:"label_turns"`               // Добавлять имена говорящих ("{{user}}: ...") в реплики для модели
+
+	MinResponseLength int `json:"min_response_length" bson:"min_response_length"` // Минимальная длина ответа в символах (0 - без ограничения)
+	MaxContinuations  int `json:"max_continuations" bson:"max_continuations"`     // Сколько раз продлевать слишком короткий ответ
+
+	// TimeBudget максимальное время генерации ответа в секундах (0 - без ограничения, действует только таймаут шлюза).
+	TimeBudget int `json:"time_budget,omitempty" bson:"time_budget,omitempty"`
+
+	Mode CharacterMode `json:"mode,omitempty" bson:"mode,omitempty"` // Режим персонажа (пусто - ModeAssistant)
+
+	PromptPosition PromptPosition `json:"prompt_position,omitempty" bson:"prompt_position,omitempty"` // Позиция промпта (пусто - по умолчанию)
+	ModelConfig    *ModelConfig   `json:"model_config,omitempty" bson:"model_config,omitempty"`       // Собственные параметры модели (nil - по умолчанию)
+
+	// DisplayName имя, которым подписываются ответы персонажа в Telegram. В промптах и плейсхолдере {{char}}
+	// по-прежнему используется Name. Пустая строка - ответы не подписываются, в списках показывается Name.
+	DisplayName string `json:"display_name,omitempty" bson:"display_name,omitempty"`
+
+	// ContextStart индекс первого сообщения Chat, передаваемого модели. Более ранние сообщения
+	// остаются в истории, но не попадают в контекст (см. /clearcontext).
+	ContextStart int `json:"context_start,omitempty" bson:"context_start,omitempty"`
+
+	// SummarizeHistory заменяет удаление старых сообщений при превышении лимита истории их кратким изложением.
+	// HistorySummary накопленное краткое изложение удаленной части разговора; передается модели системным
+	// сообщением перед историей и обновляется только тогда, когда из истории удаляются новые сообщения.
+	SummarizeHistory bool   `json:"summarize_history,omitempty" bson:"summarize_history,omitempty"`
+	HistorySummary   string `json:"history_summary,omitempty" bson:"history_summary,omitempty"`
+
+	// CarryOver последние реплики разговора с предыдущим персонажем, перенесенные при переключении.
+	// Передаются модели одним системным сообщением перед историей как "предыдущий разговор".
+	CarryOver string `json:"carry_over,omitempty" bson:"carry_over,omitempty"`
+
+	// AlternateGreetings альтернативные приветствия, из которых (вместе с Greeting) случайно выбирается
+	// первая реплика нового чата с учетом весов. GreetingWeight - вес основного приветствия (nil - 1).
+	AlternateGreetings []AlternateGreeting `json:"alternate_greetings,omitempty" bson:"alternate_greetings,omitempty"`
+	GreetingWeight     *float64            `json:"greeting_weight,omitempty" bson:"greeting_weight,omitempty"`
+
+	// ExampleDialogue пример диалога (пары реплик пользователя и персонажа), показывающий модели манеру речи
+	// персонажа. Передается модели после системного промпта, отдельно от истории, и не обрезается вместе с ней.
+	ExampleDialogue []ChatMessage `json:"example_dialogue,omitempty" bson:"example_dialogue,omitempty"`
+
+	// EnablePlaceholders включает замену {{user}} и {{char}} в промптах и сообщениях (nil - включена).
+	// Отключается для персонажей, в чьих сообщениях фигурные скобки встречаются сами по себе, например в коде.
+	EnablePlaceholders *bool `json:"enable_placeholders,omitempty" bson:"enable_placeholders,omitempty"`
+
+	Normalization OutputNormalization `json:"normalization" bson:"normalization"` // Правила нормализации ответов модели
+
+	// Document текст прикрепленного пользователем документа или его краткое изложение, если документ
+	// не помещался в контекст. Передается модели системным сообщением после промпта персонажа.
+	Document     string `json:"document,omitempty" bson:"document,omitempty"`
+	DocumentName string `json:"document_name,omitempty" bson:"document_name,omitempty"` // Имя файла документа
+
+	// CreatedAt и UpdatedAt время создания персонажа и последнего изменения его настроек или истории (UTC).
+	// У персонажей, сохраненных до появления этих полей, они нулевые: CreatedAt остается неизвестным,
+	// а UpdatedAt заполняется при первом изменении.
+	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
+// DefaultCharacterName имя, которое получает персонаж, созданный без имени.
+const DefaultCharacterName = "Default"
+
 // NewCharacterPreset создает новый экземпляр CharacterPreset с настройками по умолчанию.
 func NewCharacterPreset() *CharacterPreset {
+	now := time.Now().UTC()
 	return &CharacterPreset{
-		ID:       0, // Будет автоматически назначен при добавлении в список
-		Name:     "Default",
-		Greeting: "Hello! How can I help you today?",
-		Prompt:   "You are a helpful AI assistant.",
-		Chat:     []ChatMessage{},
+		ID:        0, // Будет автоматически назначен при добавлении в список
+		Name:      DefaultCharacterName,
+		Greeting:  "Hello! How can I help you today?",
+		Prompt:    DefaultAssistantPrompt,
+		Chat:      []ChatMessage{},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// Touch отмечает изменение персонажа текущим временем.
+func (cp *CharacterPreset) Touch() {
+	cp.UpdatedAt = time.Now().UTC()
+}
+
+// LastActivity возвращает время последнего изменения персонажа, а если оно неизвестно - время создания.
+func (cp *CharacterPreset) LastActivity() time.Time {
+	if cp.UpdatedAt.IsZero() {
+		return cp.CreatedAt
+	}
+	return cp.UpdatedAt
+}
+
+// VisibleName возвращает имя персонажа для показа в Telegram: DisplayName, а если оно не задано - Name.
+func (cp *CharacterPreset) VisibleName() string {
+	if cp.DisplayName != "" {
+		return cp.DisplayName
+	}
+	return cp.Name
+}
+
+// PlaceholdersEnabled сообщает, заменяются ли плейсхолдеры для персонажа.
+func (cp *CharacterPreset) PlaceholdersEnabled() bool {
+	return cp.EnablePlaceholders == nil || *cp.EnablePlaceholders
+}
+
+// EffectiveMode возвращает режим персонажа с учетом значения по умолчанию.
+func (cp *CharacterPreset) EffectiveMode() CharacterMode {
+	if cp.Mode.IsValid() {
+		return cp.Mode
+	}
+	return ModeAssistant
+}
+
+// ApplyMode переключает режим персонажа и задает связанные с ним настройки: метки говорящих,
+// начало чата с приветствия, температуру и промпт (только если промпт пуст или является промптом
+// по умолчанию другого режима). Все настройки можно затем изменить по отдельности.
+// defaults используются как основа параметров модели, если у персонажа нет собственных.
+func (cp *CharacterPreset) ApplyMode(mode CharacterMode, defaults ModelConfig) {
+	config := defaults
+	if cp.ModelConfig != nil {
+		config = *cp.ModelConfig
+	}
+
+	cp.Mode = mode
+	switch mode {
+	case ModeRoleplay:
+		cp.LabelTurns = true
+		cp.SeedGreeting = true
+		config.Temperature = roleplayTemperature
+		if cp.Prompt == "" || cp.Prompt == DefaultAssistantPrompt {
+			cp.Prompt = DefaultRoleplayPrompt
+		}
+	default:
+		cp.LabelTurns = false
+		cp.SeedGreeting = false
+		config.Temperature = assistantTemperature
+		if cp.Prompt == "" || cp.Prompt == DefaultRoleplayPrompt {
+			cp.Prompt = DefaultAssistantPrompt
+		}
+	}
+	cp.ModelConfig = &config
+}
+
+// Clone создает глубокую копию персонажа, включая историю чата.
+func (cp *CharacterPreset) Clone() *CharacterPreset {
+	clone := *cp
+	clone.Chat = append(make([]ChatMessage, 0, len(cp.Chat)), cp.Chat...)
+	clone.AlternateGreetings = append([]AlternateGreeting(nil), cp.AlternateGreetings...)
+	clone.ExampleDialogue = append([]ChatMessage(nil), cp.ExampleDialogue...)
+	if cp.EnablePlaceholders != nil {
+		enabled := *cp.EnablePlaceholders
+		clone.EnablePlaceholders = &enabled
+	}
+	return &clone
+}
+
+// AppendMessages добавляет сообщения в историю чата, присваивая им следующие порядковые номера.
+// Номера не переиспользуются даже после очистки истории.
+func (cp *CharacterPreset) AppendMessages(messages ...ChatMessage) {
+	if last := len(cp.Chat) - 1; last >= 0 && cp.Chat[last].Seq > cp.LastSeq {
+		cp.LastSeq = cp.Chat[last].Seq // Номер мог быть выдан репозиторием (AddChatMessage)
+	}
+	for _, msg := range messages {
+		cp.LastSeq++
+		msg.Seq = cp.LastSeq
+		cp.Chat = append(cp.Chat, msg)
+	}
+	if len(messages) > 0 {
+		cp.Touch()
 	}
 }
 
 // GetChatMessagesForModel возвращает историю чата в формате, подходящем для модели.
-func (cp *CharacterPreset) GetChatMessagesForModel() []ChatMessage {
+// Правила (SystemRules) всегда идут первым системным сообщением, а системный промпт персонажа
+// размещается согласно PromptPosition персонажа, а если она не задана - defaultPosition.
+// Пример диалога (ExampleDialogue) идет после промпта и до истории, между маркерами ExampleDialogueStart
+// и ExampleDialogueEnd.
+func (cp *CharacterPreset) GetChatMessagesForModel(defaultPosition PromptPosition) []ChatMessage {
+	position := cp.PromptPosition
+	if !position.IsValid() {
+		position = defaultPosition
+	}
+
 	var messages []ChatMessage
 
-	// Добавляем системный промпт
-	if cp.Prompt != "" {
+	// Правила поведения идут перед описанием персонажа
+	if cp.SystemRules != "" {
+		messages = append(messages, NewChatMessage(System, cp.SystemRules))
+	}
+
+	// Добавляем системный промпт перед историей
+	if cp.Prompt != "" && position != PromptAtEnd {
 		messages = append(messages, NewChatMessage(System, cp.Prompt))
 	}
 
-	// Добавляем историю чата
-	messages = append(messages, cp.Chat...)
+	// Пример диалога идет сразу после описания персонажа
+	messages = append(messages, cp.exampleDialogueMessages()...)
+
+	// Прикрепленный документ идет после описания персонажа
+	if cp.Document != "" {
+		messages = append(messages, NewChatMessage(System, cp.DocumentMessage()))
+	}
+
+	// Разговор с предыдущим персонажем идет перед собственной историей
+	if cp.CarryOver != "" {
+		messages = append(messages, NewChatMessage(System, cp.CarryOver))
+	}
+
+	// Краткое изложение удаленной части разговора идет непосредственно перед оставшейся историей
+	if cp.HistorySummary != "" {
+		messages = append(messages, NewChatMessage(System, cp.HistorySummaryMessage()))
+	}
+
+	// Добавляем историю чата после границы контекста, вставляя заметку автора на заданной глубине
+	history := cp.ContextChat()
+	messages = append(messages, history...)
+	if cp.AuthorsNote != "" {
+		insertAt := len(messages) - cp.NoteDepth
+		if historyStart := len(messages) - len(history); insertAt < historyStart {
+			insertAt = historyStart // Глубина больше истории - заметка идет в начало истории
+		}
+		messages = append(messages[:insertAt], append([]ChatMessage{NewChatMessage(System, cp.AuthorsNote)}, messages[insertAt:]...)...)
+	}
+
+	// Добавляем (или повторяем) системный промпт после истории
+	if cp.Prompt != "" && (position == PromptAtEnd || position == PromptAtBoth) {
+		messages = append(messages, NewChatMessage(System, cp.Prompt))
+	}
 
 	return messages
 }
 
+// DocumentMessage возвращает текст системного сообщения с прикрепленным документом.
+func (cp *CharacterPreset) DocumentMessage() string {
+	return fmt.Sprintf("Reference document %q provided by the user:\n\n%s", cp.DocumentName, cp.Document)
+}
+
+// HistorySummaryMessage возвращает текст системного сообщения с кратким изложением ранней части разговора.
+func (cp *CharacterPreset) HistorySummaryMessage() string {
+	return "Summary of the earlier part of the conversation between {{user}} and {{char}}:\n\n" + cp.HistorySummary
+}
+
+// ContextChat возвращает часть истории чата, начиная с границы контекста ContextStart.
+func (cp *CharacterPreset) ContextChat() []ChatMessage {
+	start := cp.ContextStart
+	if start < 0 {
+		start = 0
+	}
+	if start > len(cp.Chat) {
+		start = len(cp.Chat)
+	}
+	return cp.Chat[start:]
+}
+
 // ReplacePlaceholders replaces {{char}} placeholder in a string.
+// Экранированный плейсхолдер ("\{{char}}") не заменяется, см. UnescapePlaceholders.
 func (cp *CharacterPreset) ReplacePlaceholders(input string) string {
-	return strings.ReplaceAll(input, "{{char}}", cp.Name)
+	return replacePlaceholder(input, "{{char}}", cp.Name)
 }
@@ -0,0 +1,185 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+// chatSeqs возвращает номера сообщений истории персонажа по порядку.
+func chatSeqs(cp *CharacterPreset) []int64 {
+	seqs := make([]int64, 0, len(cp.Chat))
+	for _, msg := range cp.Chat {
+		seqs = append(seqs, msg.Seq)
+	}
+	return seqs
+}
+
+func TestAppendMessagesSeq(t *testing.T) {
+	message := NewChatMessage(UserRole, "hi")
+	tests := []struct {
+		name        string
+		prepare     func(cp *CharacterPreset)
+		append      int // Сколько сообщений добавить одним вызовом
+		wantSeqs    []int64
+		wantLastSeq int64
+	}{
+		{name: "empty chat", prepare: func(cp *CharacterPreset) {}, append: 1, wantSeqs: []int64{1}, wantLastSeq: 1},
+		{name: "several in one call", prepare: func(cp *CharacterPreset) {}, append: 3, wantSeqs: []int64{1, 2, 3}, wantLastSeq: 3},
+		{
+			name:        "continues after existing messages",
+			prepare:     func(cp *CharacterPreset) { cp.AppendMessages(message, message) },
+			append:      2,
+			wantSeqs:    []int64{1, 2, 3, 4},
+			wantLastSeq: 4,
+		},
+		{
+			name:        "not reused after clearing",
+			prepare:     func(cp *CharacterPreset) { cp.AppendMessages(message, message, message); cp.Chat = nil },
+			append:      1,
+			wantSeqs:    []int64{4},
+			wantLastSeq: 4,
+		},
+		{
+			name:        "not reused after trimming",
+			prepare:     func(cp *CharacterPreset) { cp.AppendMessages(message, message, message); cp.Chat = cp.Chat[:1] },
+			append:      1,
+			wantSeqs:    []int64{1, 4},
+			wantLastSeq: 4,
+		},
+		{
+			name: "continues after a number assigned by the repository",
+			prepare: func(cp *CharacterPreset) {
+				cp.AppendMessages(message)
+				assigned := message
+				assigned.Seq = 7 // AddChatMessage выдает номер в хранилище, не меняя LastSeq загруженного персонажа
+				cp.Chat = append(cp.Chat, assigned)
+			},
+			append:      1,
+			wantSeqs:    []int64{1, 7, 8},
+			wantLastSeq: 8,
+		},
+		{name: "nothing appended", prepare: func(cp *CharacterPreset) { cp.AppendMessages(message) }, append: 0, wantSeqs: []int64{1}, wantLastSeq: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := NewCharacterPreset()
+			tt.prepare(cp)
+			messages := make([]ChatMessage, tt.append)
+			for i := range messages {
+				messages[i] = message
+			}
+			cp.AppendMessages(messages...)
+
+			if got := chatSeqs(cp); !reflect.DeepEqual(got, tt.wantSeqs) {
+				t.Errorf("message numbers = %v, want %v", got, tt.wantSeqs)
+			}
+			if cp.LastSeq != tt.wantLastSeq {
+				t.Errorf("LastSeq = %d, want %d", cp.LastSeq, tt.wantLastSeq)
+			}
+		})
+	}
+}
+
+// contents возвращает тексты сообщений по порядку.
+func contents(messages []ChatMessage) []string {
+	texts := make([]string, 0, len(messages))
+	for _, msg := range messages {
+		texts = append(texts, msg.Content)
+	}
+	return texts
+}
+
+func TestGetChatMessagesForModelPromptPosition(t *testing.T) {
+	tests := []struct {
+		name      string
+		character PromptPosition // Позиция персонажа ("" - не задана)
+		fallback  PromptPosition // Позиция по умолчанию
+		want      []string
+	}{
+		{name: "start", fallback: PromptAtStart, want: []string{"rules", "prompt", "hi", "hello"}},
+		{name: "end", fallback: PromptAtEnd, want: []string{"rules", "hi", "hello", "prompt"}},
+		{name: "both", fallback: PromptAtBoth, want: []string{"rules", "prompt", "hi", "hello", "prompt"}},
+		{name: "character overrides default", character: PromptAtEnd, fallback: PromptAtStart, want: []string{"rules", "hi", "hello", "prompt"}},
+		{name: "unknown character position uses default", character: "middle", fallback: PromptAtBoth, want: []string{"rules", "prompt", "hi", "hello", "prompt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := NewCharacterPreset()
+			cp.SystemRules = "rules"
+			cp.Prompt = "prompt"
+			cp.PromptPosition = tt.character
+			cp.AppendMessages(NewChatMessage(UserRole, "hi"), NewChatMessage(Assistant, "hello"))
+
+			if got := contents(cp.GetChatMessagesForModel(tt.fallback)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetChatMessagesForModelAuthorsNoteDepth(t *testing.T) {
+	tests := []struct {
+		name     string
+		depth    int
+		position PromptPosition
+		want     []string
+	}{
+		{name: "depth 0 after the last message", depth: 0, position: PromptAtStart, want: []string{"prompt", "one", "two", "three", "note"}},
+		{name: "depth 1", depth: 1, position: PromptAtStart, want: []string{"prompt", "one", "two", "note", "three"}},
+		{name: "depth equal to history", depth: 3, position: PromptAtStart, want: []string{"prompt", "note", "one", "two", "three"}},
+		{name: "depth beyond history", depth: 10, position: PromptAtStart, want: []string{"prompt", "note", "one", "two", "three"}},
+		{name: "prompt after history stays last", depth: 0, position: PromptAtEnd, want: []string{"one", "two", "three", "note", "prompt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := NewCharacterPreset()
+			cp.Prompt = "prompt"
+			cp.AuthorsNote = "note"
+			cp.NoteDepth = tt.depth
+			cp.AppendMessages(NewChatMessage(UserRole, "one"), NewChatMessage(Assistant, "two"), NewChatMessage(UserRole, "three"))
+
+			if got := contents(cp.GetChatMessagesForModel(tt.position)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("empty history", func(t *testing.T) {
+		cp := NewCharacterPreset()
+		cp.Prompt = "prompt"
+		cp.AuthorsNote = "note"
+		cp.NoteDepth = 2
+		if got, want := contents(cp.GetChatMessagesForModel(PromptAtStart)), []string{"prompt", "note"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("messages = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestContextChatBoundary(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int
+		want  []string
+	}{
+		{name: "no boundary", start: 0, want: []string{"prompt", "one", "two", "three"}},
+		{name: "inside the history", start: 2, want: []string{"prompt", "three"}},
+		{name: "exactly at the end", start: 3, want: []string{"prompt"}},
+		{name: "one past the end", start: 4, want: []string{"prompt"}},
+		{name: "negative", start: -1, want: []string{"prompt", "one", "two", "three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := NewCharacterPreset()
+			cp.Prompt = "prompt"
+			cp.AppendMessages(NewChatMessage(UserRole, "one"), NewChatMessage(Assistant, "two"), NewChatMessage(UserRole, "three"))
+			cp.ContextStart = tt.start
+
+			if got := contents(cp.GetChatMessagesForModel(PromptAtStart)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+			if len(cp.Chat) != 3 {
+				t.Errorf("history has %d messages, want all 3 kept", len(cp.Chat))
+			}
+		})
+	}
+}
@@ -26,15 +26,54 @@ func (r RoleEnums) String() string {
 	}
 }
 
+// RoleFromString возвращает роль по ее строковому представлению.
+// Неизвестные значения считаются сообщениями пользователя, как и в String.
+func RoleFromString(role string) RoleEnums {
+	switch role {
+	case "system":
+		return System
+	case "assistant":
+		return Assistant
+	default:
+		return UserRole
+	}
+}
+
+// RoleEnum возвращает роль сообщения. ERole не сохраняется в БД, поэтому
+// для загруженных сообщений роль восстанавливается из строкового поля Role.
+func (m ChatMessage) RoleEnum() RoleEnums {
+	if m.Role == "" {
+		return m.ERole
+	}
+	return RoleFromString(m.Role)
+}
+
 // ChatMessage представляет отдельное сообщение в чате.
 type ChatMessage struct {
 	// ERole больше не нужен для сохранения/JSON, так как Role будет строкой.
 	// Оставляем для совместимости NewChatMessage, но он больше не будет сохраняться в БД.
-	ERole   RoleEnums `json:"-" bson:"-"`       // Игнорируем ERole для JSON и BSON
-	Role    string    `json:"role" bson:"role"` // Теперь Role (строка) сохраняется в DB и используется для JSON
-	Content string    `json:"content" bson:"content"`
+	ERole   RoleEnums         `json:"-" bson:"-"`       // Игнорируем ERole для JSON и BSON
+	Role    string            `json:"role" bson:"role"` // Теперь Role (строка) сохраняется в DB и используется для JSON
+	Content string            `json:"content" bson:"content"`
+	Meta    map[string]string `json:"meta,omitempty" bson:"meta,omitempty"` // Произвольные метаданные (модель, время генерации и т.д.)
+
+	// Seq порядковый номер сообщения в чате персонажа, монотонно возрастающий (с возможными пропусками).
+	// Задает порядок сообщений независимо от времени и служит их стабильным идентификатором.
+	// У сообщений, сохраненных до появления номеров, равен 0.
+	Seq int64 `json:"seq,omitempty" bson:"seq,omitempty"`
+
+	// RawContent необработанный ответ модели до постобработки (только в режиме отладки).
+	// Шлюзы передают модели только Role и Content, поэтому это поле в контекст не попадает.
+	RawContent string `json:"raw_content,omitempty" bson:"raw_content,omitempty"`
 }
 
+// Ключи метаданных сообщений.
+const (
+	MetaModel       = "model"        // Модель (бэкенд), сгенерировавшая ответ
+	MetaGeneratedAt = "generated_at" // Время генерации ответа в формате RFC 3339
+	MetaTruncated   = "truncated"    // "true", если генерация ответа прервана и сохранена только его часть
+)
+
 // NewChatMessage создает новое сообщение чата.
 func NewChatMessage(role RoleEnums, content string) ChatMessage {
 	return ChatMessage{
@@ -43,3 +82,14 @@ func NewChatMessage(role RoleEnums, content string) ChatMessage {
 		Content: content,
 	}
 }
+
+// WithMeta возвращает копию сообщения с добавленным значением метаданных.
+func (m ChatMessage) WithMeta(key, value string) ChatMessage {
+	meta := make(map[string]string, len(m.Meta)+1)
+	for k, v := range m.Meta {
+		meta[k] = v
+	}
+	meta[key] = value
+	m.Meta = meta
+	return m
+}
@@ -0,0 +1,45 @@
+package domain
+
+// MaxExamplePairs ограничивает количество пар реплик в примере диалога персонажа.
+const MaxExamplePairs = 10
+
+// Системные сообщения, которыми пример диалога отделяется от настоящего разговора.
+const (
+	ExampleDialogueStart = "[Example dialogue follows]"
+	ExampleDialogueEnd   = "[Example dialogue ends, the real conversation follows]"
+)
+
+// MetaExample ключ метаданных, которым помечаются реплики примера диалога в контексте модели.
+const MetaExample = "example"
+
+// IsExample сообщает, что сообщение является репликой примера диалога, а не частью разговора.
+func (m ChatMessage) IsExample() bool {
+	return m.Meta[MetaExample] == "true"
+}
+
+// AddExample добавляет в пример диалога пару реплик пользователя и персонажа.
+func (cp *CharacterPreset) AddExample(userText, charText string) {
+	cp.ExampleDialogue = append(cp.ExampleDialogue, NewChatMessage(UserRole, userText), NewChatMessage(Assistant, charText))
+	cp.Touch()
+}
+
+// ExamplePairs возвращает количество пар реплик в примере диалога.
+func (cp *CharacterPreset) ExamplePairs() int {
+	return len(cp.ExampleDialogue) / 2
+}
+
+// exampleDialogueMessages возвращает пример диалога для контекста модели, заключенный между маркерами
+// ExampleDialogueStart и ExampleDialogueEnd. Реплики помечаются MetaExample, чтобы их не удаляли
+// вместе со старыми сообщениями разговора при подгонке запроса под размер контекста.
+func (cp *CharacterPreset) exampleDialogueMessages() []ChatMessage {
+	if len(cp.ExampleDialogue) == 0 {
+		return nil
+	}
+	messages := make([]ChatMessage, 0, len(cp.ExampleDialogue)+2)
+	messages = append(messages, NewChatMessage(System, ExampleDialogueStart))
+	for _, msg := range cp.ExampleDialogue {
+		msg.Meta = map[string]string{MetaExample: "true"}
+		messages = append(messages, msg)
+	}
+	return append(messages, NewChatMessage(System, ExampleDialogueEnd))
+}
@@ -0,0 +1,70 @@
+package domain
+
+import "math"
+
+// MaxGreetingWeight ограничивает вес приветствия.
+const MaxGreetingWeight = 1000
+
+// AlternateGreeting альтернативное приветствие персонажа с весом выбора.
+type AlternateGreeting struct {
+	Text   string  `json:"text" bson:"text"`
+	Weight float64 `json:"weight" bson:"weight"` // Относительная частота выбора (0 - не выбирается)
+}
+
+// ValidGreetingWeight сообщает, допустим ли вес приветствия.
+func ValidGreetingWeight(weight float64) bool {
+	return !math.IsNaN(weight) && weight >= 0 && weight <= MaxGreetingWeight
+}
+
+// MainGreetingWeight возвращает вес основного приветствия (по умолчанию 1).
+func (cp *CharacterPreset) MainGreetingWeight() float64 {
+	if cp.GreetingWeight == nil {
+		return 1
+	}
+	return *cp.GreetingWeight
+}
+
+// PickGreeting выбирает приветствие для начала чата: основное или одно из альтернативных
+// с вероятностью, пропорциональной весу. Если все веса нулевые, выбор равновероятный.
+// random должен возвращать число из [0, 1), например rand.Float64.
+func (cp *CharacterPreset) PickGreeting(random func() float64) string {
+	if len(cp.AlternateGreetings) == 0 {
+		return cp.Greeting
+	}
+	texts := make([]string, 0, len(cp.AlternateGreetings)+1)
+	weights := make([]float64, 0, len(cp.AlternateGreetings)+1)
+	if cp.Greeting != "" {
+		texts = append(texts, cp.Greeting)
+		weights = append(weights, cp.MainGreetingWeight())
+	}
+	for _, greeting := range cp.AlternateGreetings {
+		texts = append(texts, greeting.Text)
+		weights = append(weights, greeting.Weight)
+	}
+
+	total := 0.0
+	for i, weight := range weights {
+		if !ValidGreetingWeight(weight) {
+			weights[i] = 0 // Некорректные веса (например, после ручной правки БД) не учитываются
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		return texts[int(random()*float64(len(texts)))%len(texts)]
+	}
+
+	target := random() * total
+	for i, weight := range weights {
+		if target < weight {
+			return texts[i]
+		}
+		target -= weight
+	}
+	// Погрешность округления: возвращаем последнее приветствие с ненулевым весом
+	for i := len(weights) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return texts[i]
+		}
+	}
+	return texts[len(texts)-1]
+}
@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// greetingCharacter возвращает персонажа с основным приветствием "main" веса mainWeight
+// и альтернативными приветствиями "alt1", "alt2"... с весами altWeights.
+func greetingCharacter(mainWeight float64, altWeights ...float64) *CharacterPreset {
+	char := NewCharacterPreset()
+	char.Greeting = "main"
+	char.GreetingWeight = &mainWeight
+	for i, weight := range altWeights {
+		char.AlternateGreetings = append(char.AlternateGreetings, AlternateGreeting{Text: "alt" + string(rune('1'+i)), Weight: weight})
+	}
+	return char
+}
+
+func TestPickGreetingDistribution(t *testing.T) {
+	const draws = 20000
+	tests := []struct {
+		name string
+		char *CharacterPreset
+		want map[string]float64 // Ожидаемая доля каждого приветствия
+	}{
+		{name: "equal weights", char: greetingCharacter(1, 1, 1), want: map[string]float64{"main": 1.0 / 3, "alt1": 1.0 / 3, "alt2": 1.0 / 3}},
+		{name: "weighted", char: greetingCharacter(3, 1), want: map[string]float64{"main": 0.75, "alt1": 0.25}},
+		{name: "zero weight is never picked", char: greetingCharacter(0, 1, 1), want: map[string]float64{"alt1": 0.5, "alt2": 0.5}},
+		{name: "all weights zero fall back to uniform", char: greetingCharacter(0, 0, 0), want: map[string]float64{"main": 1.0 / 3, "alt1": 1.0 / 3, "alt2": 1.0 / 3}},
+		{name: "negative weight is ignored", char: greetingCharacter(-5, 1), want: map[string]float64{"alt1": 1}},
+		{name: "NaN weight is ignored", char: greetingCharacter(math.NaN(), 2, 2), want: map[string]float64{"alt1": 0.5, "alt2": 0.5}},
+		{name: "too large weight is ignored", char: greetingCharacter(MaxGreetingWeight+1, 1), want: map[string]float64{"alt1": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			random := rand.New(rand.NewSource(1)).Float64
+			counts := make(map[string]int)
+			for i := 0; i < draws; i++ {
+				counts[tt.char.PickGreeting(random)]++
+			}
+			for text, count := range counts {
+				if _, ok := tt.want[text]; !ok {
+					t.Errorf("greeting %q picked %d times, want never", text, count)
+				}
+			}
+			for text, share := range tt.want {
+				if got := float64(counts[text]) / draws; math.Abs(got-share) > 0.02 {
+					t.Errorf("greeting %q picked with share %.3f, want %.3f", text, got, share)
+				}
+			}
+		})
+	}
+}
+
+func TestPickGreetingBoundaries(t *testing.T) {
+	tests := []struct {
+		name   string
+		char   *CharacterPreset
+		random float64
+		want   string
+	}{
+		{name: "no alternates", char: &CharacterPreset{Greeting: "only"}, random: 0.99, want: "only"},
+		{name: "lowest value picks the first", char: greetingCharacter(1, 1), random: 0, want: "main"},
+		{name: "highest value picks the last", char: greetingCharacter(1, 1), random: math.Nextafter(1, 0), want: "alt1"},
+		{name: "boundary between weights", char: greetingCharacter(1, 1), random: 0.5, want: "alt1"},
+		{name: "uniform fallback uses the last for the highest value", char: greetingCharacter(0, 0), random: math.Nextafter(1, 0), want: "alt1"},
+		{name: "empty main greeting is skipped", char: func() *CharacterPreset { c := greetingCharacter(1, 1); c.Greeting = ""; return c }(), random: 0, want: "alt1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.char.PickGreeting(func() float64 { return tt.random }); got != tt.want {
+				t.Errorf("PickGreeting() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidGreetingWeight(t *testing.T) {
+	tests := []struct {
+		weight float64
+		want   bool
+	}{
+		{weight: 0, want: true},
+		{weight: 0.5, want: true},
+		{weight: MaxGreetingWeight, want: true},
+		{weight: MaxGreetingWeight + 0.1, want: false},
+		{weight: -0.1, want: false},
+		{weight: math.NaN(), want: false},
+		{weight: math.Inf(1), want: false},
+	}
+	for _, tt := range tests {
+		if got := ValidGreetingWeight(tt.weight); got != tt.want {
+			t.Errorf("ValidGreetingWeight(%v) = %v, want %v", tt.weight, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ModelConfig содержит параметры для запроса к модели.
+type ModelConfig struct {
+	MaxTokens        int     `json:"max_tokens" bson:"max_tokens"`
+	Temperature      float64 `json:"temperature" bson:"temperature"`
+	MinP             float64 `json:"min_p" bson:"min_p"`
+	TopP             float64 `json:"top_p" bson:"top_p"`
+	TopK             float64 `json:"top_k" bson:"top_k"`
+	RepeatPenalty    float64 `json:"repeat_penalty" bson:"repeat_penalty"`
+	PresencePenalty  float64 `json:"presence_penalty" bson:"presence_penalty"`
+	FrequencyPenalty float64 `json:"frequency_penalty" bson:"frequency_penalty"`
+	// StopSequences строки, на которых модель прекращает генерацию (например, "{{user}}:").
+	// Плейсхолдеры заменяются перед отправкой запроса.
+	StopSequences []string `json:"stop,omitempty" bson:"stop,omitempty"`
+
+	// JSONMode требует от модели ответа в виде JSON-объекта (response_format json_object).
+	JSONMode bool `json:"json_mode,omitempty" bson:"json_mode,omitempty"`
+}
+
+// MaxStopSequences ограничивает количество собственных стоп-последовательностей в параметрах модели.
+const MaxStopSequences = 8
+
+// DefaultModelConfig возвращает параметры модели по умолчанию.
+func DefaultModelConfig() ModelConfig {
+	return ModelConfig{
+		MaxTokens:        500,
+		Temperature:      0.7,
+		TopP:             0.9,
+		TopK:             0, // 0 отключает TopK
+		RepeatPenalty:    1.1,
+		PresencePenalty:  0.0,
+		FrequencyPenalty: 0.0,
+	}
+}
+
+// Validate проверяет, что параметры находятся в допустимых диапазонах.
+func (mc ModelConfig) Validate() error {
+	if mc.MaxTokens <= 0 || mc.MaxTokens > 8192 {
+		return fmt.Errorf("max_tokens must be between 1 and 8192, got %d", mc.MaxTokens)
+	}
+	if mc.Temperature < 0 || mc.Temperature > 2 {
+		return fmt.Errorf("temperature must be between 0 and 2, got %g", mc.Temperature)
+	}
+	if mc.MinP < 0 || mc.MinP > 1 {
+		return fmt.Errorf("min_p must be between 0 and 1, got %g", mc.MinP)
+	}
+	if mc.TopP < 0 || mc.TopP > 1 {
+		return fmt.Errorf("top_p must be between 0 and 1, got %g", mc.TopP)
+	}
+	if mc.TopK < 0 {
+		return fmt.Errorf("top_k must not be negative, got %g", mc.TopK)
+	}
+	if mc.RepeatPenalty < 0 || mc.RepeatPenalty > 2 {
+		return fmt.Errorf("repeat_penalty must be between 0 and 2, got %g", mc.RepeatPenalty)
+	}
+	if mc.PresencePenalty < -2 || mc.PresencePenalty > 2 {
+		return fmt.Errorf("presence_penalty must be between -2 and 2, got %g", mc.PresencePenalty)
+	}
+	if mc.FrequencyPenalty < -2 || mc.FrequencyPenalty > 2 {
+		return fmt.Errorf("frequency_penalty must be between -2 and 2, got %g", mc.FrequencyPenalty)
+	}
+	if len(mc.StopSequences) > MaxStopSequences {
+		return fmt.Errorf("at most %d stop sequences are allowed, got %d", MaxStopSequences, len(mc.StopSequences))
+	}
+	for _, stop := range mc.StopSequences {
+		if strings.TrimSpace(stop) == "" {
+			return fmt.Errorf("stop sequences must not be empty")
+		}
+	}
+	return nil
+}
+
+// ApplyOverride изменяет один параметр по имени (поддерживаются короткие псевдонимы, например "temp")
+// и возвращает каноническое имя параметра. Диапазоны значений проверяет Validate.
+func (mc *ModelConfig) ApplyOverride(key, value string) (string, error) {
+	var target *float64
+	var name string
+	switch strings.ToLower(key) {
+	case "max_tokens", "maxtokens", "tokens":
+		tokens, err := strconv.Atoi(value)
+		if err != nil {
+			return "", fmt.Errorf("max_tokens must be an integer, got %q", value)
+		}
+		mc.MaxTokens = tokens
+		return "max_tokens", nil
+	case "temperature", "temp":
+		target, name = &mc.Temperature, "temperature"
+	case "min_p", "minp":
+		target, name = &mc.MinP, "min_p"
+	case "top_p", "topp":
+		target, name = &mc.TopP, "top_p"
+	case "top_k", "topk":
+		target, name = &mc.TopK, "top_k"
+	case "repeat_penalty", "repeatpenalty", "rep":
+		target, name = &mc.RepeatPenalty, "repeat_penalty"
+	case "presence_penalty", "presencepenalty":
+		target, name = &mc.PresencePenalty, "presence_penalty"
+	case "frequency_penalty", "frequencypenalty":
+		target, name = &mc.FrequencyPenalty, "frequency_penalty"
+	default:
+		return "", fmt.Errorf("unknown model parameter %q", key)
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return "", fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+	*target = number
+	return name, nil
+}
+
+// ModelConfigLimits задает верхние границы параметров модели, устанавливаемые оператором.
+// Ключ - каноническое имя параметра (как в JSON ModelConfig), значение - максимально допустимое значение.
+// Параметры без записи не ограничиваются.
+type ModelConfigLimits map[string]float64
+
+// ClampTo уменьшает параметры, превышающие ограничения, и возвращает описания изменений
+// (например, "max_tokens capped at 1000").
+func (mc *ModelConfig) ClampTo(limits ModelConfigLimits) []string {
+	if len(limits) == 0 {
+		return nil
+	}
+	var notices []string
+	if ceiling, ok := limits["max_tokens"]; ok && float64(mc.MaxTokens) > ceiling {
+		mc.MaxTokens = int(ceiling)
+		notices = append(notices, fmt.Sprintf("max_tokens capped at %d", mc.MaxTokens))
+	}
+	for _, field := range []struct {
+		name  string
+		value *float64
+	}{
+		{"temperature", &mc.Temperature},
+		{"min_p", &mc.MinP},
+		{"top_p", &mc.TopP},
+		{"top_k", &mc.TopK},
+		{"repeat_penalty", &mc.RepeatPenalty},
+		{"presence_penalty", &mc.PresencePenalty},
+		{"frequency_penalty", &mc.FrequencyPenalty},
+	} {
+		if ceiling, ok := limits[field.name]; ok && *field.value > ceiling {
+			*field.value = ceiling
+			notices = append(notices, fmt.Sprintf("%s capped at %g", field.name, ceiling))
+		}
+	}
+	return notices
+}
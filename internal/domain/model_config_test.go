@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestModelConfigClampTo(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      ModelConfig
+		limits      ModelConfigLimits
+		want        ModelConfig
+		wantNotices []string
+	}{
+		{
+			name:   "no limits",
+			config: ModelConfig{MaxTokens: 4000, Temperature: 1.8},
+			limits: nil,
+			want:   ModelConfig{MaxTokens: 4000, Temperature: 1.8},
+		},
+		{
+			name:        "values above the ceiling are lowered",
+			config:      ModelConfig{MaxTokens: 4000, Temperature: 1.8, TopK: 100},
+			limits:      ModelConfigLimits{"max_tokens": 1000, "temperature": 1.2, "top_k": 50},
+			want:        ModelConfig{MaxTokens: 1000, Temperature: 1.2, TopK: 50},
+			wantNotices: []string{"max_tokens capped at 1000", "temperature capped at 1.2", "top_k capped at 50"},
+		},
+		{
+			name:   "values at or below the ceiling are kept",
+			config: ModelConfig{MaxTokens: 1000, Temperature: 0.7},
+			limits: ModelConfigLimits{"max_tokens": 1000, "temperature": 1.2},
+			want:   ModelConfig{MaxTokens: 1000, Temperature: 0.7},
+		},
+		{
+			name:        "fractional token ceiling is rounded down",
+			config:      ModelConfig{MaxTokens: 600},
+			limits:      ModelConfigLimits{"max_tokens": 512.9},
+			want:        ModelConfig{MaxTokens: 512},
+			wantNotices: []string{"max_tokens capped at 512"},
+		},
+		{
+			name:        "penalties",
+			config:      ModelConfig{RepeatPenalty: 1.5, PresencePenalty: 1, FrequencyPenalty: -1},
+			limits:      ModelConfigLimits{"repeat_penalty": 1.2, "presence_penalty": 0.5, "frequency_penalty": 0},
+			want:        ModelConfig{RepeatPenalty: 1.2, PresencePenalty: 0.5, FrequencyPenalty: -1},
+			wantNotices: []string{"repeat_penalty capped at 1.2", "presence_penalty capped at 0.5"},
+		},
+		{
+			name:   "unknown limit is ignored",
+			config: ModelConfig{MaxTokens: 500},
+			limits: ModelConfigLimits{"context": 10},
+			want:   ModelConfig{MaxTokens: 500},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			notices := config.ClampTo(tt.limits)
+			if !reflect.DeepEqual(config, tt.want) {
+				t.Errorf("config = %+v, want %+v", config, tt.want)
+			}
+			if !reflect.DeepEqual(notices, tt.wantNotices) {
+				t.Errorf("notices = %q, want %q", notices, tt.wantNotices)
+			}
+		})
+	}
+}
+
+func TestModelConfigApplyOverride(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantName   string
+		wantErr    bool
+		check      func(ModelConfig) bool
+	}{
+		{key: "tokens", value: "300", wantName: "max_tokens", check: func(c ModelConfig) bool { return c.MaxTokens == 300 }},
+		{key: "TEMP", value: "1.5", wantName: "temperature", check: func(c ModelConfig) bool { return c.Temperature == 1.5 }},
+		{key: "rep", value: "1.3", wantName: "repeat_penalty", check: func(c ModelConfig) bool { return c.RepeatPenalty == 1.3 }},
+		{key: "max_tokens", value: "1.5", wantErr: true},
+		{key: "top_p", value: "high", wantErr: true},
+		{key: "context", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key+"="+tt.value, func(t *testing.T) {
+			config := DefaultModelConfig()
+			name, err := config.ApplyOverride(tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ApplyOverride() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if name != tt.wantName || !tt.check(config) {
+				t.Errorf("ApplyOverride() = %q with config %+v, want %q", name, config, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package domain
+
+import "fmt"
+
+// OutputNormalization набор правил приведения ответов модели к единому виду.
+// Каждое правило включается отдельно; по умолчанию все выключены. Блоки кода (```) правила не изменяют.
+type OutputNormalization struct {
+	CollapseBlankLines bool `json:"collapse_blank_lines,omitempty" bson:"collapse_blank_lines,omitempty"` // Сжимать несколько пустых строк подряд в одну
+	TrimTrailingSpaces bool `json:"trim_trailing_spaces,omitempty" bson:"trim_trailing_spaces,omitempty"` // Убирать пробелы в конце строк
+	ConvertMarkdown    bool `json:"convert_markdown,omitempty" bson:"convert_markdown,omitempty"`         // Заменять выделение Markdown тегами HTML Telegram
+}
+
+// Названия правил нормализации для команд и сообщений.
+const (
+	NormalizeBlankLines = "blanklines"
+	NormalizeTrailing   = "trailing"
+	NormalizeMarkdown   = "markdown"
+)
+
+// NormalizationRules возвращает названия правил нормализации в порядке показа.
+func NormalizationRules() []string {
+	return []string{NormalizeBlankLines, NormalizeTrailing, NormalizeMarkdown}
+}
+
+// Any сообщает, включено ли хотя бы одно правило.
+func (n OutputNormalization) Any() bool {
+	return n.CollapseBlankLines || n.TrimTrailingSpaces || n.ConvertMarkdown
+}
+
+// Enabled сообщает, включено ли правило с названием rule.
+func (n OutputNormalization) Enabled(rule string) bool {
+	switch rule {
+	case NormalizeBlankLines:
+		return n.CollapseBlankLines
+	case NormalizeTrailing:
+		return n.TrimTrailingSpaces
+	case NormalizeMarkdown:
+		return n.ConvertMarkdown
+	default:
+		return false
+	}
+}
+
+// Set включает или выключает правило с названием rule.
+func (n *OutputNormalization) Set(rule string, enabled bool) error {
+	switch rule {
+	case NormalizeBlankLines:
+		n.CollapseBlankLines = enabled
+	case NormalizeTrailing:
+		n.TrimTrailingSpaces = enabled
+	case NormalizeMarkdown:
+		n.ConvertMarkdown = enabled
+	default:
+		return fmt.Errorf("unknown normalization rule %q", rule)
+	}
+	return nil
+}
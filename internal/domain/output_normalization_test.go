@@ -0,0 +1,39 @@
+package domain
+
+import "testing"
+
+func TestOutputNormalizationSet(t *testing.T) {
+	tests := []struct {
+		rule    string
+		wantErr bool
+	}{
+		{rule: NormalizeBlankLines},
+		{rule: NormalizeTrailing},
+		{rule: NormalizeMarkdown},
+		{rule: "emoji", wantErr: true},
+		{rule: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			var rules OutputNormalization
+			err := rules.Set(tt.rule, true)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Set(%q) error = %v, want error %v", tt.rule, err, tt.wantErr)
+			}
+			if rules.Enabled(tt.rule) == tt.wantErr || rules.Any() == tt.wantErr {
+				t.Errorf("after Set(%q, true): Enabled = %v, Any = %v", tt.rule, rules.Enabled(tt.rule), rules.Any())
+			}
+			for _, other := range NormalizationRules() {
+				if other != tt.rule && rules.Enabled(other) {
+					t.Errorf("Set(%q) also enabled %q", tt.rule, other)
+				}
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := rules.Set(tt.rule, false); err != nil || rules.Any() {
+				t.Errorf("Set(%q, false) = %v, Any = %v; want all rules off", tt.rule, err, rules.Any())
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// placeholderEscape экранирует плейсхолдер: "\{{user}}" не заменяется и выводится как "{{user}}".
+// Экранирование снимается только UnescapePlaceholders, поэтому повторная обработка
+// ReplacePlaceholders (при сохранении и перед отправкой модели) не заменяет экранированные плейсхолдеры.
+const placeholderEscape = `\`
+
+// replacePlaceholder заменяет все неэкранированные вхождения placeholder на value.
+func replacePlaceholder(input, placeholder, value string) string {
+	if !strings.Contains(input, placeholder) {
+		return input
+	}
+	var sb strings.Builder
+	for {
+		i := strings.Index(input, placeholder)
+		if i < 0 {
+			sb.WriteString(input)
+			return sb.String()
+		}
+		sb.WriteString(input[:i])
+		if strings.HasSuffix(input[:i], placeholderEscape) {
+			sb.WriteString(placeholder)
+		} else {
+			sb.WriteString(value)
+		}
+		input = input[i+len(placeholder):]
+	}
+}
+
+// UnescapePlaceholders превращает экранированные плейсхолдеры ("\{{user}}") в буквальный текст ("{{user}}").
+// Вызывается один раз, когда текст покидает бота (передается модели или показывается пользователю).
+func UnescapePlaceholders(input string) string {
+	return strings.ReplaceAll(input, placeholderEscape+"{{", "{{")
+}
+
+// Форматы плейсхолдеров {{date}} и {{time}}.
+const (
+	placeholderDateLayout = "Monday, 2 January 2006"
+	placeholderTimeLayout = "15:04"
+)
+
+// ReplaceTimePlaceholders заменяет {{date}} и {{time}} текущими датой и временем now в часовом поясе
+// пользователя, а {{idle}} - временем между его предыдущим и последним сообщением в чате (см. MarkChatMessage). Плейсхолдеры заменяются в этом
+// порядке и не вкладываются друг в друга: подставленные значения плейсхолдеров не содержат.
+// В отличие от {{user}} и {{char}}, которые подставляются уже при вводе текста, эти плейсхолдеры
+// заменяются только перед отправкой модели, чтобы сохраненный промпт всегда содержал текущие значения.
+func (u *User) ReplaceTimePlaceholders(input string, now time.Time) string {
+	if !strings.Contains(input, "{{") {
+		return input
+	}
+	local := now.In(u.Location())
+	input = replacePlaceholder(input, "{{date}}", local.Format(placeholderDateLayout))
+	input = replacePlaceholder(input, "{{time}}", local.Format(placeholderTimeLayout))
+	if strings.Contains(input, "{{idle}}") {
+		idle := "an unknown time"
+		if duration, ok := u.IdleTime(); ok {
+			idle = formatIdle(duration)
+		}
+		input = replacePlaceholder(input, "{{idle}}", idle)
+	}
+	return input
+}
+
+// formatIdle описывает продолжительность словами с точностью до самой крупной единицы,
+// например "less than a minute", "5 minutes" или "2 days".
+func formatIdle(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+	switch {
+	case d < time.Minute:
+		return "less than a minute"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
@@ -0,0 +1,124 @@
+package domain
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplaceTimePlaceholders(t *testing.T) {
+	now := time.Date(2026, 10, 17, 0, 30, 0, 0, time.UTC)
+
+	user := NewUser(1, "alice")
+	user.MarkChatMessage(now.Add(-3 * time.Hour))
+	user.MarkChatMessage(now)
+
+	tests := []struct {
+		name     string
+		timezone string
+		input    string
+		want     string
+	}{
+		{name: "no placeholders", input: "plain text", want: "plain text"},
+		{name: "date and time", input: "It is {{date}}, {{time}}.", want: "It is Saturday, 17 October 2026, 00:30."},
+		{name: "user timezone", timezone: "Asia/Tokyo", input: "{{date}} {{time}}", want: "Saturday, 17 October 2026 09:30"},
+		{name: "unknown timezone falls back to UTC", timezone: "Mars/Olympus", input: "{{time}}", want: "00:30"},
+		{name: "repeated", input: "{{time}}/{{time}}/{{idle}}/{{idle}}", want: "00:30/00:30/3 hours/3 hours"},
+		{name: "nested braces", input: "{{{{date}}}}", want: "{{Saturday, 17 October 2026}}"},
+		{name: "placeholder split by another", input: "{{ti{{time}}me}}", want: "{{ti00:30me}}"},
+		{name: "escaped", input: `\{{date}} is {{date}}`, want: `\{{date}} is Saturday, 17 October 2026`},
+		{name: "escaped and repeated", input: `{{idle}} \{{idle}} {{idle}}`, want: `3 hours \{{idle}} 3 hours`},
+		{name: "name placeholders are left alone", input: "{{user}} at {{time}}", want: "{{user}} at 00:30"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user.Timezone = tt.timezone
+			if got := user.ReplaceTimePlaceholders(tt.input, now); got != tt.want {
+				t.Errorf("ReplaceTimePlaceholders(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdlePlaceholder(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		previous time.Duration // Сколько прошло с предыдущего сообщения (0 - сообщений не было)
+		want     string
+	}{
+		{name: "first message", previous: 0, want: "an unknown time"},
+		{name: "seconds", previous: 20 * time.Second, want: "less than a minute"},
+		{name: "one minute", previous: time.Minute, want: "1 minute"},
+		{name: "minutes", previous: 59 * time.Minute, want: "59 minutes"},
+		{name: "hours", previous: 5*time.Hour + 40*time.Minute, want: "5 hours"},
+		{name: "one day", previous: 24 * time.Hour, want: "1 day"},
+		{name: "days", previous: 10 * 24 * time.Hour, want: "10 days"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := NewUser(1, "alice")
+			if tt.previous > 0 {
+				user.MarkChatMessage(now.Add(-tt.previous))
+			}
+			user.MarkChatMessage(now)
+			if got := user.ReplaceTimePlaceholders("{{idle}}", now); got != tt.want {
+				t.Errorf("{{idle}} = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplacePlaceholders(t *testing.T) {
+	user := NewUser(1, "Alice")
+	user.GetCurrentCharacter().Name = "Bob"
+
+	tests := []struct {
+		name     string
+		input    string
+		wantUser string // Результат User.ReplacePlaceholders
+		wantChar string // Результат CharacterPreset.ReplacePlaceholders
+	}{
+		{name: "no placeholders", input: "plain", wantUser: "plain", wantChar: "plain"},
+		{name: "unescaped", input: "{{user}} meets {{char}}", wantUser: "Alice meets Bob", wantChar: "{{user}} meets Bob"},
+		{name: "escaped", input: `\{{user}} and \{{char}}`, wantUser: `\{{user}} and \{{char}}`, wantChar: `\{{user}} and \{{char}}`},
+		{name: "mixed", input: `{{char}} says "\{{char}}" to {{user}}`, wantUser: `Bob says "\{{char}}" to Alice`, wantChar: `Bob says "\{{char}}" to {{user}}`},
+		{name: "escaped then unescaped", input: `\{{user}}{{user}}`, wantUser: `\{{user}}Alice`, wantChar: `\{{user}}{{user}}`},
+		{name: "double backslash still escapes", input: `\\{{char}}`, wantUser: `\\{{char}}`, wantChar: `\\{{char}}`},
+		{name: "backslash elsewhere", input: `a\b {{char}}`, wantUser: `a\b Bob`, wantChar: `a\b Bob`},
+		{name: "unknown placeholder", input: `{{other}} \{{other}}`, wantUser: `{{other}} \{{other}}`, wantChar: `{{other}} \{{other}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := user.ReplacePlaceholders(tt.input); got != tt.wantUser {
+				t.Errorf("User.ReplacePlaceholders(%q) = %q, want %q", tt.input, got, tt.wantUser)
+			}
+			if got := user.GetCurrentCharacter().ReplacePlaceholders(tt.input); got != tt.wantChar {
+				t.Errorf("CharacterPreset.ReplacePlaceholders(%q) = %q, want %q", tt.input, got, tt.wantChar)
+			}
+		})
+	}
+}
+
+func TestEscapedPlaceholdersSurviveRepeatedReplacement(t *testing.T) {
+	user := NewUser(1, "Alice")
+	user.GetCurrentCharacter().Name = "Bob"
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: `Write \{{user}} literally, {{user}}.`, want: "Write {{user}} literally, Alice."},
+		{input: `\{{char}} is {{char}}`, want: "{{char}} is Bob"},
+		{input: `{{user}}\{{char}}{{char}}`, want: "Alice{{char}}Bob"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			// Первая замена при сохранении промпта, вторая - перед отправкой модели
+			saved := user.ReplacePlaceholders(tt.input)
+			sent := user.GetCurrentCharacter().ReplacePlaceholders(user.ReplacePlaceholders(saved))
+			if got := UnescapePlaceholders(sent); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"fmt"
 	"strings"
 	"time"
 )
@@ -12,9 +13,27 @@ type User struct {
 	UserDescription    string             `json:"user_description" bson:"user_description"`
 	Characters         []*CharacterPreset `json:"characters" bson:"characters"` // Список настроек персонажей пользователя
 	CurrentCharacterID int                `json:"current_character_id" bson:"current_character_id"`
-	RequestTime        time.Time          `json:"request_time" bson:"request_time"`       // Время последнего запроса (для контроля частоты)
+	RequestTime        time.Time          `json:"request_time" bson:"request_time"`       // Время последнего запроса к модели (для контроля частоты)
 	PendingCommand     string             `json:"pending_command" bson:"pending_command"` // Ожидаемая команда (например, для ввода Prompt)
 	LastMessageID      int                `json:"last_message_id" bson:"last_message_id"` // ID последнего сообщения бота пользователю
+	CommandUsage       map[string]int     `json:"command_usage" bson:"command_usage"`     // Сколько раз пользователь вызывал каждую команду
+	OOCInstruction     string             `json:"ooc_instruction" bson:"ooc_instruction"` // Одноразовая внеролевая инструкция для следующей генерации
+	NextContext        string             `json:"next_context" bson:"next_context"`       // Одноразовый фактический контекст (документ, факты) для следующей генерации
+	LanguageCode       string             `json:"language_code" bson:"language_code"`     // Код языка интерфейса Telegram (например, "ru")
+	WhisperMode        bool               `json:"whisper_mode" bson:"whisper_mode"`       // Отвечать в групповых чатах личным сообщением
+	Version            int64              `json:"version" bson:"version"`                 // Версия документа для оптимистичной блокировки
+	Tier               string             `json:"tier" bson:"tier"`                       // Уровень доступа к моделям (пустая строка - базовый)
+
+	DigestOptIn    bool   `json:"digest_opt_in" bson:"digest_opt_in"`       // Получать ежедневную сводку
+	Timezone       string `json:"timezone" bson:"timezone"`                 // Часовой пояс IANA (пустая строка - UTC)
+	LastDigestDate string `json:"last_digest_date" bson:"last_digest_date"` // Местная дата последней сводки (YYYY-MM-DD)
+
+	PendingCommandSetAt time.Time `json:"pending_command_set_at" bson:"pending_command_set_at"` // Когда была задана ожидаемая команда PendingCommand
+
+	LastChatAt     time.Time `json:"last_chat_at" bson:"last_chat_at"`         // Время последнего сообщения пользователя персонажу
+	PreviousChatAt time.Time `json:"previous_chat_at" bson:"previous_chat_at"` // Время сообщения перед ним (для плейсхолдера {{idle}})
+
+	deleted bool // Пользователь удален из хранилища; объект больше не должен сохраняться
 }
 
 // NewUser создает новый экземпляр User с настройками по умолчанию.
@@ -26,10 +45,78 @@ func NewUser(userID int64, username string) *User {
 		UserDescription:    "",
 		Characters:         []*CharacterPreset{defaultChar},
 		CurrentCharacterID: 0,
-		RequestTime:        time.Now(),
 		PendingCommand:     "",
 		LastMessageID:      0,
+		CommandUsage:       map[string]int{},
+	}
+}
+
+// PendingCommandExpired сообщает, что ожидаемая команда задана раньше, чем timeout назад, и ввод для нее
+// больше не ожидается. Команда без времени установки (сохраненная до его появления) считается устаревшей.
+// Нулевой timeout отключает устаревание.
+func (u *User) PendingCommandExpired(timeout time.Duration, now time.Time) bool {
+	if u.PendingCommand == "" || timeout <= 0 {
+		return false
 	}
+	return u.PendingCommandSetAt.IsZero() || now.Sub(u.PendingCommandSetAt) > timeout
+}
+
+// MarkDeleted отмечает, что данные пользователя удалены из хранилища.
+func (u *User) MarkDeleted() {
+	u.deleted = true
+}
+
+// Deleted сообщает, что данные пользователя удалены и объект не следует сохранять: иначе пользователь
+// был бы создан заново со старыми настройками. Следующее сообщение создаст нового пользователя по умолчанию.
+func (u *User) Deleted() bool {
+	return u.deleted
+}
+
+// Repair исправляет поврежденный список персонажей (например, после неудачного импорта или ручной правки БД)
+// и возвращает описание выполненных исправлений. Пустой результат означает, что данные корректны.
+func (u *User) Repair() []string {
+	var repairs []string
+
+	valid := make([]*CharacterPreset, 0, len(u.Characters))
+	for _, char := range u.Characters {
+		if char != nil {
+			valid = append(valid, char)
+		}
+	}
+	if removed := len(u.Characters) - len(valid); removed > 0 {
+		repairs = append(repairs, fmt.Sprintf("removed %d empty character entries", removed))
+	}
+	if len(valid) == 0 {
+		valid = append(valid, NewCharacterPreset())
+		repairs = append(repairs, "added a default character to an empty character list")
+	}
+	u.Characters = valid
+
+	seenIDs := make(map[int]bool, len(u.Characters))
+	for _, char := range u.Characters {
+		if seenIDs[char.ID] {
+			// История хранится по ID персонажа, поэтому у дубликата загружена копия истории первого персонажа
+			// с тем же ID. Под новым ID она сохранилась бы второй раз.
+			oldID := char.ID
+			char.ID = u.NextCharacterID()
+			char.Chat, char.ContextStart = []ChatMessage{}, 0
+			repairs = append(repairs, fmt.Sprintf("reassigned duplicate character ID %d to %d and dropped the copy of its history", oldID, char.ID))
+		} else if char.ID < 0 {
+			oldID := char.ID
+			char.ID = u.NextCharacterID()
+			repairs = append(repairs, fmt.Sprintf("reassigned invalid character ID %d to %d", oldID, char.ID))
+		}
+		seenIDs[char.ID] = true
+		if char.Chat == nil {
+			char.Chat = []ChatMessage{}
+		}
+	}
+
+	if u.CurrentCharacterID < 0 || u.CurrentCharacterID >= len(u.Characters) {
+		repairs = append(repairs, fmt.Sprintf("reset out-of-range current character index %d to 0", u.CurrentCharacterID))
+		u.CurrentCharacterID = 0
+	}
+	return repairs
 }
 
 // GetCurrentCharacter возвращает текущего персонажа пользователя.
@@ -45,6 +132,17 @@ func (u *User) GetCurrentCharacter() *CharacterPreset {
 	return u.Characters[0]
 }
 
+// NextCharacterID возвращает свободный ID для нового персонажа.
+func (u *User) NextCharacterID() int {
+	next := 0
+	for _, char := range u.Characters {
+		if char.ID >= next {
+			next = char.ID + 1
+		}
+	}
+	return next
+}
+
 // ChangeCurrentCharacter устанавливает текущего персонажа по индексу.
 func (u *User) ChangeCurrentCharacter(index int) {
 	if index >= 0 && index < len(u.Characters) {
@@ -52,19 +150,166 @@ func (u *User) ChangeCurrentCharacter(index int) {
 	}
 }
 
+// hasCharacterName сообщает, есть ли у пользователя персонаж с таким именем (без учета регистра).
+func (u *User) hasCharacterName(name string) bool {
+	for _, char := range u.Characters {
+		if strings.EqualFold(char.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// UntitledCharacterName возвращает имя "Character N" с наименьшим свободным номером N.
+func (u *User) UntitledCharacterName() string {
+	for n := 1; ; n++ {
+		if name := fmt.Sprintf("Character %d", n); !u.hasCharacterName(name) {
+			return name
+		}
+	}
+}
+
+// UniqueCharacterName возвращает name, а если такое имя уже занято - name с суффиксом " (N)".
+func (u *User) UniqueCharacterName(name string) string {
+	if !u.hasCharacterName(name) {
+		return name
+	}
+	for n := 2; ; n++ {
+		if candidate := fmt.Sprintf("%s (%d)", name, n); !u.hasCharacterName(candidate) {
+			return candidate
+		}
+	}
+}
+
+// MoveCharacter перемещает персонажа с индекса from на индекс to, сдвигая остальных,
+// так что текущим остается тот же персонаж. Индексы вне диапазона игнорируются.
+func (u *User) MoveCharacter(from, to int) {
+	if from < 0 || from >= len(u.Characters) || to < 0 || to >= len(u.Characters) || from == to {
+		return
+	}
+	moved := u.Characters[from]
+	if from < to {
+		copy(u.Characters[from:to], u.Characters[from+1:to+1])
+	} else {
+		copy(u.Characters[to+1:from+1], u.Characters[to:from])
+	}
+	u.Characters[to] = moved
+
+	switch current := u.CurrentCharacterID; {
+	case current == from:
+		u.CurrentCharacterID = to
+	case from < current && current <= to:
+		u.CurrentCharacterID--
+	case to <= current && current < from:
+		u.CurrentCharacterID++
+	}
+}
+
+// RemoveCharacter удаляет персонажа с индексом index. Текущим остается тот же персонаж, а если
+// удален текущий - соседний с ним. Индекс вне диапазона игнорируется.
+func (u *User) RemoveCharacter(index int) {
+	if index < 0 || index >= len(u.Characters) {
+		return
+	}
+	u.Characters = append(u.Characters[:index], u.Characters[index+1:]...)
+	if index < u.CurrentCharacterID || u.CurrentCharacterID >= len(u.Characters) {
+		u.CurrentCharacterID--
+	}
+	if u.CurrentCharacterID < 0 {
+		u.CurrentCharacterID = 0
+	}
+}
+
+// Location возвращает часовой пояс пользователя или UTC, если он не задан или не распознан.
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// CarryOverText формирует из последних turns сообщений контекста персонажа from текст
+// "предыдущего разговора" для другого персонажа. Системные сообщения пропускаются.
+// Возвращает пустую строку, если переносить нечего.
+func CarryOverText(from *CharacterPreset, turns int) string {
+	history := from.ContextChat()
+	if turns <= 0 || len(history) == 0 {
+		return ""
+	}
+	if len(history) > turns {
+		history = history[len(history)-turns:]
+	}
+
+	var sb strings.Builder
+	for _, msg := range history {
+		switch msg.RoleEnum() {
+		case UserRole:
+			sb.WriteString("{{user}}: ")
+		case Assistant:
+			sb.WriteString(from.Name + ": ")
+		default:
+			continue
+		}
+		sb.WriteString(msg.Content)
+		sb.WriteString("\n")
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("Previous conversation between {{user}} and %s, a different character. It is context only; "+
+		"do not continue speaking as %s.\n%s", from.Name, from.Name, strings.TrimRight(sb.String(), "\n"))
+}
+
 // EnsureChatHistoryLimit обрезает историю чата, если она превышает лимит.
 func (u *User) EnsureChatHistoryLimit(charIndex int, limit int) {
 	if charIndex >= 0 && charIndex < len(u.Characters) {
 		char := u.Characters[charIndex]
-		if len(char.Chat) > limit {
-			char.Chat = char.Chat[len(char.Chat)-limit:] // Оставляем только 'limit' последних сообщений
+		if removed := len(char.Chat) - limit; removed > 0 {
+			char.Chat = char.Chat[removed:] // Оставляем только 'limit' последних сообщений
+			// Граница контекста сдвигается вместе с удаленными сообщениями
+			char.ContextStart -= removed
+			if char.ContextStart < 0 {
+				char.ContextStart = 0
+			}
 		}
 	}
 }
 
+// RecordCommandUsage увеличивает счетчик использования команды.
+// Вызывающая сторона должна передавать только известные команды, чтобы карта не росла бесконечно.
+func (u *User) RecordCommandUsage(command string) {
+	if u.CommandUsage == nil {
+		u.CommandUsage = make(map[string]int)
+	}
+	u.CommandUsage[command]++
+}
+
+// MarkChatMessage отмечает время нового сообщения пользователя персонажу. Команды и нажатия кнопок
+// его не отмечают, поэтому {{idle}} отсчитывается от предыдущего сообщения в чате.
+func (u *User) MarkChatMessage(now time.Time) {
+	u.PreviousChatAt = u.LastChatAt
+	u.LastChatAt = now
+}
+
+// IdleTime возвращает, сколько прошло от предыдущего сообщения пользователя персонажу до последнего,
+// и false, если время предыдущего сообщения неизвестно.
+func (u *User) IdleTime() (time.Duration, bool) {
+	if u.PreviousChatAt.IsZero() || u.LastChatAt.Before(u.PreviousChatAt) {
+		return 0, false
+	}
+	return u.LastChatAt.Sub(u.PreviousChatAt), true
+}
+
 // ReplacePlaceholders replaces {{user}} and {{char}} placeholders in a string.
+// Экранированные плейсхолдеры ("\{{user}}") не заменяются, см. UnescapePlaceholders.
+// Имена подставляются уже при вводе текста, а {{date}}, {{time}} и {{idle}} меняются со временем,
+// поэтому заменяются только перед отправкой модели, см. ReplaceTimePlaceholders.
 func (u *User) ReplacePlaceholders(input string) string {
-	input = strings.ReplaceAll(input, "{{user}}", u.UserName)
-	input = strings.ReplaceAll(input, "{{char}}", u.GetCurrentCharacter().Name)
+	input = replacePlaceholder(input, "{{user}}", u.UserName)
+	input = replacePlaceholder(input, "{{char}}", u.GetCurrentCharacter().Name)
 	return input
 }
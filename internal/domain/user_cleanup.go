@@ -0,0 +1,59 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Cleanup проверяет целостность данных пользователя и исправляет найденные ошибки.
+// Помимо структурных исправлений Repair проверяет историю чатов и настройки персонажей:
+// сообщения с неизвестной ролью или без текста, границу контекста за пределами истории,
+// отрицательную глубину заметки автора и неизвестный часовой пояс.
+// Возвращает описания исправлений; пустой результат означает, что данные в порядке.
+func (u *User) Cleanup() []string {
+	repairs := u.Repair()
+
+	for _, char := range u.Characters {
+		kept := char.Chat[:0]
+		invalidRoles, empty := 0, 0
+		for _, msg := range char.Chat {
+			switch {
+			case msg.Role != "" && msg.Role != System.String() && msg.Role != Assistant.String() && msg.Role != UserRole.String():
+				invalidRoles++
+			case strings.TrimSpace(msg.Content) == "":
+				empty++
+			default:
+				kept = append(kept, msg)
+			}
+		}
+		char.Chat = kept
+		if invalidRoles > 0 {
+			repairs = append(repairs, fmt.Sprintf("character %q: removed %d message(s) with an unknown role", char.Name, invalidRoles))
+		}
+		if empty > 0 {
+			repairs = append(repairs, fmt.Sprintf("character %q: removed %d empty message(s)", char.Name, empty))
+		}
+
+		if char.ContextStart < 0 || char.ContextStart > len(char.Chat) {
+			repairs = append(repairs, fmt.Sprintf("character %q: reset context boundary %d outside the history", char.Name, char.ContextStart))
+			char.ContextStart = 0
+		}
+		if char.NoteDepth < 0 {
+			repairs = append(repairs, fmt.Sprintf("character %q: reset negative author's note depth %d", char.Name, char.NoteDepth))
+			char.NoteDepth = 0
+		}
+		if char.PromptPosition != "" && !char.PromptPosition.IsValid() {
+			repairs = append(repairs, fmt.Sprintf("character %q: cleared unknown prompt position %q", char.Name, char.PromptPosition))
+			char.PromptPosition = ""
+		}
+	}
+
+	if u.Timezone != "" {
+		if _, err := time.LoadLocation(u.Timezone); err != nil {
+			repairs = append(repairs, fmt.Sprintf("reset unknown timezone %q to UTC", u.Timezone))
+			u.Timezone = ""
+		}
+	}
+	return repairs
+}
@@ -0,0 +1,263 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+// characterWithID возвращает персонажа по умолчанию с заданным ID.
+func characterWithID(id int) *CharacterPreset {
+	char := NewCharacterPreset()
+	char.ID = id
+	return char
+}
+
+// characterIDs возвращает ID персонажей пользователя по порядку.
+func characterIDs(u *User) []int {
+	ids := make([]int, 0, len(u.Characters))
+	for _, char := range u.Characters {
+		ids = append(ids, char.ID)
+	}
+	return ids
+}
+
+func TestUserRepair(t *testing.T) {
+	tests := []struct {
+		name        string
+		characters  []*CharacterPreset
+		current     int
+		wantIDs     []int
+		wantCurrent int
+		wantRepairs int
+	}{
+		{name: "valid user", characters: []*CharacterPreset{characterWithID(0), characterWithID(1)}, current: 1, wantIDs: []int{0, 1}, wantCurrent: 1},
+		{name: "nil character list", characters: nil, wantIDs: []int{0}, wantRepairs: 1},
+		{name: "empty character list", characters: []*CharacterPreset{}, wantIDs: []int{0}, wantRepairs: 1},
+		{name: "only nil entries", characters: []*CharacterPreset{nil, nil}, wantIDs: []int{0}, wantRepairs: 2},
+		{name: "nil entries removed", characters: []*CharacterPreset{nil, characterWithID(3), nil}, wantIDs: []int{3}, wantRepairs: 1},
+		{name: "duplicate IDs", characters: []*CharacterPreset{characterWithID(2), characterWithID(2), characterWithID(2)}, wantIDs: []int{2, 3, 4}, wantRepairs: 2},
+		{name: "negative ID", characters: []*CharacterPreset{characterWithID(-5), characterWithID(1)}, wantIDs: []int{2, 1}, wantRepairs: 1},
+		{name: "current index too large", characters: []*CharacterPreset{characterWithID(0), characterWithID(1)}, current: 2, wantIDs: []int{0, 1}, wantRepairs: 1},
+		{name: "negative current index", characters: []*CharacterPreset{characterWithID(0)}, current: -1, wantIDs: []int{0}, wantRepairs: 1},
+		{name: "current index past removed nil entries", characters: []*CharacterPreset{nil, characterWithID(0)}, current: 1, wantIDs: []int{0}, wantRepairs: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := NewUser(1, "alice")
+			user.Characters = tt.characters
+			user.CurrentCharacterID = tt.current
+
+			repairs := user.Repair()
+			if len(repairs) != tt.wantRepairs {
+				t.Errorf("Repair() = %q, want %d repairs", repairs, tt.wantRepairs)
+			}
+			if got := characterIDs(user); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("character IDs = %v, want %v", got, tt.wantIDs)
+			}
+			if user.CurrentCharacterID != tt.wantCurrent {
+				t.Errorf("CurrentCharacterID = %d, want %d", user.CurrentCharacterID, tt.wantCurrent)
+			}
+			if again := user.Repair(); len(again) != 0 {
+				t.Errorf("second Repair() = %q, want no repairs", again)
+			}
+		})
+	}
+}
+
+func TestUserRepairNilChat(t *testing.T) {
+	user := NewUser(1, "alice")
+	user.Characters[0].Chat = nil
+	if repairs := user.Repair(); len(repairs) != 0 {
+		t.Errorf("Repair() = %q, want no reported repairs", repairs)
+	}
+	if user.Characters[0].Chat == nil {
+		t.Error("Chat is still nil after Repair()")
+	}
+}
+
+func TestUserMoveCharacter(t *testing.T) {
+	tests := []struct {
+		name        string
+		from, to    int
+		current     int
+		wantIDs     []int
+		wantCurrent int // Индекс текущего персонажа после перемещения
+	}{
+		{name: "forward", from: 1, to: 3, current: 0, wantIDs: []int{0, 2, 3, 1}, wantCurrent: 0},
+		{name: "backward", from: 3, to: 1, current: 0, wantIDs: []int{0, 3, 1, 2}, wantCurrent: 0},
+		{name: "to the start", from: 2, to: 0, current: 3, wantIDs: []int{2, 0, 1, 3}, wantCurrent: 3},
+		{name: "to the end", from: 0, to: 3, current: 3, wantIDs: []int{1, 2, 3, 0}, wantCurrent: 2},
+		{name: "current character moved", from: 1, to: 3, current: 1, wantIDs: []int{0, 2, 3, 1}, wantCurrent: 3},
+		{name: "current character shifted back", from: 0, to: 2, current: 2, wantIDs: []int{1, 2, 0, 3}, wantCurrent: 1},
+		{name: "current character shifted forward", from: 3, to: 0, current: 1, wantIDs: []int{3, 0, 1, 2}, wantCurrent: 2},
+		{name: "same position", from: 2, to: 2, current: 2, wantIDs: []int{0, 1, 2, 3}, wantCurrent: 2},
+		{name: "negative from", from: -1, to: 2, current: 1, wantIDs: []int{0, 1, 2, 3}, wantCurrent: 1},
+		{name: "from out of range", from: 4, to: 0, current: 1, wantIDs: []int{0, 1, 2, 3}, wantCurrent: 1},
+		{name: "to out of range", from: 0, to: 4, current: 1, wantIDs: []int{0, 1, 2, 3}, wantCurrent: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := NewUser(1, "alice")
+			user.Characters = []*CharacterPreset{characterWithID(0), characterWithID(1), characterWithID(2), characterWithID(3)}
+			user.CurrentCharacterID = tt.current
+			currentID := user.GetCurrentCharacter().ID
+
+			user.MoveCharacter(tt.from, tt.to)
+			if got := characterIDs(user); !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("character IDs = %v, want %v", got, tt.wantIDs)
+			}
+			if user.CurrentCharacterID != tt.wantCurrent {
+				t.Errorf("CurrentCharacterID = %d, want %d", user.CurrentCharacterID, tt.wantCurrent)
+			}
+			if got := user.GetCurrentCharacter().ID; got != currentID {
+				t.Errorf("current character ID = %d, want %d (the same character)", got, currentID)
+			}
+		})
+	}
+}
+
+// userWithNames возвращает пользователя с персонажами, названными names.
+func userWithNames(names ...string) *User {
+	user := NewUser(1, "alice")
+	user.Characters = nil
+	for i, name := range names {
+		char := characterWithID(i)
+		char.Name = name
+		user.Characters = append(user.Characters, char)
+	}
+	return user
+}
+
+func TestUntitledCharacterName(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		want     string
+	}{
+		{name: "no characters", existing: nil, want: "Character 1"},
+		{name: "only the default character", existing: []string{DefaultCharacterName}, want: "Character 1"},
+		{name: "next number", existing: []string{"Character 1", "Character 2"}, want: "Character 3"},
+		{name: "gap after deletion", existing: []string{"Character 1", "Character 3"}, want: "Character 2"},
+		{name: "lowest gap first", existing: []string{"Character 2", "Character 4"}, want: "Character 1"},
+		{name: "case-insensitive", existing: []string{"character 1"}, want: "Character 2"},
+		{name: "similar names do not count", existing: []string{"Character 1 (2)", "Character 10"}, want: "Character 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userWithNames(tt.existing...).UntitledCharacterName(); got != tt.want {
+				t.Errorf("UntitledCharacterName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueCharacterName(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []string
+		input    string
+		want     string
+	}{
+		{name: "free name", existing: []string{"Ann"}, input: "Bea", want: "Bea"},
+		{name: "taken name", existing: []string{"Ann"}, input: "Ann", want: "Ann (2)"},
+		{name: "taken ignoring case", existing: []string{"ann"}, input: "Ann", want: "Ann (2)"},
+		{name: "several duplicates", existing: []string{"Ann", "Ann (2)", "Ann (3)"}, input: "Ann", want: "Ann (4)"},
+		{name: "gap in suffixes", existing: []string{"Ann", "Ann (3)"}, input: "Ann", want: "Ann (2)"},
+		{name: "suffixed name is taken", existing: []string{"Ann (2)"}, input: "Ann (2)", want: "Ann (2) (2)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userWithNames(tt.existing...).UniqueCharacterName(tt.input); got != tt.want {
+				t.Errorf("UniqueCharacterName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureChatHistoryLimitMovesContextStart(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		start     int
+		wantChat  int
+		wantStart int
+	}{
+		{name: "history exactly at the limit", limit: 4, start: 2, wantChat: 4, wantStart: 2},
+		{name: "one message over the limit", limit: 3, start: 2, wantChat: 3, wantStart: 1},
+		{name: "boundary trimmed away", limit: 1, start: 2, wantChat: 1, wantStart: 0},
+		{name: "boundary at the end", limit: 3, start: 4, wantChat: 3, wantStart: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := NewUser(1, "alice")
+			char := user.GetCurrentCharacter()
+			for i := 0; i < 4; i++ {
+				char.AppendMessages(NewChatMessage(UserRole, "message"))
+			}
+			char.ContextStart = tt.start
+
+			user.EnsureChatHistoryLimit(0, tt.limit)
+			if len(char.Chat) != tt.wantChat || char.ContextStart != tt.wantStart {
+				t.Errorf("history %d, ContextStart %d; want %d, %d", len(char.Chat), char.ContextStart, tt.wantChat, tt.wantStart)
+			}
+		})
+	}
+}
+
+func TestUserCleanupInconsistentData(t *testing.T) {
+	// История загружается по ID персонажа, поэтому у персонажей с одинаковым ID она одна и та же
+	chat := []ChatMessage{
+		NewChatMessage(UserRole, "hi"),
+		{Role: "robot", Content: "beep"},
+		NewChatMessage(Assistant, "  "),
+		NewChatMessage(Assistant, "hello"),
+	}
+	ann := characterWithID(1)
+	ann.Name = "Ann"
+	ann.Chat = append([]ChatMessage(nil), chat...)
+	ann.ContextStart = 9
+	ann.NoteDepth = -2
+	ann.PromptPosition = "middle"
+	bea := characterWithID(1)
+	bea.Name = "Bea"
+	bea.Chat = append([]ChatMessage(nil), chat...)
+	bea.ContextStart = 2
+
+	user := NewUser(1, "alice")
+	user.Characters = []*CharacterPreset{nil, ann, bea}
+	user.CurrentCharacterID = 5
+	user.Timezone = "Mars/Olympus_Mons"
+
+	repairs := user.Cleanup()
+	want := []string{
+		"removed 1 empty character entries",
+		"reassigned duplicate character ID 1 to 2 and dropped the copy of its history",
+		"reset out-of-range current character index 5 to 0",
+		`character "Ann": removed 1 message(s) with an unknown role`,
+		`character "Ann": removed 1 empty message(s)`,
+		`character "Ann": reset context boundary 9 outside the history`,
+		`character "Ann": reset negative author's note depth -2`,
+		`character "Ann": cleared unknown prompt position "middle"`,
+		`reset unknown timezone "Mars/Olympus_Mons" to UTC`,
+	}
+	if !reflect.DeepEqual(repairs, want) {
+		t.Errorf("Cleanup() =\n%q\nwant\n%q", repairs, want)
+	}
+
+	if got := characterIDs(user); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("character IDs = %v, want [1 2]", got)
+	}
+	var annChat []string
+	for _, msg := range ann.Chat {
+		annChat = append(annChat, msg.Content)
+	}
+	if !reflect.DeepEqual(annChat, []string{"hi", "hello"}) {
+		t.Errorf("Ann's history = %q, want the valid messages kept", annChat)
+	}
+	if len(bea.Chat) != 0 || bea.ContextStart != 0 {
+		t.Errorf("Bea keeps %d copied messages and ContextStart %d, want an empty history", len(bea.Chat), bea.ContextStart)
+	}
+	if again := user.Cleanup(); len(again) != 0 {
+		t.Errorf("second Cleanup() = %q, want no repairs", again)
+	}
+}
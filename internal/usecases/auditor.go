@@ -0,0 +1,71 @@
+package usecases
+
+import (
+	"context"
+	"time"
+)
+
+// Операции, попадающие в журнал аудита.
+const (
+	AuditAddCharacter      = "add_character"
+	AuditForkCharacter     = "fork_character"
+	AuditChangeCharacter   = "change_character"
+	AuditMoveCharacter     = "move_character"
+	AuditDeleteCharacter   = "delete_character"
+	AuditClearHistory      = "clear_history"
+	AuditClearContext      = "clear_context"
+	AuditClearAllHistories = "clear_all_histories"
+	AuditBackup            = "backup"
+	AuditRestore           = "restore"
+	AuditUpdateProperty    = "update_property"
+	AuditUpdateModelConfig = "update_model_config"
+	AuditAttachDocument    = "attach_document"
+	AuditCleanup           = "cleanup"
+	AuditDeleteUser        = "delete_user"
+)
+
+// maxAuditDetailLength ограничивает длину описания операции, чтобы не копировать в журнал целые промпты.
+const maxAuditDetailLength = 200
+
+// AuditEntry представляет запись журнала аудита изменяющей данные операции.
+type AuditEntry struct {
+	UserID    int64     `json:"user_id" bson:"user_id"`
+	Operation string    `json:"operation" bson:"operation"`
+	Detail    string    `json:"detail" bson:"detail"`
+	Timestamp time.Time `json:"timestamp" bson:"timestamp"`
+}
+
+// Auditor определяет интерфейс журнала аудита. Ошибки записи не прерывают операцию пользователя.
+type Auditor interface {
+	Record(ctx context.Context, entry AuditEntry) error
+}
+
+// noopAuditor используется, когда аудит отключен.
+type noopAuditor struct{}
+
+// Record ничего не делает.
+func (noopAuditor) Record(ctx context.Context, entry AuditEntry) error {
+	return nil
+}
+
+// SetAuditor подключает журнал аудита изменяющих операций (nil - отключить).
+func (uc *UserInteractor) SetAuditor(auditor Auditor) {
+	if auditor == nil {
+		auditor = noopAuditor{}
+	}
+	uc.auditor = auditor
+}
+
+// audit записывает операцию в журнал аудита. Длинные описания обрезаются.
+func (uc *UserInteractor) audit(ctx context.Context, userID int64, operation, detail string) {
+	if runes := []rune(detail); len(runes) > maxAuditDetailLength {
+		detail = string(runes[:maxAuditDetailLength]) + "…"
+	}
+	entry := AuditEntry{UserID: userID, Operation: operation, Detail: detail, Timestamp: time.Now().UTC()}
+	if err := uc.auditor.Record(ctx, entry); err != nil {
+		uc.logger.Warn("Failed to record audit entry %s for user %d: %v", operation, userID, err)
+	}
+}
+
+// Verify that noopAuditor implements Auditor
+var _ Auditor = noopAuditor{}
@@ -0,0 +1,151 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrNotAdmin возвращается, когда операцию пытается выполнить пользователь без прав администратора.
+var ErrNotAdmin = errors.New("administrator rights required")
+
+// ErrInvalidBackup возвращается, когда файл не является резервной копией бота.
+var ErrInvalidBackup = errors.New("not a valid backup file")
+
+// ErrBackupUnsupported возвращается, когда репозиторий не поддерживает резервное копирование.
+var ErrBackupUnsupported = errors.New("backup is not supported by the repository")
+
+// backupFormat идентифицирует формат резервной копии.
+const backupFormat = "neuro-chat-bot-backup/v1"
+
+// restoreBatchSize количество пользователей, записываемых в БД одним пакетом при восстановлении.
+const restoreBatchSize = 100
+
+// UserBackupRepository может быть реализован UserRepository для выгрузки и восстановления всех пользователей.
+type UserBackupRepository interface {
+	ForEachUser(ctx context.Context, fn func(user *domain.User) error) error
+	UpsertUsers(ctx context.Context, users []*domain.User) error
+}
+
+// backupDocument представляет одну часть резервной копии.
+type backupDocument struct {
+	Format string            `json:"format"`
+	Part   int               `json:"part"`
+	Users  []json.RawMessage `json:"users"`
+}
+
+// IsAdmin сообщает, является ли пользователь администратором бота.
+func (uc *UserInteractor) IsAdmin(userID int64) bool {
+	return uc.adminUserIDs[userID]
+}
+
+// ExportUsers выгружает всех пользователей в JSON-документы размером не более maxPartBytes
+// (кроме случая, когда один пользователь больше лимита) и передает каждую часть в emit.
+// Возвращает количество выгруженных пользователей.
+func (uc *UserInteractor) ExportUsers(ctx context.Context, admin *domain.User, maxPartBytes int, emit func(part int, data []byte) error) (int, error) {
+	if !uc.IsAdmin(admin.ID) {
+		return 0, ErrNotAdmin
+	}
+	backupRepo, ok := uc.userRepo.(UserBackupRepository)
+	if !ok {
+		return 0, ErrBackupUnsupported
+	}
+
+	doc := backupDocument{Format: backupFormat, Part: 1}
+	size, total := 0, 0
+	flush := func() error {
+		data, err := json.Marshal(doc)
+		if err != nil {
+			return fmt.Errorf("failed to encode backup part %d: %w", doc.Part, err)
+		}
+		if err := emit(doc.Part, data); err != nil {
+			return err
+		}
+		doc = backupDocument{Format: backupFormat, Part: doc.Part + 1}
+		size = 0
+		return nil
+	}
+
+	err := backupRepo.ForEachUser(ctx, func(user *domain.User) error {
+		data, err := json.Marshal(user)
+		if err != nil {
+			return fmt.Errorf("failed to encode user %d: %w", user.ID, err)
+		}
+		if len(doc.Users) > 0 && size+len(data) > maxPartBytes {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		doc.Users = append(doc.Users, data)
+		size += len(data) + 1 // Запятая между элементами массива
+		total++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(doc.Users) > 0 || doc.Part == 1 {
+		if err := flush(); err != nil {
+			return 0, err
+		}
+	}
+
+	uc.audit(ctx, admin.ID, AuditBackup, fmt.Sprintf("%d users exported", total))
+	return total, nil
+}
+
+// RestoreUsers восстанавливает пользователей из одной части резервной копии, перезаписывая
+// существующих пользователей с теми же ID. Возвращает количество восстановленных пользователей.
+func (uc *UserInteractor) RestoreUsers(ctx context.Context, admin *domain.User, data []byte) (int, error) {
+	if !uc.IsAdmin(admin.ID) {
+		return 0, ErrNotAdmin
+	}
+	backupRepo, ok := uc.userRepo.(UserBackupRepository)
+	if !ok {
+		return 0, ErrBackupUnsupported
+	}
+
+	var doc backupDocument
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&doc); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	if doc.Format != backupFormat {
+		return 0, fmt.Errorf("%w: unknown format %q", ErrInvalidBackup, doc.Format)
+	}
+
+	users := make([]*domain.User, 0, len(doc.Users))
+	for i, raw := range doc.Users {
+		var user domain.User
+		if err := json.Unmarshal(raw, &user); err != nil {
+			return 0, fmt.Errorf("%w: user #%d: %v", ErrInvalidBackup, i+1, err)
+		}
+		if user.ID == 0 {
+			return 0, fmt.Errorf("%w: user #%d has no ID", ErrInvalidBackup, i+1)
+		}
+		if repairs := user.Repair(); len(repairs) > 0 {
+			uc.logger.Warn("Repaired restored user %d: %v", user.ID, repairs)
+		}
+		users = append(users, &user)
+	}
+
+	for start := 0; start < len(users); start += restoreBatchSize {
+		end := start + restoreBatchSize
+		if end > len(users) {
+			end = len(users)
+		}
+		if err := backupRepo.UpsertUsers(ctx, users[start:end]); err != nil {
+			return start, err
+		}
+	}
+	for _, user := range users {
+		uc.ephemeralChats.forget(user.ID) // Восстановленная история заменяет историю в памяти
+	}
+
+	uc.logger.Info("Admin %d restored %d users from backup part %d", admin.ID, len(users), doc.Part)
+	uc.audit(ctx, admin.ID, AuditRestore, fmt.Sprintf("%d users restored from part %d", len(users), doc.Part))
+	return len(users), nil
+}
@@ -0,0 +1,213 @@
+package usecases
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// CharacterCardFormat описывает формат файла карточки персонажа для импорта.
+// Новые форматы подключаются через UserInteractor.RegisterCardFormat.
+type CharacterCardFormat interface {
+	// Name возвращает название формата для сообщений об ошибках.
+	Name() string
+	// Detect сообщает, похож ли файл на этот формат, по MIME-типу документа и первым байтам.
+	Detect(mimeType string, data []byte) bool
+	// Parse разбирает файл в карточку персонажа.
+	Parse(data []byte) (*CharacterCard, error)
+}
+
+// defaultCardFormats возвращает встроенные форматы в порядке проверки:
+// форматы с сигнатурой проверяются раньше текстовых.
+func defaultCardFormats() []CharacterCardFormat {
+	return []CharacterCardFormat{pngCardFormat{}, jsonCardFormat{}, yamlCardFormat{}}
+}
+
+// RegisterCardFormat добавляет формат карточек персонажей. Он проверяется после уже зарегистрированных.
+func (uc *UserInteractor) RegisterCardFormat(format CharacterCardFormat) {
+	uc.cardFormats = append(uc.cardFormats, format)
+}
+
+// detectCardFormat выбирает формат файла или возвращает ошибку со списком поддерживаемых форматов.
+func (uc *UserInteractor) detectCardFormat(mimeType string, data []byte) (CharacterCardFormat, error) {
+	names := make([]string, 0, len(uc.cardFormats))
+	for _, format := range uc.cardFormats {
+		if format.Detect(mimeType, data) {
+			return format, nil
+		}
+		names = append(names, format.Name())
+	}
+	return nil, fmt.Errorf("%w: unrecognized file format, supported formats: %s", ErrInvalidCharacterCard, strings.Join(names, ", "))
+}
+
+// hasMIMEType сообщает, совпадает ли MIME-тип документа (без параметров) с одним из перечисленных.
+func hasMIMEType(mimeType string, types ...string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	for _, t := range types {
+		if mimeType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonCardFormat разбирает JSON-карточки (собственный формат, TavernAI и chara_card_v2).
+type jsonCardFormat struct{}
+
+func (jsonCardFormat) Name() string { return "JSON" }
+
+func (jsonCardFormat) Detect(mimeType string, data []byte) bool {
+	return hasMIMEType(mimeType, "application/json") || bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+func (jsonCardFormat) Parse(data []byte) (*CharacterCard, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("file is not UTF-8 text")
+	}
+	var card CharacterCard
+	if err := json.NewDecoder(bytes.NewReader(data)).Decode(&card); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// pngSignature первые байты любого PNG-файла.
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngCardFormat извлекает карточку из PNG SillyTavern: JSON в base64 хранится в текстовом чанке
+// tEXt с ключом "chara" (или "ccv3" для карточек третьей версии).
+type pngCardFormat struct{}
+
+func (pngCardFormat) Name() string { return "SillyTavern PNG" }
+
+func (pngCardFormat) Detect(mimeType string, data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func (pngCardFormat) Parse(data []byte) (*CharacterCard, error) {
+	chunks := map[string][]byte{}
+	for rest := data[len(pngSignature):]; len(rest) >= 12; {
+		length := binary.BigEndian.Uint32(rest[:4])
+		if uint64(length) > uint64(len(rest)-12) {
+			return nil, fmt.Errorf("truncated PNG chunk")
+		}
+		chunkType := string(rest[4:8])
+		chunkData := rest[8 : 8+length]
+		rest = rest[12+length:] // Длина, тип, данные и CRC
+		if chunkType == "IEND" {
+			break
+		}
+		if chunkType != "tEXt" {
+			continue
+		}
+		if keyword, text, ok := bytes.Cut(chunkData, []byte{0}); ok {
+			chunks[string(keyword)] = text
+		}
+	}
+
+	encoded, ok := chunks["ccv3"]
+	if !ok {
+		encoded, ok = chunks["chara"]
+	}
+	if !ok {
+		return nil, fmt.Errorf("PNG has no embedded character data")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid embedded character data: %v", err)
+	}
+	return jsonCardFormat{}.Parse(decoded)
+}
+
+// yamlCardFormat разбирает простой YAML: пары "ключ: значение" верхнего уровня и многострочные
+// значения "ключ: |" с отступом. Вложенные структуры и списки не поддерживаются.
+type yamlCardFormat struct{}
+
+func (yamlCardFormat) Name() string { return "YAML" }
+
+func (yamlCardFormat) Detect(mimeType string, data []byte) bool {
+	if hasMIMEType(mimeType, "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml") {
+		return true
+	}
+	// Без MIME-типа считаем YAML текст, первая значимая строка которого имеет вид "ключ:"
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line == "---" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, _, ok := strings.Cut(line, ":")
+		return ok && key != "" && !strings.ContainsAny(key, " \t\"'{[")
+	}
+	return false
+}
+
+func (yamlCardFormat) Parse(data []byte) (*CharacterCard, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("file is not UTF-8 text")
+	}
+	values := map[string]string{}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		value = strings.TrimSpace(value)
+		if value == "|" || value == ">" {
+			// Многострочное значение: все следующие строки с отступом (и пустые строки между ними)
+			var block []string
+			for i+1 < len(lines) && (strings.TrimSpace(lines[i+1]) == "" || lines[i+1][0] == ' ' || lines[i+1][0] == '\t') {
+				i++
+				block = append(block, strings.TrimSpace(lines[i]))
+			}
+			separator := "\n"
+			if value == ">" {
+				separator = " "
+			}
+			value = strings.TrimSpace(strings.Join(block, separator))
+		} else {
+			value = unquoteYAML(value)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+
+	return &CharacterCard{
+		Name:        values["name"],
+		Greeting:    values["greeting"],
+		FirstMes:    values["first_mes"],
+		Prompt:      values["prompt"],
+		Description: values["description"],
+		SystemRules: values["system_rules"],
+	}, nil
+}
+
+// unquoteYAML снимает кавычки со скалярного значения YAML.
+func unquoteYAML(value string) string {
+	if len(value) >= 2 {
+		switch {
+		case value[0] == '"' && value[len(value)-1] == '"':
+			var unquoted string
+			if err := json.Unmarshal([]byte(value), &unquoted); err == nil {
+				return unquoted
+			}
+		case value[0] == '\'' && value[len(value)-1] == '\'':
+			return strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		}
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package usecases
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// minRevertedNameLength минимальная длина имени, которое заменяется обратно на плейсхолдер.
+// Более короткие имена слишком часто встречаются внутри обычных слов.
+const minRevertedNameLength = 3
+
+// AnonymizeCharacter возвращает копию текущего персонажа, пригодную для публикации: без истории чата и ее изложения,
+// перенесенного разговора, прикрепленного документа и собственных параметров модели, с именами пользователя и персонажа
+// в текстах, замененными обратно на {{user}} и {{char}}.
+func (uc *UserInteractor) AnonymizeCharacter(user *domain.User) *domain.CharacterPreset {
+	source := user.GetCurrentCharacter()
+	anonymized := source.Clone()
+	anonymized.ID = 0
+	anonymized.Chat = []domain.ChatMessage{}
+	anonymized.ContextStart = 0
+	anonymized.CarryOver = ""
+	anonymized.HistorySummary = ""
+	anonymized.ModelConfig = nil
+	anonymized.Document, anonymized.DocumentName = "", ""
+
+	revert := func(text string) string {
+		if utf8.RuneCountInString(user.UserName) >= minRevertedNameLength {
+			text = strings.ReplaceAll(text, user.UserName, "{{user}}")
+		}
+		if utf8.RuneCountInString(source.Name) >= minRevertedNameLength {
+			text = strings.ReplaceAll(text, source.Name, "{{char}}")
+		}
+		return text
+	}
+	anonymized.Prompt = revert(anonymized.Prompt)
+	anonymized.Greeting = revert(anonymized.Greeting)
+	anonymized.SystemRules = revert(anonymized.SystemRules)
+	anonymized.AuthorsNote = revert(anonymized.AuthorsNote)
+	for i := range anonymized.AlternateGreetings {
+		anonymized.AlternateGreetings[i].Text = revert(anonymized.AlternateGreetings[i].Text)
+	}
+	for i := range anonymized.ExampleDialogue {
+		anonymized.ExampleDialogue[i].Content = revert(anonymized.ExampleDialogue[i].Content)
+	}
+	return anonymized
+}
+
+// ExportCharacterCard сериализует персонажа в JSON-карточку, которую принимает ImportCharacterCard.
+func (uc *UserInteractor) ExportCharacterCard(char *domain.CharacterPreset) ([]byte, error) {
+	card := CharacterCard{
+		Name:        char.Name,
+		Greeting:    char.Greeting,
+		Prompt:      char.Prompt,
+		SystemRules: char.SystemRules,
+	}
+	for _, greeting := range char.AlternateGreetings {
+		card.AlternateGreetings = append(card.AlternateGreetings, greeting.Text)
+	}
+	if pairs := characterExamplePairs(char); len(pairs) > 0 {
+		card.MesExample = formatExampleDialogue(pairs)
+	}
+	data, err := json.MarshalIndent(card, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode character card: %w", err)
+	}
+	return data, nil
+}
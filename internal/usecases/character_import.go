@@ -0,0 +1,110 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrInvalidCharacterCard возвращается, когда импортируемый файл не является карточкой персонажа.
+var ErrInvalidCharacterCard = errors.New("not a valid character card")
+
+// maxCardFieldLength ограничивает длину текстовых полей карточки в символах.
+const maxCardFieldLength = 32000
+
+// cardPersonalityPrefix предваряет характер персонажа (поле personality), добавляемый к описанию в промпте,
+// так же, как это делает SillyTavern.
+const cardPersonalityPrefix = "{{char}}'s personality: "
+
+// CharacterCard описывает поддерживаемые поля карточки персонажа, общие для всех форматов импорта.
+// Помимо собственного формата принимаются поля карточек TavernAI (first_mes, description, personality)
+// и обертка chara_card_v2 ("data").
+type CharacterCard struct {
+	Name        string         `json:"name"`
+	Greeting    string         `json:"greeting"`
+	FirstMes    string         `json:"first_mes,omitempty"`
+	Prompt      string         `json:"prompt"`
+	Description string         `json:"description,omitempty"`
+	Personality string         `json:"personality,omitempty"`
+	SystemRules string         `json:"system_rules,omitempty"`
+	Data        *CharacterCard `json:"data,omitempty"`
+
+	AlternateGreetings []string `json:"alternate_greetings,omitempty"` // Импортируются с весом 1
+	MesExample         string   `json:"mes_example,omitempty"`         // Пример диалога: блоки <START> с репликами {{user}}: и {{char}}:
+}
+
+// ImportCharacterCard создает персонажа из файла карточки и делает его текущим.
+// Формат определяется по сигнатуре файла и MIME-типу документа (mimeType может быть пустым).
+// Ошибки формата оборачивают ErrInvalidCharacterCard.
+func (uc *UserInteractor) ImportCharacterCard(ctx context.Context, user *domain.User, data []byte, mimeType string) (*domain.CharacterPreset, error) {
+	format, err := uc.detectCardFormat(mimeType, data)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := format.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidCharacterCard, format.Name(), err)
+	}
+	card := *parsed
+	if card.Data != nil {
+		card = *card.Data
+	}
+
+	name := strings.TrimSpace(card.Name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: missing character name", ErrInvalidCharacterCard)
+	}
+	greeting := card.Greeting
+	if greeting == "" {
+		greeting = card.FirstMes
+	}
+	prompt := card.Prompt
+	if prompt == "" {
+		prompt = card.Description
+		if strings.TrimSpace(card.Personality) != "" {
+			prompt = joinPromptParts(prompt, cardPersonalityPrefix+card.Personality)
+		}
+	}
+	for field, value := range map[string]string{"name": name, "greeting": greeting, "prompt": prompt, "system_rules": card.SystemRules} {
+		if utf8.RuneCountInString(value) > maxCardFieldLength {
+			return nil, fmt.Errorf("%w: field %s is longer than %d characters", ErrInvalidCharacterCard, field, maxCardFieldLength)
+		}
+	}
+
+	char := domain.NewCharacterPreset()
+	char.Name = name
+	char.Greeting = greeting
+	char.Prompt = prompt
+	char.SystemRules = card.SystemRules
+	for _, greeting := range card.AlternateGreetings {
+		if strings.TrimSpace(greeting) != "" && utf8.RuneCountInString(greeting) <= maxCardFieldLength {
+			char.AlternateGreetings = append(char.AlternateGreetings, domain.AlternateGreeting{Text: greeting, Weight: 1})
+		}
+	}
+	// Пример диалога необязателен: слишком длинный или неразборчивый пример пропускается, а не отменяет импорт
+	if strings.TrimSpace(card.MesExample) != "" {
+		pairs, err := parseExampleDialogue(card.MesExample)
+		if err == nil && utf8.RuneCountInString(card.MesExample) > maxCardFieldLength {
+			err = fmt.Errorf("field mes_example is longer than %d characters", maxCardFieldLength)
+		}
+		if err != nil {
+			uc.logger.Warn("Skipping example dialogue of character %q imported by user %d: %v", name, user.ID, err)
+			pairs = nil
+		}
+		if len(pairs) > domain.MaxExamplePairs {
+			pairs = pairs[:domain.MaxExamplePairs]
+		}
+		for _, pair := range pairs {
+			char.AddExample(pair.user, pair.char)
+		}
+	}
+	if err := uc.AddCharacter(ctx, user, char); err != nil {
+		return nil, err
+	}
+	uc.logger.Info("User %d imported character %q from %s", user.ID, char.Name, format.Name())
+	return char, nil
+}
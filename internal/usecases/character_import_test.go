@@ -0,0 +1,121 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestImportCharacterCard(t *testing.T) {
+	long := strings.Repeat("x", maxCardFieldLength+1)
+	tests := []struct {
+		name         string
+		data         string
+		mimeType     string
+		wantErr      bool
+		wantName     string
+		wantGreeting string
+		wantPrompt   string
+		wantExamples int
+	}{
+		{name: "own format", data: `{"name":"Ann","greeting":"Hi","prompt":"A knight."}`, wantName: "Ann", wantGreeting: "Hi", wantPrompt: "A knight."},
+		{
+			name:         "TavernAI fields",
+			data:         `{"name":"Bea","first_mes":"Hello","description":"A bard.","personality":"cheerful"}`,
+			wantName:     "Bea",
+			wantGreeting: "Hello",
+			wantPrompt:   "A bard.\n" + cardPersonalityPrefix + "cheerful",
+		},
+		{name: "chara_card_v2 wrapper", data: `{"spec":"chara_card_v2","data":{"name":"Cid","first_mes":"Yo","description":"A pilot."}}`, wantName: "Cid", wantGreeting: "Yo", wantPrompt: "A pilot."},
+		{name: "YAML", data: "name: Dee\ngreeting: Welcome\nprompt: A guide.\n", mimeType: "application/yaml", wantName: "Dee", wantGreeting: "Welcome", wantPrompt: "A guide."},
+		{name: "field at the limit", data: `{"name":"Eve","prompt":"` + long[1:] + `"}`, wantName: "Eve", wantPrompt: long[1:]},
+		{
+			name:         "oversized example dialogue is skipped",
+			data:         `{"name":"Fay","mes_example":"<START>\n{{user}}: hi\n{{char}}: ` + long + `"}`,
+			wantName:     "Fay",
+			wantExamples: 0,
+		},
+		{name: "example dialogue", data: `{"name":"Gus","mes_example":"<START>\n{{user}}: hi\n{{char}}: hello"}`, wantName: "Gus", wantExamples: 1},
+		{name: "oversized name", data: `{"name":"` + long + `"}`, wantErr: true},
+		{name: "oversized prompt", data: `{"name":"Hal","prompt":"` + long + `"}`, wantErr: true},
+		{name: "oversized greeting", data: `{"name":"Ivy","first_mes":"` + long + `"}`, wantErr: true},
+		{name: "oversized system rules", data: `{"name":"Jo","system_rules":"` + long + `"}`, wantErr: true},
+		{name: "missing name", data: `{"greeting":"Hi"}`, wantErr: true},
+		{name: "blank name", data: `{"name":"   "}`, wantErr: true},
+		{name: "truncated JSON", data: `{"name":"Kim","prompt":`, wantErr: true},
+		{name: "invalid UTF-8", data: "{\"name\":\"\xff\xfe\"}", wantErr: true},
+		{name: "broken PNG", data: "\x89PNG\r\n\x1a\nnot really a png", wantErr: true},
+		{name: "unknown format", data: "just some words", wantErr: true},
+		{name: "empty file", data: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+			user := domain.NewUser(1, "alice")
+
+			char, err := uc.ImportCharacterCard(context.Background(), user, []byte(tt.data), tt.mimeType)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCharacterCard) {
+					t.Fatalf("ImportCharacterCard() error = %v, want ErrInvalidCharacterCard", err)
+				}
+				if len(user.Characters) != 1 {
+					t.Errorf("user has %d characters after a failed import, want 1", len(user.Characters))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ImportCharacterCard: %v", err)
+			}
+			if char.Name != tt.wantName || char.Greeting != tt.wantGreeting || char.Prompt != tt.wantPrompt {
+				t.Errorf("imported name %q, greeting %q, prompt %q; want %q, %q, %q",
+					char.Name, char.Greeting, char.Prompt, tt.wantName, tt.wantGreeting, tt.wantPrompt)
+			}
+			if got := char.ExamplePairs(); got != tt.wantExamples {
+				t.Errorf("imported %d example pairs, want %d", got, tt.wantExamples)
+			}
+			if user.GetCurrentCharacter() != char {
+				t.Error("imported character is not selected")
+			}
+		})
+	}
+}
+
+func TestAnonymizedExportHasNoPersonalData(t *testing.T) {
+	uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+	user := domain.NewUser(1, "alice_w")
+	char := user.GetCurrentCharacter()
+	char.Name = "Annabel"
+	char.Prompt = "Annabel is a friend of alice_w."
+	char.Greeting = "Hello, alice_w!"
+	char.AppendMessages(domain.NewChatMessage(domain.UserRole, "my secret password"), domain.NewChatMessage(domain.Assistant, "secret reply"))
+	char.HistorySummary = "secret summary"
+	char.CarryOver = "secret carried-over turns"
+	char.Document, char.DocumentName = "secret document", "diary.txt"
+
+	anonymized := uc.AnonymizeCharacter(user)
+	if len(anonymized.Chat) != 0 || anonymized.HistorySummary != "" || anonymized.CarryOver != "" || anonymized.Document != "" {
+		t.Errorf("anonymized character keeps private data: %+v", anonymized)
+	}
+	if len(char.Chat) != 2 || char.Prompt != "Annabel is a friend of alice_w." {
+		t.Error("AnonymizeCharacter changed the user's own character")
+	}
+
+	data, err := uc.ExportCharacterCard(anonymized)
+	if err != nil {
+		t.Fatalf("ExportCharacterCard: %v", err)
+	}
+	export := string(data)
+	for _, private := range []string{"secret", "alice_w", "diary.txt"} {
+		if strings.Contains(export, private) {
+			t.Errorf("export contains %q:\n%s", private, export)
+		}
+	}
+	for _, want := range []string{"{{char}} is a friend of {{user}}.", "Hello, {{user}}!"} {
+		if !strings.Contains(export, want) {
+			t.Errorf("export does not contain %q:\n%s", want, export)
+		}
+	}
+}
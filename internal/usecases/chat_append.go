@@ -0,0 +1,176 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ChatAppender может быть реализован UserRepository, чтобы дописывать сообщения в историю персонажа
+// без перезаписи всего документа пользователя. Реализация сохраняет остальные поля пользователя и
+// персонажа characterIndex, обрезает историю в хранилище до limit последних сообщений и проверяет
+// версию документа так же, как SaveUser (ErrVersionConflict при несовпадении).
+type ChatAppender interface {
+	AppendChatMessages(ctx context.Context, user *domain.User, characterIndex int, messages []domain.ChatMessage, limit int) error
+}
+
+// saveAppended сохраняет пользователя после того, как в историю персонажа charIndex были добавлены
+// count последних сообщений (и история обрезана до chatHistoryLimit). Если репозиторий поддерживает
+// ChatAppender, дописываются только новые сообщения, иначе пользователь сохраняется целиком.
+// При конфликте версий пользователь перечитывается и новые сообщения добавляются заново (см. resolveConflict).
+func (uc *UserInteractor) saveAppended(ctx context.Context, user *domain.User, charIndex int, count int) error {
+	err := uc.appendChat(ctx, user, charIndex, count)
+	if errors.Is(err, ErrVersionConflict) {
+		return uc.resolveConflict(ctx, user, charIndex, count)
+	}
+	return err
+}
+
+// appendChat выполняет одну попытку сохранения count новых сообщений персонажа charIndex.
+func (uc *UserInteractor) appendChat(ctx context.Context, user *domain.User, charIndex int, count int) error {
+	appender, ok := uc.userRepo.(ChatAppender)
+	if !ok || !uc.canAppendChat(user, charIndex) {
+		return uc.saveUser(ctx, user)
+	}
+
+	chat := user.Characters[charIndex].Chat
+	if count > len(chat) {
+		count = len(chat) // Часть новых сообщений уже отрезана лимитом истории
+	}
+	if err := appender.AppendChatMessages(ctx, user, charIndex, chat[len(chat)-count:], uc.chatHistoryLimit); err != nil {
+		return err
+	}
+	uc.ephemeralChats.remember(user)
+	return nil
+}
+
+// resolveConflict сохраняет ход чата пользователя, которого после загрузки изменил другой обработчик
+// или экземпляр бота: пользователь перечитывается из хранилища, в историю персонажа charIndex заново
+// добавляются count последних сообщений хода, и сохранение повторяется, не более conflictRetries раз.
+// Так изменения обоих обработчиков сохраняются, а user заменяется перечитанным пользователем.
+func (uc *UserInteractor) resolveConflict(ctx context.Context, user *domain.User, charIndex int, count int) error {
+	err := ErrVersionConflict
+	for attempt := 1; errors.Is(err, ErrVersionConflict) && attempt <= uc.conflictRetries; attempt++ {
+		uc.logger.Warn("User %d changed since loading, reloading and re-applying the chat turn (attempt %d)", user.ID, attempt)
+		count, err = uc.reloadChatTurn(ctx, user, charIndex, count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return nil // Все сообщения хода уже сохранены (ошибка пришла после записи)
+		}
+		err = uc.appendChat(ctx, user, charIndex, count)
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		uc.logger.Error("Giving up saving user %d after repeated version conflicts", user.ID)
+	}
+	return err
+}
+
+// reloadChatTurn перечитывает пользователя и добавляет в историю персонажа charIndex те из count последних
+// сообщений user, которых в хранимой истории еще нет. Возвращает количество добавленных сообщений.
+// Если персонаж за это время удален или перемещен, ход применить нельзя, и возвращается ErrVersionConflict.
+func (uc *UserInteractor) reloadChatTurn(ctx context.Context, user *domain.User, charIndex int, count int) (int, error) {
+	chat := user.Characters[charIndex].Chat
+	if count > len(chat) {
+		count = len(chat)
+	}
+	turn := append([]domain.ChatMessage(nil), chat[len(chat)-count:]...)
+
+	stored, err := uc.reloadUser(ctx, user.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reload user %d: %w", user.ID, err)
+	}
+	if stored == nil {
+		return 0, fmt.Errorf("%w: user %d was deleted", ErrVersionConflict, user.ID)
+	}
+	if charIndex >= len(stored.Characters) || stored.Characters[charIndex].ID != user.Characters[charIndex].ID {
+		return 0, fmt.Errorf("%w: character %q was moved or deleted", ErrVersionConflict, user.Characters[charIndex].Name)
+	}
+
+	missing := missingMessages(stored.Characters[charIndex].Chat, turn)
+	stored.Characters[charIndex].AppendMessages(missing...)
+	stored.EnsureChatHistoryLimit(charIndex, uc.chatHistoryLimit)
+
+	lastChatAt := user.LastChatAt
+	*user = *stored
+	if lastChatAt.After(user.LastChatAt) {
+		user.MarkChatMessage(lastChatAt) // Для {{idle}} предыдущим становится последнее сохраненное сообщение
+	}
+	return len(missing), nil
+}
+
+// missingMessages возвращает сообщения turn, которых нет в конце истории chat: если запись хода
+// завершилась ошибкой уже после сохранения, его начало может оказаться в хранилище.
+func missingMessages(chat, turn []domain.ChatMessage) []domain.ChatMessage {
+	for saved := len(turn); saved > 0; saved-- {
+		if saved > len(chat) {
+			continue
+		}
+		tail := chat[len(chat)-saved:]
+		matches := true
+		for i := range tail {
+			if tail[i].Seq != turn[i].Seq || tail[i].Role != turn[i].Role || tail[i].Content != turn[i].Content {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return turn[saved:]
+		}
+	}
+	return turn
+}
+
+// canAppendChat сообщает, совпадает ли загруженная история персонажа с хранимой, то есть можно ли
+// дописывать в хранилище только новые сообщения. Это не так, если загружена лишь часть истории
+// (historyLoadLimit меньше лимита истории) или у пользователя есть несохраненные изменения в очереди.
+func (uc *UserInteractor) canAppendChat(user *domain.User, charIndex int) bool {
+	if charIndex < 0 || charIndex >= len(user.Characters) || uc.chatHistoryLimit <= 0 {
+		return false
+	}
+	if uc.historyLoadLimit > 0 && uc.historyLoadLimit < uc.chatHistoryLimit {
+		return false
+	}
+	return !uc.hasPendingSave(user.ID)
+}
+
+// rollbackTurn удаляет из истории текущего персонажа count последних сообщений хода (сообщение пользователя
+// и засеянное приветствие), на которые модель так и не ответила: иначе следующий запрос передал бы модели
+// два сообщения пользователя подряд. Сообщения находятся по номерам Seq, поэтому сообщения, дописанные
+// другим обработчиком, не затрагиваются. Ошибка сохранения только записывается в журнал.
+func (uc *UserInteractor) rollbackTurn(ctx context.Context, user *domain.User, count int) {
+	char := user.GetCurrentCharacter()
+	if count > len(char.Chat) {
+		count = len(char.Chat)
+	}
+	turn := make(map[int64]bool, count)
+	for _, msg := range char.Chat[len(char.Chat)-count:] {
+		turn[msg.Seq] = true
+	}
+
+	rollback := func(u *domain.User) error {
+		i, err := characterIndexByID(u, char.ID)
+		if err != nil {
+			return err
+		}
+		stored := u.Characters[i]
+		kept := make([]domain.ChatMessage, 0, len(stored.Chat))
+		for _, msg := range stored.Chat {
+			if !turn[msg.Seq] {
+				kept = append(kept, msg)
+			}
+		}
+		stored.Chat = kept
+		if stored.ContextStart > len(kept) {
+			stored.ContextStart = len(kept)
+		}
+		return nil
+	}
+	// Генерацию могли прервать отменой контекста, но сообщение без ответа все равно удаляется
+	if err := uc.updateUser(context.WithoutCancel(ctx), user, rollback); err != nil {
+		uc.logger.Error("Failed to remove the unanswered message of user %d from history: %v", user.ID, err)
+	}
+}
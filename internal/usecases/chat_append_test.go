@@ -0,0 +1,136 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// chatContents возвращает тексты сообщений текущего персонажа сохраненного пользователя.
+func chatContents(t *testing.T, repo UserRepository, userID int64) []string {
+	t.Helper()
+	stored, err := repo.LoadUser(context.Background(), userID)
+	if err != nil || stored == nil {
+		t.Fatalf("LoadUser(%d) = %v, %v", userID, stored, err)
+	}
+	var contents []string
+	for _, msg := range stored.GetCurrentCharacter().Chat {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+func TestChatTurnVersionConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		appender bool
+	}{
+		{name: "whole document save", appender: false},
+		{name: "append only new messages", appender: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			memory := newMemoryRepository()
+			var repo UserRepository = memory
+			if tt.appender {
+				repo = &appendingRepository{memoryRepository: memory}
+			}
+			uc := newTestInteractor(repo, &stubGateway{responses: []string{"hi there"}}, nil)
+
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+
+			// Другой экземпляр бота изменяет того же пользователя после загрузки
+			other, _ := memory.LoadUser(ctx, 1)
+			other.UserDescription = "set elsewhere"
+			other.GetCurrentCharacter().AppendMessages(domain.NewChatMessage(domain.UserRole, "from another instance"))
+			if err := memory.SaveUser(ctx, other); err != nil {
+				t.Fatalf("concurrent SaveUser: %v", err)
+			}
+
+			response, err := uc.GetModelResponseForUser(ctx, user, "hello")
+			if err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+			if response != "hi there" {
+				t.Errorf("response = %q, want %q", response, "hi there")
+			}
+
+			want := []string{"from another instance", "hello", "hi there"}
+			if got := chatContents(t, repo, 1); !reflect.DeepEqual(got, want) {
+				t.Errorf("stored chat = %q, want %q", got, want)
+			}
+			stored, _ := memory.LoadUser(ctx, 1)
+			if stored.UserDescription != "set elsewhere" {
+				t.Errorf("concurrent change lost: description = %q", stored.UserDescription)
+			}
+			if user.Version != stored.Version {
+				t.Errorf("user version = %d, stored version = %d", user.Version, stored.Version)
+			}
+		})
+	}
+}
+
+func TestChatTurnVersionConflictGivesUp(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{responses: []string{"hi there"}}, func(opts *UserInteractorOptions) {
+		opts.ConflictRetries = 0
+	})
+
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	other, _ := repo.LoadUser(ctx, 1)
+	other.GetCurrentCharacter().AppendMessages(domain.NewChatMessage(domain.UserRole, "from another instance"))
+	if err := repo.SaveUser(ctx, other); err != nil {
+		t.Fatalf("concurrent SaveUser: %v", err)
+	}
+
+	if _, err := uc.GetModelResponseForUser(ctx, user, "hello"); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("GetModelResponseForUser error = %v, want ErrVersionConflict", err)
+	}
+	// Изменение другого экземпляра не перезаписано
+	if got, want := chatContents(t, repo, 1), []string{"from another instance"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored chat = %q, want %q", got, want)
+	}
+}
+
+func TestMissingMessages(t *testing.T) {
+	msg := func(seq int64, role domain.RoleEnums, content string) domain.ChatMessage {
+		m := domain.NewChatMessage(role, content)
+		m.Seq = seq
+		return m
+	}
+	turn := []domain.ChatMessage{msg(3, domain.UserRole, "hello"), msg(4, domain.Assistant, "hi")}
+
+	tests := []struct {
+		name string
+		chat []domain.ChatMessage
+		want int
+	}{
+		{name: "nothing saved", chat: []domain.ChatMessage{msg(1, domain.UserRole, "a"), msg(2, domain.Assistant, "b")}, want: 2},
+		{name: "empty history", chat: nil, want: 2},
+		{name: "first message saved", chat: []domain.ChatMessage{msg(2, domain.Assistant, "b"), msg(3, domain.UserRole, "hello")}, want: 1},
+		{name: "whole turn saved", chat: []domain.ChatMessage{msg(3, domain.UserRole, "hello"), msg(4, domain.Assistant, "hi")}, want: 0},
+		{name: "same number, other text", chat: []domain.ChatMessage{msg(3, domain.UserRole, "elsewhere")}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := missingMessages(tt.chat, turn)
+			if len(got) != tt.want {
+				t.Fatalf("missingMessages returned %d messages, want %d", len(got), tt.want)
+			}
+			if tt.want > 0 && got[0].Content != turn[len(turn)-tt.want].Content {
+				t.Errorf("first missing message = %q, want %q", got[0].Content, turn[len(turn)-tt.want].Content)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrEmptyChatHistory возвращается при экспорте, когда в истории текущего персонажа нет сообщений.
+var ErrEmptyChatHistory = errors.New("chat history is empty")
+
+// ChatExport описывает файл с историей чата персонажа, который возвращает ExportChatHistory.
+type ChatExport struct {
+	Character  string               `json:"character"`
+	User       string               `json:"user,omitempty"`
+	ExportedAt time.Time            `json:"exported_at"`
+	Messages   []domain.ChatMessage `json:"messages"`
+}
+
+// ExportChatHistory сериализует историю чата текущего персонажа в JSON и возвращает ее вместе с
+// предлагаемым именем файла вида chat_<имя>_<дата>.json. Плейсхолдеры в сообщениях заменяются
+// именами, если они не отключены для персонажа. Пустая история возвращает ErrEmptyChatHistory.
+func (uc *UserInteractor) ExportChatHistory(ctx context.Context, user *domain.User) ([]byte, string, error) {
+	char := user.GetCurrentCharacter()
+	if len(char.Chat) == 0 {
+		return nil, "", ErrEmptyChatHistory
+	}
+
+	now := time.Now().In(user.Location())
+	export := ChatExport{
+		Character:  char.Name,
+		User:       user.UserName,
+		ExportedAt: now,
+		Messages:   make([]domain.ChatMessage, len(char.Chat)),
+	}
+	for i, msg := range char.Chat {
+		content := msg.Content
+		if char.PlaceholdersEnabled() {
+			content = domain.UnescapePlaceholders(user.ReplacePlaceholders(content))
+		}
+		export.Messages[i] = domain.ChatMessage{Role: msg.Role, Content: content, Meta: msg.Meta}
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode chat history: %w", err)
+	}
+	return data, fmt.Sprintf("chat_%s_%s.json", exportFileName(char.Name), now.Format("2006-01-02")), nil
+}
+
+// exportFileName оставляет в имени персонажа только буквы, цифры, дефисы и подчеркивания для имени файла.
+func exportFileName(name string) string {
+	safe := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' {
+			return r
+		}
+		if r == ' ' {
+			return '_'
+		}
+		return -1
+	}, name)
+	if safe == "" {
+		safe = "character"
+	}
+	return safe
+}
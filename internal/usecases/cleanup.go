@@ -0,0 +1,74 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// CleanupReport описывает результат проверки данных всех пользователей.
+type CleanupReport struct {
+	Checked int                // Сколько пользователей проверено
+	Fixed   map[int64][]string // Исправления по ID пользователя (только для пользователей с ошибками)
+}
+
+// CleanupUser проверяет данные пользователя (см. domain.User.Cleanup), сохраняет исправления
+// и возвращает их описания.
+func (uc *UserInteractor) CleanupUser(ctx context.Context, user *domain.User) ([]string, error) {
+	repairs := user.Cleanup()
+	if len(repairs) == 0 {
+		return nil, nil
+	}
+	cleanup := func(u *domain.User) error {
+		if fixed := u.Cleanup(); len(fixed) > 0 {
+			repairs = fixed // Перечитанного пользователя сохранили с теми же ошибками
+		}
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, cleanup); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, user.ID, AuditCleanup, strings.Join(repairs, "; "))
+	return repairs, nil
+}
+
+// CleanupAllUsers проверяет и исправляет данные всех пользователей в БД. Доступно только администраторам.
+// Исправленные пользователи сохраняются после обхода, чтобы не изменять коллекцию во время чтения.
+func (uc *UserInteractor) CleanupAllUsers(ctx context.Context, admin *domain.User) (CleanupReport, error) {
+	report := CleanupReport{Fixed: make(map[int64][]string)}
+	if !uc.IsAdmin(admin.ID) {
+		return report, ErrNotAdmin
+	}
+	backupRepo, ok := uc.userRepo.(UserBackupRepository)
+	if !ok {
+		return report, ErrBackupUnsupported
+	}
+
+	var fixed []*domain.User
+	err := backupRepo.ForEachUser(ctx, func(user *domain.User) error {
+		report.Checked++
+		if repairs := user.Cleanup(); len(repairs) > 0 {
+			report.Fixed[user.ID] = repairs
+			fixed = append(fixed, user)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("failed to scan users: %w", err)
+	}
+
+	for _, user := range fixed {
+		cleanup := func(u *domain.User) error {
+			u.Cleanup()
+			return nil
+		}
+		if err := uc.updateUser(ctx, user, cleanup); err != nil {
+			return report, fmt.Errorf("failed to save cleaned user %d: %w", user.ID, err)
+		}
+		uc.logger.Info("Cleaned up data of user %d: %s", user.ID, strings.Join(report.Fixed[user.ID], "; "))
+	}
+	uc.audit(ctx, admin.ID, AuditCleanup, fmt.Sprintf("all users: checked %d, fixed %d", report.Checked, len(fixed)))
+	return report, nil
+}
@@ -0,0 +1,44 @@
+package usecases
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestCleanupUserSavesFixes(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	repo.users[7] = []byte(`{"id":7,"user_name":"bob","characters":[{"id":0,"name":"Ann","context_start":5,"note_depth":-1,` +
+		`"chat":[{"role":"user","content":"hi"},{"role":"robot","content":"beep"},{"role":"assistant","content":" "}]}],"version":1}`)
+	uc := newTestInteractor(repo, &stubGateway{}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 7, "bob")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	repairs, err := uc.CleanupUser(ctx, user)
+	if err != nil {
+		t.Fatalf("CleanupUser: %v", err)
+	}
+	want := []string{
+		`character "Ann": removed 1 message(s) with an unknown role`,
+		`character "Ann": removed 1 empty message(s)`,
+		`character "Ann": reset context boundary 5 outside the history`,
+		`character "Ann": reset negative author's note depth -1`,
+	}
+	if !reflect.DeepEqual(repairs, want) {
+		t.Errorf("CleanupUser() = %q, want %q", repairs, want)
+	}
+
+	if got := chatContents(t, repo, 7); !reflect.DeepEqual(got, []string{"hi"}) {
+		t.Errorf("stored chat = %q, want only the valid message", got)
+	}
+	stored, err := uc.GetOrCreateUser(ctx, 7, "bob")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if again, err := uc.CleanupUser(ctx, stored); err != nil || len(again) != 0 {
+		t.Errorf("second CleanupUser() = %q, %v; want no repairs", again, err)
+	}
+}
@@ -0,0 +1,93 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// messageTokenOverhead оценка токенов разметки шаблона чата (роль, разделители) на одно сообщение.
+const messageTokenOverhead = 4
+
+// ErrTokenCountUnsupported возвращается TokenCounter-оберткой, когда обернутые шлюзы не умеют подсчитывать токены.
+var ErrTokenCountUnsupported = errors.New("gateway cannot count tokens")
+
+// TokenCounter может быть реализован ModelGateway, чтобы подсчитать токены сообщений токенизатором модели.
+type TokenCounter interface {
+	CountTokens(ctx context.Context, messages []domain.ChatMessage) (int, error)
+}
+
+// countMessageTokens подсчитывает токены сообщений через шлюз, если он это поддерживает,
+// а иначе (или при ошибке) оценивает их токенизатором UserInteractor.
+func (uc *UserInteractor) countMessageTokens(ctx context.Context, messages []domain.ChatMessage) int {
+	if counter, ok := uc.modelGateway.(TokenCounter); ok {
+		count, err := counter.CountTokens(ctx, messages)
+		if err == nil {
+			return count
+		}
+		if !errors.Is(err, ErrTokenCountUnsupported) {
+			uc.logger.Warn("Failed to count tokens with the model tokenizer, using an estimate: %v", err)
+		}
+	}
+	return uc.estimateMessageTokens(messages)
+}
+
+// estimateMessageTokens оценивает токены сообщений токенизатором UserInteractor.
+func (uc *UserInteractor) estimateMessageTokens(messages []domain.ChatMessage) int {
+	total := 0
+	for _, msg := range messages {
+		total += uc.tokenizer.CountTokens(msg.Content) + messageTokenOverhead
+	}
+	return total
+}
+
+// fitContextWindow удаляет самые старые сообщения разговора (кроме последнего), пока запрос вместе с ответом
+// длиной replyTokens не уложится в MaxContextTokens. Чтобы не подсчитывать токены после каждого удаления,
+// за один шаг удаляется столько сообщений, сколько по оценке занимает превышение, после чего запрос
+// подсчитывается заново. Системные сообщения и реплики примера диалога не удаляются, поэтому слишком
+// длинный промпт может остаться больше лимита - об этом пишется предупреждение.
+func (uc *UserInteractor) fitContextWindow(ctx context.Context, user *domain.User, messages []domain.ChatMessage, replyTokens int) []domain.ChatMessage {
+	if uc.maxContextTokens <= 0 {
+		return messages
+	}
+	budget := uc.maxContextTokens - replyTokens
+	if budget <= 0 {
+		budget = uc.maxContextTokens // Ответ длиннее всего контекста: ограничиваем хотя бы запрос
+	}
+
+	total := uc.countMessageTokens(ctx, messages)
+	before, removed := total, 0
+	for total > budget {
+		estimated := uc.estimateMessageTokens(messages)
+		excess := (total - budget) * estimated / total // Превышение в единицах оценки
+		if excess < 1 {
+			excess = 1
+		}
+
+		kept := make([]domain.ChatMessage, 0, len(messages))
+		for i, msg := range messages {
+			if excess > 0 && i < len(messages)-1 && msg.RoleEnum() != domain.System && !msg.IsExample() {
+				excess -= uc.tokenizer.CountTokens(msg.Content) + messageTokenOverhead
+				removed++
+				continue
+			}
+			kept = append(kept, msg)
+		}
+		if len(kept) == len(messages) {
+			break // Остались только системные сообщения, пример диалога и последнее сообщение
+		}
+		messages = kept
+		total = uc.countMessageTokens(ctx, messages)
+	}
+
+	if removed > 0 {
+		uc.logger.Info("Dropped %d oldest message(s) from the request of user %d to fit the %d-token context: %d -> %d tokens, %d reserved for the reply",
+			removed, user.ID, uc.maxContextTokens, before, total, replyTokens)
+	}
+	if total > budget {
+		uc.logger.Warn("Request of user %d takes %d tokens, over the %d-token budget even without old messages; the system prompt is too long",
+			user.ID, total, budget)
+	}
+	return messages
+}
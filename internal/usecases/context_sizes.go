@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// Tokenizer подсчитывает количество токенов текста для конкретной модели.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// estimateTokenizer оценивает количество токенов как четверть количества символов (с округлением вверх),
+// что близко к типичным BPE-токенизаторам для английского текста.
+type estimateTokenizer struct{}
+
+// CountTokens возвращает оценку количества токенов.
+func (estimateTokenizer) CountTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// SetTokenizer подключает точный токенизатор модели вместо оценки по количеству символов.
+func (uc *UserInteractor) SetTokenizer(tokenizer Tokenizer) {
+	uc.tokenizer = tokenizer
+}
+
+// FieldSize описывает вклад одного поля персонажа в контекст модели.
+type FieldSize struct {
+	Name   string
+	Chars  int
+	Tokens int
+}
+
+// ContextSizes возвращает размеры полей текущего персонажа, передаваемых модели, с плейсхолдерами,
+// замененными так же, как перед отправкой. Последний элемент - итог по всем полям.
+func (uc *UserInteractor) ContextSizes(user *domain.User) []FieldSize {
+	char := user.GetCurrentCharacter()
+	now := time.Now()
+	measure := func(name string, texts ...string) FieldSize {
+		size := FieldSize{Name: name}
+		for _, text := range texts {
+			if char.PlaceholdersEnabled() {
+				text = user.ReplaceTimePlaceholders(text, now)
+				text = domain.UnescapePlaceholders(char.ReplacePlaceholders(user.ReplacePlaceholders(text)))
+			}
+			size.Chars += utf8.RuneCountInString(text)
+			size.Tokens += uc.tokenizer.CountTokens(text)
+		}
+		return size
+	}
+
+	history := char.ContextChat()
+	historyTexts := make([]string, len(history))
+	for i, msg := range history {
+		historyTexts[i] = msg.Content
+	}
+
+	examples := make([]string, len(char.ExampleDialogue))
+	for i, msg := range char.ExampleDialogue {
+		examples[i] = msg.Content
+	}
+
+	sizes := []FieldSize{
+		measure("System rules", char.SystemRules),
+		measure("Persona prompt", char.Prompt),
+		measure("Example dialogue", examples...),
+		measure("Author's note", char.AuthorsNote),
+		measure("Document", char.Document),
+		measure("Previous conversation", char.CarryOver),
+		measure("History", historyTexts...),
+	}
+	total := FieldSize{Name: "Total"}
+	for _, size := range sizes {
+		total.Chars += size.Chars
+		total.Tokens += size.Tokens
+	}
+	return append(sizes, total)
+}
@@ -0,0 +1,173 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrDigestDisabled возвращается, когда ежедневная сводка не включена в настройках бота.
+var ErrDigestDisabled = errors.New("daily digest is disabled")
+
+// ErrInvalidTimezone возвращается, когда название часового пояса не распознано.
+var ErrInvalidTimezone = errors.New("unknown timezone")
+
+// errDigestAlreadySent означает, что перечитанному пользователю сводка за сегодня уже отправлена.
+var errDigestAlreadySent = errors.New("digest already sent")
+
+const (
+	digestDateLayout   = "2006-01-02" // Формат даты последней отправленной сводки
+	digestHistoryLimit = 20           // Сколько последних сообщений передается модели для сводки
+)
+
+// digestInstruction просит модель написать сводку разговора и предложить его продолжить.
+const digestInstruction = "Write a short recap (2-3 sentences) of the conversation so far, staying in character, " +
+	"and end with an invitation for the user to continue it."
+
+// DigestSendFunc отправляет текст сводки пользователю.
+type DigestSendFunc func(ctx context.Context, userID int64, text string) error
+
+// DigestEnabled сообщает, включена ли ежедневная сводка.
+func (uc *UserInteractor) DigestEnabled() bool {
+	return uc.limits.DigestTime >= 0
+}
+
+// SetDigestSubscription подписывает пользователя на ежедневную сводку или отписывает от нее.
+// Непустой timezone (название IANA, например "Europe/Moscow") задает часовой пояс пользователя.
+func (uc *UserInteractor) SetDigestSubscription(ctx context.Context, user *domain.User, enabled bool, timezone string) error {
+	if enabled && !uc.DigestEnabled() {
+		return ErrDigestDisabled
+	}
+	if timezone != "" {
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidTimezone, timezone)
+		}
+	}
+	return uc.updateUser(ctx, user, func(u *domain.User) error {
+		if timezone != "" {
+			u.Timezone = timezone
+		}
+		u.DigestOptIn = enabled
+		return nil
+	})
+}
+
+// SendDueDigests отправляет сводку подписанным пользователям, у которых по их местному времени
+// уже наступило время рассылки, а сегодняшняя сводка еще не отправлялась.
+// Между отправками выдерживается пауза DigestSendInterval. Возвращает количество отправленных сводок.
+func (uc *UserInteractor) SendDueDigests(ctx context.Context, now time.Time, send DigestSendFunc) (int, error) {
+	if !uc.DigestEnabled() {
+		return 0, ErrDigestDisabled
+	}
+	backupRepo, ok := uc.userRepo.(UserBackupRepository)
+	if !ok {
+		return 0, ErrBackupUnsupported
+	}
+
+	// Сначала собираем ID, чтобы не держать курсор открытым во время генерации и отправки
+	var due []int64
+	err := backupRepo.ForEachUser(ctx, func(user *domain.User) error {
+		if uc.digestDue(user, now) {
+			due = append(due, user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list digest subscribers: %w", err)
+	}
+
+	sent := 0
+	for i, userID := range due {
+		if i > 0 && uc.limits.DigestSendInterval > 0 {
+			timer := time.NewTimer(uc.limits.DigestSendInterval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return sent, ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err := uc.sendDigest(ctx, userID, now, send); err != nil {
+			uc.logger.Error("Failed to send digest to user %d: %v", userID, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// sendDigest формирует и отправляет сводку одному пользователю. Дата отправки сохраняется до отправки,
+// поэтому при сбое доставки сводка не дублируется.
+func (uc *UserInteractor) sendDigest(ctx context.Context, userID int64, now time.Time, send DigestSendFunc) error {
+	user, err := uc.loadUser(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if user == nil || !uc.digestDue(user, now) {
+		return nil
+	}
+
+	text := uc.BuildDigest(ctx, user)
+	markSent := func(u *domain.User) error {
+		if !uc.digestDue(u, now) {
+			return errDigestAlreadySent
+		}
+		u.LastDigestDate = now.In(u.Location()).Format(digestDateLayout)
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, markSent); errors.Is(err, errDigestAlreadySent) {
+		return nil // Сводку уже отправил другой экземпляр бота, пока эта формировалась
+	} else if err != nil {
+		return fmt.Errorf("failed to save digest date: %w", err)
+	}
+	return send(ctx, user.ID, text)
+}
+
+// digestDue сообщает, нужно ли сейчас отправить сводку пользователю.
+func (uc *UserInteractor) digestDue(user *domain.User, now time.Time) bool {
+	if !user.DigestOptIn {
+		return false
+	}
+	local := now.In(user.Location())
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	return local.Sub(midnight) >= uc.limits.DigestTime && user.LastDigestDate != local.Format(digestDateLayout)
+}
+
+// BuildDigest возвращает текст сводки разговора с текущим персонажем.
+// Если истории нет или модель недоступна, возвращается простое напоминание.
+func (uc *UserInteractor) BuildDigest(ctx context.Context, user *domain.User) string {
+	char := user.GetCurrentCharacter()
+	reminder := fmt.Sprintf("Your conversation with %s is waiting for you. Send a message to continue.", char.Name)
+	if len(char.Chat) == 0 {
+		return reminder
+	}
+
+	recent := char.Clone()
+	recent.Chat, recent.ContextStart = recent.ContextChat(), 0
+	if len(recent.Chat) == 0 {
+		return reminder
+	}
+	if len(recent.Chat) > digestHistoryLimit {
+		recent.Chat = recent.Chat[len(recent.Chat)-digestHistoryLimit:]
+	}
+	messages := uc.applyPlaceholdersToMessages(recent.GetChatMessagesForModel(uc.promptPosition), user)
+	messages = append(messages, domain.NewChatMessage(domain.System, digestInstruction))
+
+	// Сводки отправляются в фоне, но делят с сообщениями пользователей общий лимит одновременных генераций
+	release, err := uc.acquireGenerationSlot(ctx)
+	if err != nil {
+		uc.logger.Warn("No generation slot for the digest of user %d, sending a reminder: %v", user.ID, err)
+		return reminder
+	}
+	defer release()
+
+	recap, err := uc.requestResponse(ctx, user, messages, uc.GetModelConfig(user))
+	if err != nil {
+		uc.logger.Warn("Failed to generate digest for user %d, sending a reminder: %v", user.ID, err)
+		return reminder
+	}
+	return recap
+}
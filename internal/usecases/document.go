@@ -0,0 +1,152 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrNotTextDocument возвращается, если прикрепляемый файл не является текстом в UTF-8.
+var ErrNotTextDocument = errors.New("the file is not a UTF-8 text document")
+
+// documentChunkTokens размер части документа, которая отправляется модели для краткого изложения.
+const documentChunkTokens = 3000
+
+// documentSummaryInstruction инструкция для краткого изложения части документа.
+const documentSummaryInstruction = "Summarize the following part of a document the user wants to discuss. " +
+	"Keep names, numbers, definitions and other facts that may be asked about later. Reply with the summary only."
+
+// AttachDocument прикрепляет текстовый документ к текущему персонажу. Документ, который помещается
+// в бюджет DocumentContextTokens, сохраняется целиком, а более длинный кратко излагается моделью по частям.
+// Возвращает true, если сохранено краткое изложение.
+func (uc *UserInteractor) AttachDocument(ctx context.Context, user *domain.User, name string, data []byte) (bool, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // BOM
+	if !utf8.Valid(data) || bytes.IndexByte(data, 0) != -1 {
+		return false, ErrNotTextDocument
+	}
+	text := strings.TrimSpace(strings.ReplaceAll(string(data), "\r\n", "\n"))
+	if text == "" {
+		return false, fmt.Errorf("%w: the file is empty", ErrNotTextDocument)
+	}
+
+	charID := user.GetCurrentCharacter().ID // Изложение может занять время, а документ относится к этому персонажу
+	summarized := false
+	if uc.tokenizer.CountTokens(text) > uc.documentContextTokens {
+		summary, err := uc.summarizeDocument(ctx, user, text)
+		if err != nil {
+			return false, err
+		}
+		text, summarized = summary, true
+	}
+
+	if err := uc.setDocument(ctx, user, charID, text, name); err != nil {
+		return false, err
+	}
+	uc.audit(ctx, user.ID, AuditAttachDocument, fmt.Sprintf("character id=%d: %s (summarized: %t)", charID, name, summarized))
+	return summarized, nil
+}
+
+// DetachDocument удаляет прикрепленный документ текущего персонажа.
+func (uc *UserInteractor) DetachDocument(ctx context.Context, user *domain.User) error {
+	charID := user.GetCurrentCharacter().ID
+	if err := uc.setDocument(ctx, user, charID, "", ""); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditAttachDocument, fmt.Sprintf("character id=%d: detached", charID))
+	return nil
+}
+
+// setDocument сохраняет документ text с именем name у текущего персонажа charID; пустой текст удаляет документ.
+func (uc *UserInteractor) setDocument(ctx context.Context, user *domain.User, charID int, text, name string) error {
+	return uc.updateUser(ctx, user, func(u *domain.User) error {
+		char, err := currentCharacterByID(u, charID)
+		if err != nil {
+			return err
+		}
+		char.Document, char.DocumentName = text, name
+		char.Touch()
+		return nil
+	})
+}
+
+// summarizeDocument кратко излагает документ по частям и обрезает итог до бюджета документа.
+func (uc *UserInteractor) summarizeDocument(ctx context.Context, user *domain.User, text string) (string, error) {
+	if err := uc.checkRateLimit(ctx, user); err != nil {
+		return "", err
+	}
+	release, err := uc.acquireGenerationSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	modelConfig := uc.GetModelConfig(user)
+	modelConfig.JSONMode = false
+	chunks := splitDocument(text, documentChunkTokens, uc.tokenizer)
+	summaries := make([]string, 0, len(chunks))
+	for i, chunk := range chunks {
+		messages := []domain.ChatMessage{
+			domain.NewChatMessage(domain.System, documentSummaryInstruction),
+			domain.NewChatMessage(domain.UserRole, chunk),
+		}
+		summary, err := uc.requestResponse(ctx, user, messages, modelConfig)
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize document part %d of %d: %w", i+1, len(chunks), err)
+		}
+		summaries = append(summaries, strings.TrimSpace(summary))
+	}
+	uc.logger.Info("Summarized a document of %d part(s) for user %d", len(chunks), user.ID)
+	return truncateToTokens(strings.Join(summaries, "\n\n"), uc.documentContextTokens, uc.tokenizer), nil
+}
+
+// splitDocument делит текст на части не длиннее maxTokens, по возможности по границам абзацев.
+func splitDocument(text string, maxTokens int, tokenizer Tokenizer) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+		}
+	}
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		// Слишком длинный абзац режется на части без учета границ
+		for tokenizer.CountTokens(paragraph) > maxTokens {
+			flush()
+			head := truncateToTokens(paragraph, maxTokens, tokenizer)
+			chunks = append(chunks, head)
+			paragraph = paragraph[len(head):]
+		}
+		if current.Len() > 0 && tokenizer.CountTokens(current.String()+"\n\n"+paragraph) > maxTokens {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(paragraph)
+	}
+	flush()
+	return chunks
+}
+
+// truncateToTokens обрезает текст по границе символа так, чтобы он занимал не больше maxTokens.
+func truncateToTokens(text string, maxTokens int, tokenizer Tokenizer) string {
+	runes := []rune(text)
+	for len(runes) > 0 {
+		tokens := tokenizer.CountTokens(string(runes))
+		if tokens <= maxTokens {
+			break
+		}
+		cut := len(runes) * maxTokens / tokens
+		if cut >= len(runes) {
+			cut = len(runes) - 1
+		}
+		runes = runes[:cut]
+	}
+	return string(runes)
+}
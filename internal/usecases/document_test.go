@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAttachDocument(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		wantDocument   string
+		wantSummarized bool
+		wantRequests   int
+		wantErr        error
+	}{
+		{name: "small document kept whole", data: "\xef\xbb\xbfMeeting at 5 pm.\r\nBring slides.", wantDocument: "Meeting at 5 pm.\nBring slides."},
+		{name: "long document summarized", data: strings.Repeat("Long paragraph. ", 20), wantDocument: "Summary.", wantSummarized: true, wantRequests: 1},
+		{name: "binary file", data: "PNG\x00\x01", wantErr: ErrNotTextDocument},
+		{name: "empty file", data: " \n ", wantErr: ErrNotTextDocument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			gateway := &stubGateway{responses: []string{"Summary."}}
+			uc := newTestInteractor(repo, gateway, func(opts *UserInteractorOptions) {
+				opts.DocumentContextTokens = 20 // Около 80 символов
+			})
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+
+			summarized, err := uc.AttachDocument(ctx, user, "notes.txt", []byte(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AttachDocument error = %v, want %v", err, tt.wantErr)
+			}
+			if summarized != tt.wantSummarized || len(gateway.requests) != tt.wantRequests {
+				t.Errorf("summarized = %v after %d model requests, want %v after %d", summarized, len(gateway.requests), tt.wantSummarized, tt.wantRequests)
+			}
+			stored, err := repo.LoadUser(ctx, 1)
+			if err != nil {
+				t.Fatalf("LoadUser: %v", err)
+			}
+			if got := stored.GetCurrentCharacter().Document; got != tt.wantDocument {
+				t.Errorf("stored document = %q, want %q", got, tt.wantDocument)
+			}
+		})
+	}
+}
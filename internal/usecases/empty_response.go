@@ -0,0 +1,61 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrEmptyResponse возвращается, когда модель дважды подряд вернула пустой или бессодержательный ответ.
+var ErrEmptyResponse = errors.New("the model returned an empty reply")
+
+// isTrivialResponse сообщает, что ответ пустой, состоит только из пробелов и знаков препинания
+// или короче minLength символов.
+func isTrivialResponse(response string, minLength int) bool {
+	trimmed := strings.TrimSpace(response)
+	if utf8.RuneCountInString(trimmed) < minLength {
+		return true
+	}
+	return strings.IndexFunc(trimmed, func(r rune) bool {
+		return !unicode.IsSpace(r) && !unicode.IsPunct(r)
+	}) == -1
+}
+
+// requestResponse запрашивает ответ модели и один раз повторяет запрос, если ответ оказался пустым.
+// Если и повторный ответ пустой, возвращается ErrEmptyResponse.
+// В режиме JSON запрос дополняется инструкцией отвечать JSON, а ответ, не являющийся корректным JSON,
+// также запрашивается повторно; если и повторный ответ некорректен, он возвращается как есть.
+// Если в контексте задан получатель частичного ответа (WithStreamHandler), ответ передается ему по частям.
+// Если поток прервался, вместе с ошибкой возвращается уже полученная часть ответа.
+func (uc *UserInteractor) requestResponse(ctx context.Context, user *domain.User, messages []domain.ChatMessage, modelConfig ModelConfig) (string, error) {
+	messages = withJSONInstruction(messages, modelConfig)
+	ctx = uc.recordRequests(ctx, user.ID)
+	for attempt := 0; ; attempt++ {
+		response, err := uc.getModelResponse(ctx, messages, modelConfig)
+		if err != nil {
+			return response, err
+		}
+		if isTrivialResponse(response, uc.minReplyLength) {
+			if attempt > 0 {
+				return "", ErrEmptyResponse
+			}
+			uc.logger.Warn("Model returned an empty reply %q for user %d, retrying once", response, user.ID)
+			continue
+		}
+		if !modelConfig.JSONMode {
+			return response, nil
+		}
+		if extracted, valid := extractJSON(response); valid {
+			return extracted, nil
+		}
+		if attempt > 0 {
+			uc.logger.Warn("Model returned invalid JSON twice for user %d, sending the reply as is", user.ID)
+			return response, nil
+		}
+		uc.logger.Warn("Model returned invalid JSON for user %d, retrying once", user.ID)
+	}
+}
@@ -0,0 +1,127 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestIsTrivialResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		minLength int
+		want      bool
+	}{
+		{name: "empty", response: "", minLength: 1, want: true},
+		{name: "whitespace", response: " \n\t ", minLength: 1, want: true},
+		{name: "punctuation only", response: "...", minLength: 1, want: true},
+		{name: "punctuation and spaces", response: " ?! … — ", minLength: 1, want: true},
+		{name: "single letter", response: "a", minLength: 1, want: false},
+		{name: "emoji", response: "🙂", minLength: 1, want: false},
+		{name: "normal reply", response: "Hello there.", minLength: 1, want: false},
+		{name: "shorter than the minimum", response: "Hi.", minLength: 5, want: true},
+		{name: "minimum counts characters, not bytes", response: "Привет", minLength: 6, want: false},
+		{name: "surrounding spaces are not counted", response: "  Hi  ", minLength: 3, want: true},
+		{name: "no minimum", response: "", minLength: 0, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTrivialResponse(tt.response, tt.minLength); got != tt.want {
+				t.Errorf("isTrivialResponse(%q, %d) = %v, want %v", tt.response, tt.minLength, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyResponseIsRetriedOnce(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []string
+		minLength    int
+		want         string
+		wantErr      error
+		wantRequests int
+	}{
+		{name: "first reply is fine", responses: []string{"Hello!"}, minLength: 1, want: "Hello!", wantRequests: 1},
+		{name: "empty then fine", responses: []string{"", "Hello!"}, minLength: 1, want: "Hello!", wantRequests: 2},
+		{name: "punctuation then fine", responses: []string{"...", "Hello!"}, minLength: 1, want: "Hello!", wantRequests: 2},
+		{name: "empty twice", responses: []string{"", "  "}, minLength: 1, wantErr: ErrEmptyResponse, wantRequests: 2},
+		{name: "too short twice", responses: []string{"Ok", "Hm."}, minLength: 5, wantErr: ErrEmptyResponse, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			gateway := &stubGateway{responses: tt.responses}
+			uc := newTestInteractor(repo, gateway, func(opts *UserInteractorOptions) {
+				opts.MinReplyLength = tt.minLength
+			})
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+
+			response, err := uc.GetModelResponseForUser(ctx, user, "hello")
+			if len(gateway.requests) != tt.wantRequests {
+				t.Errorf("model was asked %d times, want %d", len(gateway.requests), tt.wantRequests)
+			}
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				if chat := chatContents(t, repo, 1); len(chat) != 0 {
+					t.Errorf("stored chat = %q, want the unanswered message removed", chat)
+				}
+				return
+			}
+			last := lastMessage(t, repo, 1)
+			if err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+			if response != tt.want || last.Content != tt.want {
+				t.Errorf("response = %q, stored %q, want %q", response, last.Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmptyResponseLeavesNoUnansweredMessage(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	gateway := &stubGateway{responses: []string{"Hi!", "", "", "Fine, thanks."}}
+	uc := newTestInteractor(repo, gateway, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	if _, err := uc.GetModelResponseForUser(ctx, user, "hello"); err != nil {
+		t.Fatalf("first message: %v", err)
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "how are you?"); !errors.Is(err, ErrEmptyResponse) {
+		t.Fatalf("second message error = %v, want ErrEmptyResponse", err)
+	}
+	want := []string{"hello", "Hi!"}
+	if got := chatContents(t, repo, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("history after an empty reply = %q, want %q", got, want)
+	}
+
+	// Пользователь повторяет вопрос: модель получает его один раз, после ответа персонажа
+	if _, err := uc.GetModelResponseForUser(ctx, user, "how are you, then?"); err != nil {
+		t.Fatalf("third message: %v", err)
+	}
+	want = []string{"hello", "Hi!", "how are you, then?", "Fine, thanks."}
+	if got := chatContents(t, repo, 1); !reflect.DeepEqual(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+	request := gateway.requests[len(gateway.requests)-1]
+	for i := 1; i < len(request); i++ {
+		if request[i].Role == domain.UserRole.String() && request[i-1].Role == domain.UserRole.String() {
+			t.Errorf("request has two user messages in a row: %q, %q", request[i-1].Content, request[i].Content)
+		}
+	}
+}
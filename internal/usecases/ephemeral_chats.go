@@ -0,0 +1,113 @@
+package usecases
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ephemeralChatStore хранит в памяти историю эфемерных персонажей (CharacterPreset.Ephemeral), которая
+// не записывается в хранилище. Помнятся не более limit недавно активных пользователей; история пользователя,
+// не сохранявшегося и не загружавшегося дольше ttl, забывается. Так память не растет с числом пользователей,
+// а эфемерный разговор, как и при перезапуске бота, теряется после долгого перерыва.
+type ephemeralChatStore struct {
+	mu    sync.Mutex
+	limit int           // Максимум пользователей (0 - без ограничений)
+	ttl   time.Duration // Время жизни истории без обращений (0 - без ограничений)
+	now   func() time.Time
+	order *list.List              // Пользователи от недавно активных к давно неактивным
+	users map[int64]*list.Element // Элементы order по ID пользователя
+}
+
+// ephemeralChatEntry история эфемерных персонажей одного пользователя по ID персонажа.
+type ephemeralChatEntry struct {
+	userID int64
+	chats  map[int][]domain.ChatMessage
+	used   time.Time // Последнее сохранение или загрузка пользователя
+}
+
+// newEphemeralChatStore создает хранилище истории эфемерных персонажей не более чем limit пользователей.
+func newEphemeralChatStore(limit int, ttl time.Duration) *ephemeralChatStore {
+	return &ephemeralChatStore{limit: limit, ttl: ttl, now: time.Now, order: list.New(), users: make(map[int64]*list.Element)}
+}
+
+// remember запоминает историю эфемерных персонажей сохраненного пользователя, заменяя прежнюю.
+// История персонажей, которые удалены или перестали быть эфемерными, забывается.
+func (s *ephemeralChatStore) remember(user *domain.User) {
+	chats := make(map[int][]domain.ChatMessage)
+	for _, char := range user.Characters {
+		if char != nil && char.Ephemeral {
+			chats[char.ID] = append([]domain.ChatMessage(nil), char.Chat...)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(chats) == 0 {
+		s.remove(user.ID)
+		return
+	}
+	now := s.now()
+	if elem, ok := s.users[user.ID]; ok {
+		entry := elem.Value.(*ephemeralChatEntry)
+		entry.chats, entry.used = chats, now
+		s.order.MoveToFront(elem)
+	} else {
+		s.users[user.ID] = s.order.PushFront(&ephemeralChatEntry{userID: user.ID, chats: chats, used: now})
+	}
+	s.evict(now)
+}
+
+// restore подставляет запомненную историю эфемерных персонажей загруженного пользователя.
+func (s *ephemeralChatStore) restore(user *domain.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.evict(now)
+	elem, ok := s.users[user.ID]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*ephemeralChatEntry)
+	entry.used = now
+	s.order.MoveToFront(elem)
+	for _, char := range user.Characters {
+		if char == nil || !char.Ephemeral {
+			continue
+		}
+		if chat, ok := entry.chats[char.ID]; ok {
+			char.Chat = append([]domain.ChatMessage(nil), chat...)
+		}
+	}
+}
+
+// forget забывает историю эфемерных персонажей пользователя.
+func (s *ephemeralChatStore) forget(userID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(userID)
+}
+
+// remove удаляет пользователя из хранилища. Вызывается под s.mu.
+func (s *ephemeralChatStore) remove(userID int64) {
+	if elem, ok := s.users[userID]; ok {
+		s.order.Remove(elem)
+		delete(s.users, userID)
+	}
+}
+
+// evict забывает пользователей сверх limit и неактивных дольше ttl, начиная с давно неактивных.
+// Вызывается под s.mu.
+func (s *ephemeralChatStore) evict(now time.Time) {
+	for elem := s.order.Back(); elem != nil; elem = s.order.Back() {
+		entry := elem.Value.(*ephemeralChatEntry)
+		overLimit := s.limit > 0 && s.order.Len() > s.limit
+		expired := s.ttl > 0 && now.Sub(entry.used) > s.ttl
+		if !overLimit && !expired {
+			return
+		}
+		s.remove(entry.userID)
+	}
+}
@@ -0,0 +1,116 @@
+package usecases
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestEphemeralChatKeptInMemoryOnly(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{responses: []string{"Hi!"}}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if err := uc.UpdateUserProperty(ctx, user, "Ephemeral", "on"); err != nil {
+		t.Fatalf("UpdateUserProperty: %v", err)
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "hello"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+
+	if chat := chatContents(t, repo, 1); len(chat) != 0 {
+		t.Errorf("stored chat = %q, want the ephemeral chat kept out of the repository", chat)
+	}
+	reloaded, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	var contents []string
+	for _, msg := range reloaded.GetCurrentCharacter().Chat {
+		contents = append(contents, msg.Content)
+	}
+	if want := []string{"hello", "Hi!"}; !reflect.DeepEqual(contents, want) {
+		t.Errorf("reloaded chat = %q, want %q", contents, want)
+	}
+
+	// Новый экземпляр (перезапуск бота) эфемерной истории не видит
+	restarted := newTestInteractor(repo, &stubGateway{}, nil)
+	fresh, err := restarted.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if chat := fresh.GetCurrentCharacter().Chat; len(chat) != 0 {
+		t.Errorf("chat after restart = %+v, want empty", chat)
+	}
+
+	if err := uc.ForgetUser(ctx, reloaded); err != nil {
+		t.Fatalf("ForgetUser: %v", err)
+	}
+	again, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	again.GetCurrentCharacter().Ephemeral = true
+	uc.ephemeralChats.restore(again)
+	if chat := again.GetCurrentCharacter().Chat; len(chat) != 0 {
+		t.Errorf("chat after ForgetUser = %+v, want empty", chat)
+	}
+}
+
+func TestEphemeralChatStoreBounds(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	store := newEphemeralChatStore(2, time.Hour)
+	store.now = func() time.Time { return now }
+
+	ephemeralUser := func(id int64, text string) *domain.User {
+		user := domain.NewUser(id, "user")
+		char := user.GetCurrentCharacter()
+		char.Ephemeral = true
+		char.Chat = []domain.ChatMessage{{Role: domain.UserRole.String(), Content: text}}
+		return user
+	}
+	restored := func(id int64) []domain.ChatMessage {
+		user := ephemeralUser(id, "")
+		user.GetCurrentCharacter().Chat = nil
+		store.restore(user)
+		return user.GetCurrentCharacter().Chat
+	}
+
+	store.remember(ephemeralUser(1, "one"))
+	store.remember(ephemeralUser(2, "two"))
+	if chat := restored(1); len(chat) != 1 || chat[0].Content != "one" {
+		t.Fatalf("user 1 chat = %+v, want the remembered message", chat)
+	}
+	store.remember(ephemeralUser(3, "three")) // Пользователь 2 давно неактивен и вытесняется
+	if chat := restored(2); chat != nil {
+		t.Errorf("user 2 chat = %+v, want evicted over the limit", chat)
+	}
+	if chat := restored(1); len(chat) != 1 {
+		t.Errorf("user 1 chat = %+v, want kept as recently used", chat)
+	}
+
+	now = now.Add(30 * time.Minute)
+	if chat := restored(3); len(chat) != 1 {
+		t.Errorf("user 3 chat = %+v, want kept within the TTL", chat)
+	}
+	now = now.Add(45 * time.Minute) // Пользователь 1 неактивен 75 минут, пользователь 3 - 45
+	if chat := restored(1); chat != nil {
+		t.Errorf("user 1 chat = %+v, want expired after the TTL", chat)
+	}
+	if chat := restored(3); len(chat) != 1 {
+		t.Errorf("user 3 chat = %+v, want kept within the TTL", chat)
+	}
+
+	notEphemeral := ephemeralUser(3, "")
+	notEphemeral.GetCurrentCharacter().Ephemeral = false
+	store.remember(notEphemeral)
+	if chat := restored(3); chat != nil {
+		t.Errorf("user 3 chat = %+v, want forgotten once the character is no longer ephemeral", chat)
+	}
+}
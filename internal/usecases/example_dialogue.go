@@ -0,0 +1,93 @@
+package usecases
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// Метки реплик во вводе примера диалога, как в поле mes_example карточек TavernAI.
+const (
+	exampleUserLabel  = "{{user}}:"
+	exampleCharLabel  = "{{char}}:"
+	exampleBlockStart = "<START>" // Разделитель примеров в mes_example
+)
+
+// examplePair пара реплик примера диалога.
+type examplePair struct {
+	user, char string
+}
+
+// parseExampleDialogue разбирает пример диалога вида
+//
+//	{{user}}: реплика пользователя
+//	{{char}}: ответ персонажа
+//
+// Реплика продолжается до следующей строки с меткой, поэтому может занимать несколько строк.
+// Строки <START> разделяют примеры и пропускаются. Реплика пользователя без ответа персонажа, как и
+// несколько реплик одной стороны подряд, считается ошибкой, как и текст перед первой меткой.
+func parseExampleDialogue(text string) ([]examplePair, error) {
+	errUnanswered := fmt.Errorf("%w: each %q line must be followed by a %q line", ErrInvalidParameters, exampleUserLabel, exampleCharLabel)
+	var pairs []examplePair
+	var target *string    // Реплика, которую продолжают строки без метки (nil - вне реплики)
+	awaitingChar := false // Последняя реплика пользователя еще без ответа персонажа
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == exampleBlockStart:
+			if awaitingChar {
+				return nil, errUnanswered
+			}
+			target = nil
+		case strings.HasPrefix(trimmed, exampleUserLabel):
+			if awaitingChar {
+				return nil, errUnanswered
+			}
+			pairs = append(pairs, examplePair{user: trimmed[len(exampleUserLabel):]})
+			target, awaitingChar = &pairs[len(pairs)-1].user, true
+		case strings.HasPrefix(trimmed, exampleCharLabel):
+			if !awaitingChar {
+				return nil, fmt.Errorf("%w: each %q line must follow a %q line", ErrInvalidParameters, exampleCharLabel, exampleUserLabel)
+			}
+			pairs[len(pairs)-1].char = trimmed[len(exampleCharLabel):]
+			target, awaitingChar = &pairs[len(pairs)-1].char, false
+		case target != nil:
+			*target += "\n" + line
+		case trimmed != "":
+			return nil, fmt.Errorf("%w: the example must start with %q", ErrInvalidParameters, exampleUserLabel)
+		}
+	}
+	if awaitingChar {
+		return nil, errUnanswered
+	}
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("%w: expected a %q line followed by a %q line", ErrInvalidParameters, exampleUserLabel, exampleCharLabel)
+	}
+	for i := range pairs {
+		pairs[i].user, pairs[i].char = strings.TrimSpace(pairs[i].user), strings.TrimSpace(pairs[i].char)
+		if pairs[i].user == "" || pairs[i].char == "" {
+			return nil, fmt.Errorf("%w: example lines must not be empty", ErrInvalidParameters)
+		}
+	}
+	return pairs, nil
+}
+
+// formatExampleDialogue записывает пример диалога персонажа в формате mes_example карточек TavernAI:
+// каждая пара реплик - отдельный блок <START>.
+func formatExampleDialogue(pairs []examplePair) string {
+	blocks := make([]string, len(pairs))
+	for i, pair := range pairs {
+		blocks[i] = exampleBlockStart + "\n" + exampleUserLabel + " " + pair.user + "\n" + exampleCharLabel + " " + pair.char
+	}
+	return strings.Join(blocks, "\n")
+}
+
+// characterExamplePairs возвращает пример диалога персонажа парами реплик.
+func characterExamplePairs(char *domain.CharacterPreset) []examplePair {
+	pairs := make([]examplePair, 0, char.ExamplePairs())
+	for i := 0; i+1 < len(char.ExampleDialogue); i += 2 {
+		pairs = append(pairs, examplePair{user: char.ExampleDialogue[i].Content, char: char.ExampleDialogue[i+1].Content})
+	}
+	return pairs
+}
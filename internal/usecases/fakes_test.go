@@ -0,0 +1,184 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
+)
+
+// memoryRepository хранит пользователей в памяти в сериализованном виде, как настоящее хранилище:
+// загруженный пользователь не разделяет данные с сохраненным. Версия документа проверяется так же,
+// как в MongoDbRepository, а при отмененном контексте запись не выполняется. История эфемерных персонажей,
+// как и в MongoDbRepository, не записывается.
+type memoryRepository struct {
+	mu    sync.Mutex
+	users map[int64][]byte
+
+	saveErrors []error // Ошибки, которые вернут следующие вызовы SaveUser (nil - сохранить)
+	saves      int     // Количество успешных сохранений
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{users: make(map[int64][]byte)}
+}
+
+func (r *memoryRepository) SaveUser(ctx context.Context, user *domain.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.saveErrors) > 0 {
+		err := r.saveErrors[0]
+		r.saveErrors = r.saveErrors[1:]
+		if err != nil {
+			return err
+		}
+	}
+	return r.store(user)
+}
+
+// store записывает пользователя с проверкой версии. Вызывается под r.mu.
+func (r *memoryRepository) store(user *domain.User) error {
+	if stored, ok := r.users[user.ID]; ok {
+		var current domain.User
+		if err := json.Unmarshal(stored, &current); err != nil {
+			return err
+		}
+		if current.Version != user.Version {
+			return ErrVersionConflict
+		}
+	}
+	user.Version++
+	persisted := *user
+	persisted.Characters = make([]*domain.CharacterPreset, len(user.Characters))
+	for i, char := range user.Characters {
+		charCopy := *char
+		if charCopy.Ephemeral {
+			charCopy.Chat = nil
+		}
+		persisted.Characters[i] = &charCopy
+	}
+	data, err := json.Marshal(&persisted)
+	if err != nil {
+		user.Version--
+		return err
+	}
+	r.users[user.ID] = data
+	r.saves++
+	return nil
+}
+
+func (r *memoryRepository) LoadUser(ctx context.Context, userID int64) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.users[userID]
+	if !ok {
+		return nil, nil
+	}
+	var user domain.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *memoryRepository) LoadUserWithRecentHistory(ctx context.Context, userID int64, limit int) (*domain.User, error) {
+	user, err := r.LoadUser(ctx, userID)
+	if err != nil || user == nil {
+		return user, err
+	}
+	for _, char := range user.Characters {
+		if len(char.Chat) > limit {
+			char.Chat = char.Chat[len(char.Chat)-limit:]
+		}
+	}
+	return user, nil
+}
+
+func (r *memoryRepository) AddChatMessage(ctx context.Context, userID int64, characterIndex int, message domain.ChatMessage) error {
+	user, err := r.LoadUser(ctx, userID)
+	if err != nil || user == nil {
+		return err
+	}
+	user.Characters[characterIndex].AppendMessages(message)
+	return r.SaveUser(ctx, user)
+}
+
+func (r *memoryRepository) CountMessages(ctx context.Context, userID int64) (int, error) {
+	user, err := r.LoadUser(ctx, userID)
+	if err != nil || user == nil {
+		return 0, err
+	}
+	count := 0
+	for _, char := range user.Characters {
+		count += len(char.Chat)
+	}
+	return count, nil
+}
+
+func (r *memoryRepository) DeleteUser(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.users, userID)
+	return nil
+}
+
+// appendingRepository дополнительно реализует ChatAppender.
+type appendingRepository struct {
+	*memoryRepository
+	appends int // Количество успешных дописываний
+}
+
+func (r *appendingRepository) AppendChatMessages(ctx context.Context, user *domain.User, characterIndex int, messages []domain.ChatMessage, limit int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.store(user); err != nil {
+		return err
+	}
+	r.appends++
+	return nil
+}
+
+// stubGateway возвращает заранее заданные ответы по очереди (последний повторяется) и запоминает запросы.
+type stubGateway struct {
+	mu        sync.Mutex
+	responses []string
+	errs      []error
+	requests  [][]domain.ChatMessage
+}
+
+func (g *stubGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config ModelConfig) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests = append(g.requests, messages)
+	i := len(g.requests) - 1
+	var err error
+	if i < len(g.errs) {
+		err = g.errs[i]
+	}
+	if len(g.responses) == 0 {
+		return "", err
+	}
+	if i >= len(g.responses) {
+		i = len(g.responses) - 1
+	}
+	return g.responses[i], err
+}
+
+// newTestInteractor создает UserInteractor с настройками по умолчанию и без вывода логов.
+func newTestInteractor(repo UserRepository, gateway ModelGateway, configure func(*UserInteractorOptions)) *UserInteractor {
+	opts := DefaultUserInteractorOptions()
+	opts.QueueTimeout = 0
+	if configure != nil {
+		configure(&opts)
+	}
+	return NewUserInteractorWithOptions(repo, gateway, logger.NewWriterLogger(io.Discard, logger.None), opts)
+}
@@ -0,0 +1,103 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+const (
+	historySummaryTokens      = 500  // Максимальный размер краткого изложения ранней части разговора в токенах
+	historySummaryChunkTokens = 3000 // Максимальный объем реплик, излагаемых одним запросом к модели
+
+	// historySummaryBatchDivisor определяет запас, с которым обрезается история при изложении: кроме превышения
+	// удаляется еще 1/historySummaryBatchDivisor лимита, чтобы изложение обновлялось раз в несколько ходов,
+	// а не на каждом сообщении.
+	historySummaryBatchDivisor = 4
+)
+
+// historySummaryInstruction инструкция для краткого изложения удаляемой части разговора.
+const historySummaryInstruction = "You maintain a running summary of a long conversation that no longer fits in memory. " +
+	"Merge the existing summary (if any) with the new messages into one updated summary in the third person. " +
+	"Keep names, facts, decisions, promises, relationships and open plot threads; drop small talk. " +
+	"Reply with the summary only."
+
+// SummarizeOldHistory заменяет сообщения текущего персонажа charIndex, выходящие за лимит истории, кратким изложением,
+// если у персонажа включен SummarizeHistory. Изложение объединяется с ранее накопленным (HistorySummary),
+// а удаляемые реплики отправляются модели частями не длиннее historySummaryChunkTokens, поэтому ни один
+// запрос не превышает контекст независимо от длины разговора. Возвращает true, если история была обрезана
+// и пользователя нужно сохранить целиком. При ошибке модели история не изменяется.
+func (uc *UserInteractor) SummarizeOldHistory(ctx context.Context, user *domain.User, charIndex int) (bool, error) {
+	if charIndex < 0 || charIndex >= len(user.Characters) || uc.chatHistoryLimit <= 0 {
+		return false, nil
+	}
+	char := user.Characters[charIndex]
+	if !char.SummarizeHistory || len(char.Chat) <= uc.chatHistoryLimit {
+		return false, nil
+	}
+
+	removed := len(char.Chat) - uc.chatHistoryLimit + uc.chatHistoryLimit/historySummaryBatchDivisor
+	if removed > len(char.Chat)-1 {
+		removed = len(char.Chat) - 1 // Последнее сообщение пользователя остается в истории
+	}
+	// Сообщения до границы контекста (/clearcontext) модели уже не передаются и не излагаются
+	from := char.ContextStart
+	if from < 0 {
+		from = 0
+	}
+	if from >= removed {
+		return false, nil
+	}
+
+	userName := user.UserName
+	if strings.TrimSpace(userName) == "" {
+		userName = "User"
+	}
+	var transcript strings.Builder
+	for _, msg := range char.Chat[from:removed] {
+		switch msg.RoleEnum() {
+		case domain.UserRole:
+			transcript.WriteString(userName + ": ")
+		case domain.Assistant:
+			transcript.WriteString(char.Name + ": ")
+		}
+		transcript.WriteString(replaceCharacterPlaceholders(user, msg.Content))
+		transcript.WriteString("\n\n")
+	}
+
+	// Изложение не должно попадать в сообщение с частичным ответом и не подчиняется режиму JSON
+	ctx = withoutStreamHandler(ctx)
+	modelConfig := uc.GetModelConfig(user)
+	modelConfig.JSONMode = false
+	modelConfig.StopSequences = nil
+	modelConfig.MaxTokens = historySummaryTokens
+
+	summary := char.HistorySummary
+	chunks := splitDocument(strings.TrimSpace(transcript.String()), historySummaryChunkTokens, uc.tokenizer)
+	for i, chunk := range chunks {
+		request := "New messages:\n\n" + chunk
+		if summary != "" {
+			request = "Existing summary:\n\n" + summary + "\n\n" + request
+		}
+		messages := []domain.ChatMessage{
+			domain.NewChatMessage(domain.System, historySummaryInstruction),
+			domain.NewChatMessage(domain.UserRole, request),
+		}
+		response, err := uc.requestResponse(ctx, user, messages, modelConfig)
+		if err != nil {
+			return false, fmt.Errorf("failed to summarize history part %d of %d: %w", i+1, len(chunks), err)
+		}
+		summary = truncateToTokens(strings.TrimSpace(response), historySummaryTokens, uc.tokenizer)
+	}
+
+	char.HistorySummary = summary
+	char.Chat = char.Chat[removed:]
+	char.ContextStart -= removed
+	if char.ContextStart < 0 {
+		char.ContextStart = 0
+	}
+	uc.logger.Info("Summarized %d old message(s) of character %d for user %d", removed-from, char.ID, user.ID)
+	return true, nil
+}
@@ -0,0 +1,36 @@
+package usecases
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// jsonModeInstruction системная инструкция, которая добавляется к запросу в режиме JSON.
+// Она нужна и бэкендам, поддерживающим response_format: без нее модель может зациклиться на пробелах.
+const jsonModeInstruction = "Respond only with a single valid JSON object. Do not add any text, explanations or Markdown outside of the JSON."
+
+// withJSONInstruction добавляет инструкцию отвечать JSON-объектом, если режим JSON включен.
+func withJSONInstruction(messages []domain.ChatMessage, modelConfig ModelConfig) []domain.ChatMessage {
+	if !modelConfig.JSONMode {
+		return messages
+	}
+	result := make([]domain.ChatMessage, len(messages), len(messages)+1)
+	copy(result, messages)
+	return append(result, domain.NewChatMessage(domain.System, jsonModeInstruction))
+}
+
+// extractJSON убирает обрамление блоком кода Markdown (```json ... ```), которым модели часто
+// окружают JSON, и сообщает, является ли результат корректным JSON.
+func extractJSON(response string) (string, bool) {
+	trimmed := strings.TrimSpace(response)
+	if strings.HasPrefix(trimmed, "```") && strings.HasSuffix(trimmed, "```") && len(trimmed) > 6 {
+		body := strings.TrimSuffix(trimmed[3:], "```")
+		if newline := strings.IndexByte(body, '\n'); newline != -1 {
+			body = body[newline+1:] // Пропускаем язык блока кода
+		}
+		trimmed = strings.TrimSpace(body)
+	}
+	return trimmed, json.Valid([]byte(trimmed))
+}
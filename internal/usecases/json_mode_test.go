@@ -0,0 +1,81 @@
+package usecases
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		response  string
+		want      string
+		wantValid bool
+	}{
+		{name: "plain object", response: `{"a":1}`, want: `{"a":1}`, wantValid: true},
+		{name: "surrounding spaces", response: "\n  {\"a\":1}  \n", want: `{"a":1}`, wantValid: true},
+		{name: "fenced with language", response: "```json\n{\"a\":1}\n```", want: `{"a":1}`, wantValid: true},
+		{name: "fenced without language", response: "```\n[1, 2]\n```", want: "[1, 2]", wantValid: true},
+		{name: "text around the object", response: `Sure! {"a":1}`, want: `Sure! {"a":1}`, wantValid: false},
+		{name: "truncated object", response: `{"a":`, want: `{"a":`, wantValid: false},
+		{name: "empty fence", response: "``````", want: "``````", wantValid: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, valid := extractJSON(tt.response)
+			if got != tt.want || valid != tt.wantValid {
+				t.Errorf("extractJSON(%q) = %q, %v; want %q, %v", tt.response, got, valid, tt.want, tt.wantValid)
+			}
+		})
+	}
+}
+
+func TestJSONModeRequests(t *testing.T) {
+	tests := []struct {
+		name            string
+		jsonMode        bool
+		responses       []string
+		want            string
+		wantRequests    int
+		wantInstruction bool
+	}{
+		{name: "off", jsonMode: false, responses: []string{"Just text."}, want: "Just text.", wantRequests: 1},
+		{name: "valid JSON", jsonMode: true, responses: []string{`{"mood":"happy"}`}, want: `{"mood":"happy"}`, wantRequests: 1, wantInstruction: true},
+		{name: "fenced JSON is unwrapped", jsonMode: true, responses: []string{"```json\n{\"mood\":\"happy\"}\n```"}, want: `{"mood":"happy"}`, wantRequests: 1, wantInstruction: true},
+		{name: "invalid JSON is retried", jsonMode: true, responses: []string{"I feel happy", `{"mood":"happy"}`}, want: `{"mood":"happy"}`, wantRequests: 2, wantInstruction: true},
+		{name: "invalid twice is sent as is", jsonMode: true, responses: []string{"I feel happy", "Still happy"}, want: "Still happy", wantRequests: 2, wantInstruction: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			gateway := &stubGateway{responses: tt.responses}
+			uc := newTestInteractor(newMemoryRepository(), gateway, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			config := domain.DefaultModelConfig()
+			config.JSONMode = tt.jsonMode
+			user.GetCurrentCharacter().ModelConfig = &config
+
+			got, err := uc.GetModelResponseForUser(ctx, user, "How are you?")
+			if err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("response = %q, want %q", got, tt.want)
+			}
+			if len(gateway.requests) != tt.wantRequests {
+				t.Fatalf("model was asked %d times, want %d", len(gateway.requests), tt.wantRequests)
+			}
+			for i, request := range gateway.requests {
+				last := request[len(request)-1]
+				if hasInstruction := last.Content == jsonModeInstruction; hasInstruction != tt.wantInstruction {
+					t.Errorf("request %d ends with the JSON instruction = %v, want %v", i+1, hasInstruction, tt.wantInstruction)
+				}
+			}
+		})
+	}
+}
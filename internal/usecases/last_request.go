@@ -0,0 +1,76 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// maxLastRequestBytes ограничивает размер хранимого тела последнего запроса одного пользователя.
+const maxLastRequestBytes = 256 * 1024
+
+var (
+	// ErrLastRequestForbidden возвращается, если пользователю недоступен просмотр запросов к модели.
+	ErrLastRequestForbidden = errors.New("inspecting model requests is not allowed")
+	// ErrNoLastRequest возвращается, если с момента запуска бота пользователь не отправлял запросов к модели.
+	ErrNoLastRequest = errors.New("no model request has been recorded yet")
+)
+
+// requestRecorderKey ключ контекста, под которым хранится функция записи тела запроса.
+type requestRecorderKey struct{}
+
+// WithRequestRecorder возвращает контекст, в котором шлюз модели передает тело каждого запроса в record.
+func WithRequestRecorder(ctx context.Context, record func(body []byte)) context.Context {
+	return context.WithValue(ctx, requestRecorderKey{}, record)
+}
+
+// RecordRequest передает тело запроса к модели функции записи из контекста, если она задана.
+// Вызывается шлюзами модели перед отправкой запроса.
+func RecordRequest(ctx context.Context, body []byte) {
+	if record, ok := ctx.Value(requestRecorderKey{}).(func([]byte)); ok {
+		record(body)
+	}
+}
+
+// CanInspectRequests сообщает, доступна ли пользователю команда просмотра последнего запроса к модели:
+// администраторам всегда, остальным - если включена настройка InspectRequestsForAll.
+func (uc *UserInteractor) CanInspectRequests(userID int64) bool {
+	return uc.inspectRequestsForAll || uc.IsAdmin(userID)
+}
+
+// recordRequests добавляет в контекст запись тел запросов пользователя, если он может их просматривать.
+// Запросы хранятся только в памяти и теряются при перезапуске.
+func (uc *UserInteractor) recordRequests(ctx context.Context, userID int64) context.Context {
+	if !uc.CanInspectRequests(userID) {
+		return ctx
+	}
+	return WithRequestRecorder(ctx, func(body []byte) {
+		if len(body) > maxLastRequestBytes {
+			body = body[:maxLastRequestBytes]
+		}
+		uc.lastRequestsMu.Lock()
+		uc.lastRequests[userID] = append([]byte(nil), body...)
+		uc.lastRequestsMu.Unlock()
+	})
+}
+
+// LastRequest возвращает отформатированное тело последнего запроса к модели, отправленного для пользователя.
+// Обрезанное по maxLastRequestBytes тело возвращается как есть.
+func (uc *UserInteractor) LastRequest(userID int64) ([]byte, error) {
+	if !uc.CanInspectRequests(userID) {
+		return nil, ErrLastRequestForbidden
+	}
+	uc.lastRequestsMu.Lock()
+	body, ok := uc.lastRequests[userID]
+	uc.lastRequestsMu.Unlock()
+	if !ok {
+		return nil, ErrNoLastRequest
+	}
+
+	var formatted bytes.Buffer
+	if err := json.Indent(&formatted, body, "", "  "); err != nil {
+		return body, nil
+	}
+	return formatted.Bytes(), nil
+}
@@ -0,0 +1,75 @@
+package usecases
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// recordingGateway передает тело запроса записи из контекста, как это делают шлюзы модели.
+// Тело содержит только последнее сообщение запроса.
+type recordingGateway struct {
+	stubGateway
+}
+
+func (g *recordingGateway) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config ModelConfig) (string, error) {
+	body, err := json.Marshal(map[string]string{"last": messages[len(messages)-1].Content})
+	if err != nil {
+		return "", err
+	}
+	RecordRequest(ctx, body)
+	return g.stubGateway.GetModelResponse(ctx, messages, config)
+}
+
+func TestLastRequestCapturesMostRecent(t *testing.T) {
+	const adminID, userID, otherAdminID = 1, 2, 3
+	tests := []struct {
+		name       string
+		userID     int64
+		allUsers   bool
+		wantErr    error // Ошибка после двух запросов (nil - запрос записан)
+		wantBefore error // Ошибка до первого запроса
+	}{
+		{name: "admin", userID: adminID, wantBefore: ErrNoLastRequest},
+		{name: "regular user", userID: userID, wantErr: ErrLastRequestForbidden, wantBefore: ErrLastRequestForbidden},
+		{name: "regular user allowed for all", userID: userID, allUsers: true, wantBefore: ErrNoLastRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			uc := newTestInteractor(newMemoryRepository(), &recordingGateway{stubGateway{responses: []string{"ok"}}}, func(opts *UserInteractorOptions) {
+				opts.AdminUserIDs = []int64{adminID, otherAdminID}
+				opts.InspectRequestsForAll = tt.allUsers
+			})
+			user, err := uc.GetOrCreateUser(ctx, tt.userID, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			if _, err := uc.LastRequest(tt.userID); !errors.Is(err, tt.wantBefore) {
+				t.Errorf("LastRequest before any request: error %v, want %v", err, tt.wantBefore)
+			}
+
+			for _, text := range []string{"first", "second"} {
+				if _, err := uc.GetModelResponseForUser(ctx, user, text); err != nil {
+					t.Fatalf("GetModelResponseForUser(%q): %v", text, err)
+				}
+			}
+			body, err := uc.LastRequest(tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LastRequest: error %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if want := "{\n  \"last\": \"second\"\n}"; string(body) != want {
+				t.Errorf("LastRequest = %q, want the formatted second request %q", body, want)
+			}
+			if _, err := uc.LastRequest(otherAdminID); !errors.Is(err, ErrNoLastRequest) {
+				t.Errorf("LastRequest of another user: error %v, want %v", err, ErrNoLastRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package usecases
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// maxTokensPerCharacter верхняя граница max_tokens, задаваемая командой /setmaxtokens.
+// Через /setparams можно задать и больше, в пределах ModelConfig.Validate.
+const maxTokensPerCharacter = 4096
+
+// setModelParameter изменяет один параметр модели текущего персонажа (как в ModelConfig.ApplyOverride),
+// начиная с его текущих параметров или параметров по умолчанию. Значения, превышающие ограничения
+// оператора, уменьшаются до них.
+func (uc *UserInteractor) setModelParameter(user *domain.User, name, value string) error {
+	config := uc.GetModelConfig(user)
+	canonical, err := config.ApplyOverride(name, strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	if canonical == "max_tokens" && config.MaxTokens > maxTokensPerCharacter {
+		return fmt.Errorf("%w: max_tokens must be between 1 and %d, got %d", ErrInvalidParameters, maxTokensPerCharacter, config.MaxTokens)
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	config.ClampTo(uc.modelLimits(user.ID))
+	user.GetCurrentCharacter().ModelConfig = &config
+	return nil
+}
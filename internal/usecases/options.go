@@ -0,0 +1,97 @@
+package usecases
+
+import (
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// UserInteractorOptions содержит настройки UserInteractor.
+// Новые настройки добавляются сюда с разумным значением по умолчанию, не меняя сигнатуры конструкторов.
+type UserInteractorOptions struct {
+	ChatHistoryLimit   int         // Максимальное количество сообщений в истории персонажа
+	DefaultModelConfig ModelConfig // Параметры модели для персонажей без собственных настроек
+
+	DefaultPromptPosition domain.PromptPosition // Позиция системного промпта для персонажей без собственной настройки
+
+	MinLanguageConfidence float64 // Порог уверенности автоопределения языка
+	MaxCharacters         int     // Максимум персонажей у пользователя (0 - без ограничений)
+	HistoryLoadLimit      int     // Сколько последних сообщений персонажа загружать из БД (0 - всю историю)
+
+	// История эфемерных персонажей хранится только в памяти: не более чем для EphemeralChatUsers пользователей
+	// (0 - без ограничений), и забывается, если пользователь не писал дольше EphemeralChatTTL (0 - до перезапуска).
+	EphemeralChatUsers int
+	EphemeralChatTTL   time.Duration
+
+	RateLimitOptions // Частота запросов, очередь генераций и темп рассылки сводок (см. rate_limit.go)
+
+	// ModelAllowlist сопоставляет названию модели уровни пользователей (User.Tier), которым она доступна.
+	// Модели, отсутствующие в списке, доступны всем. Администраторы из AdminUserIDs не ограничены.
+	ModelAllowlist map[string][]string
+	AdminUserIDs   []int64
+
+	// MinReplyLength минимальная длина ответа модели в символах без учета пробелов по краям.
+	// Более короткие ответы, а также ответы только из знаков препинания, запрашиваются повторно один раз,
+	// после чего генерация завершается ошибкой ErrEmptyResponse, а ответ не сохраняется в историю.
+	MinReplyLength int
+
+	// StoreRawResponses сохраняет необработанный ответ модели в ChatMessage.RawContent, если постобработка
+	// (продление коротких ответов, обрезка реплик пользователя) его изменила. Предназначено для отладки.
+	StoreRawResponses bool
+
+	// AutoNameCharacters дает новым персонажам без имени (или с именем по умолчанию) имя "Character N"
+	// с наименьшим свободным номером, а к повторяющимся именам добавляет суффикс " (2)", " (3)" и т.д.
+	AutoNameCharacters bool
+
+	// CarryOverTurns сколько последних сообщений разговора с прежним персонажем передавать новому
+	// при переключении (как "предыдущий разговор" в системном сообщении). 0 - новый персонаж начинает
+	// только со своей истории.
+	CarryOverTurns int
+
+	// ModelLimits верхние границы параметров модели, которые пользователи могут установить.
+	// Превышающие значения уменьшаются до границы при изменении параметров и перед каждым запросом.
+	// Для администраторов из AdminUserIDs действует AdminModelLimits (nil - без ограничений).
+	ModelLimits      domain.ModelConfigLimits
+	AdminModelLimits domain.ModelConfigLimits
+
+	// InspectRequestsForAll разрешает всем пользователям смотреть тело последнего запроса к модели
+	// (по умолчанию - только администраторам из AdminUserIDs).
+	InspectRequestsForAll bool
+
+	// DocumentContextTokens сколько токенов прикрепленного документа передавать модели.
+	// Более длинный документ перед сохранением кратко излагается моделью по частям.
+	DocumentContextTokens int
+
+	// ResponseSaveRetries сколько раз повторять сохранение пользователя после получения ответа модели.
+	// Если все попытки неудачны, ответ все равно возвращается, а пользователь ставится в очередь
+	// отложенных сохранений (см. FlushPendingSaves), чтобы история не потеряла ответ.
+	ResponseSaveRetries int
+
+	// ConflictRetries сколько раз при сохранении перечитывать пользователя, измененного другим обработчиком
+	// или экземпляром бота (ErrVersionConflict), и заново применять к нему изменение (новые сообщения хода,
+	// свойство, персонажа и т. д.).
+	ConflictRetries int
+
+	// MaxContextTokens размер контекста модели в токенах (0 - не ограничивать). Если запрос вместе с ответом
+	// (MaxTokens) в него не помещается, самые старые сообщения истории не передаются модели; в истории они остаются.
+	MaxContextTokens int
+}
+
+// DefaultUserInteractorOptions возвращает настройки UserInteractor по умолчанию.
+func DefaultUserInteractorOptions() UserInteractorOptions {
+	return UserInteractorOptions{
+		ChatHistoryLimit:      100,
+		DefaultModelConfig:    domain.DefaultModelConfig(),
+		DefaultPromptPosition: domain.PromptAtStart,
+		MinLanguageConfidence: 0.7,
+		MaxCharacters:         20,
+		EphemeralChatUsers:    1000,
+		EphemeralChatTTL:      24 * time.Hour,
+		RateLimitOptions:      DefaultRateLimitOptions(),
+		MinReplyLength:        1,
+		AutoNameCharacters:    true,
+		DocumentContextTokens: 2000,
+		ResponseSaveRetries:   2,
+		ConflictRetries:       3,
+	}
+}
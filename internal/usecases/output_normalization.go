@@ -0,0 +1,98 @@
+package usecases
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+const codeFence = "```"
+
+var (
+	trailingSpacesPattern = regexp.MustCompile(`(?m)[ \t]+$`)
+	blankLinesPattern     = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+	inlineCodePattern     = regexp.MustCompile("`([^`\n]+)`")
+	boldPattern           = regexp.MustCompile(`\*\*([^*\n]+)\*\*|__([^_\n]+)__`)
+	italicPattern         = regexp.MustCompile(`(^|[^\w*])\*([^*\s][^*\n]*?)\*`)
+	strikePattern         = regexp.MustCompile(`~~([^~\n]+)~~`)
+)
+
+// normalizeOutput применяет к ответу модели включенные правила нормализации персонажа.
+// Содержимое блоков кода (```) не изменяется; при ConvertMarkdown блок оформляется тегом <pre>.
+func normalizeOutput(response string, rules domain.OutputNormalization) string {
+	if !rules.Any() {
+		return response
+	}
+
+	var sb strings.Builder
+	rest := response
+	for rest != "" {
+		start := strings.Index(rest, codeFence)
+		if start == -1 {
+			sb.WriteString(normalizeText(rest, rules))
+			break
+		}
+		sb.WriteString(normalizeText(rest[:start], rules))
+
+		block := rest[start:]
+		end := strings.Index(block[len(codeFence):], codeFence)
+		if end == -1 {
+			sb.WriteString(block) // Незакрытый блок кода оставляем как есть
+			break
+		}
+		block = block[:len(codeFence)+end+len(codeFence)]
+		rest = rest[start+len(block):]
+		if rules.ConvertMarkdown {
+			sb.WriteString(preBlock(block))
+		} else {
+			sb.WriteString(block)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// normalizeText применяет правила нормализации к тексту вне блоков кода.
+func normalizeText(text string, rules domain.OutputNormalization) string {
+	if rules.TrimTrailingSpaces {
+		text = trailingSpacesPattern.ReplaceAllString(text, "")
+	}
+	if rules.CollapseBlankLines {
+		text = blankLinesPattern.ReplaceAllString(text, "\n\n")
+	}
+	if rules.ConvertMarkdown {
+		text = convertEmphasis(text)
+	}
+	return text
+}
+
+// convertEmphasis заменяет выделение Markdown (**жирный**, *курсив*, ~~зачеркнутый~~, `код`)
+// тегами HTML, которые поддерживает Telegram. Текст внутри `кода` не изменяется.
+func convertEmphasis(text string) string {
+	var sb strings.Builder
+	last := 0
+	for _, match := range inlineCodePattern.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(convertInlineEmphasis(text[last:match[0]]))
+		sb.WriteString("<code>" + html.EscapeString(text[match[2]:match[3]]) + "</code>")
+		last = match[1]
+	}
+	sb.WriteString(convertInlineEmphasis(text[last:]))
+	return sb.String()
+}
+
+// convertInlineEmphasis заменяет жирный, курсив и зачеркивание Markdown тегами HTML.
+func convertInlineEmphasis(text string) string {
+	text = boldPattern.ReplaceAllString(text, "<b>$1$2</b>")
+	text = italicPattern.ReplaceAllString(text, "$1<i>$2</i>")
+	return strikePattern.ReplaceAllString(text, "<s>$1</s>")
+}
+
+// preBlock оформляет блок кода Markdown тегом <pre>, убирая строку с языком блока.
+func preBlock(block string) string {
+	body := strings.TrimSuffix(strings.TrimPrefix(block, codeFence), codeFence)
+	if newline := strings.IndexByte(body, '\n'); newline != -1 {
+		body = body[newline+1:]
+	}
+	return "<pre>" + html.EscapeString(strings.TrimRight(body, "\n")) + "</pre>"
+}
@@ -0,0 +1,84 @@
+package usecases
+
+import (
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestNormalizeOutput(t *testing.T) {
+	all := domain.OutputNormalization{CollapseBlankLines: true, TrimTrailingSpaces: true, ConvertMarkdown: true}
+	tests := []struct {
+		name  string
+		rules domain.OutputNormalization
+		input string
+		want  string
+	}{
+		{
+			name:  "no rules",
+			input: "Hello   \n\n\n\n**there**  ",
+			want:  "Hello   \n\n\n\n**there**  ",
+		},
+		{
+			name:  "trailing spaces",
+			rules: domain.OutputNormalization{TrimTrailingSpaces: true},
+			input: "line one  \t\nline two \n\n  indented stays\n",
+			want:  "line one\nline two\n\n  indented stays",
+		},
+		{
+			name:  "blank lines",
+			rules: domain.OutputNormalization{CollapseBlankLines: true},
+			input: "first\n\n\n\nsecond\n \t\n  \nthird\n\nfourth",
+			want:  "first\n\nsecond\n\nthird\n\nfourth",
+		},
+		{
+			name:  "markdown emphasis",
+			rules: domain.OutputNormalization{ConvertMarkdown: true},
+			input: "**bold** and __bold__, *italic*, ~~gone~~ and `a < b`",
+			want:  "<b>bold</b> and <b>bold</b>, <i>italic</i>, <s>gone</s> and <code>a &lt; b</code>",
+		},
+		{
+			name:  "markdown keeps multiplication and list markers",
+			rules: domain.OutputNormalization{ConvertMarkdown: true},
+			input: "2*3*4 = 24\n* item",
+			want:  "2*3*4 = 24\n* item",
+		},
+		{
+			name:  "code block is not changed",
+			rules: domain.OutputNormalization{CollapseBlankLines: true, TrimTrailingSpaces: true},
+			input: "Code:   \n\n\n```go\nx := 1   \n\n\n\ny := 2\n```\n\n\nDone.  ",
+			want:  "Code:\n\n```go\nx := 1   \n\n\n\ny := 2\n```\n\nDone.",
+		},
+		{
+			name:  "code block becomes pre with markdown",
+			rules: all,
+			input: "Run **this**:\n```sh\necho \"*not italic*\" > <file>\n```\nThen *wait*.",
+			want:  "Run <b>this</b>:\n<pre>echo &#34;*not italic*&#34; &gt; &lt;file&gt;</pre>\nThen <i>wait</i>.",
+		},
+		{
+			name:  "several code blocks",
+			rules: domain.OutputNormalization{CollapseBlankLines: true},
+			input: "a\n\n\n```\n1\n\n\n2\n```\n\n\n\nb\n```\n3\n\n\n4\n```",
+			want:  "a\n\n```\n1\n\n\n2\n```\n\nb\n```\n3\n\n\n4\n```",
+		},
+		{
+			name:  "unclosed code block is left as is",
+			rules: domain.OutputNormalization{CollapseBlankLines: true},
+			input: "text\n\n\n```\nopen   \n\n\n",
+			want:  "text\n\n```\nopen",
+		},
+		{
+			name:  "messy multi-line reply",
+			rules: all,
+			input: "  \n*smiles*   \n\n\n\n\"Hello, **friend**.\"  \n\n\n~~No~~ yes.\n\n",
+			want:  "<i>smiles</i>\n\n\"Hello, <b>friend</b>.\"\n\n<s>No</s> yes.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeOutput(tt.input, tt.rules); got != tt.want {
+				t.Errorf("normalizeOutput(%q)\n got %q\nwant %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// pendingSave пользователь, чей ответ модели (последнее сообщение персонажа charIndex) не удалось сохранить.
+type pendingSave struct {
+	user      *domain.User
+	charIndex int
+}
+
+// responseSaveBackoff пауза перед повторным сохранением ответа модели, растущая с каждой попыткой.
+const responseSaveBackoff = 200 * time.Millisecond
+
+// saveResponse сохраняет пользователя после добавления ответа модели в историю персонажа charIndex,
+// повторяя сохранение не более responseSaveRetries раз. Первая попытка дописывает только ответ
+// (см. saveAppended), повторные сохраняют пользователя целиком: если ошибка пришла уже после записи,
+// повторное добавление продублировало бы ответ. Конфликт версий разрешается перечитыванием пользователя
+// (см. resolveConflict), которое не добавляет уже сохраненный ответ второй раз. Если сохранить так и не удалось, пользователь вместе с ответом
+// ставится в очередь отложенных сохранений: ответ уже получен и будет показан, а история
+// досохранится при следующей загрузке пользователя или вызове FlushPendingSaves.
+func (uc *UserInteractor) saveResponse(ctx context.Context, user *domain.User, charIndex int) {
+	err := uc.saveAppended(ctx, user, charIndex, 1)
+	for attempt := 1; err != nil && attempt <= uc.responseSaveRetries && ctx.Err() == nil; attempt++ {
+		uc.logger.Warn("Failed to save model response for user %d (attempt %d): %v", user.ID, attempt, err)
+		timer := time.NewTimer(responseSaveBackoff * time.Duration(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			continue // Контекст отменен: условие цикла переводит ответ в очередь
+		case <-timer.C:
+		}
+		err = uc.saveWhole(ctx, user, charIndex)
+	}
+	if err == nil {
+		return
+	}
+
+	uc.logger.Error("HISTORY NOT SAVED: model response for user %d could not be saved (%v); queued for a later retry", user.ID, err)
+	uc.pendingSavesMu.Lock()
+	uc.pendingSaves[user.ID] = pendingSave{user: user, charIndex: charIndex}
+	uc.pendingSavesMu.Unlock()
+}
+
+// saveWhole сохраняет пользователя с новым ответом модели в истории персонажа charIndex целиком.
+func (uc *UserInteractor) saveWhole(ctx context.Context, user *domain.User, charIndex int) error {
+	err := uc.saveUser(ctx, user)
+	if errors.Is(err, ErrVersionConflict) {
+		return uc.resolveConflict(ctx, user, charIndex, 1)
+	}
+	return err
+}
+
+// takePendingSave пытается досохранить пользователя из очереди отложенных сохранений.
+// Если сохранить снова не удалось, возвращается пользователь из очереди, чтобы следующий запрос
+// продолжил согласованную историю, а не ту, что осталась в БД.
+func (uc *UserInteractor) takePendingSave(ctx context.Context, userID int64) *domain.User {
+	uc.pendingSavesMu.Lock()
+	pending, ok := uc.pendingSaves[userID]
+	uc.pendingSavesMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	user := pending.user
+	if err := uc.saveWhole(ctx, user, pending.charIndex); err != nil {
+		uc.logger.Error("Still failing to save queued history for user %d: %v", userID, err)
+		return user
+	}
+	uc.pendingSavesMu.Lock()
+	if uc.pendingSaves[userID].user == user {
+		delete(uc.pendingSaves, userID)
+	}
+	uc.pendingSavesMu.Unlock()
+	uc.logger.Info("Saved queued history for user %d", userID)
+	return nil
+}
+
+// dropPendingSave убирает пользователя из очереди отложенных сохранений, не сохраняя его.
+func (uc *UserInteractor) dropPendingSave(userID int64) {
+	uc.pendingSavesMu.Lock()
+	delete(uc.pendingSaves, userID)
+	uc.pendingSavesMu.Unlock()
+}
+
+// hasPendingSave сообщает, есть ли у пользователя отложенное сохранение.
+func (uc *UserInteractor) hasPendingSave(userID int64) bool {
+	uc.pendingSavesMu.Lock()
+	defer uc.pendingSavesMu.Unlock()
+	_, ok := uc.pendingSaves[userID]
+	return ok
+}
+
+// FlushPendingSaves повторяет все отложенные сохранения и возвращает количество сохраненных
+// и оставшихся в очереди пользователей.
+func (uc *UserInteractor) FlushPendingSaves(ctx context.Context) (saved, remaining int) {
+	uc.pendingSavesMu.Lock()
+	userIDs := make([]int64, 0, len(uc.pendingSaves))
+	for userID := range uc.pendingSaves {
+		userIDs = append(userIDs, userID)
+	}
+	uc.pendingSavesMu.Unlock()
+
+	for _, userID := range userIDs {
+		if uc.takePendingSave(ctx, userID) == nil {
+			saved++
+		} else {
+			remaining++
+		}
+	}
+	return saved, remaining
+}
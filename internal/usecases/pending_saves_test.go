@@ -0,0 +1,136 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestSaveResponseRetries(t *testing.T) {
+	errDB := errors.New("database unavailable")
+	tests := []struct {
+		name        string
+		retries     int
+		failures    int // Сколько сохранений ответа подряд завершатся ошибкой
+		wantQueued  bool
+		wantAttempt int // Ожидаемое число попыток сохранения ответа
+	}{
+		{name: "saved at once", retries: 2, failures: 0, wantAttempt: 1},
+		{name: "saved on retry", retries: 2, failures: 1, wantAttempt: 2},
+		{name: "saved on the last retry", retries: 2, failures: 2, wantAttempt: 3},
+		{name: "queued after all retries", retries: 2, failures: 3, wantQueued: true, wantAttempt: 3},
+		{name: "queued without retries", retries: 0, failures: 1, wantQueued: true, wantAttempt: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{responses: []string{"reply"}}, func(opts *UserInteractorOptions) {
+				opts.ResponseSaveRetries = tt.retries
+			})
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			// Первое сохранение - сообщение пользователя, следующие - ответ модели
+			repo.saveErrors = []error{nil}
+			for i := 0; i < tt.failures; i++ {
+				repo.saveErrors = append(repo.saveErrors, errDB)
+			}
+			saves := repo.saves
+
+			response, err := uc.GetModelResponseForUser(ctx, user, "hello")
+			if err != nil || response != "reply" {
+				t.Fatalf("GetModelResponseForUser() = %q, %v; want the reply even if saving fails", response, err)
+			}
+			// Попытки: неудачные плюс успешные сохранения, кроме сохранения сообщения пользователя
+			if got := tt.failures + repo.saves - saves - 1; got != tt.wantAttempt {
+				t.Errorf("response save attempts = %d, want %d", got, tt.wantAttempt)
+			}
+			if queued := uc.hasPendingSave(1); queued != tt.wantQueued {
+				t.Fatalf("queued = %v, want %v", queued, tt.wantQueued)
+			}
+			last := lastMessage(t, repo, 1)
+			if tt.wantQueued {
+				if last.Content != "hello" {
+					t.Errorf("last stored message = %q, want only the user's message before the queue is flushed", last.Content)
+				}
+				return
+			}
+			if last.Content != "reply" {
+				t.Errorf("last stored message = %q, want the reply", last.Content)
+			}
+		})
+	}
+}
+
+func TestPendingSaves(t *testing.T) {
+	errDB := errors.New("database unavailable")
+	tests := []struct {
+		name          string
+		flushFailures int  // Сколько попыток досохранения завершатся ошибкой
+		flushByLoad   bool // Досохранять загрузкой пользователя, а не FlushPendingSaves
+		wantSaved     int
+		wantRemaining int
+	}{
+		{name: "flush saves the queued reply", wantSaved: 1},
+		{name: "flush keeps a still failing save", flushFailures: 1, wantRemaining: 1},
+		{name: "loading the user saves the queued reply", flushByLoad: true},
+		{name: "loading the user returns the queued copy while saving fails", flushByLoad: true, flushFailures: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{responses: []string{"reply"}}, func(opts *UserInteractorOptions) {
+				opts.ResponseSaveRetries = 0
+			})
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			repo.saveErrors = []error{nil, errDB}
+			if _, err := uc.GetModelResponseForUser(ctx, user, "hello"); err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+			if !uc.hasPendingSave(1) {
+				t.Fatal("reply was not queued")
+			}
+
+			for i := 0; i < tt.flushFailures; i++ {
+				repo.saveErrors = append(repo.saveErrors, errDB)
+			}
+			var loaded *domain.User
+			if tt.flushByLoad {
+				if loaded, err = uc.GetOrCreateUser(ctx, 1, "alice"); err != nil {
+					t.Fatalf("GetOrCreateUser: %v", err)
+				}
+			} else {
+				saved, remaining := uc.FlushPendingSaves(ctx)
+				if saved != tt.wantSaved || remaining != tt.wantRemaining {
+					t.Errorf("FlushPendingSaves() = %d, %d; want %d, %d", saved, remaining, tt.wantSaved, tt.wantRemaining)
+				}
+			}
+
+			stillQueued := tt.flushFailures > 0
+			if got := uc.hasPendingSave(1); got != stillQueued {
+				t.Errorf("queued after flush = %v, want %v", got, stillQueued)
+			}
+			if loaded != nil {
+				chat := loaded.GetCurrentCharacter().Chat
+				if last := chat[len(chat)-1]; last.Content != "reply" {
+					t.Errorf("loaded user's last message = %q, want the queued reply", last.Content)
+				}
+			}
+			wantStored := "reply"
+			if stillQueued {
+				wantStored = "hello"
+			}
+			if last := lastMessage(t, repo, 1); last.Content != wantStored {
+				t.Errorf("last stored message = %q, want %q", last.Content, wantStored)
+			}
+		})
+	}
+}
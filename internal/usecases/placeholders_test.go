@@ -0,0 +1,195 @@
+package usecases
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestIdleCountsOnlyChatMessages(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	gateway := &stubGateway{responses: []string{"ok"}}
+	uc := newTestInteractor(repo, gateway, nil)
+
+	user, err := uc.GetOrCreateUser(ctx, 1, "{{time}}")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	user.GetCurrentCharacter().Prompt = "Idle: {{idle}}. User: {{user}}."
+
+	systemPrompt := func() string {
+		gateway.mu.Lock()
+		defer gateway.mu.Unlock()
+		return gateway.requests[len(gateway.requests)-1][0].Content
+	}
+
+	if _, err := uc.GetModelResponseForUser(ctx, user, "first"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+	// Имя пользователя подставляется после плейсхолдеров времени и не раскрывается как {{time}}
+	if got := systemPrompt(); !strings.Contains(got, "Idle: an unknown time. User: {{time}}.") {
+		t.Errorf("first prompt = %q, want an unknown idle time and the literal user name", got)
+	}
+
+	// Сообщение два часа назад; команды (повторные загрузки пользователя) время не меняют
+	user.LastChatAt = user.LastChatAt.Add(-2 * time.Hour)
+	if err := uc.SaveUser(ctx, user); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if user, err = uc.GetOrCreateUser(ctx, 1, "{{time}}"); err != nil {
+			t.Fatalf("GetOrCreateUser: %v", err)
+		}
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "second"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+	if got := systemPrompt(); !strings.Contains(got, "Idle: 2 hours.") {
+		t.Errorf("second prompt = %q, want an idle time of 2 hours", got)
+	}
+
+	// Повторная генерация последнего хода не является новым сообщением
+	if _, err := uc.ReapplyLastUserMessage(ctx, user); err != nil {
+		t.Fatalf("ReapplyLastUserMessage: %v", err)
+	}
+	if got := systemPrompt(); !strings.Contains(got, "Idle: 2 hours.") {
+		t.Errorf("regenerated prompt = %q, want the same idle time of 2 hours", got)
+	}
+}
+
+func TestApplyPlaceholdersUnescapesOnce(t *testing.T) {
+	uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+	user := domain.NewUser(1, "Alice")
+	user.GetCurrentCharacter().Name = "Bob"
+
+	tests := []struct {
+		name    string
+		role    domain.RoleEnums
+		content string
+		want    string
+	}{
+		{name: "system prompt", role: domain.System, content: `You are {{char}}. Write \{{user}} for the user.`, want: "You are Bob. Write {{user}} for the user."},
+		{name: "user message", role: domain.UserRole, content: `Say \{{char}}, {{char}}`, want: "Say {{char}}, Bob"},
+		{name: "assistant message", role: domain.Assistant, content: `{{user}}\{{user}}`, want: "Alice{{user}}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := uc.applyPlaceholdersToMessages([]domain.ChatMessage{domain.NewChatMessage(tt.role, tt.content)}, user)
+			if got[0].Content != tt.want {
+				t.Errorf("content = %q, want %q", got[0].Content, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpeakerLabels(t *testing.T) {
+	messages := []domain.ChatMessage{
+		domain.NewChatMessage(domain.System, "You are {{char}}."),
+		domain.NewChatMessage(domain.UserRole, "hi"),
+		domain.NewChatMessage(domain.Assistant, "hello"),
+		domain.NewChatMessage(domain.UserRole, "{{user}}: already labeled"),
+		domain.NewChatMessage(domain.Assistant, "Bob: answered with a name"),
+	}
+	tests := []struct {
+		name         string
+		labels       bool
+		placeholders bool
+		want         []string
+	}{
+		{
+			name: "labels on", labels: true, placeholders: true,
+			want: []string{"You are Bob.", "Alice: hi", "Bob: hello", "Alice: already labeled", "Bob: answered with a name"},
+		},
+		{
+			name: "labels off", labels: false, placeholders: true,
+			want: []string{"You are Bob.", "hi", "hello", "Alice: already labeled", "Bob: answered with a name"},
+		},
+		{
+			name: "labels on without placeholders", labels: true, placeholders: false,
+			want: []string{"You are {{char}}.", "Alice: hi", "Bob: hello", "{{user}}: already labeled", "Bob: answered with a name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+			user := domain.NewUser(1, "Alice")
+			char := user.GetCurrentCharacter()
+			char.Name = "Bob"
+			char.LabelTurns = tt.labels
+			char.EnablePlaceholders = &tt.placeholders
+
+			got := uc.applyPlaceholdersToMessages(messages, user)
+			var contents []string
+			for _, msg := range got {
+				contents = append(contents, msg.Content)
+			}
+			if !reflect.DeepEqual(contents, tt.want) {
+				t.Errorf("messages = %q, want %q", contents, tt.want)
+			}
+
+			// Повторная обработка уже помеченных реплик не добавляет вторую метку
+			if tt.labels && tt.placeholders {
+				var again []string
+				for _, msg := range uc.applyPlaceholdersToMessages(got, user) {
+					again = append(again, msg.Content)
+				}
+				if !reflect.DeepEqual(again, tt.want) {
+					t.Errorf("messages after a second pass = %q, want %q", again, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestPlaceholdersToggle(t *testing.T) {
+	tests := []struct {
+		toggle      string
+		wantPrompt  string
+		wantMessage string
+	}{
+		{toggle: "on", wantPrompt: "You are Bob, helping Alice.", wantMessage: "Render Bob for Alice."},
+		{toggle: "off", wantPrompt: "You are {{char}}, helping {{user}}.", wantMessage: "Render {{char}} for {{user}}."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.toggle, func(t *testing.T) {
+			ctx := context.Background()
+			gateway := &stubGateway{responses: []string{"Done."}}
+			uc := newTestInteractor(newMemoryRepository(), gateway, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "Alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			user.GetCurrentCharacter().Name = "Bob"
+
+			if err := uc.UpdateUserProperty(ctx, user, "EnablePlaceholders", tt.toggle); err != nil {
+				t.Fatalf("UpdateUserProperty(EnablePlaceholders): %v", err)
+			}
+			// Плейсхолдеры в промпте заменяются при сохранении, в сообщениях - при отправке модели
+			if err := uc.UpdateUserProperty(ctx, user, "Prompt", "You are {{char}}, helping {{user}}."); err != nil {
+				t.Fatalf("UpdateUserProperty(Prompt): %v", err)
+			}
+			if _, err := uc.GetModelResponseForUser(ctx, user, "Render {{char}} for {{user}}."); err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+
+			request := gateway.requests[0]
+			if got := request[0].Content; got != tt.wantPrompt {
+				t.Errorf("system prompt = %q, want %q", got, tt.wantPrompt)
+			}
+			if got := request[len(request)-1].Content; got != tt.wantMessage {
+				t.Errorf("user message = %q, want %q", got, tt.wantMessage)
+			}
+		})
+	}
+
+	uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+	user, _ := uc.GetOrCreateUser(context.Background(), 1, "Alice")
+	if err := uc.UpdateUserProperty(context.Background(), user, "EnablePlaceholders", "maybe"); err == nil {
+		t.Error("UpdateUserProperty accepted an invalid toggle value")
+	}
+}
@@ -0,0 +1,118 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrRateLimited возвращается (через RateLimitError), когда пользователь отправляет запросы чаще,
+// чем разрешено MinRequestInterval.
+var ErrRateLimited = errors.New("too many requests")
+
+// RateLimitOptions настройки нагрузки на модель: частота запросов одного пользователя, общая очередь
+// генераций и темп рассылки ежедневных сводок. Встроены в UserInteractorOptions.
+type RateLimitOptions struct {
+	// MinRequestInterval минимальный интервал между генерациями одного пользователя (0 - без ограничений),
+	// отсчитываемый от User.RequestTime. Более частые запросы отклоняются с RateLimitError, содержащим время ожидания.
+	MinRequestInterval time.Duration
+
+	// ExemptAdminsFromRateLimit освобождает администраторов из AdminUserIDs от MinRequestInterval.
+	ExemptAdminsFromRateLimit bool
+
+	// MaxConcurrentGenerations ограничивает количество одновременных генераций для всех пользователей (0 - без ограничений).
+	// Если QueueTimeout равен 0, запрос при занятых слотах сразу отклоняется с ErrBackendBusy,
+	// иначе ожидает освобождения слота не дольше QueueTimeout.
+	MaxConcurrentGenerations int
+	QueueTimeout             time.Duration
+
+	// DigestTime местное время пользователя (от полуночи), после которого отправляется ежедневная сводка
+	// подписавшимся пользователям. Отрицательное значение отключает сводку.
+	// DigestSendInterval пауза между отправками, чтобы не превышать лимиты Telegram и нагрузку на модель.
+	DigestTime         time.Duration
+	DigestSendInterval time.Duration
+}
+
+// DefaultRateLimitOptions возвращает настройки нагрузки на модель по умолчанию.
+func DefaultRateLimitOptions() RateLimitOptions {
+	return RateLimitOptions{
+		ExemptAdminsFromRateLimit: true,
+		QueueTimeout:              30 * time.Second,
+		DigestTime:                -1,
+		DigestSendInterval:        time.Second,
+	}
+}
+
+// RateLimitError сообщает, через сколько пользователь сможет отправить следующий запрос.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+// Error возвращает текст ошибки.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v: retry after %s", ErrRateLimited, e.RetryAfter.Round(time.Second))
+}
+
+// Is позволяет проверять ошибку через errors.Is(err, ErrRateLimited).
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RetryAfterSeconds возвращает время ожидания в целых секундах с округлением вверх.
+func (e *RateLimitError) RetryAfterSeconds() int {
+	return int(math.Ceil(e.RetryAfter.Seconds()))
+}
+
+// checkRateLimit проверяет, что с предыдущего запроса пользователя к модели (User.RequestTime) прошло
+// не меньше MinRequestInterval, и запоминает время нового запроса. Время хранится в документе пользователя
+// и сохраняется сразу с проверкой версии, поэтому ограничение действует для всех экземпляров бота и после
+// перезапуска, а из двух одновременных запросов проходит один: второй получает конфликт версий, перечитывает
+// пользователя и видит новое время. Более частый запрос отклоняется с RateLimitError.
+// Администраторы не ограничиваются, если включен ExemptAdminsFromRateLimit.
+func (uc *UserInteractor) checkRateLimit(ctx context.Context, user *domain.User) error {
+	now := time.Now()
+	if uc.limits.MinRequestInterval <= 0 || (uc.limits.ExemptAdminsFromRateLimit && uc.IsAdmin(user.ID)) {
+		user.RequestTime = now // Сохранится вместе со следующим изменением пользователя
+		return nil
+	}
+	return uc.updateUser(ctx, user, func(u *domain.User) error {
+		if next := u.RequestTime.Add(uc.limits.MinRequestInterval); now.Before(next) {
+			return &RateLimitError{RetryAfter: next.Sub(now)}
+		}
+		u.RequestTime = now
+		return nil
+	})
+}
+
+// acquireGenerationSlot занимает слот глобального семафора генераций и возвращает функцию его освобождения.
+func (uc *UserInteractor) acquireGenerationSlot(ctx context.Context) (func(), error) {
+	if uc.generationSlots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-uc.generationSlots }
+
+	select {
+	case uc.generationSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if uc.limits.QueueTimeout <= 0 {
+		return nil, ErrBackendBusy
+	}
+
+	uc.logger.DebugInfo("All %d generation slots are busy, queueing request", cap(uc.generationSlots))
+	timer := time.NewTimer(uc.limits.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case uc.generationSlots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBackendBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimitUsesStoredRequestTime(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{responses: []string{"hi"}}, func(opts *UserInteractorOptions) {
+		opts.MinRequestInterval = time.Minute
+	})
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "first"); err != nil {
+		t.Fatalf("first message of a new user: %v", err)
+	}
+
+	// Другой экземпляр бота (или тот же после перезапуска) загружает пользователя из хранилища
+	restarted := newTestInteractor(repo, &stubGateway{responses: []string{"hi"}}, func(opts *UserInteractorOptions) {
+		opts.MinRequestInterval = time.Minute
+	})
+	user, err = restarted.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	_, err = restarted.GetModelResponseForUser(ctx, user, "second")
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("second message error = %v, want RateLimitError", err)
+	}
+	if rateLimitErr.RetryAfter <= 0 || rateLimitErr.RetryAfter > time.Minute {
+		t.Errorf("RetryAfter = %s, want up to a minute", rateLimitErr.RetryAfter)
+	}
+	if got := lastMessage(t, repo, 1); got.Content != "hi" {
+		t.Errorf("rate-limited message was saved: last message = %q", got.Content)
+	}
+
+	// Когда интервал прошел, запрос снова разрешен
+	stored, _ := repo.LoadUser(ctx, 1)
+	stored.RequestTime = time.Now().Add(-2 * time.Minute)
+	if err := repo.SaveUser(ctx, stored); err != nil {
+		t.Fatalf("SaveUser: %v", err)
+	}
+	user, _ = restarted.GetOrCreateUser(ctx, 1, "alice")
+	if _, err := restarted.GetModelResponseForUser(ctx, user, "third"); err != nil {
+		t.Errorf("message after the interval: %v", err)
+	}
+}
+
+func TestRateLimitConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{}, func(opts *UserInteractorOptions) {
+		opts.MinRequestInterval = time.Minute
+	})
+	if _, err := uc.GetOrCreateUser(ctx, 1, "alice"); err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	// Два обработчика загрузили пользователя до того, как любой из них отправил запрос к модели
+	first, _ := uc.GetOrCreateUser(ctx, 1, "alice")
+	second, _ := uc.GetOrCreateUser(ctx, 1, "alice")
+
+	if err := uc.checkRateLimit(ctx, first); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if err := uc.checkRateLimit(ctx, second); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("second request error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestRateLimitAdminExempt(t *testing.T) {
+	ctx := context.Background()
+	uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, func(opts *UserInteractorOptions) {
+		opts.MinRequestInterval = time.Minute
+		opts.AdminUserIDs = []int64{1}
+		opts.ExemptAdminsFromRateLimit = true
+	})
+	admin, err := uc.GetOrCreateUser(ctx, 1, "admin")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := uc.checkRateLimit(ctx, admin); err != nil {
+			t.Fatalf("admin request %d: %v", i+1, err)
+		}
+	}
+}
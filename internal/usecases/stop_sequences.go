@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// defaultStopSequences стоп-последовательности, которые добавляются к каждому запросу ответа персонажа,
+// чтобы модель не продолжала разговор за пользователя.
+var defaultStopSequences = []string{"{{user}}:"}
+
+// stopSequences возвращает стоп-последовательности для ответа текущего персонажа: собственные
+// из параметров модели и стандартные ("{{user}}:" и "<имя пользователя>:") с замененными плейсхолдерами.
+// Повторы и пустые строки отбрасываются.
+func stopSequences(user *domain.User, configured []string) []string {
+	candidates := append(append([]string(nil), configured...), defaultStopSequences...)
+	if name := strings.TrimSpace(user.UserName); name != "" {
+		candidates = append(candidates, name+":")
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	stops := make([]string, 0, len(candidates))
+	for _, stop := range candidates {
+		stop = replaceCharacterPlaceholders(user, stop)
+		if strings.TrimSpace(stop) == "" || seen[stop] {
+			continue
+		}
+		seen[stop] = true
+		stops = append(stops, stop)
+	}
+	return stops
+}
+
+// addStopSequence добавляет собственную стоп-последовательность в параметры модели текущего персонажа.
+func (uc *UserInteractor) addStopSequence(user *domain.User, stop string) error {
+	if strings.TrimSpace(stop) == "" {
+		return fmt.Errorf("%w: stop sequence must not be empty", ErrInvalidParameters)
+	}
+	config := uc.GetModelConfig(user)
+	for _, existing := range config.StopSequences {
+		if existing == stop {
+			return nil
+		}
+	}
+	config.StopSequences = append(append([]string(nil), config.StopSequences...), stop)
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	user.GetCurrentCharacter().ModelConfig = &config
+	return nil
+}
@@ -0,0 +1,68 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// ErrResponseTruncated возвращается вместе с частью ответа, если генерация прервалась после начала ответа
+// (оборвался поток, истекло время персонажа или генерацию остановил пользователь). Полученная часть ответа
+// сохраняется в историю с отметкой domain.MetaTruncated; причина прерывания доступна через errors.Is.
+var ErrResponseTruncated = errors.New("the reply was cut short")
+
+// streamChunkBuffer размер буфера канала частей ответа, чтобы медленный получатель не задерживал чтение потока.
+const streamChunkBuffer = 64
+
+// StreamingGateway определяет шлюз модели, который может передавать ответ по частям по мере генерации.
+type StreamingGateway interface {
+	// GetModelResponseStream отправляет в chunks новые части ответа и возвращает ответ целиком.
+	// Канал не закрывается шлюзом. Если поток прервался, возвращается уже полученная часть ответа и ошибка.
+	GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config ModelConfig, chunks chan<- string) (string, error)
+}
+
+// streamHandlerKey ключ контекста, под которым хранится получатель частичного ответа.
+type streamHandlerKey struct{}
+
+// WithStreamHandler возвращает контекст, в котором ответ модели запрашивается по частям, если шлюз
+// это поддерживает. onPartial получает весь накопленный текст после каждой части; он вызывается
+// из отдельной горутины и не должен надолго блокироваться. Ответы в режиме JSON по частям не передаются.
+// Показанный через onPartial текст - необработанный ответ модели: окончательный ответ
+// (после обрезки, нормализации и замены плейсхолдеров) возвращает сам метод генерации.
+func WithStreamHandler(ctx context.Context, onPartial func(text string)) context.Context {
+	return context.WithValue(ctx, streamHandlerKey{}, onPartial)
+}
+
+// withoutStreamHandler возвращает контекст, в котором ответ модели не передается по частям,
+// для служебных запросов внутри генерации ответа (например, изложения истории).
+func withoutStreamHandler(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamHandlerKey{}, nil)
+}
+
+// getModelResponse запрашивает ответ модели, передавая его по частям получателю из контекста,
+// если он задан, а шлюз поддерживает потоковую передачу. Если поток прервался, вместе с ошибкой
+// возвращается уже полученная часть ответа.
+func (uc *UserInteractor) getModelResponse(ctx context.Context, messages []domain.ChatMessage, modelConfig ModelConfig) (string, error) {
+	onPartial, ok := ctx.Value(streamHandlerKey{}).(func(string))
+	streamer, streaming := uc.modelGateway.(StreamingGateway)
+	if !ok || !streaming || modelConfig.JSONMode {
+		return uc.modelGateway.GetModelResponse(ctx, messages, modelConfig)
+	}
+
+	chunks := make(chan string, streamChunkBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var text strings.Builder
+		for chunk := range chunks {
+			text.WriteString(chunk)
+			onPartial(text.String())
+		}
+	}()
+	response, err := streamer.GetModelResponseStream(ctx, messages, modelConfig, chunks)
+	close(chunks)
+	<-done
+	return response, err
+}
@@ -0,0 +1,195 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// streamingStub передает части ответа по очереди и после них возвращает err вместе с уже переданным текстом.
+// Если wait не nil, перед ошибкой шлюз ждет закрытия wait или отмены контекста.
+type streamingStub struct {
+	chunks []string
+	err    error
+	wait   chan struct{}
+}
+
+func (g *streamingStub) GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config ModelConfig) (string, error) {
+	return g.GetModelResponseStream(ctx, messages, config, make(chan string, len(g.chunks)))
+}
+
+func (g *streamingStub) GetModelResponseStream(ctx context.Context, messages []domain.ChatMessage, config ModelConfig, chunks chan<- string) (string, error) {
+	var text strings.Builder
+	for _, chunk := range g.chunks {
+		text.WriteString(chunk)
+		chunks <- chunk
+	}
+	if g.wait != nil {
+		select {
+		case <-g.wait:
+		case <-ctx.Done():
+			return text.String(), ctx.Err()
+		}
+	}
+	return text.String(), g.err
+}
+
+// lastMessage возвращает последнее сообщение текущего персонажа сохраненного пользователя.
+func lastMessage(t *testing.T, repo UserRepository, userID int64) domain.ChatMessage {
+	t.Helper()
+	stored, err := repo.LoadUser(context.Background(), userID)
+	if err != nil || stored == nil {
+		t.Fatalf("LoadUser(%d) = %v, %v", userID, stored, err)
+	}
+	chat := stored.GetCurrentCharacter().Chat
+	if len(chat) == 0 {
+		t.Fatal("stored chat is empty")
+	}
+	return chat[len(chat)-1]
+}
+
+func TestStreamErrorKeepsPartialResponse(t *testing.T) {
+	errBroken := errors.New("connection reset")
+	tests := []struct {
+		name          string
+		chunks        []string
+		wantTruncated bool
+		wantLast      string
+	}{
+		{name: "partial text is saved", chunks: []string{"Once upon", " a time"}, wantTruncated: true, wantLast: "Once upon a time"},
+		{name: "nothing received", chunks: nil, wantTruncated: false},
+		{name: "only whitespace received", chunks: []string{"  ", "\n"}, wantTruncated: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &streamingStub{chunks: tt.chunks, err: errBroken}, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+
+			var mu sync.Mutex
+			var shown string
+			streamCtx := WithStreamHandler(ctx, func(text string) {
+				mu.Lock()
+				shown = text
+				mu.Unlock()
+			})
+			response, err := uc.GetModelResponseForUser(streamCtx, user, "hello")
+
+			if !errors.Is(err, errBroken) {
+				t.Fatalf("error = %v, want the stream error", err)
+			}
+			if got := errors.Is(err, ErrResponseTruncated); got != tt.wantTruncated {
+				t.Fatalf("errors.Is(err, ErrResponseTruncated) = %v, want %v", got, tt.wantTruncated)
+			}
+			if !tt.wantTruncated {
+				if chat := chatContents(t, repo, 1); len(chat) != 0 {
+					t.Errorf("stored chat = %q, want the unanswered message removed", chat)
+				}
+				return
+			}
+			last := lastMessage(t, repo, 1)
+			if last.Content != tt.wantLast {
+				t.Errorf("last stored message = %q, want %q", last.Content, tt.wantLast)
+			}
+			if response != tt.wantLast {
+				t.Errorf("response = %q, want %q", response, tt.wantLast)
+			}
+			if last.Role != domain.Assistant.String() || last.Meta[domain.MetaTruncated] != "true" {
+				t.Errorf("last stored message = %+v, want an assistant message marked as truncated", last)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if shown != tt.wantLast {
+				t.Errorf("streamed text = %q, want %q", shown, tt.wantLast)
+			}
+		})
+	}
+}
+
+func TestStoppedGenerationSavesPartialResponse(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &streamingStub{chunks: []string{"Half of", " the answer"}, wait: make(chan struct{})}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	// Пользователь нажимает "Stop", как только виден весь переданный текст
+	generationCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	generationCtx = WithStreamHandler(generationCtx, func(text string) {
+		if text == "Half of the answer" {
+			stop()
+		}
+	})
+	response, err := uc.GetModelResponseForUser(generationCtx, user, "hello")
+
+	if !errors.Is(err, ErrResponseTruncated) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("error = %v, want ErrResponseTruncated caused by context.Canceled", err)
+	}
+	if response != "Half of the answer" {
+		t.Errorf("response = %q, want the partial text", response)
+	}
+	last := lastMessage(t, repo, 1)
+	if last.Content != "Half of the answer" || last.Meta[domain.MetaTruncated] != "true" {
+		t.Errorf("last stored message = %+v, want the partial reply marked as truncated", last)
+	}
+	if uc.hasPendingSave(1) {
+		t.Error("partial reply was queued instead of saved")
+	}
+}
+
+func TestTimeBudgetKeepsPartialResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunks   []string
+		wantText string
+	}{
+		{name: "partial text arrived", chunks: []string{"The first", " sentence."}, wantText: "The first sentence."},
+		{name: "nothing arrived", chunks: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			// Шлюз не завершает ответ, пока не истечет время персонажа
+			uc := newTestInteractor(repo, &streamingStub{chunks: tt.chunks, wait: make(chan struct{})}, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			user.GetCurrentCharacter().TimeBudget = 1
+
+			response, err := uc.GetModelResponseForUser(WithStreamHandler(ctx, func(string) {}), user, "hello")
+
+			if !errors.Is(err, ErrTimeBudgetExceeded) {
+				t.Fatalf("error = %v, want ErrTimeBudgetExceeded", err)
+			}
+			if got, want := errors.Is(err, ErrResponseTruncated), tt.wantText != ""; got != want {
+				t.Fatalf("errors.Is(err, ErrResponseTruncated) = %v, want %v", got, want)
+			}
+			if tt.wantText == "" {
+				if chat := chatContents(t, repo, 1); len(chat) != 0 {
+					t.Errorf("stored chat = %q, want the unanswered message removed", chat)
+				}
+				return
+			}
+			last := lastMessage(t, repo, 1)
+			if response != tt.wantText {
+				t.Errorf("response = %q, want %q", response, tt.wantText)
+			}
+			if last.Content != tt.wantText || last.Meta[domain.MetaTruncated] != "true" {
+				t.Errorf("last stored message = %+v, want the partial reply marked as truncated", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// maxTimeBudgetSeconds верхняя граница времени генерации, которое можно задать персонажу.
+const maxTimeBudgetSeconds = 600
+
+// ErrTimeBudgetExceeded возвращается, когда модель не успела ответить за время, заданное персонажу.
+var ErrTimeBudgetExceeded = errors.New("the reply did not arrive within the character's time budget")
+
+// withTimeBudget ограничивает контекст генерации временем TimeBudget персонажа. По истечении времени
+// запрос к модели отменяется: если ответ передается потоком, уже полученная часть сохраняется как прерванный
+// ответ (ErrResponseTruncated), иначе генерация завершается ошибкой ErrTimeBudgetExceeded.
+func withTimeBudget(ctx context.Context, char *domain.CharacterPreset) (context.Context, context.CancelFunc) {
+	if char.TimeBudget <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(char.TimeBudget)*time.Second)
+}
+
+// timeBudgetError заменяет ошибку генерации на ErrTimeBudgetExceeded, если ее причина - истекшее
+// время персонажа, а не отмена исходного запроса пользователем.
+func timeBudgetError(ctx, budgetCtx context.Context, err error) error {
+	if ctx.Err() == nil && errors.Is(budgetCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %v", ErrTimeBudgetExceeded, err)
+	}
+	return err
+}
+
+// parseTimeBudget разбирает время генерации в секундах ("0" или "off" отключают ограничение).
+func parseTimeBudget(value string) (int, error) {
+	value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "s"))
+	if value == "off" {
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 || seconds > maxTimeBudgetSeconds {
+		return 0, fmt.Errorf("%w: time budget must be between 0 and %d seconds, got %q", ErrInvalidParameters, maxTimeBudgetSeconds, value)
+	}
+	return seconds, nil
+}
@@ -2,19 +2,66 @@ package usecases
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
 	"github.com/alex-pyslar/neuro-chat-bot/pkg/logger"
 )
 
+// ErrBackendBusy возвращается, когда все слоты генерации заняты и ожидание невозможно или истекло.
+var ErrBackendBusy = errors.New("model backend is busy")
+
+// ErrNoUserMessage возвращается, когда в истории текущего персонажа нет сообщений пользователя.
+var ErrNoUserMessage = errors.New("no user message in chat history")
+
+// ErrCharacterLimit возвращается, когда пользователь достиг максимального количества персонажей.
+var ErrCharacterLimit = errors.New("character limit reached")
+
+// ErrLastCharacter возвращается при попытке удалить единственного персонажа пользователя.
+var ErrLastCharacter = errors.New("cannot delete the only character")
+
+// maxTranslationCacheSize ограничивает количество закэшированных переводов приветствий.
+const maxTranslationCacheSize = 1000
+
+// nextContextHeader предваряет одноразовый контекст (/context) в системном сообщении для модели.
+const nextContextHeader = "Additional context for the next reply only:\n\n"
+
+// maxNoteDepth ограничивает глубину вставки заметки автора.
+const maxNoteDepth = 1000
+
+// Настройки автоматического продления коротких ответов.
+const (
+	defaultContinuations  = 2 // Количество продолжений, если пользователь его не указал
+	maxContinuationsLimit = 5 // Верхняя граница количества продолжений, защищающая от длинных циклов запросов
+	continueInstruction   = "Continue your previous reply from where it stopped. Do not repeat what you have already written."
+)
+
+// ErrVersionConflict возвращается репозиторием, когда пользователь был изменен другим обработчиком или экземпляром бота.
+var ErrVersionConflict = errors.New("user was modified concurrently")
+
+// ErrModelNotAvailable возвращается, когда модель недоступна для уровня пользователя.
+var ErrModelNotAvailable = errors.New("model is not available for this user")
+
+// ErrInvalidParameters возвращается, когда переданные пользователем параметры модели некорректны.
+var ErrInvalidParameters = errors.New("invalid model parameters")
+
 // UserRepository определяет интерфейс для сохранения и загрузки пользователей.
 // Этот интерфейс находится в слое Use Cases, но его реализация будет в Adapters/Persistence.
 type UserRepository interface {
 	SaveUser(ctx context.Context, user *domain.User) error
 	LoadUser(ctx context.Context, userID int64) (*domain.User, error)
+	LoadUserWithRecentHistory(ctx context.Context, userID int64, limit int) (*domain.User, error)
 	AddChatMessage(ctx context.Context, userID int64, characterIndex int, message domain.ChatMessage) error
+	CountMessages(ctx context.Context, userID int64) (int, error)
+	DeleteUser(ctx context.Context, userID int64) error
 }
 
 // ModelGateway определяет интерфейс для взаимодействия с моделью ИИ.
@@ -23,145 +70,973 @@ type ModelGateway interface {
 	GetModelResponse(ctx context.Context, messages []domain.ChatMessage, config ModelConfig) (string, error)
 }
 
-// ModelConfig содержит параметры для запроса к модели.
-type ModelConfig struct {
-	MaxTokens        int
-	Temperature      float64
-	MinP             float64
-	TopP             float64
-	TopK             float64
-	RepeatPenalty    float64
-	PresencePenalty  float64
-	FrequencyPenalty float64
-	// StopSequences []string
+// GatewayInfo описывает бэкенд модели для диагностики (команда /about).
+type GatewayInfo struct {
+	Backend string // Тип бэкенда (например, "llamacpp")
+	Model   string // Настроенное название модели (пустая строка - модель выбирает сервер)
+	BaseURL string // Адрес сервера модели
+}
+
+// GatewayDescriber может быть реализован ModelGateway, чтобы сообщить сведения о бэкенде.
+type GatewayDescriber interface {
+	Info() GatewayInfo
+}
+
+// ModelNamer может быть реализован ModelGateway, чтобы сообщить название используемой модели или бэкенда.
+type ModelNamer interface {
+	ModelName() string
+}
+
+// LanguageDetector определяет интерфейс для определения языка текста.
+// Реализация находится в Adapters/LangDetect и может быть заменена.
+type LanguageDetector interface {
+	// Detect возвращает название языка (например, "English") и уверенность от 0 до 1.
+	Detect(text string) (language string, confidence float64)
 }
 
+// Translator определяет интерфейс для перевода текста.
+type Translator interface {
+	// Translate переводит текст на язык с кодом targetLanguage (например, "ru").
+	Translate(ctx context.Context, text string, targetLanguage string) (string, error)
+}
+
+// ModelConfig содержит параметры для запроса к модели.
+// Определен в domain, чтобы персонаж мог хранить собственные параметры.
+type ModelConfig = domain.ModelConfig
+
 // UserInteractor содержит бизнес-логику, связанную с пользователями и чатом.
 type UserInteractor struct {
-	userRepo         UserRepository
-	modelGateway     ModelGateway
-	logger           logger.Logger
-	chatHistoryLimit int
+	userRepo           UserRepository
+	modelGateway       ModelGateway
+	logger             logger.Logger
+	chatHistoryLimit   int
+	defaultModelConfig ModelConfig           // Параметры модели для персонажей без собственных настроек
+	promptPosition     domain.PromptPosition // Позиция системного промпта по умолчанию
+
+	languageDetector      LanguageDetector // Необязательный детектор языка (nil - отключено)
+	minLanguageConfidence float64          // Порог уверенности, ниже которого язык не переключается
+
+	maxCharacters    int // Максимальное количество персонажей у пользователя (0 - без ограничений)
+	historyLoadLimit int // Сколько последних сообщений персонажа загружать из БД (0 - всю историю)
+
+	ephemeralChats *ephemeralChatStore // История эфемерных персонажей, не записываемая в хранилище
+
+	translator       Translator        // Необязательный переводчик приветствий (nil - отключено)
+	translationMu    sync.Mutex        // Защищает translationCache
+	translationCache map[string]string // Кэш переведенных приветствий по ключу "язык\x00текст"
+
+	limits          RateLimitOptions // Частота запросов, очередь генераций и темп рассылки сводок
+	generationSlots chan struct{}    // Глобальный семафор одновременных генераций (nil - без ограничений)
+
+	modelAllowlist map[string][]string // Уровни пользователей, которым доступна модель (отсутствие записи - доступна всем)
+	adminUserIDs   map[int64]bool      // Администраторы, на которых не распространяются ограничения моделей
+
+	auditor Auditor // Журнал аудита изменяющих операций (по умолчанию отключен)
+
+	minReplyLength int // Ответы короче этого числа символов считаются пустыми и запрашиваются повторно
+
+	cardFormats []CharacterCardFormat // Форматы импорта карточек персонажей в порядке проверки
+
+	storeRawResponses bool // Сохранять необработанный ответ модели в ChatMessage.RawContent
+
+	autoNameCharacters bool // Давать безымянным персонажам имена "Character N" и различать одинаковые имена
+
+	carryOverTurns int // Сколько последних сообщений переносить новому персонажу при переключении (0 - не переносить)
+
+	tokenizer Tokenizer // Подсчет токенов для /sizes (по умолчанию оценка по количеству символов)
+
+	random func() float64 // Источник случайных чисел для выбора приветствия
+
+	userModelLimits  domain.ModelConfigLimits // Верхние границы параметров модели для пользователей
+	adminModelLimits domain.ModelConfigLimits // Верхние границы параметров модели для администраторов
+
+	inspectRequestsForAll bool             // Разрешить /lastrequest всем пользователям, а не только администраторам
+	lastRequestsMu        sync.Mutex       // Защищает lastRequests
+	lastRequests          map[int64][]byte // Тело последнего запроса к модели по ID пользователя
+
+	documentContextTokens int // Бюджет токенов прикрепленного документа; больший документ кратко излагается
+
+	responseSaveRetries int                   // Сколько раз повторять сохранение ответа модели перед постановкой в очередь
+	conflictRetries     int                   // Сколько раз перечитывать пользователя при конфликте версий
+	maxContextTokens    int                   // Размер контекста модели в токенах (0 - не ограничивать)
+	pendingSavesMu      sync.Mutex            // Защищает pendingSaves
+	pendingSaves        map[int64]pendingSave // Пользователи, чей ответ модели не удалось сохранить
 }
 
 // NewUserInteractor создает новый экземпляр UserInteractor.
+// Остальные настройки берутся из DefaultUserInteractorOptions.
 func NewUserInteractor(userRepo UserRepository, modelGateway ModelGateway, logger logger.Logger, chatHistoryLimit int) *UserInteractor {
-	return &UserInteractor{
-		userRepo:         userRepo,
-		modelGateway:     modelGateway,
-		logger:           logger,
-		chatHistoryLimit: chatHistoryLimit,
+	opts := DefaultUserInteractorOptions()
+	opts.ChatHistoryLimit = chatHistoryLimit
+	return NewUserInteractorWithOptions(userRepo, modelGateway, logger, opts)
+}
+
+// NewUserInteractorWithOptions создает новый экземпляр UserInteractor с заданными настройками.
+func NewUserInteractorWithOptions(userRepo UserRepository, modelGateway ModelGateway, logger logger.Logger, opts UserInteractorOptions) *UserInteractor {
+	uc := &UserInteractor{
+		userRepo:              userRepo,
+		modelGateway:          modelGateway,
+		logger:                logger,
+		chatHistoryLimit:      opts.ChatHistoryLimit,
+		defaultModelConfig:    opts.DefaultModelConfig,
+		promptPosition:        opts.DefaultPromptPosition,
+		minLanguageConfidence: opts.MinLanguageConfidence,
+		maxCharacters:         opts.MaxCharacters,
+		historyLoadLimit:      opts.HistoryLoadLimit,
+		ephemeralChats:        newEphemeralChatStore(opts.EphemeralChatUsers, opts.EphemeralChatTTL),
+		limits:                opts.RateLimitOptions,
+		modelAllowlist:        opts.ModelAllowlist,
+		adminUserIDs:          make(map[int64]bool, len(opts.AdminUserIDs)),
+		auditor:               noopAuditor{},
+		minReplyLength:        opts.MinReplyLength,
+		cardFormats:           defaultCardFormats(),
+		storeRawResponses:     opts.StoreRawResponses,
+		autoNameCharacters:    opts.AutoNameCharacters,
+		carryOverTurns:        opts.CarryOverTurns,
+		tokenizer:             estimateTokenizer{},
+		random:                rand.Float64,
+		userModelLimits:       opts.ModelLimits,
+		adminModelLimits:      opts.AdminModelLimits,
+		inspectRequestsForAll: opts.InspectRequestsForAll,
+		lastRequests:          make(map[int64][]byte),
+		documentContextTokens: opts.DocumentContextTokens,
+		responseSaveRetries:   opts.ResponseSaveRetries,
+		conflictRetries:       opts.ConflictRetries,
+		maxContextTokens:      opts.MaxContextTokens,
+		pendingSaves:          make(map[int64]pendingSave),
+	}
+	for _, id := range opts.AdminUserIDs {
+		uc.adminUserIDs[id] = true
+	}
+
+	// Лимит загрузки не может быть меньше лимита истории чата: пользователь сохраняется целиком,
+	// и более короткая загрузка привела бы к потере сообщений в БД.
+	if uc.historyLoadLimit > 0 && uc.historyLoadLimit < uc.chatHistoryLimit {
+		logger.Warn("History load limit %d is below chat history limit %d, using %d", uc.historyLoadLimit, uc.chatHistoryLimit, uc.chatHistoryLimit)
+		uc.historyLoadLimit = uc.chatHistoryLimit
+	}
+	if opts.MaxConcurrentGenerations > 0 {
+		uc.generationSlots = make(chan struct{}, opts.MaxConcurrentGenerations)
+	}
+	return uc
+}
+
+// BackendInfo возвращает сведения об используемом бэкенде модели.
+func (uc *UserInteractor) BackendInfo() GatewayInfo {
+	if describer, ok := uc.modelGateway.(GatewayDescriber); ok {
+		return describer.Info()
+	}
+	return GatewayInfo{Backend: "unknown"}
+}
+
+// CheckModelAccess проверяет, может ли пользователь переключиться на модель.
+// Должна вызываться при любой смене модели пользователем.
+func (uc *UserInteractor) CheckModelAccess(user *domain.User, model string) error {
+	if uc.adminUserIDs[user.ID] {
+		return nil
+	}
+	tiers, restricted := uc.modelAllowlist[model]
+	if !restricted {
+		return nil
+	}
+	for _, tier := range tiers {
+		if tier == user.Tier {
+			return nil
+		}
 	}
+	uc.logger.Info("User %d (tier %q) denied access to model %s", user.ID, user.Tier, model)
+	return fmt.Errorf("%w: %s", ErrModelNotAvailable, model)
+}
+
+// SetLanguageDetector подключает детектор языка для персонажей с включенным AutoLanguage.
+func (uc *UserInteractor) SetLanguageDetector(detector LanguageDetector) {
+	uc.languageDetector = detector
+}
+
+// SetTranslator подключает переводчик для персонажей с включенным переводом приветствия.
+func (uc *UserInteractor) SetTranslator(translator Translator) {
+	uc.translator = translator
+	uc.translationCache = make(map[string]string)
 }
 
 // GetOrCreateUser загружает существующего пользователя или создает нового.
 func (uc *UserInteractor) GetOrCreateUser(ctx context.Context, userID int64, username string) (*domain.User, error) {
-	user, err := uc.userRepo.LoadUser(ctx, userID)
+	user, err := uc.loadUser(ctx, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load user: %w", err)
 	}
 
 	if user == nil {
 		user = domain.NewUser(userID, username)
-		if err := uc.userRepo.SaveUser(ctx, user); err != nil {
+		if err := uc.saveUser(ctx, user); err != nil {
 			return nil, fmt.Errorf("failed to save new user: %w", err)
 		}
 		uc.logger.Info("Created new user with ID: %d", userID)
 	} else {
+		if repairs := user.Repair(); len(repairs) > 0 {
+			uc.logger.Warn("Repaired corrupted data for user %d: %s", userID, strings.Join(repairs, "; "))
+			repair := func(u *domain.User) error {
+				u.Repair() // Перечитанного пользователя мог сохранить другой обработчик с теми же повреждениями
+				return nil
+			}
+			if err := uc.updateUser(ctx, user, repair); err != nil {
+				uc.logger.Error("Failed to save repaired user %d: %v", userID, err)
+			}
+		}
 		// Update username if it changed
 		if user.UserName != username {
-			user.UserName = username
-			if err := uc.userRepo.SaveUser(ctx, user); err != nil {
+			rename := func(u *domain.User) error {
+				u.UserName = username
+				return nil
+			}
+			if err := uc.updateUser(ctx, user, rename); err != nil {
 				uc.logger.Error("Failed to update username for user %d: %v", userID, err)
 			}
 		}
 	}
-	user.RequestTime = time.Now() // Обновляем время запроса
 	return user, nil
 }
 
-// SaveUser сохраняет данные пользователя.
+// SaveUser сохраняет данные пользователя. Пользователь, удаленный через ForgetUser, не сохраняется.
 func (uc *UserInteractor) SaveUser(ctx context.Context, user *domain.User) error {
-	return uc.userRepo.SaveUser(ctx, user)
+	if user.Deleted() {
+		return nil // Заново пользователь создается только через GetOrCreateUser
+	}
+	return uc.saveUser(ctx, user)
 }
 
 // GetModelResponseForUser генерирует ответ модели для пользователя.
+// Если генерация прервалась после начала ответа, возвращается сохраненная часть ответа и ErrResponseTruncated.
 func (uc *UserInteractor) GetModelResponseForUser(ctx context.Context, user *domain.User, userMessage string) (string, error) {
+	return uc.generateResponse(ctx, user, userMessage, uc.GetModelConfig(user), false)
+}
+
+// generateResponse добавляет сообщение пользователя в историю и генерирует ответ модели с заданными параметрами.
+// historyEdited означает, что история уже изменена не только добавлением сообщений (например, из нее удален
+// последний ход), поэтому первое сохранение должно перезаписать пользователя целиком.
+// Если модель так и не ответила (в том числе ErrEmptyResponse), новое сообщение удаляется из истории
+// (см. rollbackTurn); при повторной генерации оно остается, чтобы ее можно было повторить.
+// Прерванный ответ (ErrResponseTruncated) не продлевается и сохраняется с отметкой domain.MetaTruncated.
+func (uc *UserInteractor) generateResponse(ctx context.Context, user *domain.User, userMessage string, modelConfig ModelConfig, historyEdited bool) (string, error) {
+	modelConfig.ClampTo(uc.modelLimits(user.ID))
+	if err := uc.checkRateLimit(ctx, user); err != nil {
+		return "", err
+	}
+
+	release, err := uc.acquireGenerationSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if !historyEdited {
+		// Повторная генерация последнего хода не является новым сообщением и не меняет {{idle}}
+		user.MarkChatMessage(time.Now())
+	}
 	currentChatIndex := user.CurrentCharacterID
-	currentChat := user.GetCurrentCharacter().Chat
+	added := 1 // Сообщение пользователя и, возможно, приветствие
+	if char := user.GetCurrentCharacter(); char.SeedGreeting && len(char.Chat) == 0 {
+		// Приветствие (с учетом весов альтернативных) становится первой репликой персонажа,
+		// чтобы модель продолжила разговор с него
+		if greeting := char.PickGreeting(uc.random); greeting != "" {
+			char.AppendMessages(domain.NewChatMessage(domain.Assistant, uc.translateGreeting(ctx, user, greeting)))
+			added++
+		}
+	}
+	uc.detectLanguage(user.GetCurrentCharacter(), userMessage)
 
-	// Добавляем сообщение пользователя в историю
-	user.GetCurrentCharacter().Chat = append(currentChat, domain.NewChatMessage(domain.UserRole, userMessage))
+	// Добавляем сообщение пользователя в историю. В БД дописываются только новые сообщения,
+	// история обрезается там же (если репозиторий это поддерживает)
+	user.GetCurrentCharacter().AppendMessages(domain.NewChatMessage(domain.UserRole, userMessage))
+	// Старые сообщения излагаются с запасом, поэтому после ответа история обычно остается в пределах лимита
+	summarized, err := uc.SummarizeOldHistory(ctx, user, currentChatIndex)
+	if err != nil {
+		uc.logger.Warn("Failed to summarize old history for user %d, dropping it instead: %v", user.ID, err)
+	}
 	user.EnsureChatHistoryLimit(currentChatIndex, uc.chatHistoryLimit) // Обрезаем историю
-	if err := uc.userRepo.SaveUser(ctx, user); err != nil {
+	if historyEdited || summarized {
+		err = uc.updateUser(ctx, user, replaceHistory(user.GetCurrentCharacter()))
+	} else {
+		err = uc.saveAppended(ctx, user, currentChatIndex, added)
+	}
+	if err != nil {
 		uc.logger.Error("Failed to save user after adding message: %v", err)
 		return "", fmt.Errorf("failed to save chat message: %w", err)
 	}
 
 	// Подготовка сообщений для модели
-	messagesForModel := user.GetCurrentCharacter().GetChatMessagesForModel()
+	messagesForModel := user.GetCurrentCharacter().GetChatMessagesForModel(uc.promptPosition)
 	messagesForModel = uc.applyPlaceholdersToMessages(messagesForModel, user) // Применяем плейсхолдеры
-
-	// Параметры для модели (можно сделать настраиваемыми)
-	modelConfig := ModelConfig{
-		MaxTokens:        500,
-		Temperature:      0.7,
-		TopP:             0.9,
-		TopK:             0, // 0 отключает TopK
-		RepeatPenalty:    1.1,
-		PresencePenalty:  0.0,
-		FrequencyPenalty: 0.0,
+	messagesForModel = uc.appendLanguageInstruction(messagesForModel, user.GetCurrentCharacter())
+	nextContext, oocInstruction := user.NextContext, user.OOCInstruction
+	if user.NextContext != "" {
+		// Одноразовый контекст передается только в этой генерации и не сохраняется в истории
+		messagesForModel = append(messagesForModel, domain.NewChatMessage(domain.System, nextContextHeader+user.NextContext))
+		user.NextContext = ""
+	}
+	if user.OOCInstruction != "" {
+		// Внеролевая инструкция действует только на одну генерацию и не сохраняется в истории
+		messagesForModel = append(messagesForModel, domain.NewChatMessage(domain.System, replaceCharacterPlaceholders(user, user.OOCInstruction)))
+		user.OOCInstruction = ""
 	}
 
-	response, err := uc.modelGateway.GetModelResponse(ctx, messagesForModel, modelConfig)
-	if err != nil {
+	messagesForModel = uc.fitContextWindow(ctx, user, messagesForModel, modelConfig.MaxTokens)
+
+	modelConfig.StopSequences = stopSequences(user, modelConfig.StopSequences)
+	budgetCtx, cancel := withTimeBudget(ctx, user.GetCurrentCharacter())
+	defer cancel()
+	response, err := uc.requestResponse(budgetCtx, user, messagesForModel, modelConfig)
+	truncated := err != nil && !isTrivialResponse(response, uc.minReplyLength)
+	if err != nil && !truncated {
 		uc.logger.Error("Failed to get model response: %v", err)
-		return "", fmt.Errorf("failed to get model response: %w", err)
+		if !historyEdited {
+			// Одноразовые инструкции не израсходованы и достанутся следующему запросу
+			user.NextContext, user.OOCInstruction = nextContext, oocInstruction
+			uc.rollbackTurn(ctx, user, added)
+		}
+		return "", fmt.Errorf("failed to get model response: %w", timeBudgetError(ctx, budgetCtx, err))
+	}
+	if truncated {
+		// Уже полученная часть ответа сохраняется как ответ персонажа с отметкой о прерывании
+		err = timeBudgetError(ctx, budgetCtx, err)
+		uc.logger.Warn("Model reply for user %d was cut short after %d characters: %v", user.ID, utf8.RuneCountInString(response), err)
+	}
+	rawResponse := response
+	if !truncated {
+		// Продления укладываются в то же время: при его истечении остается уже полученный текст
+		response = uc.extendShortResponse(budgetCtx, user, messagesForModel, response, modelConfig)
+	}
+	response = uc.trimUserLines(user, response)
+	if !modelConfig.JSONMode {
+		response = normalizeOutput(response, user.GetCurrentCharacter().Normalization)
 	}
 
 	// Добавляем ответ модели в историю
-	user.GetCurrentCharacter().Chat = append(user.GetCurrentCharacter().Chat, domain.NewChatMessage(domain.Assistant, response))
+	assistantMessage := domain.NewChatMessage(domain.Assistant, response).
+		WithMeta(domain.MetaGeneratedAt, time.Now().UTC().Format(time.RFC3339))
+	if namer, ok := uc.modelGateway.(ModelNamer); ok {
+		assistantMessage = assistantMessage.WithMeta(domain.MetaModel, namer.ModelName())
+	}
+	if uc.storeRawResponses && rawResponse != response {
+		assistantMessage.RawContent = rawResponse
+	}
+	if truncated {
+		assistantMessage = assistantMessage.WithMeta(domain.MetaTruncated, "true")
+	}
+	user.GetCurrentCharacter().AppendMessages(assistantMessage)
 	user.EnsureChatHistoryLimit(currentChatIndex, uc.chatHistoryLimit) // Обрезаем историю после добавления ответа
-	if err := uc.userRepo.SaveUser(ctx, user); err != nil {
-		uc.logger.Error("Failed to save user after adding model response: %v", err)
-		return "", fmt.Errorf("failed to save model response: %w", err)
+	saveCtx := ctx
+	if truncated {
+		// Генерацию могли остановить отменой контекста, но полученная часть ответа все равно сохраняется
+		saveCtx = context.WithoutCancel(ctx)
 	}
+	// Ответ возвращается, даже если сохранение истории пришлось отложить
+	uc.saveResponse(saveCtx, user, currentChatIndex)
 
+	if truncated {
+		return response, fmt.Errorf("%w: %w", ErrResponseTruncated, err)
+	}
 	return response, nil
 }
 
+// TryPrompt генерирует одноразовый ответ текущего персонажа на пробное сообщение без истории чата.
+// Ни сообщение, ни ответ не сохраняются.
+func (uc *UserInteractor) TryPrompt(ctx context.Context, user *domain.User, sample string) (string, error) {
+	if err := uc.checkRateLimit(ctx, user); err != nil {
+		return "", err
+	}
+	release, err := uc.acquireGenerationSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	trial := user.GetCurrentCharacter().Clone()
+	trial.Chat = []domain.ChatMessage{domain.NewChatMessage(domain.UserRole, sample)}
+	trial.ContextStart = 0
+	trial.CarryOver = ""
+	messagesForModel := uc.applyPlaceholdersToMessages(trial.GetChatMessagesForModel(uc.promptPosition), user)
+
+	modelConfig := uc.GetModelConfig(user)
+	modelConfig.StopSequences = stopSequences(user, modelConfig.StopSequences)
+	response, err := uc.requestResponse(ctx, user, messagesForModel, modelConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to get model response: %w", err)
+	}
+	response = uc.trimUserLines(user, response)
+	if !modelConfig.JSONMode {
+		response = normalizeOutput(response, trial.Normalization)
+	}
+	return response, nil
+}
+
+// extendShortResponse продлевает ответ короче MinResponseLength персонажа запросами на продолжение,
+// но не более MaxContinuations раз. Ошибка или пустое продолжение прекращают продление,
+// и возвращается уже полученный текст.
+func (uc *UserInteractor) extendShortResponse(ctx context.Context, user *domain.User, messagesForModel []domain.ChatMessage, response string, modelConfig ModelConfig) string {
+	if modelConfig.JSONMode {
+		return response // Продолжение испортило бы JSON-объект
+	}
+	char := user.GetCurrentCharacter()
+	continuations := 0
+	for continuations < char.MaxContinuations && utf8.RuneCountInString(response) < char.MinResponseLength {
+		messages := make([]domain.ChatMessage, len(messagesForModel), len(messagesForModel)+2)
+		copy(messages, messagesForModel)
+		messages = append(messages,
+			domain.NewChatMessage(domain.Assistant, response),
+			domain.NewChatMessage(domain.System, continueInstruction))
+
+		continuation, err := uc.modelGateway.GetModelResponse(ctx, messages, modelConfig)
+		if err != nil {
+			uc.logger.Warn("Failed to continue short response for user %d: %v", user.ID, err)
+			break
+		}
+		continuation = strings.TrimSpace(continuation)
+		if continuation == "" {
+			uc.logger.Warn("Model returned an empty continuation for user %d, keeping short response", user.ID)
+			break
+		}
+		response = strings.TrimRight(response, " \t\n") + " " + continuation
+		continuations++
+	}
+	if continuations > 0 {
+		uc.logger.Info("Extended response for user %d with %d continuation(s) to %d characters", user.ID, continuations, utf8.RuneCountInString(response))
+	}
+	return response
+}
+
+// trimUserLines обрезает ответ на первом маркере реплики пользователя (например, "{{user}}:"),
+// оставляя только реплику персонажа. Если после обрезки ничего не остается, ответ не изменяется.
+func (uc *UserInteractor) trimUserLines(user *domain.User, response string) string {
+	char := user.GetCurrentCharacter()
+	if !char.TrimUserLines {
+		return response
+	}
+	marker := char.TrimMarker
+	if marker == "" {
+		marker = domain.DefaultTrimMarker
+	}
+	marker = domain.UnescapePlaceholders(user.ReplacePlaceholders(marker))
+
+	index := strings.Index(response, marker)
+	if index == -1 {
+		return response
+	}
+	trimmed := strings.TrimSpace(response[:index])
+	if trimmed == "" {
+		uc.logger.Warn("Response for user %d starts with the user marker %q, keeping it untrimmed", user.ID, marker)
+		return response
+	}
+	uc.logger.DebugInfo("Trimmed %d characters of user lines from response for user %d", len(response)-index, user.ID)
+	return trimmed
+}
+
+// ReapplyLastUserMessage повторно отправляет последнее сообщение пользователя с текущими настройками персонажа.
+// Сообщение и все последующие ответы удаляются из истории, после чего ответ генерируется заново.
+func (uc *UserInteractor) ReapplyLastUserMessage(ctx context.Context, user *domain.User) (string, error) {
+	return uc.regenerateLastTurn(ctx, user, uc.GetModelConfig(user))
+}
+
+// RetryWithOverrides заново генерирует ответ на последнее сообщение пользователя с однократно измененными
+// параметрами модели. Переопределения задаются строкой вида "temp=1.1 maxtokens=800" и не сохраняются.
+// Возвращает ответ и список примененных переопределений.
+func (uc *UserInteractor) RetryWithOverrides(ctx context.Context, user *domain.User, overrides string) (string, []string, error) {
+	config := uc.GetModelConfig(user)
+	var applied []string
+	for _, field := range strings.Fields(overrides) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return "", nil, fmt.Errorf("%w: expected key=value, got %q", ErrInvalidParameters, field)
+		}
+		name, err := config.ApplyOverride(key, value)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+		}
+		applied = append(applied, fmt.Sprintf("%s=%s", name, value))
+	}
+	if len(applied) == 0 {
+		return "", nil, fmt.Errorf("%w: no parameter overrides given", ErrInvalidParameters)
+	}
+	if err := config.Validate(); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+	}
+	applied = append(applied, config.ClampTo(uc.modelLimits(user.ID))...)
+
+	response, err := uc.regenerateLastTurn(ctx, user, config)
+	if err != nil {
+		return "", nil, err
+	}
+	return response, applied, nil
+}
+
+// regenerateLastTurn удаляет из истории последнее сообщение пользователя вместе с последующими ответами
+// и генерирует ответ на него заново.
+func (uc *UserInteractor) regenerateLastTurn(ctx context.Context, user *domain.User, modelConfig ModelConfig) (string, error) {
+	char := user.GetCurrentCharacter()
+	lastIndex := -1
+	for i := len(char.Chat) - 1; i >= 0; i-- {
+		if char.Chat[i].Role == domain.UserRole.String() {
+			lastIndex = i
+			break
+		}
+	}
+	if lastIndex == -1 {
+		return "", ErrNoUserMessage
+	}
+
+	lastMessage := char.Chat[lastIndex].Content
+	char.Chat = char.Chat[:lastIndex]
+	return uc.generateResponse(ctx, user, lastMessage, modelConfig, true)
+}
+
 // AddCharacter добавляет нового персонажа для пользователя и делает его текущим.
 func (uc *UserInteractor) AddCharacter(ctx context.Context, user *domain.User, newChar *domain.CharacterPreset) error {
-	// Присваиваем ID новому персонажу (простой инкремент)
-	newChar.ID = len(user.Characters) // Простое присвоение ID на основе количества существующих персонажей
-	user.Characters = append(user.Characters, newChar)
-	user.ChangeCurrentCharacter(len(user.Characters) - 1)
-	return uc.userRepo.SaveUser(ctx, user)
+	name := strings.TrimSpace(newChar.Name)
+	if newChar.CreatedAt.IsZero() {
+		newChar.CreatedAt = time.Now().UTC()
+	}
+	add := func(u *domain.User) error {
+		if uc.maxCharacters > 0 && len(u.Characters) >= uc.maxCharacters {
+			return ErrCharacterLimit
+		}
+		// Имя и ID выбираются заново при каждом применении: другой обработчик мог добавить персонажа
+		if uc.autoNameCharacters {
+			if name == "" || name == domain.DefaultCharacterName {
+				newChar.Name = u.UntitledCharacterName()
+			} else {
+				newChar.Name = u.UniqueCharacterName(name)
+			}
+		}
+		newChar.ID = u.NextCharacterID()
+		newChar.Touch()
+		u.Characters = append(u.Characters, newChar)
+		u.ChangeCurrentCharacter(len(u.Characters) - 1)
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, add); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditAddCharacter, fmt.Sprintf("id=%d name=%q", newChar.ID, newChar.Name))
+	return nil
+}
+
+// ForkConversation создает нового персонажа с копией настроек и истории текущего и делает его текущим.
+// Исходный персонаж и его история не изменяются.
+func (uc *UserInteractor) ForkConversation(ctx context.Context, user *domain.User) (*domain.CharacterPreset, error) {
+	fork := user.GetCurrentCharacter().Clone()
+	sourceID := user.GetCurrentCharacter().ID
+	fork.Name = fork.Name + " (fork)"
+	fork.CreatedAt = time.Now().UTC()
+	if err := uc.AddCharacter(ctx, user, fork); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, user.ID, AuditForkCharacter, fmt.Sprintf("from id=%d to id=%d", sourceID, fork.ID))
+	return fork, nil
+}
+
+// GetGreeting возвращает приветствие текущего персонажа, при необходимости переведенное на язык пользователя.
+// При ошибке перевода возвращается исходное приветствие.
+func (uc *UserInteractor) GetGreeting(ctx context.Context, user *domain.User) string {
+	return uc.translateGreeting(ctx, user, user.GetCurrentCharacter().Greeting)
+}
+
+// translateGreeting переводит приветствие greeting текущего персонажа на язык пользователя, если перевод включен.
+// При ошибке перевода возвращается исходное приветствие.
+func (uc *UserInteractor) translateGreeting(ctx context.Context, user *domain.User, greeting string) string {
+	char := user.GetCurrentCharacter()
+	if !char.TranslateGreeting || uc.translator == nil || user.LanguageCode == "" || greeting == "" {
+		return greeting
+	}
+
+	cacheKey := user.LanguageCode + "\x00" + greeting
+	uc.translationMu.Lock()
+	cached, ok := uc.translationCache[cacheKey]
+	uc.translationMu.Unlock()
+	if ok {
+		return cached
+	}
+
+	translated, err := uc.translator.Translate(ctx, greeting, user.LanguageCode)
+	if err != nil {
+		uc.logger.Warn("Failed to translate greeting for user %d to %s: %v", user.ID, user.LanguageCode, err)
+		return greeting
+	}
+
+	uc.translationMu.Lock()
+	if len(uc.translationCache) >= maxTranslationCacheSize {
+		uc.translationCache = make(map[string]string) // Простая защита от бесконечного роста
+	}
+	uc.translationCache[cacheKey] = translated
+	uc.translationMu.Unlock()
+	return translated
+}
+
+// GetModelConfig возвращает параметры модели для текущего персонажа пользователя.
+func (uc *UserInteractor) GetModelConfig(user *domain.User) ModelConfig {
+	config := uc.defaultModelConfig
+	if char := user.GetCurrentCharacter(); char.ModelConfig != nil {
+		config = *char.ModelConfig
+	}
+	config.ClampTo(uc.modelLimits(user.ID)) // Сохраненные ранее значения могут превышать текущие ограничения
+	return config
+}
+
+// modelLimits возвращает ограничения параметров модели для пользователя.
+func (uc *UserInteractor) modelLimits(userID int64) domain.ModelConfigLimits {
+	if uc.IsAdmin(userID) {
+		return uc.adminModelLimits
+	}
+	return uc.userModelLimits
+}
+
+// UpdateModelConfig применяет параметры модели из JSON-объекта к текущему персонажу.
+// Поля, отсутствующие в JSON, сохраняют текущие значения; неизвестные поля считаются ошибкой.
+func (uc *UserInteractor) UpdateModelConfig(ctx context.Context, user *domain.User, rawJSON string) ([]string, error) {
+	config := uc.GetModelConfig(user)
+	decoder := json.NewDecoder(strings.NewReader(rawJSON))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid model parameters: %w", err)
+	}
+	notices := config.ClampTo(uc.modelLimits(user.ID))
+	charID := user.GetCurrentCharacter().ID
+	update := func(u *domain.User) error {
+		char, err := currentCharacterByID(u, charID)
+		if err != nil {
+			return err
+		}
+		applied := config // Каждому персонажу своя копия параметров
+		char.ModelConfig = &applied
+		char.Touch()
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, update); err != nil {
+		return nil, err
+	}
+	uc.audit(ctx, user.ID, AuditUpdateModelConfig, fmt.Sprintf("character id=%d: %s", user.GetCurrentCharacter().ID, rawJSON))
+	return notices, nil
 }
 
 // ClearChatHistory clears the chat history for the current character.
 func (uc *UserInteractor) ClearChatHistory(ctx context.Context, user *domain.User) error {
-	user.GetCurrentCharacter().Chat = make([]domain.ChatMessage, 0, uc.chatHistoryLimit)
-	return uc.userRepo.SaveUser(ctx, user)
+	charID := user.GetCurrentCharacter().ID
+	clear := func(u *domain.User) error {
+		char, err := currentCharacterByID(u, charID)
+		if err != nil {
+			return err
+		}
+		char.Chat = make([]domain.ChatMessage, 0, uc.chatHistoryLimit)
+		char.ContextStart = 0
+		char.CarryOver = ""
+		char.HistorySummary = ""
+		char.Touch()
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, clear); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditClearHistory, fmt.Sprintf("character id=%d", user.GetCurrentCharacter().ID))
+	return nil
+}
+
+// ClearContext исключает всю текущую историю персонажа из контекста модели, не удаляя ее,
+// и возвращает количество скрытых сообщений.
+func (uc *UserInteractor) ClearContext(ctx context.Context, user *domain.User) (int, error) {
+	charID := user.GetCurrentCharacter().ID
+	hidden := 0
+	clear := func(u *domain.User) error {
+		char, err := currentCharacterByID(u, charID)
+		if err != nil {
+			return err
+		}
+		hidden = len(char.ContextChat())
+		char.ContextStart = len(char.Chat)
+		char.CarryOver = ""
+		char.HistorySummary = ""
+		char.Touch()
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, clear); err != nil {
+		return 0, err
+	}
+	uc.audit(ctx, user.ID, AuditClearContext, fmt.Sprintf("character id=%d, %d messages hidden", charID, hidden))
+	return hidden, nil
+}
+
+// ClearAllHistories очищает историю чата всех персонажей пользователя, сохраняя их настройки,
+// и возвращает количество очищенных непустых историй.
+func (uc *UserInteractor) ClearAllHistories(ctx context.Context, user *domain.User) (int, error) {
+	cleared := 0
+	clear := func(u *domain.User) error {
+		cleared = 0
+		for _, char := range u.Characters {
+			if len(char.Chat) > 0 {
+				cleared++
+			}
+			char.Chat = make([]domain.ChatMessage, 0, uc.chatHistoryLimit)
+			char.ContextStart = 0
+			char.CarryOver = ""
+			char.HistorySummary = ""
+			char.Touch()
+		}
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, clear); err != nil {
+		return 0, err
+	}
+	uc.audit(ctx, user.ID, AuditClearAllHistories, fmt.Sprintf("%d histories cleared", cleared))
+	return cleared, nil
+}
+
+// userProperties свойства UpdateUserProperty, относящиеся к пользователю, а не к текущему персонажу.
+// Их изменение не обновляет время изменения персонажа.
+var userProperties = map[string]bool{
+	"UserName":        true,
+	"UserDescription": true,
+	"OOCInstruction":  true,
+	"NextContext":     true,
+	"Timezone":        true,
 }
 
 // UpdateUserProperty updates a string property of the user and saves it.
+// Свойство персонажа изменяется только у того персонажа, который был текущим при вызове.
 func (uc *UserInteractor) UpdateUserProperty(ctx context.Context, user *domain.User, prop string, value string) error {
+	charID := user.GetCurrentCharacter().ID
+	update := func(u *domain.User) error {
+		if !userProperties[prop] {
+			if _, err := currentCharacterByID(u, charID); err != nil {
+				return err
+			}
+		}
+		return uc.applyUserProperty(u, prop, value)
+	}
+	if err := uc.updateUser(ctx, user, update); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditUpdateProperty, fmt.Sprintf("character id=%d %s=%s", user.GetCurrentCharacter().ID, prop, value))
+	return nil
+}
+
+// applyUserProperty изменяет свойство prop пользователя или его текущего персонажа без сохранения.
+func (uc *UserInteractor) applyUserProperty(user *domain.User, prop string, value string) error {
 	switch prop {
 	case "Prompt":
-		user.GetCurrentCharacter().Prompt = user.ReplacePlaceholders(value)
+		user.GetCurrentCharacter().Prompt = replaceCharacterPlaceholders(user, value)
+	case "AppendPrompt":
+		char := user.GetCurrentCharacter()
+		char.Prompt = joinPromptParts(char.Prompt, replaceCharacterPlaceholders(user, value))
+	case "PrependPrompt":
+		char := user.GetCurrentCharacter()
+		char.Prompt = joinPromptParts(replaceCharacterPlaceholders(user, value), char.Prompt)
+	case "SystemRules":
+		user.GetCurrentCharacter().SystemRules = replaceCharacterPlaceholders(user, value)
+	case "Mode":
+		mode := domain.CharacterMode(strings.ToLower(strings.TrimSpace(value)))
+		if !mode.IsValid() {
+			return fmt.Errorf("%w: mode must be %s or %s, got %q", ErrInvalidParameters, domain.ModeAssistant, domain.ModeRoleplay, value)
+		}
+		user.GetCurrentCharacter().ApplyMode(mode, uc.defaultModelConfig)
+	case "EnablePlaceholders":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().EnablePlaceholders = &enabled
+	case "Normalization":
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return fmt.Errorf("%w: expected a rule and on or off, got %q", ErrInvalidParameters, value)
+		}
+		enabled, err := parseToggle(fields[1])
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+		}
+		if err := user.GetCurrentCharacter().Normalization.Set(strings.ToLower(fields[0]), enabled); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidParameters, err)
+		}
+	case "JSONMode":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		config := uc.GetModelConfig(user)
+		config.JSONMode = enabled
+		user.GetCurrentCharacter().ModelConfig = &config
+	case "TrimUserLines":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().TrimUserLines = enabled
+	case "TrimMarker":
+		user.GetCurrentCharacter().TrimMarker = strings.TrimSpace(value)
+	case "AuthorsNote":
+		user.GetCurrentCharacter().AuthorsNote = replaceCharacterPlaceholders(user, value)
+	case "NoteDepth":
+		depth, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || depth < 0 || depth > maxNoteDepth {
+			return fmt.Errorf("%w: note depth must be an integer between 0 and %d, got %q", ErrInvalidParameters, maxNoteDepth, value)
+		}
+		user.GetCurrentCharacter().NoteDepth = depth
+	case "Timezone":
+		// "-" возвращает UTC; пояс используется сводками и плейсхолдерами {{date}} и {{time}}
+		timezone := strings.TrimSpace(value)
+		if timezone == "-" {
+			timezone = ""
+		} else if _, err := time.LoadLocation(timezone); err != nil || timezone == "" {
+			return fmt.Errorf("%w: %s", ErrInvalidTimezone, timezone)
+		}
+		user.Timezone = timezone
 	case "UserName":
 		user.UserName = value
 	case "UserDescription":
 		user.UserDescription = user.ReplacePlaceholders(value)
+	case "AddGreeting":
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("%w: greeting must not be empty", ErrInvalidParameters)
+		}
+		char := user.GetCurrentCharacter()
+		char.AlternateGreetings = append(char.AlternateGreetings, domain.AlternateGreeting{Text: replaceCharacterPlaceholders(user, value), Weight: 1})
+	case "AddExample":
+		pairs, err := parseExampleDialogue(value)
+		if err != nil {
+			return err
+		}
+		char := user.GetCurrentCharacter()
+		if char.ExamplePairs()+len(pairs) > domain.MaxExamplePairs {
+			return fmt.Errorf("%w: a character can have at most %d example exchanges", ErrInvalidParameters, domain.MaxExamplePairs)
+		}
+		for _, pair := range pairs {
+			char.AddExample(replaceCharacterPlaceholders(user, pair.user), replaceCharacterPlaceholders(user, pair.char))
+		}
+	case "ClearExamples":
+		user.GetCurrentCharacter().ExampleDialogue = nil
+	case "GreetingWeight":
+		// Формат "<номер> <вес>": номер 1 - основное приветствие, 2 и далее - альтернативные
+		char := user.GetCurrentCharacter()
+		var number int
+		var weight float64
+		if _, err := fmt.Sscanf(value, "%d %g", &number, &weight); err != nil {
+			return fmt.Errorf("%w: expected <number> <weight>, got %q", ErrInvalidParameters, value)
+		}
+		if !domain.ValidGreetingWeight(weight) {
+			return fmt.Errorf("%w: weight must be between 0 and %d", ErrInvalidParameters, domain.MaxGreetingWeight)
+		}
+		if number < 1 || number > len(char.AlternateGreetings)+1 {
+			return fmt.Errorf("%w: greeting number must be between 1 and %d", ErrInvalidParameters, len(char.AlternateGreetings)+1)
+		}
+		if number == 1 {
+			char.GreetingWeight = &weight
+		} else {
+			char.AlternateGreetings[number-2].Weight = weight
+		}
+	case "DeleteGreeting":
+		char := user.GetCurrentCharacter()
+		number, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || number < 2 || number > len(char.AlternateGreetings)+1 {
+			return fmt.Errorf("%w: only alternate greetings (2 to %d) can be deleted", ErrInvalidParameters, len(char.AlternateGreetings)+1)
+		}
+		char.AlternateGreetings = append(char.AlternateGreetings[:number-2], char.AlternateGreetings[number-1:]...)
+	case "Temperature":
+		if err := uc.setModelParameter(user, "temperature", value); err != nil {
+			return err
+		}
+	case "MaxTokens":
+		if err := uc.setModelParameter(user, "max_tokens", value); err != nil {
+			return err
+		}
+	case "AddStopSequence":
+		if err := uc.addStopSequence(user, value); err != nil {
+			return err
+		}
+	case "ClearStopSequences":
+		config := uc.GetModelConfig(user)
+		config.StopSequences = nil
+		user.GetCurrentCharacter().ModelConfig = &config
 	case "CharacterName":
 		user.GetCurrentCharacter().Name = value
+	case "DisplayName":
+		user.GetCurrentCharacter().DisplayName = strings.TrimSpace(value)
 	case "Greeting":
-		user.GetCurrentCharacter().Greeting = user.ReplacePlaceholders(value)
+		user.GetCurrentCharacter().Greeting = replaceCharacterPlaceholders(user, value)
+	case "OOCInstruction":
+		user.OOCInstruction = value
+	case "NextContext":
+		user.NextContext = value
+	case "Ephemeral":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().Ephemeral = enabled
+	case "TranslateGreeting":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().TranslateGreeting = enabled
+	case "SeedGreeting":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().SeedGreeting = enabled
+	case "SummarizeHistory":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().SummarizeHistory = enabled
+	case "PromptPosition":
+		position := domain.PromptPosition(strings.ToLower(strings.TrimSpace(value)))
+		if !position.IsValid() {
+			return fmt.Errorf("invalid prompt position: %s", value)
+		}
+		user.GetCurrentCharacter().PromptPosition = position
+	case "WhisperMode":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.WhisperMode = enabled
+	case "LabelTurns":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().LabelTurns = enabled
+	case "TimeBudget":
+		seconds, err := parseTimeBudget(value)
+		if err != nil {
+			return err
+		}
+		user.GetCurrentCharacter().TimeBudget = seconds
+	case "MinResponseLength":
+		minLength, maxContinuations, err := parseMinResponseLength(value)
+		if err != nil {
+			return err
+		}
+		char := user.GetCurrentCharacter()
+		char.MinResponseLength = minLength
+		char.MaxContinuations = maxContinuations
+	case "AutoLanguage":
+		enabled, err := parseToggle(value)
+		if err != nil {
+			return err
+		}
+		char := user.GetCurrentCharacter()
+		char.AutoLanguage = enabled
+		char.DetectedLanguage = ""
 	default:
 		return fmt.Errorf("unknown user property: %s", prop)
 	}
-	return uc.userRepo.SaveUser(ctx, user)
+	if !userProperties[prop] {
+		user.GetCurrentCharacter().Touch()
+	}
+	return nil
 }
 
 // ChangeCurrentCharacter changes the active character for the user.
@@ -169,8 +1044,114 @@ func (uc *UserInteractor) ChangeCurrentCharacter(ctx context.Context, user *doma
 	if index < 0 || index >= len(user.Characters) {
 		return fmt.Errorf("invalid character index: %d", index)
 	}
-	user.ChangeCurrentCharacter(index)
-	return uc.userRepo.SaveUser(ctx, user)
+	targetID := user.Characters[index].ID
+	change := func(u *domain.User) error {
+		i, err := characterIndexByID(u, targetID)
+		if err != nil {
+			return err
+		}
+		if uc.carryOverTurns > 0 && i != u.CurrentCharacterID {
+			u.Characters[i].CarryOver = domain.CarryOverText(u.GetCurrentCharacter(), uc.carryOverTurns)
+		}
+		u.ChangeCurrentCharacter(i)
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, change); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditChangeCharacter, fmt.Sprintf("id=%d", user.GetCurrentCharacter().ID))
+	return nil
+}
+
+// MoveCharacter перемещает персонажа с позиции fromIndex на позицию toIndex в списке пользователя.
+// Текущий персонаж при этом не меняется.
+func (uc *UserInteractor) MoveCharacter(ctx context.Context, user *domain.User, fromIndex, toIndex int) error {
+	if fromIndex < 0 || fromIndex >= len(user.Characters) {
+		return fmt.Errorf("invalid character index: %d", fromIndex)
+	}
+	if toIndex < 0 || toIndex >= len(user.Characters) {
+		return fmt.Errorf("invalid character index: %d", toIndex)
+	}
+	if fromIndex == toIndex {
+		return nil
+	}
+	movedID := user.Characters[fromIndex].ID
+	move := func(u *domain.User) error {
+		from, err := characterIndexByID(u, movedID)
+		if err != nil {
+			return err
+		}
+		if toIndex >= len(u.Characters) {
+			return fmt.Errorf("%w: character list changed", ErrVersionConflict)
+		}
+		u.MoveCharacter(from, toIndex)
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, move); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditMoveCharacter, fmt.Sprintf("id=%d from %d to %d", movedID, fromIndex, toIndex))
+	return nil
+}
+
+// DeleteCharacter удаляет персонажа с индексом index вместе с его историей. Единственного
+// персонажа удалить нельзя (ErrLastCharacter), текущим становится соседний персонаж.
+func (uc *UserInteractor) DeleteCharacter(ctx context.Context, user *domain.User, index int) error {
+	if index < 0 || index >= len(user.Characters) {
+		return fmt.Errorf("invalid character index: %d", index)
+	}
+	if len(user.Characters) == 1 {
+		return ErrLastCharacter
+	}
+	deleted := user.Characters[index]
+	remove := func(u *domain.User) error {
+		i, err := characterIndexByID(u, deleted.ID)
+		if err != nil {
+			return err
+		}
+		if len(u.Characters) == 1 {
+			return ErrLastCharacter
+		}
+		u.RemoveCharacter(i)
+		return nil
+	}
+	if err := uc.updateUser(ctx, user, remove); err != nil {
+		return err
+	}
+	uc.audit(ctx, user.ID, AuditDeleteCharacter, fmt.Sprintf("id=%d %s", deleted.ID, deleted.Name))
+	return nil
+}
+
+// ForgetUser удаляет все данные пользователя по его запросу: документ, историю сообщений, отложенное
+// сохранение и записанный запрос к модели. После удаления объект user отмечается удаленным и не должен
+// сохраняться; следующее сообщение пользователя создаст его заново с настройками по умолчанию.
+// В журнале аудита остается только ID пользователя и факт удаления.
+func (uc *UserInteractor) ForgetUser(ctx context.Context, user *domain.User) error {
+	uc.dropPendingSave(user.ID) // Иначе очередь досохранила бы пользователя при следующей загрузке
+	if err := uc.userRepo.DeleteUser(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	user.MarkDeleted()
+	uc.ephemeralChats.forget(user.ID)
+
+	uc.lastRequestsMu.Lock()
+	delete(uc.lastRequests, user.ID)
+	uc.lastRequestsMu.Unlock()
+
+	uc.logger.Info("Deleted all data of user %d at their request", user.ID)
+	uc.audit(ctx, user.ID, AuditDeleteUser, "")
+	return nil
+}
+
+// joinPromptParts соединяет две части промпта переводом строки, пропуская пустые части.
+func joinPromptParts(first, second string) string {
+	if strings.TrimSpace(first) == "" {
+		return second
+	}
+	if strings.TrimSpace(second) == "" {
+		return first
+	}
+	return strings.TrimRight(first, "\n") + "\n" + second
 }
 
 // ChatHistoryLimit возвращает текущий лимит истории чата.
@@ -178,16 +1159,154 @@ func (uc *UserInteractor) ChatHistoryLimit() int {
 	return uc.chatHistoryLimit
 }
 
+// loadUser загружает пользователя; пользователь, которого не удалось досохранить из очереди отложенных
+// сохранений, возвращается из очереди.
+func (uc *UserInteractor) loadUser(ctx context.Context, userID int64) (*domain.User, error) {
+	if pending := uc.takePendingSave(ctx, userID); pending != nil {
+		return pending, nil
+	}
+	return uc.reloadUser(ctx, userID)
+}
+
+// reloadUser загружает пользователя из хранилища с учетом лимита загружаемой истории и подставляет
+// историю эфемерных персонажей из памяти.
+func (uc *UserInteractor) reloadUser(ctx context.Context, userID int64) (*domain.User, error) {
+	var user *domain.User
+	var err error
+	if uc.historyLoadLimit > 0 {
+		user, err = uc.userRepo.LoadUserWithRecentHistory(ctx, userID, uc.historyLoadLimit)
+	} else {
+		user, err = uc.userRepo.LoadUser(ctx, userID)
+	}
+	if user != nil {
+		uc.ephemeralChats.restore(user)
+	}
+	return user, err
+}
+
 // applyPlaceholdersToMessages применяет плейсхолдеры к сообщениям.
+// Если у персонажа плейсхолдеры отключены (EnablePlaceholders), текст сообщений не изменяется.
+// Метаданные сообщений сохраняются: по MetaExample fitContextWindow узнает реплики примера диалога.
+// Сначала заменяются {{date}}, {{time}} и {{idle}}, затем {{user}} и {{char}}, чтобы имена, подставляемые
+// здесь (в том числе в метки говорящих), не раскрывались как плейсхолдеры времени.
 func (uc *UserInteractor) applyPlaceholdersToMessages(messages []domain.ChatMessage, user *domain.User) []domain.ChatMessage {
+	labelTurns := user.GetCurrentCharacter().LabelTurns
+	now := time.Now()
 	processedMessages := make([]domain.ChatMessage, len(messages))
+	if !user.GetCurrentCharacter().PlaceholdersEnabled() {
+		// Плейсхолдеры в тексте остаются как есть, имена подставляются только в метки говорящих
+		for i, msg := range messages {
+			role := msg.RoleEnum()
+			content := msg.Content
+			if labelTurns {
+				labeled := labelTurn(user, role, content)
+				content = user.ReplacePlaceholders(labeled[:len(labeled)-len(content)]) + content
+			}
+			processedMessages[i] = domain.NewChatMessage(role, content)
+			processedMessages[i].Meta = msg.Meta
+		}
+		return processedMessages
+	}
 	for i, msg := range messages {
-		processedContent := user.ReplacePlaceholders(msg.Content)
+		role := msg.RoleEnum()
+		content := user.ReplaceTimePlaceholders(msg.Content, now)
+		if labelTurns {
+			content = labelTurn(user, role, content)
+		}
+		processedContent := user.ReplacePlaceholders(content)
 		// Проверяем, нужно ли применять плейсхолдеры для персонажа, если это не системное сообщение
-		if msg.ERole != domain.System {
+		if role != domain.System {
 			processedContent = user.GetCurrentCharacter().ReplacePlaceholders(processedContent)
 		}
-		processedMessages[i] = domain.NewChatMessage(msg.ERole, processedContent)
+		processedMessages[i] = domain.NewChatMessage(role, domain.UnescapePlaceholders(processedContent))
+		processedMessages[i].Meta = msg.Meta
 	}
 	return processedMessages
 }
+
+// replaceCharacterPlaceholders заменяет плейсхолдеры в тексте, относящемся к текущему персонажу,
+// если они не отключены для него.
+func replaceCharacterPlaceholders(user *domain.User, text string) string {
+	if !user.GetCurrentCharacter().PlaceholdersEnabled() {
+		return text
+	}
+	return user.ReplacePlaceholders(text)
+}
+
+// labelTurn добавляет к реплике метку говорящего ("{{user}}: " или "{{char}}: ").
+// Системные сообщения не помечаются, а уже помеченные реплики не помечаются повторно: ни меткой-плейсхолдером,
+// ни именем говорящего (модель, видящая помеченную историю, часто сама начинает ответ с имени персонажа).
+func labelTurn(user *domain.User, role domain.RoleEnums, content string) string {
+	var label, name string
+	switch role {
+	case domain.UserRole:
+		label, name = "{{user}}: ", user.UserName
+	case domain.Assistant:
+		label, name = "{{char}}: ", user.GetCurrentCharacter().Name
+	default:
+		return content
+	}
+	if strings.HasPrefix(content, label) || (name != "" && strings.HasPrefix(content, name+": ")) {
+		return content
+	}
+	return label + content
+}
+
+// detectLanguage обновляет язык персонажа, если включен AutoLanguage и детектор достаточно уверен.
+// При низкой уверенности сохраняется ранее определенный язык, чтобы ответы не "прыгали" между языками.
+func (uc *UserInteractor) detectLanguage(char *domain.CharacterPreset, text string) {
+	if !char.AutoLanguage || uc.languageDetector == nil {
+		return
+	}
+	language, confidence := uc.languageDetector.Detect(text)
+	if language == "" || confidence < uc.minLanguageConfidence {
+		uc.logger.DebugInfo("Language detection skipped (language=%q, confidence=%.2f)", language, confidence)
+		return
+	}
+	char.DetectedLanguage = language
+}
+
+// appendLanguageInstruction добавляет системную инструкцию отвечать на определенном языке.
+func (uc *UserInteractor) appendLanguageInstruction(messages []domain.ChatMessage, char *domain.CharacterPreset) []domain.ChatMessage {
+	if !char.AutoLanguage || char.DetectedLanguage == "" {
+		return messages
+	}
+	instruction := fmt.Sprintf("Always respond in %s, the language the user is writing in.", char.DetectedLanguage)
+	return append(messages, domain.NewChatMessage(domain.System, instruction))
+}
+
+// parseMinResponseLength разбирает значение вида "<символов> [продолжений]" для MinResponseLength.
+// Если количество продолжений не указано, используется defaultContinuations.
+func parseMinResponseLength(value string) (int, int, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, fmt.Errorf("%w: expected minimum length and optional continuation count", ErrInvalidParameters)
+	}
+	minLength, err := strconv.Atoi(fields[0])
+	if err != nil || minLength < 0 {
+		return 0, 0, fmt.Errorf("%w: minimum length must be a non-negative integer, got %q", ErrInvalidParameters, fields[0])
+	}
+	maxContinuations := defaultContinuations
+	if len(fields) == 2 {
+		maxContinuations, err = strconv.Atoi(fields[1])
+		if err != nil || maxContinuations < 0 || maxContinuations > maxContinuationsLimit {
+			return 0, 0, fmt.Errorf("%w: continuation count must be between 0 and %d, got %q", ErrInvalidParameters, maxContinuationsLimit, fields[1])
+		}
+	}
+	if minLength == 0 {
+		maxContinuations = 0
+	}
+	return minLength, maxContinuations, nil
+}
+
+// parseToggle преобразует пользовательский ввод вида "on"/"off" в bool.
+func parseToggle(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "on", "true", "yes", "1":
+		return true, nil
+	case "off", "false", "no", "0":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid toggle value: %s", value)
+	}
+}
@@ -0,0 +1,583 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestGetOrCreateUserRepairsCorruptedUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		stored      string
+		wantChars   int
+		wantCurrent int
+	}{
+		{name: "null character list", stored: `{"id":7,"user_name":"bob","characters":null,"version":1}`, wantChars: 1},
+		{name: "null entries", stored: `{"id":7,"user_name":"bob","characters":[null,{"id":0,"name":"Ann"},null],"current_character_id":2,"version":1}`, wantChars: 1},
+		{name: "duplicate IDs", stored: `{"id":7,"user_name":"bob","characters":[{"id":1,"name":"Ann"},{"id":1,"name":"Bea"}],"current_character_id":1,"version":1}`, wantChars: 2, wantCurrent: 1},
+		{name: "current index out of range", stored: `{"id":7,"user_name":"bob","characters":[{"id":0,"name":"Ann"}],"current_character_id":9,"version":1}`, wantChars: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository()
+			repo.users[7] = []byte(tt.stored)
+			uc := newTestInteractor(repo, &stubGateway{}, nil)
+
+			user, err := uc.GetOrCreateUser(context.Background(), 7, "bob")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			if len(user.Characters) != tt.wantChars || user.CurrentCharacterID != tt.wantCurrent {
+				t.Fatalf("got %d characters with current index %d, want %d and %d",
+					len(user.Characters), user.CurrentCharacterID, tt.wantChars, tt.wantCurrent)
+			}
+			if char := user.GetCurrentCharacter(); char == nil || char.Chat == nil {
+				t.Fatal("current character or its chat is nil after repair")
+			}
+
+			stored, err := repo.LoadUser(context.Background(), 7)
+			if err != nil {
+				t.Fatalf("LoadUser: %v", err)
+			}
+			if repairs := stored.Repair(); len(repairs) != 0 {
+				t.Errorf("repaired user was not saved, stored data still needs %q", repairs)
+			}
+		})
+	}
+}
+
+func TestMoveCharacter(t *testing.T) {
+	tests := []struct {
+		name      string
+		from, to  int
+		wantErr   bool
+		wantNames []string
+		wantSaved bool
+	}{
+		{name: "move to the end", from: 0, to: 2, wantNames: []string{"Bea", "Cid", "Ann"}, wantSaved: true},
+		{name: "move to the start", from: 2, to: 0, wantNames: []string{"Cid", "Ann", "Bea"}, wantSaved: true},
+		{name: "same position", from: 1, to: 1, wantNames: []string{"Ann", "Bea", "Cid"}},
+		{name: "invalid from", from: 3, to: 0, wantErr: true, wantNames: []string{"Ann", "Bea", "Cid"}},
+		{name: "invalid to", from: 0, to: -1, wantErr: true, wantNames: []string{"Ann", "Bea", "Cid"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{}, nil)
+			user := domain.NewUser(1, "alice")
+			user.Characters = nil
+			for i, name := range []string{"Ann", "Bea", "Cid"} {
+				char := domain.NewCharacterPreset()
+				char.ID = i
+				char.Name = name
+				user.Characters = append(user.Characters, char)
+			}
+			user.CurrentCharacterID = 1
+			if err := repo.SaveUser(context.Background(), user); err != nil {
+				t.Fatalf("SaveUser: %v", err)
+			}
+			saves := repo.saves
+
+			err := uc.MoveCharacter(context.Background(), user, tt.from, tt.to)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveCharacter() error = %v, want error %v", err, tt.wantErr)
+			}
+			stored, err := repo.LoadUser(context.Background(), 1)
+			if err != nil {
+				t.Fatalf("LoadUser: %v", err)
+			}
+			for i, name := range tt.wantNames {
+				if stored.Characters[i].Name != name {
+					t.Errorf("stored character %d = %q, want %q", i, stored.Characters[i].Name, name)
+				}
+				if user.Characters[i].Name != name {
+					t.Errorf("character %d = %q, want %q", i, user.Characters[i].Name, name)
+				}
+			}
+			if saved := repo.saves > saves; saved != tt.wantSaved {
+				t.Errorf("user saved = %v, want %v", saved, tt.wantSaved)
+			}
+			if got := user.GetCurrentCharacter().Name; got != "Bea" {
+				t.Errorf("current character = %q, want Bea", got)
+			}
+		})
+	}
+}
+
+func TestAddCharacterNaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		autoName bool
+		existing []string
+		remove   int // Индекс персонажа, удаляемого перед добавлением (-1 - не удалять)
+		input    string
+		want     string
+	}{
+		{name: "untitled", autoName: true, existing: []string{"Ann"}, remove: -1, input: "", want: "Character 1"},
+		{name: "default name", autoName: true, existing: []string{"Character 1"}, remove: -1, input: domain.DefaultCharacterName, want: "Character 2"},
+		{name: "gap after deletion", autoName: true, existing: []string{"Character 1", "Character 2", "Character 3"}, remove: 1, input: "", want: "Character 2"},
+		{name: "duplicate name", autoName: true, existing: []string{"Ann", "Ann (2)"}, remove: -1, input: "Ann", want: "Ann (3)"},
+		{name: "name is trimmed before the check", autoName: true, existing: []string{"Ann"}, remove: -1, input: "  Ann ", want: "Ann (2)"},
+		{name: "disabled", autoName: false, existing: []string{"Ann"}, remove: -1, input: "Ann", want: "Ann"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{}, func(opts *UserInteractorOptions) {
+				opts.AutoNameCharacters = tt.autoName
+			})
+			user := domain.NewUser(1, "alice")
+			user.Characters[0].Name = tt.existing[0]
+			for _, name := range tt.existing[1:] {
+				char := domain.NewCharacterPreset()
+				char.ID = user.NextCharacterID()
+				char.Name = name
+				user.Characters = append(user.Characters, char)
+			}
+			if tt.remove >= 0 {
+				user.RemoveCharacter(tt.remove)
+			}
+
+			char := domain.NewCharacterPreset()
+			char.Name = tt.input
+			if err := uc.AddCharacter(context.Background(), user, char); err != nil {
+				t.Fatalf("AddCharacter: %v", err)
+			}
+			if char.Name != tt.want {
+				t.Errorf("added character name = %q, want %q", char.Name, tt.want)
+			}
+			if user.GetCurrentCharacter() != char {
+				t.Error("added character is not selected")
+			}
+		})
+	}
+}
+
+func TestUpdateGreetingWeight(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		wantErr    bool
+		wantMain   float64
+		wantWeight float64 // Вес альтернативного приветствия
+	}{
+		{name: "main greeting", value: "1 3", wantMain: 3, wantWeight: 1},
+		{name: "alternate greeting", value: "2 0.5", wantMain: 1, wantWeight: 0.5},
+		{name: "zero weight", value: "2 0", wantMain: 1, wantWeight: 0},
+		{name: "negative weight", value: "2 -1", wantErr: true, wantMain: 1, wantWeight: 1},
+		{name: "too large weight", value: "1 1001", wantErr: true, wantMain: 1, wantWeight: 1},
+		{name: "unknown greeting", value: "3 1", wantErr: true, wantMain: 1, wantWeight: 1},
+		{name: "malformed", value: "heavy", wantErr: true, wantMain: 1, wantWeight: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+			user := domain.NewUser(1, "alice")
+			char := user.GetCurrentCharacter()
+			char.Greeting = "Hello"
+			char.AlternateGreetings = []domain.AlternateGreeting{{Text: "Hi", Weight: 1}}
+
+			err := uc.UpdateUserProperty(context.Background(), user, "GreetingWeight", tt.value)
+			if tt.wantErr != errors.Is(err, ErrInvalidParameters) {
+				t.Fatalf("UpdateUserProperty(%q) error = %v, want invalid parameters %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("UpdateUserProperty: %v", err)
+			}
+			if got := char.MainGreetingWeight(); got != tt.wantMain {
+				t.Errorf("main greeting weight = %v, want %v", got, tt.wantMain)
+			}
+			if got := char.AlternateGreetings[0].Weight; got != tt.wantWeight {
+				t.Errorf("alternate greeting weight = %v, want %v", got, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func TestModelLimits(t *testing.T) {
+	const adminID = 42
+	tests := []struct {
+		name       string
+		userID     int64
+		saved      *domain.ModelConfig
+		update     string
+		wantTokens int
+		wantTemp   float64
+		wantNotice bool
+	}{
+		{name: "defaults within limits", userID: 1, wantTokens: 500, wantTemp: 0.7},
+		{name: "saved value above the limit", userID: 1, saved: &domain.ModelConfig{MaxTokens: 4000, Temperature: 1.9}, wantTokens: 1000, wantTemp: 1.2},
+		{name: "update above the limit", userID: 1, update: `{"max_tokens": 3000}`, wantTokens: 1000, wantTemp: 0.7, wantNotice: true},
+		{name: "update within the limit", userID: 1, update: `{"temperature": 1.1}`, wantTokens: 500, wantTemp: 1.1},
+		{name: "admin limits", userID: adminID, saved: &domain.ModelConfig{MaxTokens: 4000, Temperature: 1.9}, wantTokens: 2000, wantTemp: 1.9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, func(opts *UserInteractorOptions) {
+				opts.AdminUserIDs = []int64{adminID}
+				opts.ModelLimits = domain.ModelConfigLimits{"max_tokens": 1000, "temperature": 1.2}
+				opts.AdminModelLimits = domain.ModelConfigLimits{"max_tokens": 2000}
+			})
+			user := domain.NewUser(tt.userID, "alice")
+			user.GetCurrentCharacter().ModelConfig = tt.saved
+
+			if tt.update != "" {
+				notices, err := uc.UpdateModelConfig(context.Background(), user, tt.update)
+				if err != nil {
+					t.Fatalf("UpdateModelConfig: %v", err)
+				}
+				if got := len(notices) > 0; got != tt.wantNotice {
+					t.Errorf("notices = %q, want notices %v", notices, tt.wantNotice)
+				}
+			}
+			config := uc.GetModelConfig(user)
+			if config.MaxTokens != tt.wantTokens || config.Temperature != tt.wantTemp {
+				t.Errorf("max_tokens %d, temperature %g; want %d, %g", config.MaxTokens, config.Temperature, tt.wantTokens, tt.wantTemp)
+			}
+		})
+	}
+}
+
+func TestSeedGreeting(t *testing.T) {
+	tests := []struct {
+		name      string
+		seed      bool
+		wantChat  []string
+		wantRoles []string // Роли сообщений истории в первом запросе к модели
+	}{
+		{name: "seeded", seed: true, wantChat: []string{"Welcome!", "hello", "Hi!", "again", "Hi!"}, wantRoles: []string{"assistant", "user"}},
+		{name: "not seeded", seed: false, wantChat: []string{"hello", "Hi!", "again", "Hi!"}, wantRoles: []string{"user"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			gateway := &stubGateway{responses: []string{"Hi!"}}
+			uc := newTestInteractor(repo, gateway, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			char := user.GetCurrentCharacter()
+			char.Prompt = "You are Bob."
+			char.Greeting = "Welcome!"
+			char.SeedGreeting = tt.seed
+			if err := uc.SaveUser(ctx, user); err != nil {
+				t.Fatalf("SaveUser: %v", err)
+			}
+
+			for _, text := range []string{"hello", "again"} {
+				if _, err := uc.GetModelResponseForUser(ctx, user, text); err != nil {
+					t.Fatalf("GetModelResponseForUser(%q): %v", text, err)
+				}
+			}
+			if got := chatContents(t, repo, 1); !reflect.DeepEqual(got, tt.wantChat) {
+				t.Errorf("stored chat = %q, want %q", got, tt.wantChat)
+			}
+
+			// Приветствие идет сразу после системного промпта и перед первой репликой пользователя
+			var roles []string
+			for _, msg := range gateway.requests[0][1:] {
+				roles = append(roles, msg.Role)
+			}
+			if !reflect.DeepEqual(roles, tt.wantRoles) {
+				t.Errorf("first request roles after the prompt = %q, want %q", roles, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestTrimUserLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		marker   string
+		response string
+		want     string
+	}{
+		{name: "disabled", enabled: false, response: "Hello!\nAlice: hi", want: "Hello!\nAlice: hi"},
+		{name: "default marker", enabled: true, response: "Hello there!\nAlice: and then I said", want: "Hello there!"},
+		{name: "no marker", enabled: true, response: "Hello there!\nBob: more", want: "Hello there!\nBob: more"},
+		{name: "only the first marker counts", enabled: true, response: "One.\nAlice: two\nBob: three\nAlice: four", want: "One."},
+		{name: "marker at the start keeps the response", enabled: true, response: "Alice: hi", want: "Alice: hi"},
+		{name: "custom marker", enabled: true, marker: "[{{user}}]", response: "Fine.\n[Alice] ok", want: "Fine."},
+		{name: "custom marker does not match the default", enabled: true, marker: "[{{user}}]", response: "Fine.\nAlice: ok", want: "Fine.\nAlice: ok"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uc := newTestInteractor(newMemoryRepository(), &stubGateway{}, nil)
+			user := domain.NewUser(1, "Alice")
+			char := user.GetCurrentCharacter()
+			char.Name = "Bob"
+			char.TrimUserLines = tt.enabled
+			char.TrimMarker = tt.marker
+
+			if got := uc.trimUserLines(user, tt.response); got != tt.want {
+				t.Errorf("trimUserLines(%q) = %q, want %q", tt.response, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClearContext(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	gateway := &stubGateway{responses: []string{"Hi!"}}
+	uc := newTestInteractor(repo, gateway, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	// dialogue возвращает тексты сообщений пользователя и персонажа в последнем запросе к модели
+	dialogue := func() []string {
+		var texts []string
+		for _, msg := range gateway.requests[len(gateway.requests)-1] {
+			if msg.Role != domain.System.String() {
+				texts = append(texts, msg.Content)
+			}
+		}
+		return texts
+	}
+
+	if _, err := uc.GetModelResponseForUser(ctx, user, "one"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+	hidden, err := uc.ClearContext(ctx, user)
+	if err != nil || hidden != 2 {
+		t.Fatalf("ClearContext = %d, %v; want 2 hidden messages", hidden, err)
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "two"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+	if got, want := dialogue(), []string{"two"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("request after /clearcontext = %q, want %q", got, want)
+	}
+	if got, want := chatContents(t, repo, 1), []string{"one", "Hi!", "two", "Hi!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored chat = %q, want the hidden messages kept: %q", got, want)
+	}
+
+	if err := uc.ClearChatHistory(ctx, user); err != nil {
+		t.Fatalf("ClearChatHistory: %v", err)
+	}
+	if start := user.GetCurrentCharacter().ContextStart; start != 0 {
+		t.Errorf("ContextStart after /clearchat = %d, want 0", start)
+	}
+	if _, err := uc.GetModelResponseForUser(ctx, user, "three"); err != nil {
+		t.Fatalf("GetModelResponseForUser: %v", err)
+	}
+	if got, want := dialogue(), []string{"three"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("request after /clearchat = %q, want %q", got, want)
+	}
+}
+
+func TestCarryOverAfterCharacterSwitch(t *testing.T) {
+	tests := []struct {
+		name  string
+		turns int
+		want  string // Перенесенный разговор ("" - не переносится)
+	}{
+		{name: "disabled", turns: 0},
+		{name: "last two messages", turns: 2, want: "alice: more\nAnn: Sure."},
+		{name: "more turns than history", turns: 10, want: "alice: hello\nAnn: Hi!\nalice: more\nAnn: Sure."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			gateway := &stubGateway{responses: []string{"Hi!", "Sure.", "Nice to meet you."}}
+			uc := newTestInteractor(newMemoryRepository(), gateway, func(opts *UserInteractorOptions) {
+				opts.CarryOverTurns = tt.turns
+			})
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			user.GetCurrentCharacter().Name = "Ann"
+			bea := domain.NewCharacterPreset()
+			bea.ID, bea.Name, bea.Prompt = 1, "Bea", "You are Bea."
+			user.Characters = append(user.Characters, bea)
+			if err := uc.SaveUser(ctx, user); err != nil {
+				t.Fatalf("SaveUser: %v", err)
+			}
+			for _, text := range []string{"hello", "more"} {
+				if _, err := uc.GetModelResponseForUser(ctx, user, text); err != nil {
+					t.Fatalf("GetModelResponseForUser(%q): %v", text, err)
+				}
+			}
+
+			if err := uc.ChangeCurrentCharacter(ctx, user, 1); err != nil {
+				t.Fatalf("ChangeCurrentCharacter: %v", err)
+			}
+			if _, err := uc.GetModelResponseForUser(ctx, user, "who are you?"); err != nil {
+				t.Fatalf("GetModelResponseForUser: %v", err)
+			}
+
+			request := gateway.requests[len(gateway.requests)-1]
+			var got []string
+			for _, msg := range request {
+				got = append(got, msg.Content)
+			}
+			want := []string{"You are Bea."}
+			if tt.want != "" {
+				want = append(want, "Previous conversation between alice and Ann, a different character. "+
+					"It is context only; do not continue speaking as Ann.\n"+tt.want)
+			}
+			want = append(want, "who are you?")
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("request after the switch = %q, want %q", got, want)
+			}
+			if chat := user.GetCurrentCharacter().Chat; len(chat) != 2 {
+				t.Errorf("new character history has %d messages, want only its own 2", len(chat))
+			}
+		})
+	}
+}
+
+func TestNextContextUsedOnce(t *testing.T) {
+	const fact = "The meeting is at 5 pm."
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	gateway := &stubGateway{responses: []string{"", "Noted.", "Sure."}, errs: []error{errors.New("backend down")}}
+	uc := newTestInteractor(repo, gateway, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if err := uc.UpdateUserProperty(ctx, user, "NextContext", fact); err != nil {
+		t.Fatalf("UpdateUserProperty: %v", err)
+	}
+	// hasContext сообщает, передан ли одноразовый контекст в i-м запросе к модели
+	hasContext := func(i int) bool {
+		for _, msg := range gateway.requests[i] {
+			if msg.Content == nextContextHeader+fact {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Неудачная генерация не расходует контекст
+	if _, err := uc.GetModelResponseForUser(ctx, user, "when?"); err == nil {
+		t.Fatal("GetModelResponseForUser succeeded, want the backend error")
+	}
+	for i, text := range []string{"when?", "and then?"} {
+		if _, err := uc.GetModelResponseForUser(ctx, user, text); err != nil {
+			t.Fatalf("GetModelResponseForUser(%q): %v", text, err)
+		}
+		if i == 0 && user.NextContext != "" {
+			t.Errorf("NextContext = %q after the reply, want it cleared", user.NextContext)
+		}
+	}
+	for i, want := range []bool{true, true, false} {
+		if got := hasContext(i); got != want {
+			t.Errorf("request %d includes the one-off context = %v, want %v", i+1, got, want)
+		}
+	}
+
+	stored, err := repo.LoadUser(ctx, 1)
+	if err != nil {
+		t.Fatalf("LoadUser: %v", err)
+	}
+	if stored.NextContext != "" {
+		t.Errorf("stored NextContext = %q, want it cleared", stored.NextContext)
+	}
+	if got, want := chatContents(t, repo, 1), []string{"when?", "Noted.", "and then?", "Sure."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored chat = %q, want %q without the context", got, want)
+	}
+}
+
+func TestUpdatedAtBumpedOnMutation(t *testing.T) {
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	property := func(prop, value string) func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+		return func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+			return uc.UpdateUserProperty(ctx, user, prop, value)
+		}
+	}
+	tests := []struct {
+		name   string
+		mutate func(ctx context.Context, uc *UserInteractor, user *domain.User) error
+		bumped bool
+	}{
+		{name: "prompt", mutate: property("Prompt", "You are Bob."), bumped: true},
+		{name: "greeting", mutate: property("Greeting", "Hi there."), bumped: true},
+		{name: "name", mutate: property("CharacterName", "Bob"), bumped: true},
+		{name: "example", mutate: property("AddExample", "{{user}}: hi\n{{char}}: hello"), bumped: true},
+		{name: "message", mutate: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+			_, err := uc.GetModelResponseForUser(ctx, user, "hello")
+			return err
+		}, bumped: true},
+		{name: "clear history", mutate: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+			return uc.ClearChatHistory(ctx, user)
+		}, bumped: true},
+		{name: "clear context", mutate: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+			_, err := uc.ClearContext(ctx, user)
+			return err
+		}, bumped: true},
+		{name: "document", mutate: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+			_, err := uc.AttachDocument(ctx, user, "notes.txt", []byte("Meeting at 5 pm."))
+			return err
+		}, bumped: true},
+		{name: "user name is not a character change", mutate: property("UserName", "Alice"), bumped: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{responses: []string{"Hi!"}}, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+			other := domain.NewCharacterPreset()
+			other.ID = 1
+			user.Characters = append(user.Characters, other)
+			for _, char := range user.Characters {
+				char.UpdatedAt = old
+			}
+			if err := uc.SaveUser(ctx, user); err != nil {
+				t.Fatalf("SaveUser: %v", err)
+			}
+
+			if err := tt.mutate(ctx, uc, user); err != nil {
+				t.Fatalf("mutation: %v", err)
+			}
+			stored, err := repo.LoadUser(ctx, 1)
+			if err != nil {
+				t.Fatalf("LoadUser: %v", err)
+			}
+			if bumped := stored.Characters[0].UpdatedAt.After(old); bumped != tt.bumped {
+				t.Errorf("UpdatedAt = %s, bumped = %v, want %v", stored.Characters[0].UpdatedAt, bumped, tt.bumped)
+			}
+			if got := stored.Characters[1].UpdatedAt; !got.Equal(old) {
+				t.Errorf("UpdatedAt of the other character = %s, want it unchanged", got)
+			}
+		})
+	}
+}
+
+func TestUpdatedAtOfLegacyCharacter(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	repo.users[7] = []byte(`{"id":7,"user_name":"bob","characters":[{"id":0,"name":"Ann"}],"version":1}`)
+	uc := newTestInteractor(repo, &stubGateway{}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 7, "bob")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if char := user.GetCurrentCharacter(); !char.LastActivity().IsZero() {
+		t.Errorf("LastActivity of a character without timestamps = %s, want zero", char.LastActivity())
+	}
+
+	before := time.Now().UTC()
+	if err := uc.UpdateUserProperty(ctx, user, "Prompt", "You are Ann."); err != nil {
+		t.Fatalf("UpdateUserProperty: %v", err)
+	}
+	char := user.GetCurrentCharacter()
+	if !char.CreatedAt.IsZero() || char.UpdatedAt.Before(before) {
+		t.Errorf("CreatedAt = %s, UpdatedAt = %s; want an unknown creation time and an update after %s", char.CreatedAt, char.UpdatedAt, before)
+	}
+}
@@ -0,0 +1,105 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+// UserMutation изменение пользователя, которое можно применить повторно к перечитанному из хранилища
+// пользователю. Функция должна изменять только переданного ей пользователя; ошибка отменяет сохранение.
+type UserMutation func(user *domain.User) error
+
+// UpdateUser применяет изменение mutate к пользователю и сохраняет его (см. updateUser).
+// Пользователь, удаленный через ForgetUser, не изменяется и не сохраняется.
+func (uc *UserInteractor) UpdateUser(ctx context.Context, user *domain.User, mutate UserMutation) error {
+	if user.Deleted() {
+		return nil // Заново пользователь создается только через GetOrCreateUser
+	}
+	return uc.updateUser(ctx, user, mutate)
+}
+
+// updateUser применяет изменение mutate к пользователю и сохраняет его. Если пользователя после загрузки
+// изменил другой обработчик или экземпляр бота (ErrVersionConflict, в том числе после дописывания
+// сообщений в историю), он перечитывается из хранилища, mutate применяется к перечитанному пользователю
+// заново, и сохранение повторяется не более conflictRetries раз. Так изменения обоих обработчиков
+// сохраняются, а user заменяется сохраненным пользователем.
+func (uc *UserInteractor) updateUser(ctx context.Context, user *domain.User, mutate UserMutation) error {
+	if err := mutate(user); err != nil {
+		return err
+	}
+	err := uc.saveUser(ctx, user)
+	for attempt := 1; errors.Is(err, ErrVersionConflict) && attempt <= uc.conflictRetries; attempt++ {
+		uc.logger.Warn("User %d changed since loading, reloading and re-applying the update (attempt %d)", user.ID, attempt)
+		stored, loadErr := uc.reloadUser(ctx, user.ID)
+		if loadErr != nil {
+			return fmt.Errorf("failed to reload user %d: %w", user.ID, loadErr)
+		}
+		if stored == nil {
+			return fmt.Errorf("%w: user %d was deleted", ErrVersionConflict, user.ID)
+		}
+		if err := mutate(stored); err != nil {
+			return err
+		}
+		if err = uc.saveUser(ctx, stored); err == nil {
+			*user = *stored
+		}
+	}
+	if errors.Is(err, ErrVersionConflict) {
+		uc.logger.Error("Giving up saving user %d after repeated version conflicts", user.ID)
+	}
+	return err
+}
+
+// saveUser сохраняет пользователя в хранилище и запоминает в памяти историю его эфемерных персонажей.
+func (uc *UserInteractor) saveUser(ctx context.Context, user *domain.User) error {
+	if err := uc.userRepo.SaveUser(ctx, user); err != nil {
+		return err
+	}
+	uc.ephemeralChats.remember(user)
+	return nil
+}
+
+// characterIndexByID возвращает позицию персонажа с идентификатором id. Если персонаж удален другим
+// обработчиком после загрузки пользователя, изменение применить нельзя, и возвращается ErrVersionConflict.
+func characterIndexByID(user *domain.User, id int) (int, error) {
+	for i, char := range user.Characters {
+		if char.ID == id {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: character %d was deleted", ErrVersionConflict, id)
+}
+
+// currentCharacterByID возвращает текущего персонажа пользователя, если его идентификатор равен id.
+// Изменение, запрошенное для одного персонажа, не применяется к другому, ставшему текущим после загрузки
+// пользователя: в этом случае возвращается ErrVersionConflict.
+func currentCharacterByID(user *domain.User, id int) (*domain.CharacterPreset, error) {
+	char := user.GetCurrentCharacter()
+	if char.ID != id {
+		return nil, fmt.Errorf("%w: current character changed from %d to %d", ErrVersionConflict, id, char.ID)
+	}
+	return char, nil
+}
+
+// replaceHistory возвращает изменение, которое заменяет историю персонажа char (сообщения, начало контекста
+// и краткое изложение) у перечитанного пользователя: история изменена не только добавлением сообщений
+// (например, удален последний ход), поэтому дописать ее нельзя.
+func replaceHistory(char *domain.CharacterPreset) UserMutation {
+	chat := append([]domain.ChatMessage(nil), char.Chat...)
+	contextStart, summary, lastSeq := char.ContextStart, char.HistorySummary, char.LastSeq
+	return func(u *domain.User) error {
+		stored, err := currentCharacterByID(u, char.ID)
+		if err != nil {
+			return err
+		}
+		stored.Chat = append([]domain.ChatMessage(nil), chat...)
+		stored.ContextStart, stored.HistorySummary = contextStart, summary
+		if stored.LastSeq < lastSeq {
+			stored.LastSeq = lastSeq
+		}
+		return nil
+	}
+}
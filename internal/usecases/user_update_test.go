@@ -0,0 +1,206 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/alex-pyslar/neuro-chat-bot/internal/domain"
+)
+
+func TestUpdateUserPropertyConcurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	const writers = 8
+	uc := newTestInteractor(repo, &stubGateway{}, func(opts *UserInteractorOptions) {
+		opts.ConflictRetries = writers // Каждый писатель может проиграть гонку всем остальным
+	})
+	if _, err := uc.GetOrCreateUser(ctx, 1, "alice"); err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	// Каждый обработчик загружает своего пользователя до того, как остальные его изменят
+	users := make([]*domain.User, writers)
+	for i := range users {
+		user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+		if err != nil {
+			t.Fatalf("GetOrCreateUser: %v", err)
+		}
+		users[i] = user
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i, user := range users {
+		wg.Add(1)
+		go func(i int, user *domain.User) {
+			defer wg.Done()
+			errs[i] = uc.UpdateUserProperty(ctx, user, "AddGreeting", fmt.Sprintf("greeting %d", i))
+		}(i, user)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("UpdateUserProperty #%d: %v", i, err)
+		}
+	}
+	stored, _ := repo.LoadUser(ctx, 1)
+	greetings := make(map[string]bool)
+	for _, greeting := range stored.GetCurrentCharacter().AlternateGreetings {
+		greetings[greeting.Text] = true
+	}
+	for i := 0; i < writers; i++ {
+		if want := fmt.Sprintf("greeting %d", i); !greetings[want] {
+			t.Errorf("greeting %q lost, stored %v", want, stored.GetCurrentCharacter().AlternateGreetings)
+		}
+	}
+}
+
+func TestUpdateUserAfterConcurrentChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(ctx context.Context, uc *UserInteractor, user *domain.User) error
+		check  func(t *testing.T, stored *domain.User)
+	}{
+		{
+			name: "property",
+			update: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+				return uc.UpdateUserProperty(ctx, user, "UserDescription", "a tester")
+			},
+			check: func(t *testing.T, stored *domain.User) {
+				if stored.UserDescription != "a tester" {
+					t.Errorf("description = %q, want %q", stored.UserDescription, "a tester")
+				}
+			},
+		},
+		{
+			name: "add character",
+			update: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+				char := domain.NewCharacterPreset()
+				char.Name = "Bob"
+				return uc.AddCharacter(ctx, user, char)
+			},
+			check: func(t *testing.T, stored *domain.User) {
+				if len(stored.Characters) != 2 || stored.GetCurrentCharacter().Name != "Bob" {
+					t.Errorf("characters = %d, current = %q; want 2 and Bob", len(stored.Characters), stored.GetCurrentCharacter().Name)
+				}
+			},
+		},
+		{
+			name: "controller bookkeeping",
+			update: func(ctx context.Context, uc *UserInteractor, user *domain.User) error {
+				return uc.UpdateUser(ctx, user, func(u *domain.User) error {
+					u.LastMessageID, u.PendingCommand = 42, "set_prompt"
+					return nil
+				})
+			},
+			check: func(t *testing.T, stored *domain.User) {
+				if stored.LastMessageID != 42 || stored.PendingCommand != "set_prompt" {
+					t.Errorf("LastMessageID = %d, PendingCommand = %q", stored.LastMessageID, stored.PendingCommand)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newMemoryRepository()
+			uc := newTestInteractor(repo, &stubGateway{}, nil)
+			user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+			if err != nil {
+				t.Fatalf("GetOrCreateUser: %v", err)
+			}
+
+			// Другой обработчик дописывает ход чата: версия документа увеличивается, как при выдаче номера сообщения
+			other, _ := repo.LoadUser(ctx, 1)
+			other.GetCurrentCharacter().AppendMessages(domain.NewChatMessage(domain.UserRole, "from another handler"))
+			if err := repo.SaveUser(ctx, other); err != nil {
+				t.Fatalf("concurrent SaveUser: %v", err)
+			}
+
+			if err := tt.update(ctx, uc, user); err != nil {
+				t.Fatalf("update: %v", err)
+			}
+			stored, _ := repo.LoadUser(ctx, 1)
+			tt.check(t, stored)
+			if chat := stored.Characters[0].Chat; len(chat) != 1 || chat[0].Content != "from another handler" {
+				t.Errorf("concurrent chat turn lost: %v", chat)
+			}
+			if user.Version != stored.Version {
+				t.Errorf("user version = %d, stored version = %d", user.Version, stored.Version)
+			}
+		})
+	}
+}
+
+func TestUpdateUserPropertyCharacterSwitched(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+	if err := uc.AddCharacter(ctx, user, domain.NewCharacterPreset()); err != nil {
+		t.Fatalf("AddCharacter: %v", err)
+	}
+	if err := uc.ChangeCurrentCharacter(ctx, user, 0); err != nil {
+		t.Fatalf("ChangeCurrentCharacter: %v", err)
+	}
+
+	// Другой обработчик переключает персонажа: промпт, заданный для первого, не должен попасть второму
+	other, _ := repo.LoadUser(ctx, 1)
+	other.ChangeCurrentCharacter(1)
+	if err := repo.SaveUser(ctx, other); err != nil {
+		t.Fatalf("concurrent SaveUser: %v", err)
+	}
+
+	err = uc.UpdateUserProperty(ctx, user, "Prompt", "for the first character")
+	if !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("UpdateUserProperty error = %v, want ErrVersionConflict", err)
+	}
+	stored, _ := repo.LoadUser(ctx, 1)
+	for _, char := range stored.Characters {
+		if char.Prompt == "for the first character" {
+			t.Errorf("prompt applied to character %d after a conflict", char.ID)
+		}
+	}
+}
+
+func TestUpdateUserAfterMessageAdded(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryRepository()
+	uc := newTestInteractor(repo, &stubGateway{}, nil)
+	user, err := uc.GetOrCreateUser(ctx, 1, "alice")
+	if err != nil {
+		t.Fatalf("GetOrCreateUser: %v", err)
+	}
+
+	// AddChatMessage выдает номер сообщения и увеличивает версию документа
+	if err := repo.AddChatMessage(ctx, 1, 0, domain.NewChatMessage(domain.UserRole, "added directly")); err != nil {
+		t.Fatalf("AddChatMessage: %v", err)
+	}
+
+	if err := uc.UpdateUserProperty(ctx, user, "Timezone", "Europe/Moscow"); err != nil {
+		t.Fatalf("UpdateUserProperty after a message was added: %v", err)
+	}
+	setLastMessage := func(u *domain.User) error {
+		u.LastMessageID = 42
+		return nil
+	}
+	if err := uc.UpdateUser(ctx, user, setLastMessage); err != nil {
+		t.Fatalf("UpdateUser: %v", err)
+	}
+
+	stored, _ := repo.LoadUser(ctx, 1)
+	if stored.Timezone != "Europe/Moscow" || stored.LastMessageID != 42 {
+		t.Errorf("Timezone = %q, LastMessageID = %d", stored.Timezone, stored.LastMessageID)
+	}
+	chat := stored.GetCurrentCharacter().Chat
+	if len(chat) != 1 || chat[0].Seq != 1 || stored.GetCurrentCharacter().LastSeq != 1 {
+		t.Errorf("added message or its number lost: chat = %v, last seq = %d", chat, stored.GetCurrentCharacter().LastSeq)
+	}
+}
@@ -0,0 +1,150 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy определяет поведение AsyncLogger при заполненном буфере.
+type OverflowPolicy int
+
+const (
+	DropOnOverflow  OverflowPolicy = iota // Отбрасывать сообщение, не блокируя вызывающую горутину
+	BlockOnOverflow                       // Ждать освобождения места в буфере
+)
+
+// logEntry представляет сообщение, ожидающее записи.
+type logEntry struct {
+	level   LogLevel
+	message string
+}
+
+// AsyncLogger является асинхронной оберткой над Logger. Сообщения форматируются в момент вызова
+// и записываются отдельной горутиной, поэтому медленный вывод не блокирует обработчики.
+// Fatal записывается синхронно после сброса буфера.
+type AsyncLogger struct {
+	inner   Logger
+	policy  OverflowPolicy
+	entries chan logEntry
+	dropped atomic.Int64 // Количество отброшенных сообщений с момента последнего отчета
+
+	closeOnce sync.Once
+	closing   chan struct{}
+	done      chan struct{}
+}
+
+// NewAsyncLogger создает новый экземпляр AsyncLogger с буфером на bufferSize сообщений.
+func NewAsyncLogger(inner Logger, bufferSize int, policy OverflowPolicy) *AsyncLogger {
+	l := &AsyncLogger{
+		inner:   inner,
+		policy:  policy,
+		entries: make(chan logEntry, bufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// run записывает сообщения из буфера до закрытия логгера.
+func (l *AsyncLogger) run() {
+	defer close(l.done)
+	for {
+		select {
+		case entry := <-l.entries:
+			l.write(entry)
+		case <-l.closing:
+			// Дописываем все, что осталось в буфере
+			for {
+				select {
+				case entry := <-l.entries:
+					l.write(entry)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write передает сообщение во внутренний логгер, предварительно сообщив об отброшенных сообщениях.
+func (l *AsyncLogger) write(entry logEntry) {
+	if dropped := l.dropped.Swap(0); dropped > 0 {
+		l.inner.Log(WarningLevel, "Async logger buffer overflow: %d messages dropped", dropped)
+	}
+	l.inner.Log(entry.level, "%s", entry.message)
+}
+
+// Close сбрасывает буфер и останавливает горутину записи. Сообщения после Close пишутся синхронно.
+func (l *AsyncLogger) Close() {
+	l.closeOnce.Do(func() {
+		close(l.closing)
+	})
+	<-l.done
+}
+
+// SetLogLevel устанавливает текущий уровень логирования.
+func (l *AsyncLogger) SetLogLevel(level LogLevel) {
+	l.inner.SetLogLevel(level)
+}
+
+// Log ставит сообщение в очередь на запись с заданным уровнем.
+func (l *AsyncLogger) Log(level LogLevel, format string, args ...interface{}) {
+	if level == FatalLevel {
+		l.Close()
+		l.inner.Log(level, format, args...)
+		return
+	}
+
+	entry := logEntry{level: level, message: fmt.Sprintf(format, args...)}
+	select {
+	case <-l.closing:
+		l.inner.Log(entry.level, "%s", entry.message)
+		return
+	default:
+	}
+
+	if l.policy == BlockOnOverflow {
+		select {
+		case l.entries <- entry:
+		case <-l.closing:
+			l.inner.Log(entry.level, "%s", entry.message)
+		}
+		return
+	}
+
+	select {
+	case l.entries <- entry:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Info логирует информационное сообщение.
+func (l *AsyncLogger) Info(format string, args ...interface{}) {
+	l.Log(InfoLevel, format, args...)
+}
+
+// DebugInfo логирует отладочную информацию.
+func (l *AsyncLogger) DebugInfo(format string, args ...interface{}) {
+	l.Log(DebugInfo, format, args...)
+}
+
+// Error логирует сообщение об ошибке.
+func (l *AsyncLogger) Error(format string, args ...interface{}) {
+	l.Log(ErrorLevel, format, args...)
+}
+
+// Warn логирует предупреждающее сообщение.
+func (l *AsyncLogger) Warn(format string, args ...interface{}) {
+	l.Log(WarningLevel, format, args...)
+}
+
+// Fatal сбрасывает буфер, логирует критическую ошибку и завершает программу.
+func (l *AsyncLogger) Fatal(format string, args ...interface{}) {
+	l.Log(FatalLevel, format, args...)
+}
+
+// Verify that AsyncLogger implements Logger
+var _ Logger = (*AsyncLogger)(nil)
@@ -0,0 +1,61 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gatedWriter задерживает первую запись до закрытия release, чтобы остальные сообщения ждали в буфере.
+type gatedWriter struct {
+	release chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+	buf     bytes.Buffer
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { <-w.release })
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gatedWriter) lines() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return strings.Split(strings.TrimSpace(w.buf.String()), "\n")
+}
+
+func TestAsyncLoggerCloseFlushesQueue(t *testing.T) {
+	const messages = 50
+	out := &gatedWriter{release: make(chan struct{})}
+	l := NewAsyncLogger(NewWriterLogger(out, AllLevels), messages, DropOnOverflow)
+	for i := 0; i < messages; i++ {
+		l.Info("message %d", i)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		l.Close()
+		close(closed)
+	}()
+	close(out.release) // Запись первого сообщения завершается, остальные все еще в буфере
+	<-closed
+	l.Info("after close")
+
+	lines := out.lines()
+	if len(lines) != messages+1 {
+		t.Fatalf("got %d log lines, want %d: %q", len(lines), messages+1, lines)
+	}
+	for i, line := range lines[:messages] {
+		if want := fmt.Sprintf("[INFO] message %d", i); !strings.HasSuffix(line, want) {
+			t.Fatalf("line %d = %q, want suffix %q", i, line, want)
+		}
+	}
+	if !strings.HasSuffix(lines[messages], "[INFO] after close") {
+		t.Errorf("message after Close = %q, want it written synchronously", lines[messages])
+	}
+}
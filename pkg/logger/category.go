@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Именованные уровни логирования для настройки из окружения и команд.
+var namedLevels = map[string]LogLevel{
+	"debug": AllLevels,
+	"info":  InfoLevel | WarningLevel | ErrorLevel | FatalLevel,
+	"warn":  WarningLevel | ErrorLevel | FatalLevel,
+	"error": ErrorLevel | FatalLevel,
+	"quiet": FatalLevel,
+}
+
+// ParseLevel преобразует имя уровня (debug, info, warn, error, quiet) в набор флагов LogLevel.
+// Уровень включает все более важные сообщения: например, warn пишет предупреждения, ошибки и критические ошибки.
+func ParseLevel(name string) (LogLevel, error) {
+	level, ok := namedLevels[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return None, fmt.Errorf("unknown log level %q (expected debug, info, warn, error or quiet)", name)
+	}
+	return level, nil
+}
+
+// LevelName возвращает имя уровня для набора флагов или его шестнадцатеричное значение, если имени нет.
+func LevelName(level LogLevel) string {
+	for name, named := range namedLevels {
+		if named == level {
+			return name
+		}
+	}
+	return fmt.Sprintf("%#x", int(level))
+}
+
+// LevelRegistry хранит общий уровень логирования и уровни отдельных категорий (компонентов),
+// например "gateway" или "persistence". Категория без собственного уровня использует общий.
+// Уровни можно менять во время работы; методы безопасны для одновременного вызова.
+type LevelRegistry struct {
+	inner Logger
+
+	mu         sync.RWMutex
+	global     LogLevel
+	overrides  map[string]LogLevel
+	categories map[string]bool // Категории, для которых были созданы логгеры
+}
+
+// NewLevelRegistry создает реестр уровней, пишущий через inner. Фильтрацию выполняет реестр,
+// поэтому inner должен пропускать все уровни.
+func NewLevelRegistry(inner Logger, global LogLevel) *LevelRegistry {
+	return &LevelRegistry{
+		inner:      inner,
+		global:     global,
+		overrides:  make(map[string]LogLevel),
+		categories: make(map[string]bool),
+	}
+}
+
+// For возвращает логгер категории category. Сообщения помечаются именем категории.
+func (r *LevelRegistry) For(category string) Logger {
+	r.mu.Lock()
+	r.categories[category] = true
+	r.mu.Unlock()
+	return &categoryLogger{registry: r, category: category}
+}
+
+// Categories возвращает известные категории в алфавитном порядке.
+func (r *LevelRegistry) Categories() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	categories := make([]string, 0, len(r.categories))
+	for category := range r.categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// SetGlobal задает общий уровень для категорий без собственного уровня.
+func (r *LevelRegistry) SetGlobal(level LogLevel) {
+	r.mu.Lock()
+	r.global = level
+	r.mu.Unlock()
+}
+
+// Global возвращает общий уровень.
+func (r *LevelRegistry) Global() LogLevel {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.global
+}
+
+// SetCategory задает собственный уровень категории.
+func (r *LevelRegistry) SetCategory(category string, level LogLevel) {
+	r.mu.Lock()
+	r.overrides[category] = level
+	r.mu.Unlock()
+}
+
+// ResetCategory возвращает категории общий уровень.
+func (r *LevelRegistry) ResetCategory(category string) {
+	r.mu.Lock()
+	delete(r.overrides, category)
+	r.mu.Unlock()
+}
+
+// Level возвращает действующий уровень категории и признак того, что он задан для нее отдельно.
+func (r *LevelRegistry) Level(category string) (LogLevel, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if level, ok := r.overrides[category]; ok {
+		return level, true
+	}
+	return r.global, false
+}
+
+// categoryLogger логгер одной категории реестра уровней.
+type categoryLogger struct {
+	registry *LevelRegistry
+	category string
+}
+
+// SetLogLevel задает собственный уровень категории.
+func (l *categoryLogger) SetLogLevel(level LogLevel) {
+	l.registry.SetCategory(l.category, level)
+}
+
+// Log пишет сообщение, если уровень включен для категории. Критические ошибки пишутся всегда,
+// чтобы программа завершилась.
+func (l *categoryLogger) Log(level LogLevel, format string, args ...interface{}) {
+	if current, _ := l.registry.Level(l.category); current&level == 0 && level != FatalLevel {
+		return
+	}
+	l.registry.inner.Log(level, "[%s] %s", l.category, fmt.Sprintf(format, args...))
+}
+
+// Info логирует информационное сообщение.
+func (l *categoryLogger) Info(format string, args ...interface{}) {
+	l.Log(InfoLevel, format, args...)
+}
+
+// DebugInfo логирует отладочную информацию.
+func (l *categoryLogger) DebugInfo(format string, args ...interface{}) {
+	l.Log(DebugInfo, format, args...)
+}
+
+// Error логирует сообщение об ошибке.
+func (l *categoryLogger) Error(format string, args ...interface{}) {
+	l.Log(ErrorLevel, format, args...)
+}
+
+// Warn логирует предупреждающее сообщение.
+func (l *categoryLogger) Warn(format string, args ...interface{}) {
+	l.Log(WarningLevel, format, args...)
+}
+
+// Fatal логирует критическую ошибку и завершает программу.
+func (l *categoryLogger) Fatal(format string, args ...interface{}) {
+	l.Log(FatalLevel, format, args...)
+}
+
+// Verify that categoryLogger implements Logger
+var _ Logger = (*categoryLogger)(nil)
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLevelRegistryCategoryOverrides(t *testing.T) {
+	var out bytes.Buffer
+	registry := NewLevelRegistry(NewWriterLogger(&out, AllLevels), mustParseLevel(t, "info"))
+	gateway, persistence, telegram := registry.For("gateway"), registry.For("persistence"), registry.For("telegram")
+	// logAll пишет сообщения всех уровней, кроме критического, во все категории и возвращает записанные строки
+	logAll := func() []string {
+		out.Reset()
+		for _, l := range []Logger{gateway, persistence, telegram} {
+			l.DebugInfo("debug")
+			l.Info("info")
+			l.Warn("warn")
+			l.Error("error")
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if line != "" {
+				lines = append(lines, line[strings.Index(line, "] ")+2:]) // Без отметки времени и уровня
+			}
+		}
+		return lines
+	}
+	check := func(step string, want []string) {
+		t.Helper()
+		if got := logAll(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s: logged\n%s\nwant\n%s", step, strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	}
+
+	check("global info", []string{
+		"[gateway] info", "[gateway] warn", "[gateway] error",
+		"[persistence] info", "[persistence] warn", "[persistence] error",
+		"[telegram] info", "[telegram] warn", "[telegram] error",
+	})
+
+	registry.SetCategory("gateway", mustParseLevel(t, "debug"))
+	registry.SetCategory("persistence", mustParseLevel(t, "error"))
+	check("gateway debug, persistence error", []string{
+		"[gateway] debug", "[gateway] info", "[gateway] warn", "[gateway] error",
+		"[persistence] error",
+		"[telegram] info", "[telegram] warn", "[telegram] error",
+	})
+
+	// Общий уровень не влияет на категории с собственным уровнем
+	registry.SetGlobal(mustParseLevel(t, "quiet"))
+	check("global quiet", []string{
+		"[gateway] debug", "[gateway] info", "[gateway] warn", "[gateway] error",
+		"[persistence] error",
+	})
+
+	registry.ResetCategory("gateway")
+	check("gateway reset", []string{"[persistence] error"})
+	if level, overridden := registry.Level("gateway"); overridden || level != FatalLevel {
+		t.Errorf("Level(gateway) = %#x, %v; want the global level", int(level), overridden)
+	}
+	if got, want := strings.Join(registry.Categories(), ","), "gateway,persistence,telegram"; got != want {
+		t.Errorf("Categories() = %s, want %s", got, want)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error", "quiet"} {
+		level, err := ParseLevel(" " + strings.ToUpper(name) + " ")
+		if err != nil {
+			t.Fatalf("ParseLevel(%q): %v", name, err)
+		}
+		if got := LevelName(level); got != name {
+			t.Errorf("LevelName(ParseLevel(%q)) = %q", name, got)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
+
+// mustParseLevel возвращает уровень с именем name.
+func mustParseLevel(t *testing.T, name string) LogLevel {
+	t.Helper()
+	level, err := ParseLevel(name)
+	if err != nil {
+		t.Fatalf("ParseLevel(%q): %v", name, err)
+	}
+	return level
+}
@@ -2,7 +2,10 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -31,29 +34,43 @@ type Logger interface {
 	Fatal(format string, args ...interface{}) // Добавлен критический уровень
 }
 
-// ConsoleLogger является реализацией Logger, которая выводит сообщения в консоль.
+// ConsoleLogger является реализацией Logger, которая выводит сообщения в консоль или другой io.Writer.
+// Каждое сообщение записывается одним вызовом Write под мьютексом, поэтому строки из разных горутин не перемешиваются,
+// а уровень можно менять во время работы.
 type ConsoleLogger struct {
-	currentLogLevel LogLevel
+	currentLogLevel atomic.Int64 // Текущий LogLevel
+
+	mu  sync.Mutex
+	out io.Writer
 }
 
 // NewConsoleLogger создает новый экземпляр ConsoleLogger с заданным начальным уровнем.
 func NewConsoleLogger(initialLogLevel LogLevel) *ConsoleLogger {
-	return &ConsoleLogger{
-		currentLogLevel: initialLogLevel,
-	}
+	return NewWriterLogger(os.Stdout, initialLogLevel)
+}
+
+// NewWriterLogger создает ConsoleLogger, пишущий в out, например в RotatingFile
+// или в io.MultiWriter(os.Stdout, file) для вывода одновременно в консоль и в файл.
+func NewWriterLogger(out io.Writer, initialLogLevel LogLevel) *ConsoleLogger {
+	l := &ConsoleLogger{out: out}
+	l.currentLogLevel.Store(int64(initialLogLevel))
+	return l
 }
 
 // SetLogLevel устанавливает текущий уровень логирования.
 func (l *ConsoleLogger) SetLogLevel(level LogLevel) {
-	l.currentLogLevel = level
+	l.currentLogLevel.Store(int64(level))
 }
 
 // Log выводит сообщение с заданным уровнем.
 func (l *ConsoleLogger) Log(level LogLevel, format string, args ...interface{}) {
-	if (l.currentLogLevel & level) != 0 {
+	if (LogLevel(l.currentLogLevel.Load()) & level) != 0 {
 		timestamp := time.Now().Format("2006-01-02 15:04:05")
 		logMessage := fmt.Sprintf(format, args...)
-		fmt.Printf("[%s][%s] %s\n", timestamp, l.levelToString(level), logMessage)
+		line := fmt.Sprintf("[%s][%s] %s\n", timestamp, l.levelToString(level), logMessage)
+		l.mu.Lock()
+		io.WriteString(l.out, line)
+		l.mu.Unlock()
 
 		if level == FatalLevel {
 			os.Exit(1) // При фатальной ошибке завершаем выполнение программы
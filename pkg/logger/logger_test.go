@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// byteWriter дописывает данные по одному байту, уступая процессор между байтами, и не синхронизирован:
+// если бы запись строк не была атомарной, строки из разных горутин перемешались бы.
+type byteWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *byteWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		w.buf.WriteByte(b)
+		runtime.Gosched()
+	}
+	return len(p), nil
+}
+
+func TestConsoleLoggerConcurrentLog(t *testing.T) {
+	const (
+		goroutines = 100
+		messages   = 20
+	)
+	out := &byteWriter{}
+	l := NewWriterLogger(out, AllLevels)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for m := 0; m < messages; m++ {
+				l.Info("goroutine %d message %d end", g, m)
+				if m%5 == 0 {
+					l.SetLogLevel(AllLevels) // Уровень меняется одновременно с записью
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	line := regexp.MustCompile(`^\[\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\]\[INFO\] goroutine (\d+) message (\d+) end$`)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(&out.buf)
+	for scanner.Scan() {
+		match := line.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			t.Fatalf("partial or interleaved log line: %q", scanner.Text())
+		}
+		seen[match[1]+"/"+match[2]] = true
+	}
+	if len(seen) != goroutines*messages {
+		t.Errorf("got %d distinct log lines, want %d", len(seen), goroutines*messages)
+	}
+}
+
+func TestConsoleLoggerLevels(t *testing.T) {
+	tests := []struct {
+		name  string
+		level LogLevel
+		log   func(l Logger)
+		want  string
+	}{
+		{name: "info enabled", level: InfoLevel, log: func(l Logger) { l.Info("hello") }, want: "[INFO] hello"},
+		{name: "warning enabled", level: WarningLevel, log: func(l Logger) { l.Warn("careful") }, want: "[WARNING] careful"},
+		{name: "debug enabled", level: DebugInfo, log: func(l Logger) { l.DebugInfo("details") }, want: "[DEBUG] details"},
+		{name: "error filtered out", level: InfoLevel | WarningLevel, log: func(l Logger) { l.Error("failure") }, want: ""},
+		{name: "nothing logged", level: None, log: func(l Logger) { l.Info("hello") }, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.log(NewWriterLogger(&out, tt.level))
+			got := out.String()
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("got %q, want no output", got)
+				}
+				return
+			}
+			if !bytes.Contains(out.Bytes(), []byte(tt.want+"\n")) {
+				t.Errorf("got %q, want a line containing %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile является io.Writer, который дописывает данные в файл и переименовывает его по достижении
+// заданного размера: path → path.1 → path.2 и так далее до path.<backups>; более старые файлы удаляются.
+// Методы безопасны для одновременного вызова.
+type RotatingFile struct {
+	path     string
+	maxBytes int64 // Размер, после которого файл ротируется (0 - без ротации)
+	backups  int   // Сколько старых файлов хранить
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile открывает (или создает) файл path для дозаписи.
+func NewRotatingFile(path string, maxBytes int64, backups int) (*RotatingFile, error) {
+	f := &RotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open открывает текущий файл и запоминает его размер.
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write дописывает p в файл, предварительно ротируя его, если запись превысит maxBytes.
+// Запись больше maxBytes целиком попадает в новый файл.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate закрывает текущий файл, сдвигает старые копии и открывает новый файл.
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	f.file = nil
+
+	if f.backups > 0 {
+		for i := f.backups - 1; i >= 1; i-- {
+			os.Rename(f.backupName(i), f.backupName(i+1)) // Отсутствующие копии пропускаются
+		}
+		if err := os.Rename(f.path, f.backupName(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+// backupName возвращает имя i-й старой копии файла.
+func (f *RotatingFile) backupName(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Close закрывает файл. Запись после Close возвращает ошибку.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}